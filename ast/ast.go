@@ -4,54 +4,81 @@ package ast
 
 import (
 	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/smasher164/arvo/scan"
 )
 
+// A Package is a set of files that share a package scope.
 type Package struct {
-	Files   []*File
-	version string // TODO, type.
+	Name  string  // package name
+	Path  string  // path by which the package is used; empty for the main package
+	Files []*File // files of the package, in the order that they are checked
+	Scope *Scope  // package scope, shared by every file
 }
 
 type File struct {
-	Comments   *CommentGroup
+	Comments   []*CommentGroup // all comments in the file, in source order
 	Package    PackageDecl
 	Decls      []*GenDecl
 	Stmts      []Stmt
-	Scope      *Scope
-	Unresolved []*Ident
+	Scope      *Scope   // package scope
+	Unresolved []*Ident // identifiers that no scope declares
 	Src        NamedReader
+	Fset       *scan.FileSet // positions of the file's nodes; set by the parser if nil
 }
 
-type Node interface{}
+// All node types implement the Node interface.
+type Node interface {
+	Pos() scan.Pos // position of the first character of the node
+	End() scan.Pos // position of the first character after the node
+}
 
-// maybe every node has positional information?
-type Stmt interface{}
+// All expression nodes implement the Expr interface.
+type Expr interface {
+	Node
+	exprNode()
+}
 
-type Expr interface{}
+// All statement nodes implement the Stmt interface.
+type Stmt interface {
+	Node
+	stmtNode()
+}
 
-type Spec interface{}
+// All specification nodes implement the Spec interface.
+type Spec interface {
+	Node
+	specNode()
+}
 
 type NamedReader interface {
 	Name() string
 	io.Reader
 }
 
+// A CommentGroup is a sequence of comments with no other tokens and no
+// empty lines between them.
 type CommentGroup struct {
 	List []*Comment
 }
 
+// RelComments holds the comments attached to a node, keyed by where they
+// appear relative to it.
 type RelComments struct {
-	List map[CommentPos]*Comment
+	List map[CommentPos]*CommentGroup
 }
 
 type CommentPos int
 
 const (
-	Above CommentPos = iota
-	Below
-	Before
-	After
+	Above    CommentPos = iota // on the lines preceding the node
+	Below                      // on the lines following the node, or inside it if it is empty
+	Before                     // on the node's first line, preceding it
+	After                      // on the node's last line, following it
+	Trailing                   // on the line of the node's opening brace, following it
 )
 
 type Comment struct {
@@ -63,6 +90,7 @@ type PackageDecl struct {
 	Comments *RelComments
 	Package  scan.Token
 	Name     string
+	NamePos  scan.Pos
 }
 
 type GenDecl struct {
@@ -104,7 +132,7 @@ type Object struct {
 	Name string      // declared name
 	Decl interface{} // corresponding Field, XxxSpec, FuncDef, LabeledStmt, AssignStmt, Scope; or nil
 	Data interface{} // object-specific data; or nil
-	Type interface{} // placeholder for type information; may be nil
+	Type interface{} // type of a predeclared object; or nil
 }
 
 func (o *Object) Tok() scan.Token {
@@ -150,6 +178,26 @@ func NewObj(kind ObjKind, name string) *Object {
 	return &Object{Kind: kind, Name: name}
 }
 
+// IsExported reports whether name starts with an upper-case letter, which
+// makes it visible to the packages that use the package declaring it.
+func IsExported(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+// Qualified returns the selector of x if x is a qualified identifier, which
+// refers to a name declared by a used package, and nil otherwise.
+func Qualified(x Expr) *Ident {
+	sel, ok := x.(*SelectorExpr)
+	if !ok || sel == nil {
+		return nil
+	}
+	if id, ok := sel.X.(*Ident); ok && id.Obj != nil && id.Obj.Kind == Pkg {
+		return sel.Sel
+	}
+	return nil
+}
+
 type ValueSpec struct {
 	Comments *RelComments
 	Names    []*Ident
@@ -164,8 +212,9 @@ type BinaryExpr struct {
 }
 
 type UnaryExpr struct {
-	Op scan.Token
-	X  Expr
+	Comments *RelComments
+	Op       scan.Token
+	X        Expr
 }
 
 type ArrayLit struct {
@@ -179,7 +228,8 @@ type RecordLit struct {
 }
 
 type BasicLit struct {
-	Value scan.Token
+	Comments *RelComments
+	Value    scan.Token
 }
 
 type BlockStmt struct {
@@ -203,6 +253,7 @@ type FunDef struct {
 	Params   []*Param
 	Rparen   scan.Token
 	Body     *BlockStmt
+	Scope    *Scope // scope of the parameters and of the body's declarations
 }
 
 type ParenExpr struct {
@@ -363,6 +414,7 @@ type DeclStmt struct {
 }
 
 type EmptyStmt struct {
+	Comments  *RelComments
 	Semicolon scan.Token
 	Implicit  bool
 }
@@ -379,9 +431,372 @@ const (
 	Lbl                // label
 )
 
+var objKindStrings = [...]string{
+	Bad: "bad",
+	Pkg: "package",
+	Con: "const",
+	Typ: "type",
+	Var: "var",
+	Fun: "fun",
+	Lbl: "label",
+}
+
+func (kind ObjKind) String() string { return objKindStrings[kind] }
+
 type Ident struct {
-	Name scan.Token
-	Obj  *Object
+	Comments *RelComments
+	Name     scan.Token
+	Obj      *Object
+}
+
+// Pos and End implementations for nodes.
+
+func (p *Package) Pos() scan.Pos { return scan.NoPos }
+func (p *Package) End() scan.Pos { return scan.NoPos }
+
+func (f *File) Pos() scan.Pos {
+	if f.Package.Package.Pos.IsValid() {
+		return f.Package.Pos()
+	}
+	if len(f.Decls) > 0 {
+		return f.Decls[0].Pos()
+	}
+	if len(f.Stmts) > 0 {
+		return f.Stmts[0].Pos()
+	}
+	return scan.NoPos
+}
+func (f *File) End() scan.Pos {
+	if n := len(f.Stmts); n > 0 {
+		return f.Stmts[n-1].End()
+	}
+	if n := len(f.Decls); n > 0 {
+		return f.Decls[n-1].End()
+	}
+	return f.Package.End()
+}
+
+func (c *Comment) Pos() scan.Pos { return c.Slash.Pos }
+func (c *Comment) End() scan.Pos { return c.Slash.Pos + scan.Pos(len(c.Text)) }
+
+// Text returns the text of the comment group with the comment markers and
+// surrounding blank lines removed.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+	var lines []string
+	for _, c := range g.List {
+		t := c.Text
+		if strings.HasPrefix(t, "//") {
+			t = t[2:]
+		} else {
+			t = strings.TrimSuffix(t[2:], "*/")
+		}
+		for _, l := range strings.Split(t, "\n") {
+			lines = append(lines, strings.TrimRight(l, " \t"))
+		}
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (g *CommentGroup) Pos() scan.Pos { return g.List[0].Pos() }
+func (g *CommentGroup) End() scan.Pos { return g.List[len(g.List)-1].End() }
+
+func (r *RelComments) Pos() scan.Pos {
+	pos := scan.NoPos
+	for _, c := range r.List {
+		if p := c.Pos(); !pos.IsValid() || p < pos {
+			pos = p
+		}
+	}
+	return pos
+}
+func (r *RelComments) End() scan.Pos {
+	end := scan.NoPos
+	for _, c := range r.List {
+		if e := c.End(); e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+func (d *PackageDecl) Pos() scan.Pos { return d.Package.Pos }
+func (d *PackageDecl) End() scan.Pos {
+	if d.NamePos.IsValid() {
+		return d.NamePos + scan.Pos(len(d.Name))
+	}
+	return d.Package.End()
+}
+
+func (d *GenDecl) Pos() scan.Pos { return d.Keyword.Pos }
+func (d *GenDecl) End() scan.Pos {
+	if d.Rparen.Pos.IsValid() {
+		return d.Rparen.End()
+	}
+	if n := len(d.Specs); n > 0 {
+		return d.Specs[n-1].End()
+	}
+	return d.Keyword.End()
+}
+
+func (s *UseSpec) Pos() scan.Pos {
+	if s.Name != nil {
+		return s.Name.Pos()
+	}
+	return s.Path.Pos
+}
+func (s *UseSpec) End() scan.Pos { return s.Path.End() }
+
+func (s *ValueSpec) Pos() scan.Pos { return s.Names[0].Pos() }
+func (s *ValueSpec) End() scan.Pos {
+	if n := len(s.Values); n > 0 {
+		return s.Values[n-1].End()
+	}
+	return s.Names[len(s.Names)-1].End()
+}
+
+func (p *Param) Pos() scan.Pos {
+	if p.Ellipsis.Pos.IsValid() {
+		return p.Ellipsis.Pos
+	}
+	return p.Name.Pos()
+}
+func (p *Param) End() scan.Pos { return p.Name.End() }
+
+func (x *BinaryExpr) Pos() scan.Pos   { return x.X.Pos() }
+func (x *UnaryExpr) Pos() scan.Pos    { return x.Op.Pos }
+func (x *ArrayLit) Pos() scan.Pos     { return x.A.Pos }
+func (x *RecordLit) Pos() scan.Pos    { return x.R.Pos }
+func (x *BasicLit) Pos() scan.Pos     { return x.Value.Pos }
+func (x *FunDef) Pos() scan.Pos       { return x.Fun.Pos }
+func (x *ParenExpr) Pos() scan.Pos    { return x.Lparen.Pos }
+func (x *BadExpr) Pos() scan.Pos      { return x.From.Pos }
+func (x *SliceExpr) Pos() scan.Pos    { return x.X.Pos() }
+func (x *IndexExpr) Pos() scan.Pos    { return x.X.Pos() }
+func (x *SelectorExpr) Pos() scan.Pos { return x.X.Pos() }
+func (x *KeyValueExpr) Pos() scan.Pos { return x.Key.Pos() }
+func (x *CompositeLit) Pos() scan.Pos {
+	if x.Type != nil {
+		return x.Type.Pos()
+	}
+	return x.Lbrace.Pos
+}
+func (x *CallExpr) Pos() scan.Pos { return x.Fun.Pos() }
+func (x *Ident) Pos() scan.Pos    { return x.Name.Pos }
+
+func (x *BinaryExpr) End() scan.Pos { return x.Y.End() }
+func (x *UnaryExpr) End() scan.Pos  { return x.X.End() }
+func (x *ArrayLit) End() scan.Pos   { return x.A.End() }
+func (x *RecordLit) End() scan.Pos  { return x.R.End() }
+func (x *BasicLit) End() scan.Pos   { return x.Value.End() }
+func (x *FunDef) End() scan.Pos     { return x.Body.End() }
+func (x *ParenExpr) End() scan.Pos  { return x.Rparen.End() }
+func (x *BadExpr) End() scan.Pos    { return x.To.Pos }
+func (x *SliceExpr) End() scan.Pos  { return x.Rbrack.End() }
+func (x *IndexExpr) End() scan.Pos {
+	if x.Backwards {
+		return x.RbrackOut.End()
+	}
+	return x.RbrackIn.End()
+}
+func (x *SelectorExpr) End() scan.Pos { return x.Sel.End() }
+func (x *KeyValueExpr) End() scan.Pos { return x.Value.End() }
+func (x *CompositeLit) End() scan.Pos { return x.Rbrace.End() }
+func (x *CallExpr) End() scan.Pos     { return x.Rparen.End() }
+func (x *Ident) End() scan.Pos        { return x.Name.End() }
+
+func (s *BlockStmt) Pos() scan.Pos { return s.Lbrace.Pos }
+func (s *AssignStmt) Pos() scan.Pos {
+	if len(s.Lhs) > 0 {
+		return s.Lhs[0].Pos()
+	}
+	return s.Tok.Pos
+}
+func (s *LabeledStmt) Pos() scan.Pos { return s.Label.Pos() }
+func (s *BadStmt) Pos() scan.Pos     { return s.From.Pos }
+func (s *IncDecStmt) Pos() scan.Pos  { return s.X.Pos() }
+func (s *ExprStmt) Pos() scan.Pos    { return s.X.Pos() }
+func (s *ReturnStmt) Pos() scan.Pos  { return s.Return.Pos }
+func (s *BranchStmt) Pos() scan.Pos  { return s.Tok.Pos }
+func (s *IfStmt) Pos() scan.Pos      { return s.If.Pos }
+func (s *SwitchStmt) Pos() scan.Pos  { return s.Switch.Pos }
+func (s *CaseClause) Pos() scan.Pos  { return s.Case.Pos }
+func (s *InStmt) Pos() scan.Pos      { return s.For.Pos }
+func (s *ForStmt) Pos() scan.Pos     { return s.For.Pos }
+func (s *DeclStmt) Pos() scan.Pos    { return s.Decl.Pos() }
+func (s *EmptyStmt) Pos() scan.Pos   { return s.Semicolon.Pos }
+
+func (s *BlockStmt) End() scan.Pos { return s.Rbrace.End() }
+func (s *AssignStmt) End() scan.Pos {
+	if n := len(s.Rhs); n > 0 {
+		return s.Rhs[n-1].End()
+	}
+	return s.Tok.End()
+}
+func (s *LabeledStmt) End() scan.Pos { return s.Stmt.End() }
+func (s *BadStmt) End() scan.Pos     { return s.To.Pos }
+func (s *IncDecStmt) End() scan.Pos  { return s.Tok.End() }
+func (s *ExprStmt) End() scan.Pos    { return s.X.End() }
+func (s *ReturnStmt) End() scan.Pos {
+	if n := len(s.Results); n > 0 {
+		return s.Results[n-1].End()
+	}
+	return s.Return.End()
+}
+func (s *BranchStmt) End() scan.Pos {
+	if s.Label != nil {
+		return s.Label.End()
+	}
+	return s.Tok.End()
+}
+func (s *IfStmt) End() scan.Pos {
+	if s.Else != nil {
+		return s.Else.End()
+	}
+	return s.Body.End()
+}
+func (s *SwitchStmt) End() scan.Pos { return s.Body.End() }
+func (s *CaseClause) End() scan.Pos {
+	if n := len(s.Body); n > 0 {
+		return s.Body[n-1].End()
+	}
+	return s.Colon.End()
+}
+func (s *InStmt) End() scan.Pos   { return s.Body.End() }
+func (s *ForStmt) End() scan.Pos  { return s.Body.End() }
+func (s *DeclStmt) End() scan.Pos { return s.Decl.End() }
+func (s *EmptyStmt) End() scan.Pos {
+	if s.Implicit {
+		return s.Semicolon.Pos
+	}
+	return s.Semicolon.Pos + 1
+}
+
+// exprNode() ensures that only expression nodes can be assigned to an Expr.
+func (*BinaryExpr) exprNode()   {}
+func (*UnaryExpr) exprNode()    {}
+func (*ArrayLit) exprNode()     {}
+func (*RecordLit) exprNode()    {}
+func (*BasicLit) exprNode()     {}
+func (*FunDef) exprNode()       {}
+func (*ParenExpr) exprNode()    {}
+func (*BadExpr) exprNode()      {}
+func (*SliceExpr) exprNode()    {}
+func (*IndexExpr) exprNode()    {}
+func (*SelectorExpr) exprNode() {}
+func (*KeyValueExpr) exprNode() {}
+func (*CompositeLit) exprNode() {}
+func (*CallExpr) exprNode()     {}
+func (*Ident) exprNode()        {}
+
+// stmtNode() ensures that only statement nodes can be assigned to a Stmt.
+func (*BlockStmt) stmtNode()   {}
+func (*AssignStmt) stmtNode()  {}
+func (*LabeledStmt) stmtNode() {}
+func (*BadStmt) stmtNode()     {}
+func (*IncDecStmt) stmtNode()  {}
+func (*ExprStmt) stmtNode()    {}
+func (*ReturnStmt) stmtNode()  {}
+func (*BranchStmt) stmtNode()  {}
+func (*IfStmt) stmtNode()      {}
+func (*SwitchStmt) stmtNode()  {}
+func (*CaseClause) stmtNode()  {}
+func (*InStmt) stmtNode()      {}
+func (*ForStmt) stmtNode()     {}
+func (*DeclStmt) stmtNode()    {}
+func (*EmptyStmt) stmtNode()   {}
+
+// specNode() ensures that only specification nodes can be assigned to a Spec.
+func (*UseSpec) specNode()   {}
+func (*ValueSpec) specNode() {}
+
+// CommentsField returns the address of n's Comments field, or nil if n has
+// no such field.
+func CommentsField(n Node) **RelComments {
+	switch n := n.(type) {
+	case *PackageDecl:
+		return &n.Comments
+	case *GenDecl:
+		return &n.Comments
+	case *UseSpec:
+		return &n.Comments
+	case *ValueSpec:
+		return &n.Comments
+	case *Param:
+		return &n.Comments
+	case *BinaryExpr:
+		return &n.Comments
+	case *UnaryExpr:
+		return &n.Comments
+	case *ArrayLit:
+		return &n.Comments
+	case *RecordLit:
+		return &n.Comments
+	case *BasicLit:
+		return &n.Comments
+	case *FunDef:
+		return &n.Comments
+	case *ParenExpr:
+		return &n.Comments
+	case *BadExpr:
+		return &n.Comments
+	case *SliceExpr:
+		return &n.Comments
+	case *IndexExpr:
+		return &n.Comments
+	case *SelectorExpr:
+		return &n.Comments
+	case *KeyValueExpr:
+		return &n.Comments
+	case *CompositeLit:
+		return &n.Comments
+	case *CallExpr:
+		return &n.Comments
+	case *Ident:
+		return &n.Comments
+	case *BlockStmt:
+		return &n.Comments
+	case *AssignStmt:
+		return &n.Comments
+	case *LabeledStmt:
+		return &n.Comments
+	case *BadStmt:
+		return &n.Comments
+	case *IncDecStmt:
+		return &n.Comments
+	case *ExprStmt:
+		return &n.Comments
+	case *ReturnStmt:
+		return &n.Comments
+	case *BranchStmt:
+		return &n.Comments
+	case *IfStmt:
+		return &n.Comments
+	case *SwitchStmt:
+		return &n.Comments
+	case *CaseClause:
+		return &n.Comments
+	case *InStmt:
+		return &n.Comments
+	case *ForStmt:
+		return &n.Comments
+	case *DeclStmt:
+		return &n.Comments
+	case *EmptyStmt:
+		return &n.Comments
+	}
+	return nil
 }
 
 func Walk(n Node, pre, post WalkFunc) Node {
@@ -390,8 +805,15 @@ func Walk(n Node, pre, post WalkFunc) Node {
 	}
 	switch n := n.(type) {
 	case nil:
-	case *RelComments, *Comment:
-	case *BadExpr, *Ident, *BasicLit:
+	case *RelComments, *CommentGroup, *Comment:
+	case *BadExpr:
+		Walk(n.Comments, pre, post)
+	case *Ident:
+		if n != nil {
+			Walk(n.Comments, pre, post)
+		}
+	case *BasicLit:
+		Walk(n.Comments, pre, post)
 	case *FunDef:
 		Walk(n.Comments, pre, post)
 		Walk(n.Name, pre, post)
@@ -399,6 +821,9 @@ func Walk(n Node, pre, post WalkFunc) Node {
 			Walk(p, pre, post)
 		}
 		Walk(n.Body, pre, post)
+	case *Param:
+		Walk(n.Comments, pre, post)
+		Walk(n.Name, pre, post)
 	case *CompositeLit:
 		Walk(n.Comments, pre, post)
 		Walk(n.Type, pre, post)
@@ -432,6 +857,7 @@ func Walk(n Node, pre, post WalkFunc) Node {
 			Walk(e, pre, post)
 		}
 	case *UnaryExpr:
+		Walk(n.Comments, pre, post)
 		Walk(n.X, pre, post)
 	case *BinaryExpr:
 		Walk(n.Comments, pre, post)
@@ -442,10 +868,12 @@ func Walk(n Node, pre, post WalkFunc) Node {
 		Walk(n.Key, pre, post)
 		Walk(n.Value, pre, post)
 	case *BadStmt:
+		Walk(n.Comments, pre, post)
 	case *DeclStmt:
 		Walk(n.Comments, pre, post)
 		Walk(n.Decl, pre, post)
 	case *EmptyStmt:
+		Walk(n.Comments, pre, post)
 	case *LabeledStmt:
 		Walk(n.Comments, pre, post)
 		Walk(n.Label, pre, post)
@@ -528,7 +956,7 @@ func Walk(n Node, pre, post WalkFunc) Node {
 			Walk(sp, pre, post)
 		}
 	case *File:
-		Walk(n.Package, pre, post)
+		Walk(&n.Package, pre, post)
 		for _, d := range n.Decls {
 			Walk(d, pre, post)
 		}
@@ -0,0 +1,232 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/smasher164/arvo/scan"
+)
+
+// A FieldFilter may be provided to Fprint to control the output. It is
+// called with the name and value of each struct field, and the field is
+// printed only if it returns true.
+type FieldFilter func(name string, value reflect.Value) bool
+
+// NotNilFilter returns true for field values that are not nil.
+func NotNilFilter(_ string, v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return !v.IsNil()
+	}
+	return true
+}
+
+// Fprint prints the tree rooted at x to w, one field per line. Tokens are
+// printed with their positions, which are resolved through fset if it is
+// not nil. A node that has already been printed, such as the declaration
+// that an Object refers back to, is printed as a reference to the output
+// line where it first appeared.
+func Fprint(w io.Writer, fset *scan.FileSet, x interface{}, f FieldFilter) (err error) {
+	p := &printer{w: w, fset: fset, filter: f, ptrmap: make(map[interface{}]int), last: '\n'}
+	defer func() {
+		if e := recover(); e != nil {
+			err = e.(localError).err
+		}
+	}()
+	if x == nil {
+		p.printf("nil\n")
+		return
+	}
+	p.print(reflect.ValueOf(x))
+	p.printf("\n")
+	return
+}
+
+type printer struct {
+	w      io.Writer
+	fset   *scan.FileSet
+	filter FieldFilter
+	ptrmap map[interface{}]int // *T -> line number
+	indent int                 // current indentation level
+	last   byte                // the last byte processed by Write
+	line   int                 // current line number
+}
+
+var indent = []byte(".  ")
+
+func (p *printer) Write(data []byte) (n int, err error) {
+	var m int
+	for i, b := range data {
+		if b == '\n' {
+			m, err = p.w.Write(data[n : i+1])
+			n += m
+			if err != nil {
+				return
+			}
+			p.line++
+		} else if p.last == '\n' {
+			_, err = fmt.Fprintf(p.w, "%6d  ", p.line)
+			if err != nil {
+				return
+			}
+			for j := p.indent; j > 0; j-- {
+				_, err = p.w.Write(indent)
+				if err != nil {
+					return
+				}
+			}
+		}
+		p.last = b
+	}
+	if len(data) > n {
+		m, err = p.w.Write(data[n:])
+		n += m
+	}
+	return
+}
+
+// localError wraps errors that Write returns, so that Fprint can tell
+// them apart from other panics.
+type localError struct {
+	err error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(p, format, args...); err != nil {
+		panic(localError{err})
+	}
+}
+
+var tokenType = reflect.TypeOf(scan.Token{})
+
+func (p *printer) print(x reflect.Value) {
+	if !NotNilFilter("", x) {
+		p.printf("nil")
+		return
+	}
+
+	switch x.Kind() {
+	case reflect.Interface:
+		p.print(x.Elem())
+
+	case reflect.Map:
+		p.printf("%s (len = %d) {", x.Type(), x.Len())
+		if x.Len() > 0 {
+			p.indent++
+			p.printf("\n")
+			for _, key := range x.MapKeys() {
+				p.print(key)
+				p.printf(": ")
+				p.print(x.MapIndex(key))
+				p.printf("\n")
+			}
+			p.indent--
+		}
+		p.printf("}")
+
+	case reflect.Ptr:
+		p.printf("*")
+		ptr := x.Interface()
+		if line, exists := p.ptrmap[ptr]; exists {
+			p.printf("(obj @ %d)", line)
+		} else {
+			p.ptrmap[ptr] = p.line
+			p.print(x.Elem())
+		}
+
+	case reflect.Array:
+		p.printf("%s {", x.Type())
+		if x.Len() > 0 {
+			p.indent++
+			p.printf("\n")
+			for i, n := 0, x.Len(); i < n; i++ {
+				p.printf("%d: ", i)
+				p.print(x.Index(i))
+				p.printf("\n")
+			}
+			p.indent--
+		}
+		p.printf("}")
+
+	case reflect.Slice:
+		if s, ok := x.Interface().([]byte); ok {
+			p.printf("%#q", s)
+			return
+		}
+		p.printf("%s (len = %d) {", x.Type(), x.Len())
+		if x.Len() > 0 {
+			p.indent++
+			p.printf("\n")
+			for i, n := 0, x.Len(); i < n; i++ {
+				p.printf("%d: ", i)
+				p.print(x.Index(i))
+				p.printf("\n")
+			}
+			p.indent--
+		}
+		p.printf("}")
+
+	case reflect.Struct:
+		if x.Type() == tokenType {
+			p.token(x.Interface().(scan.Token))
+			return
+		}
+		t := x.Type()
+		p.printf("%s {", t)
+		p.indent++
+		first := true
+		for i, n := 0, t.NumField(); i < n; i++ {
+			// exclude non-exported fields because their values cannot be
+			// accessed via reflection
+			if name := t.Field(i).Name; isExported(name) {
+				value := x.Field(i)
+				if p.filter == nil || p.filter(name, value) {
+					if first {
+						p.printf("\n")
+						first = false
+					}
+					p.printf("%s: ", name)
+					p.print(value)
+					p.printf("\n")
+				}
+			}
+		}
+		p.indent--
+		p.printf("}")
+
+	default:
+		v := x.Interface()
+		switch v := v.(type) {
+		case string:
+			// print strings in quotes
+			p.printf("%q", v)
+			return
+		case scan.Pos:
+			// position values can be printed nicely if we have a file set
+			if p.fset != nil {
+				p.printf("%s", p.fset.Position(v))
+				return
+			}
+		}
+		// default
+		p.printf("%v", v)
+	}
+}
+
+// token prints t on one line, as its type, literal and position.
+func (p *printer) token(t scan.Token) {
+	pos := scan.Position{Offset: t.Offset, Line: t.Line, Column: t.Column}
+	if p.fset != nil && t.Pos.IsValid() {
+		pos = p.fset.Position(t.Pos)
+	}
+	if t.Lit == "" {
+		p.printf("%s @ %s", t.Type, pos)
+		return
+	}
+	p.printf("%s %q @ %s", t.Type, t.Lit, pos)
+}
+
+func isExported(name string) bool {
+	return name != "" && 'A' <= name[0] && name[0] <= 'Z'
+}
@@ -0,0 +1,125 @@
+//go:build !nollvm
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/smasher164/arvo/llvm"
+	"github.com/smasher164/arvo/runtime"
+
+	llvmapi "llvm.org/llvm/bindings/go/llvm"
+)
+
+func init() {
+	llvmapi.InitializeAllTargetInfos()
+	llvmapi.InitializeAllTargets()
+	llvmapi.InitializeAllTargetMCs()
+	llvmapi.InitializeAllAsmPrinters()
+}
+
+// compile returns the LLVM module for the program made up of the named
+// files, or reports errors and returns false if the program is not valid or
+// cannot be compiled.
+func compile(filenames []string) (llvmapi.Module, bool) {
+	conf := checkProgram(filenames)
+	if conf == nil {
+		return llvmapi.Module{}, false
+	}
+	g := &llvm.Generator{Config: *conf}
+	mod, err := g.CreateModule()
+	if err != nil {
+		if _, ok := err.(*llvm.Error); !ok {
+			err = fmt.Errorf("%s: %v", filenames[0], err)
+		}
+		report(err)
+		return llvmapi.Module{}, false
+	}
+	return mod, true
+}
+
+func runIR(_ *command, files []string) {
+	if mod, ok := compile(files); ok {
+		fmt.Print(mod.String())
+	}
+}
+
+func runBuild(_ *command, files []string) {
+	mod, ok := compile(files)
+	if !ok {
+		return
+	}
+	out := output
+	if out == "" {
+		out = strings.TrimSuffix(filepath.Base(files[0]), ".arvo")
+	}
+	obj, err := emitObject(mod)
+	if err != nil {
+		report(err)
+		return
+	}
+	if strings.HasSuffix(out, ".o") {
+		if err := os.WriteFile(out, obj, 0666); err != nil {
+			report(err)
+		}
+		return
+	}
+	if err := link(out, obj); err != nil {
+		report(err)
+	}
+}
+
+// emitObject returns an object file for mod, targeting the host.
+func emitObject(mod llvmapi.Module) ([]byte, error) {
+	triple := llvmapi.DefaultTargetTriple()
+	target, err := llvmapi.GetTargetFromTriple(triple)
+	if err != nil {
+		return nil, err
+	}
+	tm := target.CreateTargetMachine(triple, "", "", llvmapi.CodeGenLevelDefault, llvmapi.RelocPIC, llvmapi.CodeModelDefault)
+	defer tm.Dispose()
+	td := tm.CreateTargetData()
+	defer td.Dispose()
+	mod.SetTarget(triple)
+	mod.SetDataLayout(td.String())
+	buf, err := tm.EmitToMemoryBuffer(mod, llvmapi.ObjectFile)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Dispose()
+	return buf.Bytes(), nil
+}
+
+// link writes an executable to out, compiling the runtime and linking it
+// with obj using the C compiler named by $CC, or cc if it is not set.
+func link(out string, obj []byte) error {
+	dir, err := os.MkdirTemp("", "arvo")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	objFile := filepath.Join(dir, "main.o")
+	if err := os.WriteFile(objFile, obj, 0666); err != nil {
+		return err
+	}
+	srcs, err := runtime.WriteSources(dir)
+	if err != nil {
+		return err
+	}
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	args := append([]string{"-O2", "-pthread", "-I", dir, "-o", out, objFile}, srcs...)
+	cmd := exec.Command(cc, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("link: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+// Arvo is a tool for checking, compiling and running arvo programs.
+//
+// Usage:
+//
+//	arvo <command> [flags] [file ...]
+//
+// The commands are:
+//
+//	check   parse and type-check a program, reporting any errors
+//	build   compile a program to an executable or object file
+//	run     run a program with the interpreter
+//	ir      print the LLVM IR for a program
+//	fmt     format files in place, printing the names of changed files
+//	tokens  print the tokens that the scanner produces for files
+//	ast     print the syntax trees of files
+//
+// Run "arvo help <command>" for the flags that a command accepts.
+//
+// A program is given by the files of its main package. The packages that it
+// uses are looked up in the directory named by $ARVOPATH, or, if it is not
+// set, in the directory of the first file.
+//
+// The exit status is 0 on success, 1 if a file has errors or a command
+// fails, and 2 if arvo is invoked incorrectly. The run command instead exits
+// with the status that the program passes to exit, or 2 if the program
+// stops with a runtime error.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/format"
+	"github.com/smasher164/arvo/interp"
+	"github.com/smasher164/arvo/load"
+	"github.com/smasher164/arvo/parse"
+	"github.com/smasher164/arvo/scan"
+	"github.com/smasher164/arvo/types"
+)
+
+// A command is an arvo subcommand.
+type command struct {
+	name  string
+	args  string // arguments, after the flags
+	short string // one-line description
+	flag  *flag.FlagSet
+	run   func(cmd *command, files []string)
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "check", args: "file ...", short: "parse and type-check a program, reporting any errors", run: runCheck},
+		{name: "build", args: "file ...", short: "compile a program to an executable or object file", run: runBuild},
+		{name: "run", args: "file ...", short: "run a program with the interpreter", run: runRun},
+		{name: "ir", args: "file ...", short: "print the LLVM IR for a program", run: runIR},
+		{name: "fmt", args: "file ...", short: "format files in place, printing the names of changed files", run: runFmt},
+		{name: "tokens", args: "file ...", short: "print the tokens that the scanner produces for files", run: runTokens},
+		{name: "ast", args: "file ...", short: "print the syntax trees of files", run: runAST},
+	}
+	for _, cmd := range commands {
+		cmd.flag = flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		cmd.flag.Usage = cmd.usage
+	}
+	lookup("build").flag.StringVar(&output, "o", "", "write the result to `file`; an object file if it ends in .o")
+	lookup("check").flag.BoolVar(&allErrors, "e", false, "report all errors, not just the first on each line and up to 10")
+	lookup("ast").flag.BoolVar(&traceParse, "trace", false, "print a trace of the parse before each tree")
+}
+
+var (
+	output     string // the -o flag of build
+	allErrors  bool   // the -e flag of check
+	traceParse bool   // the -trace flag of ast
+)
+
+var exitCode = 0
+
+// report prints err and makes arvo exit with status 1.
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 1
+}
+
+// reportIn reports each line of err, prefixed by the file it occurred in.
+func reportIn(filename string, err error) {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		report(fmt.Errorf("%s:%s", filename, line))
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: arvo <command> [flags] [file ...]\n\nThe commands are:\n\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-7s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'arvo help <command>' for the flags that a command accepts.\n")
+	os.Exit(2)
+}
+
+func (cmd *command) usage() {
+	fmt.Fprintf(os.Stderr, "usage: arvo %s [flags] %s\n", cmd.name, cmd.args)
+	cmd.flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func lookup(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+	if args[0] == "help" {
+		if len(args) == 2 && lookup(args[1]) != nil {
+			lookup(args[1]).usage()
+		}
+		usage()
+	}
+	cmd := lookup(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "arvo: unknown command %q\n", args[0])
+		usage()
+	}
+	cmd.flag.Parse(args[1:])
+	files := cmd.flag.Args()
+	if len(files) == 0 {
+		cmd.usage()
+	}
+	cmd.run(cmd, files)
+	os.Exit(exitCode)
+}
+
+// parseFile parses the file at filename, reporting any errors. It returns
+// nil if the file could not be parsed.
+func parseFile(filename string, mode parse.Mode) *ast.File {
+	src, err := os.Open(filename)
+	if err != nil {
+		report(err)
+		return nil
+	}
+	defer src.Close()
+	f := &ast.File{Src: src}
+	if err := parse.File(f, mode); err != nil {
+		reportIn(filename, err)
+		return nil
+	}
+	return f
+}
+
+// checkProgram loads and type-checks the program whose main package is
+// made up of the named files, reporting any errors. It returns nil if the
+// program is not valid.
+func checkProgram(filenames []string) *types.Config {
+	root := os.Getenv("ARVOPATH")
+	if root == "" {
+		root = filepath.Dir(filenames[0])
+	}
+	mode := parse.DeclarationErrors
+	if allErrors {
+		mode |= parse.AllErrors
+	}
+	lc := &load.Config{Root: root, Mode: mode, Universe: types.Universe}
+	pkgs, err := lc.Load(filenames...)
+	if err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			report(errors.New(line))
+		}
+		return nil
+	}
+	conf := &types.Config{Packages: pkgs}
+	if err := types.Infer(conf); err != nil {
+		for _, e := range err.(types.ErrorList) {
+			report(fmt.Errorf("%s:%v", lc.Fset.Position(e.Tok.Pos).Filename, e))
+		}
+		return nil
+	}
+	return conf
+}
+
+func runCheck(_ *command, files []string) {
+	checkProgram(files)
+}
+
+func runRun(_ *command, files []string) {
+	conf := checkProgram(files)
+	if conf == nil {
+		return
+	}
+	in := &interp.Interpreter{Config: *conf}
+	switch err := in.Run().(type) {
+	case nil:
+	case interp.Exit:
+		os.Exit(int(err))
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func runFmt(_ *command, files []string) {
+	for _, filename := range files {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			report(err)
+			continue
+		}
+		res, err := format.Source(src)
+		if err != nil {
+			reportIn(filename, err)
+			continue
+		}
+		if bytes.Equal(src, res) {
+			continue
+		}
+		info, err := os.Stat(filename)
+		if err == nil {
+			err = os.WriteFile(filename, res, info.Mode().Perm())
+		}
+		if err != nil {
+			report(err)
+			continue
+		}
+		fmt.Println(filename)
+	}
+}
+
+func runTokens(_ *command, files []string) {
+	for _, filename := range files {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			report(err)
+			continue
+		}
+		fset := scan.NewFileSet()
+		eh := func(pos scan.Position, msg string) {
+			reportIn(filename, fmt.Errorf("%d:%d:%d: %s", pos.Offset, pos.Line, pos.Column, msg))
+		}
+		s := scan.NewFile(fset.AddFile(filename, len(src)), bytes.NewReader(src), eh)
+		for {
+			tok := s.Scan()
+			fmt.Printf("%s\t%s\t%q\n", fset.Position(tok.Pos), tok.Type, tok.Lit)
+			if tok.Type == scan.EOF {
+				break
+			}
+		}
+	}
+}
+
+func runAST(_ *command, files []string) {
+	for _, filename := range files {
+		mode := parse.ParseComments
+		if traceParse {
+			mode |= parse.Trace
+		}
+		f := parseFile(filename, mode)
+		if f == nil {
+			continue
+		}
+		if err := ast.Fprint(os.Stdout, f.Fset, f, astFilter); err != nil {
+			report(err)
+		}
+	}
+}
+
+// astFilter omits empty fields and the fields of a file that do not
+// describe its syntax.
+func astFilter(name string, v reflect.Value) bool {
+	switch name {
+	case "Fset", "Src", "Scope":
+		return false
+	}
+	if v.Kind() == reflect.Struct && v.IsZero() {
+		return false
+	}
+	return ast.NotNilFilter(name, v)
+}
@@ -0,0 +1,17 @@
+//go:build nollvm
+
+package main
+
+import "errors"
+
+// Without LLVM, arvo can still check, format and interpret programs.
+
+var errNoLLVM = errors.New("arvo was built without LLVM support (-tags nollvm)")
+
+func runIR(_ *command, _ []string) {
+	report(errNoLLVM)
+}
+
+func runBuild(_ *command, _ []string) {
+	report(errNoLLVM)
+}
@@ -0,0 +1,170 @@
+// Arvofmt formats arvo source files.
+//
+// Usage:
+//
+//	arvofmt [flags] [path ...]
+//
+// Without paths, it formats standard input. A directory is formatted
+// recursively, including every .arvo file in it. By default, arvofmt
+// prints the formatted source to standard output.
+//
+// The flags are:
+//
+//	-d
+//		Print diffs instead of the formatted source.
+//	-w
+//		Write the result to the source file instead of standard output.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/smasher164/arvo/format"
+)
+
+var (
+	doDiff = flag.Bool("d", false, "display diffs instead of rewriting files")
+	write  = flag.Bool("w", false, "write result to (source) file instead of stdout")
+)
+
+var exitCode = 0
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 2
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: arvofmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		if *write {
+			report(fmt.Errorf("arvofmt: cannot use -w with standard input"))
+		} else if err := processFile("<standard input>", os.Stdin, os.Stdout); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+	for _, path := range flag.Args() {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			report(err)
+		case info.IsDir():
+			walkDir(path)
+		default:
+			if err := processFile(path, nil, os.Stdout); err != nil {
+				report(err)
+			}
+		}
+	}
+	os.Exit(exitCode)
+}
+
+func walkDir(path string) {
+	filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			report(err)
+		} else if !d.IsDir() && strings.HasSuffix(d.Name(), ".arvo") {
+			if err := processFile(path, nil, os.Stdout); err != nil {
+				report(err)
+			}
+		}
+		return nil
+	})
+}
+
+// processFile formats the file at filename, reading it from in if non-nil.
+func processFile(filename string, in io.Reader, out io.Writer) error {
+	var src []byte
+	var err error
+	if in == nil {
+		src, err = os.ReadFile(filename)
+	} else {
+		src, err = io.ReadAll(in)
+	}
+	if err != nil {
+		return err
+	}
+	res, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	if bytes.Equal(src, res) && (*write || *doDiff) {
+		return nil
+	}
+	if *write {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filename, res, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if *doDiff {
+		data, err := diff(src, res, filename)
+		if err != nil {
+			return fmt.Errorf("computing diff: %s", err)
+		}
+		fmt.Fprintf(out, "diff -u %s %s\n", filepath.ToSlash(filename+".orig"), filepath.ToSlash(filename))
+		out.Write(data)
+	}
+	if !*write && !*doDiff {
+		_, err = out.Write(res)
+	}
+	return err
+}
+
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := os.CreateTemp("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// diff returns the output of diff -u between b1 and b2.
+func diff(b1, b2 []byte, filename string) ([]byte, error) {
+	f1, err := writeTempFile("arvofmt", b1)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTempFile("arvofmt", b2)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+	data, err := exec.Command("diff", "-u",
+		"--label", filepath.ToSlash(filename+".orig"),
+		"--label", filepath.ToSlash(filename),
+		f1, f2).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files don't match.
+		// Ignore that failure as long as we get output.
+		err = nil
+	}
+	return data, err
+}
@@ -0,0 +1,446 @@
+// Package constant implements the values of constant expressions: arvo
+// literals, the predeclared bools, and the operations on them. Numbers are
+// represented exactly: integers with arbitrary precision, and floats with
+// far more precision than a float64 has, so that the value of a constant
+// expression is only rounded once, where a program uses it.
+package constant
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/smasher164/arvo/scan"
+)
+
+// A Kind is the kind of a Value.
+type Kind int
+
+const (
+	// Unknown is the kind of values that are not known, such as that of a
+	// malformed literal.
+	Unknown Kind = iota
+	Bool
+	String
+	Int
+	Float
+)
+
+// prec is the precision of the mantissa of Float values.
+const prec = 512
+
+// A Value is the value of a constant.
+type Value interface {
+	// Kind returns the kind of the value.
+	Kind() Kind
+
+	// String returns a short, quoted and possibly rounded form of the
+	// value, suitable for messages.
+	String() string
+
+	implementsValue()
+}
+
+type (
+	unknownVal struct{}
+	boolVal    bool
+	stringVal  string
+	intVal     struct{ val *big.Int }
+	floatVal   struct{ val *big.Float }
+)
+
+func (unknownVal) Kind() Kind { return Unknown }
+func (boolVal) Kind() Kind    { return Bool }
+func (stringVal) Kind() Kind  { return String }
+func (intVal) Kind() Kind     { return Int }
+func (floatVal) Kind() Kind   { return Float }
+
+func (unknownVal) String() string { return "unknown" }
+func (x boolVal) String() string  { return strconv.FormatBool(bool(x)) }
+
+// String quotes the value as a string literal, shortening it if it is
+// long.
+func (x stringVal) String() string {
+	const max = 72
+	s := string(x)
+	if len(s) > max {
+		i := max - 3
+		for i > 0 && !utf8RuneStart(s[i]) {
+			i--
+		}
+		s = s[:i] + "..."
+	}
+	q := strconv.Quote(s)
+	q = strings.ReplaceAll(q[1:len(q)-1], `\"`, `"`)
+	return "'" + strings.ReplaceAll(q, "'", `\'`) + "'"
+}
+
+func (x intVal) String() string { return x.val.String() }
+
+func (x floatVal) String() string {
+	if f, _ := x.val.Float64(); !math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return x.val.Text('g', 6)
+}
+
+func (unknownVal) implementsValue() {}
+func (boolVal) implementsValue()    {}
+func (stringVal) implementsValue()  {}
+func (intVal) implementsValue()     {}
+func (floatVal) implementsValue()   {}
+
+func utf8RuneStart(b byte) bool { return b&0xC0 != 0x80 }
+
+func newFloat() *big.Float { return new(big.Float).SetPrec(prec) }
+
+// MakeUnknown returns the Unknown value.
+func MakeUnknown() Value { return unknownVal{} }
+
+// MakeBool returns the Bool value for b.
+func MakeBool(b bool) Value { return boolVal(b) }
+
+// MakeString returns the String value for s.
+func MakeString(s string) Value { return stringVal(s) }
+
+// MakeInt64 returns the Int value for x.
+func MakeInt64(x int64) Value { return intVal{big.NewInt(x)} }
+
+// MakeFloat64 returns the Float value for x, or Unknown if x is not finite.
+func MakeFloat64(x float64) Value {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return unknownVal{}
+	}
+	return floatVal{newFloat().SetFloat64(x)}
+}
+
+// MakeFromLiteral returns the value of the literal lit, a token of type
+// typ, which is one of scan.Int, scan.Float or scan.String. The value is
+// Unknown if lit is malformed.
+func MakeFromLiteral(lit string, typ scan.Type) Value {
+	switch typ {
+	case scan.Int:
+		if x, ok := new(big.Int).SetString(lit, 0); ok {
+			return intVal{x}
+		}
+	case scan.Float:
+		if x, _, err := newFloat().Parse(lit, 0); err == nil && !x.IsInf() {
+			return floatVal{x}
+		}
+	case scan.String:
+		if s, err := unquote(lit); err == nil {
+			return stringVal(s)
+		}
+	}
+	return unknownVal{}
+}
+
+// unquote returns the string denoted by a string literal.
+func unquote(lit string) (string, error) {
+	if len(lit) < 2 {
+		return "", strconv.ErrSyntax
+	}
+	if lit[0] == '`' {
+		return lit[1 : len(lit)-1], nil
+	}
+	s := lit[1 : len(lit)-1]
+	var b strings.Builder
+	for len(s) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(s, '\'')
+		if err != nil {
+			return "", err
+		}
+		if r < 0x80 || !multibyte {
+			b.WriteByte(byte(r))
+		} else {
+			b.WriteRune(r)
+		}
+		s = tail
+	}
+	return b.String(), nil
+}
+
+// BoolVal returns the bool that x holds, which must be a Bool or Unknown
+// value. It is false if x is Unknown.
+func BoolVal(x Value) bool {
+	switch x := x.(type) {
+	case boolVal:
+		return bool(x)
+	case unknownVal:
+		return false
+	}
+	panic(fmt.Sprintf("%v not a Bool", x))
+}
+
+// StringVal returns the string that x holds, which must be a String or
+// Unknown value. It is "" if x is Unknown.
+func StringVal(x Value) string {
+	switch x := x.(type) {
+	case stringVal:
+		return string(x)
+	case unknownVal:
+		return ""
+	}
+	panic(fmt.Sprintf("%v not a String", x))
+}
+
+// Int64Val returns the int64 value of x, which must be an Int or Unknown
+// value, and whether it is exact. If it is not, the result is undefined.
+func Int64Val(x Value) (int64, bool) {
+	switch x := x.(type) {
+	case intVal:
+		return x.val.Int64(), x.val.IsInt64()
+	case unknownVal:
+		return 0, false
+	}
+	panic(fmt.Sprintf("%v not an Int", x))
+}
+
+// Float64Val returns the nearest float64 to x, which must be an Int, Float
+// or Unknown value, and whether it is exact. A value too large in magnitude
+// for a float64 is rounded to an infinity.
+func Float64Val(x Value) (float64, bool) {
+	switch x := x.(type) {
+	case intVal:
+		f, acc := newFloat().SetInt(x.val).Float64()
+		return f, acc == big.Exact
+	case floatVal:
+		f, acc := x.val.Float64()
+		return f, acc == big.Exact
+	case unknownVal:
+		return 0, false
+	}
+	panic(fmt.Sprintf("%v not a Float", x))
+}
+
+// ToFloat converts x to a Float value if x is an Int or Float value, and
+// returns Unknown otherwise.
+func ToFloat(x Value) Value {
+	switch x := x.(type) {
+	case intVal:
+		return floatVal{newFloat().SetInt(x.val)}
+	case floatVal:
+		return x
+	}
+	return unknownVal{}
+}
+
+// Sign returns -1, 0 or 1 as x, which must be an Int, Float or Unknown
+// value, is negative, zero or positive. It is 1 if x is Unknown, so that
+// an unknown value is not mistaken for zero.
+func Sign(x Value) int {
+	switch x := x.(type) {
+	case intVal:
+		return x.val.Sign()
+	case floatVal:
+		return x.val.Sign()
+	case unknownVal:
+		return 1
+	}
+	panic(fmt.Sprintf("%v not numeric", x))
+}
+
+// match converts x and y to the same kind: an Int meeting a Float becomes a
+// Float.
+func match(x, y Value) (Value, Value) {
+	if x.Kind() == Int && y.Kind() == Float {
+		return ToFloat(x), y
+	}
+	if x.Kind() == Float && y.Kind() == Int {
+		return x, ToFloat(y)
+	}
+	return x, y
+}
+
+// UnaryOp returns the result of the unary operation op applied to x. The
+// operator is one of +, -, ^ for Int values, + and - for Float values, and
+// ! for Bool values. The result is Unknown if x is.
+func UnaryOp(op scan.Type, x Value) Value {
+	switch x := x.(type) {
+	case unknownVal:
+		return x
+	case boolVal:
+		if op == scan.Not {
+			return !x
+		}
+	case intVal:
+		switch op {
+		case scan.Add:
+			return x
+		case scan.Sub:
+			return intVal{new(big.Int).Neg(x.val)}
+		case scan.Xor:
+			return intVal{new(big.Int).Not(x.val)}
+		}
+	case floatVal:
+		switch op {
+		case scan.Add:
+			return x
+		case scan.Sub:
+			return floatVal{newFloat().Neg(x.val)}
+		}
+	}
+	panic(fmt.Sprintf("invalid unary operation %s%v", op, x))
+}
+
+// BinaryOp returns the result of the binary operation x op y. Quo of two
+// Int values is integer division, truncated toward zero, and Rem is its
+// remainder; the caller must ensure that y is not zero. An Int operand
+// meeting a Float is converted to a Float. The result is Unknown if either
+// operand is.
+func BinaryOp(x Value, op scan.Type, y Value) Value {
+	x, y = match(x, y)
+	switch x := x.(type) {
+	case unknownVal:
+		return x
+	case boolVal:
+		if y, ok := y.(boolVal); ok {
+			switch op {
+			case scan.Land:
+				return x && y
+			case scan.Lor:
+				return x || y
+			}
+		}
+	case stringVal:
+		if y, ok := y.(stringVal); ok && op == scan.Add {
+			return x + y
+		}
+	case intVal:
+		y, ok := y.(intVal)
+		if !ok {
+			break
+		}
+		z := new(big.Int)
+		switch op {
+		case scan.Add:
+			z.Add(x.val, y.val)
+		case scan.Sub:
+			z.Sub(x.val, y.val)
+		case scan.Mul:
+			z.Mul(x.val, y.val)
+		case scan.Quo:
+			z.Quo(x.val, y.val)
+		case scan.Rem:
+			z.Rem(x.val, y.val)
+		case scan.And:
+			z.And(x.val, y.val)
+		case scan.Or:
+			z.Or(x.val, y.val)
+		case scan.Xor:
+			z.Xor(x.val, y.val)
+		case scan.AndNot:
+			z.AndNot(x.val, y.val)
+		default:
+			panic(fmt.Sprintf("invalid binary operation %v %s %v", x, op, y))
+		}
+		return intVal{z}
+	case floatVal:
+		y, ok := y.(floatVal)
+		if !ok {
+			break
+		}
+		z := newFloat()
+		switch op {
+		case scan.Add:
+			z.Add(x.val, y.val)
+		case scan.Sub:
+			z.Sub(x.val, y.val)
+		case scan.Mul:
+			z.Mul(x.val, y.val)
+		case scan.Quo:
+			z.Quo(x.val, y.val)
+		default:
+			panic(fmt.Sprintf("invalid binary operation %v %s %v", x, op, y))
+		}
+		return floatVal{z}
+	}
+	if y.Kind() == Unknown {
+		return y
+	}
+	panic(fmt.Sprintf("invalid binary operation %v %s %v", x, op, y))
+}
+
+// Shift returns the result of the shift x op s, where op is << or >> and x
+// is an Int or Unknown value. A right shift rounds toward negative
+// infinity, like >> on a num.
+func Shift(x Value, op scan.Type, s uint) Value {
+	switch x := x.(type) {
+	case unknownVal:
+		return x
+	case intVal:
+		switch op {
+		case scan.Shl:
+			return intVal{new(big.Int).Lsh(x.val, s)}
+		case scan.Shr:
+			return intVal{new(big.Int).Rsh(x.val, s)}
+		}
+	}
+	panic(fmt.Sprintf("invalid shift %v %s %d", x, op, s))
+}
+
+// Compare returns the result of the comparison x op y. Bool values may only
+// be compared for equality. The result is false if either operand is
+// Unknown.
+func Compare(x Value, op scan.Type, y Value) bool {
+	x, y = match(x, y)
+	var c int
+	switch x := x.(type) {
+	case unknownVal:
+		return false
+	case boolVal:
+		y, ok := y.(boolVal)
+		if !ok {
+			break
+		}
+		switch op {
+		case scan.Eql:
+			return x == y
+		case scan.Neq:
+			return x != y
+		}
+		panic(fmt.Sprintf("invalid comparison %v %s %v", x, op, y))
+	case stringVal:
+		y, ok := y.(stringVal)
+		if !ok {
+			break
+		}
+		c = strings.Compare(string(x), string(y))
+	case intVal:
+		y, ok := y.(intVal)
+		if !ok {
+			break
+		}
+		c = x.val.Cmp(y.val)
+	case floatVal:
+		y, ok := y.(floatVal)
+		if !ok {
+			break
+		}
+		c = x.val.Cmp(y.val)
+	}
+	switch y.Kind() {
+	case Unknown:
+		return false
+	case x.Kind():
+	default:
+		panic(fmt.Sprintf("invalid comparison %v %s %v", x, op, y))
+	}
+	switch op {
+	case scan.Eql:
+		return c == 0
+	case scan.Neq:
+		return c != 0
+	case scan.Lss:
+		return c < 0
+	case scan.Leq:
+		return c <= 0
+	case scan.Gtr:
+		return c > 0
+	case scan.Geq:
+		return c >= 0
+	}
+	panic(fmt.Sprintf("invalid comparison %v %s %v", x, op, y))
+}
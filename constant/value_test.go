@@ -0,0 +1,138 @@
+package constant
+
+import (
+	"math"
+	"testing"
+
+	"github.com/smasher164/arvo/scan"
+)
+
+var literalCases = []struct {
+	lit  string
+	typ  scan.Type
+	kind Kind
+	str  string
+}{
+	{"42", scan.Int, Int, "42"},
+	{"0b1010", scan.Int, Int, "10"},
+	{"0o17", scan.Int, Int, "15"},
+	{"017", scan.Int, Int, "15"},
+	{"0X1F", scan.Int, Int, "31"},
+	{"1_000_000", scan.Int, Int, "1000000"},
+	{"123456789012345678901234567890", scan.Int, Int, "123456789012345678901234567890"},
+	{"0x", scan.Int, Unknown, "unknown"},
+	{"1.5", scan.Float, Float, "1.5"},
+	{"089.5", scan.Float, Float, "89.5"},
+	{"0x1p-2", scan.Float, Float, "0.25"},
+	{"0x1.8P+3", scan.Float, Float, "12"},
+	{"1_0.2_5e1", scan.Float, Float, "102.5"},
+	{"1e400", scan.Float, Float, "1e+400"},
+	{"1e", scan.Float, Unknown, "unknown"},
+	{`'a\tb\''`, scan.String, String, `'a\tb\''`},
+	{"`a\\n`", scan.String, String, `'a\\n'`},
+	{`'\q'`, scan.String, Unknown, "unknown"},
+}
+
+func TestMakeFromLiteral(t *testing.T) {
+	for i, tc := range literalCases {
+		x := MakeFromLiteral(tc.lit, tc.typ)
+		if x.Kind() != tc.kind || x.String() != tc.str {
+			t.Errorf("case #%d, %s: got %v %s, want %v %s", i, tc.lit, x.Kind(), x, tc.kind, tc.str)
+		}
+	}
+}
+
+func TestConversions(t *testing.T) {
+	if n, ok := Int64Val(MakeFromLiteral("0x7fff_ffff_ffff_ffff", scan.Int)); !ok || n != math.MaxInt64 {
+		t.Errorf("got %d %v, want MaxInt64 exactly", n, ok)
+	}
+	if _, ok := Int64Val(MakeFromLiteral("0x8000_0000_0000_0000", scan.Int)); ok {
+		t.Errorf("2**63 converts exactly to int64")
+	}
+	if f, ok := Float64Val(MakeFromLiteral("0.1", scan.Float)); f != 0.1 || ok {
+		t.Errorf("got %v %v, want 0.1 inexactly", f, ok)
+	}
+	if f, _ := Float64Val(MakeFromLiteral("1e400", scan.Float)); !math.IsInf(f, 1) {
+		t.Errorf("got %v, want +Inf", f)
+	}
+	x := ToFloat(MakeFromLiteral("3", scan.Int))
+	if f, ok := Float64Val(x); x.Kind() != Float || f != 3 || !ok {
+		t.Errorf("got %v %v %v, want float 3 exactly", x.Kind(), f, ok)
+	}
+	if s := StringVal(MakeFromLiteral(`'hé'`, scan.String)); s != "hé" {
+		t.Errorf("got %q, want %q", s, "hé")
+	}
+}
+
+func lit(s string) Value {
+	if s[0] == '\'' {
+		return MakeFromLiteral(s, scan.String)
+	}
+	if _, ok := Int64Val(MakeFromLiteral(s, scan.Int)); ok {
+		return MakeFromLiteral(s, scan.Int)
+	}
+	return MakeFromLiteral(s, scan.Float)
+}
+
+var opCases = []struct {
+	x    string
+	op   scan.Type
+	y    string
+	want string
+}{
+	{"9223372036854775807", scan.Add, "1", "9223372036854775808"},
+	{"-7", scan.Quo, "2", "-3"},
+	{"-7", scan.Rem, "2", "-1"},
+	{"12", scan.AndNot, "10", "4"},
+	{"7", scan.Quo, "2.0", "3.5"},
+	{"0.1", scan.Add, "0.2", "0.3"},
+	{"1e300", scan.Mul, "1e300", "1e+600"},
+	{"'a'", scan.Add, "'b'", "'ab'"},
+}
+
+func TestBinaryOp(t *testing.T) {
+	for i, tc := range opCases {
+		if z := BinaryOp(lit(tc.x), tc.op, lit(tc.y)); z.String() != tc.want {
+			t.Errorf("case #%d, %s %s %s: got %s, want %s", i, tc.x, tc.op, tc.y, z, tc.want)
+		}
+	}
+	if z := BinaryOp(MakeBool(true), scan.Land, MakeBool(false)); BoolVal(z) {
+		t.Errorf("true && false is true")
+	}
+	if z := BinaryOp(MakeInt64(1), scan.Add, MakeUnknown()); z.Kind() != Unknown {
+		t.Errorf("got %v, want unknown", z)
+	}
+}
+
+func TestUnaryOp(t *testing.T) {
+	if z := UnaryOp(scan.Sub, lit("1.5")); z.String() != "-1.5" {
+		t.Errorf("got %s, want -1.5", z)
+	}
+	if z := UnaryOp(scan.Xor, lit("5")); z.String() != "-6" {
+		t.Errorf("got %s, want -6", z)
+	}
+	if z := UnaryOp(scan.Not, MakeBool(false)); !BoolVal(z) {
+		t.Errorf("!false is false")
+	}
+}
+
+func TestShift(t *testing.T) {
+	if z := Shift(lit("1"), scan.Shl, 70); z.String() != "1180591620717411303424" {
+		t.Errorf("got %s, want 2**70", z)
+	}
+	if z := Shift(lit("-7"), scan.Shr, 1); z.String() != "-4" {
+		t.Errorf("got %s, want -4", z)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	if !Compare(lit("1"), scan.Lss, lit("1.5")) {
+		t.Errorf("1 < 1.5 is false")
+	}
+	if !Compare(lit("'ab'"), scan.Gtr, lit("'a'")) {
+		t.Errorf("'ab' > 'a' is false")
+	}
+	if Compare(MakeUnknown(), scan.Eql, MakeUnknown()) {
+		t.Errorf("unknown == unknown is true")
+	}
+}
@@ -0,0 +1,687 @@
+// Package format implements canonical formatting of arvo source.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/parse"
+	"github.com/smasher164/arvo/scan"
+)
+
+type source struct {
+	*bytes.Reader
+	name string
+}
+
+func (s source) Name() string { return s.name }
+
+// Source formats src in canonical arvo style. src must be a syntactically
+// correct source file.
+func Source(src []byte) ([]byte, error) {
+	f := &ast.File{Src: source{bytes.NewReader(src), "<input>"}}
+	if err := parse.File(f, parse.ParseComments); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := Node(&buf, f.Fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Node formats node in canonical arvo style and writes the result to w.
+// Comments attached to the nodes are printed in their relative positions.
+// Line breaks are taken from the positions in fset, so node must have been
+// produced by the parser.
+func Node(w io.Writer, fset *scan.FileSet, node ast.Node) error {
+	p := &printer{fset: fset, bol: true}
+	switch n := node.(type) {
+	case *ast.File:
+		p.file(n)
+	case ast.Stmt:
+		p.stmt(n)
+	case ast.Expr:
+		p.expr(n)
+	case ast.Spec:
+		p.spec(n)
+	default:
+		return errors.New("format: unsupported node type")
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if p.buf.Len() > 0 {
+		p.newline()
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+var errBad = errors.New("format: cannot format source with syntax errors")
+
+type below struct {
+	line int // last line of the node the comments are below
+	g    *ast.CommentGroup
+}
+
+type printer struct {
+	fset   *scan.FileSet
+	buf    bytes.Buffer
+	indent int
+	bol    bool // at the beginning of a line
+	cont   bool // the current line continues the previous one
+	nl     bool // a line comment was written; what follows needs a new line
+	space  bool // a block comment was written; what follows needs a space
+	after  []*ast.Comment
+	below  []below
+	err    error
+}
+
+func (p *printer) line(pos scan.Pos) int {
+	return p.fset.Position(pos).Line
+}
+
+// text writes s, after the block comments trailing the last node, so that
+// they stay next to the node rather than follow a separator.
+func (p *printer) text(s string) {
+	for len(p.after) > 0 && !isLine(p.after[0]) {
+		p.trailing(p.after[0])
+		p.after = p.after[1:]
+	}
+	p.write(s)
+}
+
+// write writes s, indenting it if it starts a line.
+func (p *printer) write(s string) {
+	if p.nl {
+		p.buf.WriteByte('\n')
+		p.bol, p.cont, p.nl = true, true, false
+	}
+	if p.bol {
+		n := p.indent
+		if p.cont {
+			n++
+		}
+		p.buf.WriteString(strings.Repeat("\t", n))
+		p.bol, p.space = false, false
+	}
+	if p.space && s != "" && !strings.ContainsAny(s[:1], " ,;:)]}") {
+		p.buf.WriteByte(' ')
+	}
+	p.space = false
+	p.buf.WriteString(s)
+}
+
+// linebreak ends the current line without flushing pending comments.
+func (p *printer) linebreak() {
+	p.buf.WriteByte('\n')
+	p.bol, p.nl, p.space = true, false, false
+}
+
+// newline flushes every pending comment and ends the current line.
+func (p *printer) newline() {
+	p.flushAfter()
+	for _, b := range p.below {
+		last := b.line
+		for _, c := range b.g.List {
+			p.linebreak()
+			if p.line(c.Pos()) > last+1 {
+				p.linebreak()
+			}
+			p.text(c.Text)
+			last = p.line(c.End())
+		}
+	}
+	p.below = p.below[:0]
+	p.linebreak()
+	p.cont = false
+}
+
+// flushAfter writes the comments trailing the last node on its line.
+func (p *printer) flushAfter() {
+	for _, c := range p.after {
+		p.trailing(c)
+	}
+	p.after = p.after[:0]
+}
+
+// trailing writes c, a comment that follows text on its line, separated
+// from the text by a single space.
+func (p *printer) trailing(c *ast.Comment) {
+	if b := p.buf.Bytes(); p.nl || len(b) == 0 || b[len(b)-1] != ' ' {
+		p.write(" ")
+	}
+	p.write(c.Text)
+	if isLine(c) {
+		p.nl = true
+	} else {
+		p.space = true
+	}
+}
+
+// isLine reports whether c is a line comment, which ends its line.
+func isLine(c *ast.Comment) bool {
+	return strings.HasPrefix(c.Text, "//")
+}
+
+func relComments(n ast.Node) *ast.RelComments {
+	if r := ast.CommentsField(n); r != nil {
+		return *r
+	}
+	return nil
+}
+
+// enter writes the comments above and before n.
+func (p *printer) enter(n ast.Node) {
+	p.flushAfter()
+	rel := relComments(n)
+	if rel == nil {
+		return
+	}
+	if g := rel.List[ast.Above]; g != nil {
+		if !p.bol {
+			p.linebreak()
+			p.cont = true
+		}
+		last := 0
+		for i, c := range g.List {
+			if i > 0 && p.line(c.Pos()) > last+1 {
+				p.linebreak()
+			}
+			p.text(c.Text)
+			p.linebreak()
+			last = p.line(c.End())
+		}
+	}
+	if g := rel.List[ast.Before]; g != nil {
+		for _, c := range g.List {
+			p.text(c.Text + " ")
+		}
+	}
+}
+
+// leave queues the comments after and below n.
+func (p *printer) leave(n ast.Node) {
+	rel := relComments(n)
+	if rel == nil {
+		return
+	}
+	if g := rel.List[ast.After]; g != nil {
+		p.after = append(p.after, g.List...)
+	}
+	if g := rel.List[ast.Below]; g != nil {
+		if b, ok := n.(*ast.BlockStmt); ok && inside(b, g) {
+			// printed by block
+			return
+		}
+		if c, ok := n.(*ast.CaseClause); ok && len(c.Body) == 0 {
+			// printed as the body of the clause
+			return
+		}
+		p.below = append(p.below, below{p.line(n.End()), g})
+	}
+}
+
+// span returns the first and last lines of n, including its comments.
+func (p *printer) span(n ast.Node) (first, last int) {
+	first, last = p.line(n.Pos()), p.line(n.End())
+	if rel := relComments(n); rel != nil {
+		if g := rel.List[ast.Above]; g != nil {
+			first = p.line(g.Pos())
+		}
+		if g := rel.List[ast.Below]; g != nil {
+			last = p.line(g.End())
+		}
+	}
+	return first, last
+}
+
+func (p *printer) file(f *ast.File) {
+	var items []ast.Node
+	if f.Package.Package.Pos.IsValid() {
+		items = append(items, &f.Package)
+	}
+	for _, d := range f.Decls {
+		items = append(items, d)
+	}
+	for _, s := range f.Stmts {
+		if _, ok := s.(*ast.EmptyStmt); ok {
+			continue
+		}
+		items = append(items, s)
+	}
+	if len(items) == 0 {
+		// only comments, if anything
+		for i, g := range f.Comments {
+			if i > 0 {
+				p.linebreak()
+			}
+			for _, c := range g.List {
+				p.text(c.Text)
+				p.linebreak()
+			}
+		}
+		return
+	}
+	for i, n := range items {
+		if i > 0 {
+			p.newline()
+			if _, last := p.span(items[i-1]); p.first(n) > last+1 {
+				p.linebreak()
+			}
+		}
+		switch n := n.(type) {
+		case *ast.PackageDecl:
+			p.enter(n)
+			p.text("pkg " + n.Name)
+			p.leave(n)
+		case *ast.GenDecl:
+			p.genDecl(n)
+		case ast.Stmt:
+			p.stmt(n)
+		}
+	}
+}
+
+func (p *printer) first(n ast.Node) int {
+	first, _ := p.span(n)
+	return first
+}
+
+func (p *printer) genDecl(d *ast.GenDecl) {
+	p.enter(d)
+	p.text(d.Keyword.Type.String())
+	if d.Lparen.Pos.IsValid() {
+		p.text(" (")
+		p.indent++
+		for _, s := range d.Specs {
+			p.newline()
+			p.spec(s)
+		}
+		p.indent--
+		p.newline()
+		p.text(")")
+	} else if len(d.Specs) > 0 {
+		p.text(" ")
+		p.spec(d.Specs[0])
+	}
+	p.leave(d)
+}
+
+func (p *printer) spec(s ast.Spec) {
+	p.enter(s)
+	switch s := s.(type) {
+	case *ast.UseSpec:
+		if s.Name != nil {
+			p.text(s.Name.Name.Lit + " ")
+		}
+		p.text(s.Path.Lit)
+	case *ast.ValueSpec:
+		for i, id := range s.Names {
+			if i > 0 {
+				p.text(", ")
+			}
+			p.expr(id)
+		}
+		if len(s.Values) > 0 {
+			p.text(" = ")
+			p.exprList(s.Values)
+		}
+	}
+	p.leave(s)
+}
+
+func (p *printer) block(b *ast.BlockStmt) {
+	p.text("{")
+	if rel := relComments(b); rel != nil && rel.List[ast.Trailing] != nil {
+		for _, c := range rel.List[ast.Trailing].List {
+			p.trailing(c)
+		}
+	}
+	empty := true
+	for _, s := range b.List {
+		if _, ok := s.(*ast.EmptyStmt); !ok {
+			empty = false
+		}
+	}
+	if empty {
+		if rel := relComments(b); rel != nil && inside(b, rel.List[ast.Below]) {
+			p.indent++
+			for _, c := range rel.List[ast.Below].List {
+				p.newline()
+				p.text(c.Text)
+			}
+			p.indent--
+			p.newline()
+		} else if p.nl {
+			p.newline()
+		} else if p.space {
+			p.write(" ")
+		}
+		p.text("}")
+		return
+	}
+	p.indent++
+	p.newline()
+	p.stmtList(b.List)
+	p.indent--
+	p.newline()
+	p.text("}")
+}
+
+// inside reports whether g lies between the braces of b, which happens
+// when b has no statements for g to be attached to.
+func inside(b *ast.BlockStmt, g *ast.CommentGroup) bool {
+	return g != nil && b.Lbrace.Pos < g.Pos() && g.End() <= b.Rbrace.Pos
+}
+
+func (p *printer) stmtList(list []ast.Stmt) {
+	var prev ast.Stmt
+	for _, s := range list {
+		if _, ok := s.(*ast.EmptyStmt); ok {
+			continue
+		}
+		if prev != nil {
+			p.newline()
+			if _, last := p.span(prev); p.first(s) > last+1 {
+				p.linebreak()
+			}
+		}
+		p.stmt(s)
+		prev = s
+	}
+}
+
+func (p *printer) stmt(s ast.Stmt) {
+	p.enter(s)
+	switch s := s.(type) {
+	case *ast.BadStmt:
+		p.err = errBad
+	case *ast.EmptyStmt:
+	case *ast.DeclStmt:
+		p.genDecl(s.Decl)
+	case *ast.LabeledStmt:
+		if p.indent > 0 {
+			p.indent--
+			p.text(s.Label.Name.Lit + ":")
+			p.indent++
+		} else {
+			p.text(s.Label.Name.Lit + ":")
+		}
+		if _, ok := s.Stmt.(*ast.EmptyStmt); !ok {
+			p.newline()
+			p.stmt(s.Stmt)
+		}
+	case *ast.ExprStmt:
+		p.expr(s.X)
+	case *ast.IncDecStmt:
+		p.expr(s.X)
+		p.text(s.Tok.Type.String())
+	case *ast.AssignStmt:
+		p.exprList(s.Lhs)
+		p.text(" " + s.Tok.Type.String() + " ")
+		p.exprList(s.Rhs)
+	case *ast.ReturnStmt:
+		p.text("return")
+		if len(s.Results) > 0 {
+			p.text(" ")
+			p.exprList(s.Results)
+		}
+	case *ast.BranchStmt:
+		p.text(s.Tok.Type.String())
+		if s.Label != nil {
+			p.text(" ")
+			p.expr(s.Label)
+		}
+	case *ast.BlockStmt:
+		p.block(s)
+	case *ast.IfStmt:
+		p.text("if ")
+		if s.Init != nil {
+			p.stmt(s.Init)
+			p.text("; ")
+		}
+		p.expr(s.Cond)
+		p.text(" ")
+		p.stmt(s.Body)
+		if s.Else != nil {
+			p.text(" else ")
+			p.stmt(s.Else)
+		}
+	case *ast.SwitchStmt:
+		p.text("switch ")
+		if s.Init != nil {
+			p.stmt(s.Init)
+			p.text("; ")
+		}
+		if s.Tag != nil {
+			p.expr(s.Tag)
+			p.text(" ")
+		}
+		p.enter(s.Body)
+		p.text("{")
+		for _, c := range s.Body.List {
+			p.newline()
+			p.stmt(c)
+		}
+		p.newline()
+		p.text("}")
+		p.leave(s.Body)
+	case *ast.CaseClause:
+		if s.Case.Type == scan.Case {
+			p.text("case ")
+			p.exprList(s.List)
+		} else {
+			p.text("default")
+		}
+		p.text(":")
+		p.indent++
+		if len(s.Body) > 0 {
+			p.newline()
+			p.stmtList(s.Body)
+		} else if rel := relComments(s); rel != nil && rel.List[ast.Below] != nil {
+			// the comments stand in for the body
+			for _, c := range rel.List[ast.Below].List {
+				p.newline()
+				p.text(c.Text)
+			}
+		}
+		p.indent--
+	case *ast.ForStmt:
+		p.text("for ")
+		if s.Init != nil || s.Post != nil {
+			if s.Init != nil {
+				p.stmt(s.Init)
+			}
+			p.text("; ")
+			if s.Cond != nil {
+				p.expr(s.Cond)
+			}
+			p.text("; ")
+			if s.Post != nil {
+				p.stmt(s.Post)
+				p.text(" ")
+			}
+		} else if s.Cond != nil {
+			p.expr(s.Cond)
+			p.text(" ")
+		}
+		p.stmt(s.Body)
+	case *ast.InStmt:
+		p.text("for ")
+		var lhs []ast.Expr
+		for _, x := range []ast.Expr{s.Index, s.Key, s.Value} {
+			if x != nil {
+				lhs = append(lhs, x)
+			}
+		}
+		if len(lhs) > 0 {
+			p.exprList(lhs)
+			if s.Tok.Type != scan.In {
+				p.text(" " + s.Tok.Type.String())
+			}
+			p.text(" ")
+		}
+		p.text("in ")
+		p.expr(s.X)
+		p.text(" ")
+		p.stmt(s.Body)
+	}
+	p.leave(s)
+}
+
+func (p *printer) exprList(list []ast.Expr) {
+	for i, x := range list {
+		if i > 0 {
+			p.text(", ")
+		}
+		p.expr(x)
+	}
+}
+
+// broken reports whether a list of elements between the delimiters open
+// and close spans several lines, in which case it is printed one element
+// per line.
+func (p *printer) broken(open, close scan.Token, list []ast.Expr) bool {
+	last := p.line(open.Pos)
+	for _, x := range list {
+		if p.first(x) > last {
+			return true
+		}
+		_, last = p.span(x)
+	}
+	return len(list) > 0 && p.line(close.Pos) > last
+}
+
+// elements prints a delimited list of elements.
+func (p *printer) elements(open, close scan.Token, list []ast.Expr, ellipsis scan.Token) {
+	p.text(open.Type.String())
+	if !p.broken(open, close, list) {
+		p.exprList(list)
+		if ellipsis.Pos.IsValid() {
+			p.text("...")
+		}
+		p.text(close.Type.String())
+		return
+	}
+	p.indent++
+	for i, x := range list {
+		p.newline()
+		p.expr(x)
+		if i == len(list)-1 && ellipsis.Pos.IsValid() {
+			p.text("...")
+		}
+		p.text(",")
+	}
+	p.indent--
+	p.newline()
+	p.text(close.Type.String())
+}
+
+// merges reports whether the unary operator op followed by x would be
+// scanned as a different token.
+func merges(op scan.Type, x ast.Expr) bool {
+	u, ok := x.(*ast.UnaryExpr)
+	if !ok {
+		return false
+	}
+	switch op {
+	case scan.Add, scan.Sub:
+		return u.Op.Type == op
+	case scan.And:
+		return u.Op.Type == scan.Xor
+	}
+	return false
+}
+
+func (p *printer) expr(x ast.Expr) {
+	p.enter(x)
+	switch x := x.(type) {
+	case *ast.BadExpr:
+		p.err = errBad
+	case *ast.Ident:
+		p.text(x.Name.Lit)
+	case *ast.BasicLit:
+		p.text(x.Value.Lit)
+	case *ast.ArrayLit:
+		p.text("a")
+	case *ast.RecordLit:
+		p.text("r")
+	case *ast.FunDef:
+		p.text("fun")
+		if x.Name != nil {
+			p.text(" " + x.Name.Name.Lit)
+		}
+		p.text("(")
+		for i, pr := range x.Params {
+			if i > 0 {
+				p.text(", ")
+			}
+			p.enter(pr)
+			if pr.Ellipsis.Pos.IsValid() {
+				p.text("...")
+			}
+			p.text(pr.Name.Name.Lit)
+			p.leave(pr)
+		}
+		p.text(") ")
+		p.stmt(x.Body)
+	case *ast.ParenExpr:
+		p.text("(")
+		p.expr(x.X)
+		p.text(")")
+	case *ast.UnaryExpr:
+		p.text(x.Op.Type.String())
+		if merges(x.Op.Type, x.X) {
+			p.text(" ")
+		}
+		p.expr(x.X)
+	case *ast.BinaryExpr:
+		p.expr(x.X)
+		p.text(" " + x.Op.Type.String() + " ")
+		p.expr(x.Y)
+	case *ast.SelectorExpr:
+		p.expr(x.X)
+		p.text(".")
+		p.expr(x.Sel)
+	case *ast.IndexExpr:
+		p.expr(x.X)
+		if x.Backwards {
+			p.text("[[")
+			p.expr(x.Index)
+			p.text("]]")
+		} else {
+			p.text("[")
+			p.expr(x.Index)
+			p.text("]")
+		}
+	case *ast.SliceExpr:
+		p.expr(x.X)
+		p.text("[")
+		if x.Low != nil {
+			p.expr(x.Low)
+		}
+		p.text(":")
+		if x.High != nil {
+			p.expr(x.High)
+		}
+		p.text("]")
+	case *ast.KeyValueExpr:
+		p.expr(x.Key)
+		p.text(": ")
+		p.expr(x.Value)
+	case *ast.CompositeLit:
+		if x.Type != nil {
+			p.expr(x.Type)
+		}
+		p.elements(x.Lbrace, x.Rbrace, x.Elts, scan.Token{})
+	case *ast.CallExpr:
+		p.expr(x.Fun)
+		p.elements(x.Lparen, x.Rparen, x.Args, x.Ellipsis)
+	}
+	p.leave(x)
+}
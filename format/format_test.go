@@ -0,0 +1,59 @@
+package format
+
+import "testing"
+
+var cases = []struct {
+	input, want string
+}{
+	{"x=1+2*3", "x = 1 + 2 * 3\n"},
+	{"x = - -1", "x = - -1\n"},
+	{"fun f(a,...b){return a,b}", "fun f(a, ...b) {\n\treturn a, b\n}\n"},
+	{"x = a{1,2}\ny = r{a: 1, b: 'c'}", "x = a{1, 2}\ny = r{a: 1, b: 'c'}\n"},
+	{"x = a{1,\n2}", "x = a{\n\t1,\n\t2,\n}\n"},
+	{"y = x[[1]]+x[:2][0]", "y = x[[1]] + x[:2][0]\n"},
+	{"for k, v in x { print(k) }", "for k, v in x {\n\tprint(k)\n}\n"},
+	{"for k = in x {}", "for k = in x {}\n"},
+	{"for i = 0; i < 3; i++ {}\nfor {}", "for i = 0; i < 3; i++ {}\nfor {}\n"},
+	{"L:\nfor {\nM: for {}\n}", "L:\nfor {\nM:\n\tfor {}\n}\n"},
+	{"switch x {\ncase 1:\nx++\ndefault:\n}", "switch x {\ncase 1:\n\tx++\ndefault:\n}\n"},
+	{"if x { y = 1 } else { y = 2 }", "if x {\n\ty = 1\n} else {\n\ty = 2\n}\n"},
+	{"f(a, b...)", "f(a, b...)\n"},
+
+	// blank lines are kept, but collapsed
+	{"x = 1\n\n\n\ny = 2", "x = 1\n\ny = 2\n"},
+
+	// comments stay where they were
+	{"// doc\nx = 1 // after\n/* before */ y = 2", "// doc\nx = 1 // after\n/* before */ y = 2\n"},
+	{"x = a{\n1, // one\n2,\n}", "x = a{\n\t1, // one\n\t2,\n}\n"},
+	{"fun f() {\n// nothing\n}", "fun f() {\n\t// nothing\n}\n"},
+	{"fun f(a) { // note\nreturn a\n}", "fun f(a) { // note\n\treturn a\n}\n"},
+	{"if x { // cond\ny = 1 }\nfor { /* spin */ }", "if x { // cond\n\ty = 1\n}\nfor { /* spin */ }\n"},
+	{"if x { // cond\n}", "if x { // cond\n}\n"},
+	{"x = 1\n\n// below\n", "x = 1\n\n// below\n"},
+	{"x = a{'a': 1, /* inline */ 'b': 2}", "x = a{'a': 1, /* inline */ 'b': 2}\n"},
+	{"x = f(1,/* two */2)\ny = 1 +/* c */2", "x = f(1, /* two */ 2)\ny = 1 + /* c */ 2\n"},
+	{"x = f(1 /* one */,2)\ny = 1/* c */+ 2", "x = f(1 /* one */, 2)\ny = 1 /* c */ + 2\n"},
+	{"x = 1 + // plus\n2", "x = 1 + // plus\n\t2\n"},
+}
+
+func TestSource(t *testing.T) {
+	for _, tc := range cases {
+		got, err := Source([]byte(tc.input))
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%q:\ngot:\n%s\nwant:\n%s", tc.input, got, tc.want)
+			continue
+		}
+		again, err := Source(got)
+		if err != nil {
+			t.Errorf("%q: reformatting: %v", tc.input, err)
+			continue
+		}
+		if string(again) != string(got) {
+			t.Errorf("%q: not idempotent:\n%s\nbecame:\n%s", tc.input, got, again)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Package parsetest provides helpers for the tests of packages that work on
+// parsed arvo programs.
+package parsetest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/parse"
+)
+
+type source struct {
+	*strings.Reader
+}
+
+func (source) Name() string { return "test.arvo" }
+
+// File parses src as a file named test.arvo, whose package scope is
+// enclosed by outer if it is not nil. The file is parsed with
+// parse.DeclarationErrors, as the type checker requires. File fails t if
+// src cannot be parsed.
+func File(t testing.TB, src string, outer *ast.Scope) *ast.File {
+	t.Helper()
+	f := &ast.File{Src: source{strings.NewReader(src)}}
+	if outer != nil {
+		f.Scope = ast.NewScope(outer)
+	}
+	if err := parse.File(f, parse.DeclarationErrors); err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	return f
+}
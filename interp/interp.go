@@ -0,0 +1,861 @@
+// Package interp evaluates type-checked arvo programs by walking their
+// syntax trees. It defines the reference semantics that the compiled
+// backends are expected to follow.
+package interp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/constant"
+	"github.com/smasher164/arvo/scan"
+	"github.com/smasher164/arvo/types"
+)
+
+// An Interpreter runs the program in Config, which must have been checked
+// by types.Infer without errors.
+type Interpreter struct {
+	Config types.Config
+	Stdout io.Writer // output of printf; os.Stdout if nil
+}
+
+// Exit is returned by Run when the program calls exit.
+type Exit int
+
+func (e Exit) Error() string {
+	return "exit status " + strconv.Itoa(int(e))
+}
+
+// A RuntimeError is returned by Run when the program performs an invalid
+// operation, such as indexing an array with a missing key.
+type RuntimeError struct {
+	Pos scan.Position
+	Msg string
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Pos.String() + ": runtime error: " + e.Msg
+}
+
+// Run executes the statements of the program's files in order. The named
+// functions of every file are bound first, so that a file can call the
+// functions of the files that follow it.
+func (in *Interpreter) Run() (err error) {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+		case Exit:
+			err = r
+		case *RuntimeError:
+			err = r
+		default:
+			panic(r)
+		}
+	}()
+	if in.Stdout == nil {
+		in.Stdout = os.Stdout
+	}
+	e := newEnv(nil)
+	files := in.Config.Files()
+	for _, f := range files {
+		in.bindAll(f.Stmts, e)
+	}
+	for _, f := range files {
+		for _, s := range f.Stmts {
+			in.stmt(s, e, nil)
+		}
+	}
+	return nil
+}
+
+func (in *Interpreter) errorf(pos scan.Pos, format string, args ...interface{}) {
+	panic(&RuntimeError{
+		Pos: in.Config.Fset().Position(pos),
+		Msg: fmt.Sprintf(format, args...),
+	})
+}
+
+// A ctrl says how control leaves a statement.
+type ctrl int
+
+const (
+	fallOff ctrl = iota // continue with the next statement
+	brk
+	cont
+	ret
+)
+
+// A flow is the outcome of executing a statement. label is the object of
+// the label named by a break or continue, and results holds the values of
+// a return.
+type flow struct {
+	ctrl    ctrl
+	label   *ast.Object
+	results []value
+}
+
+// targets reports whether f is a branch that refers to the statement
+// labeled by lbl, which is nil if the statement has no label.
+func (f flow) targets(lbl *ast.Object) bool {
+	return f.label == nil || f.label == lbl
+}
+
+// stmts executes list. Named functions are bound before any statement runs,
+// so that functions in the same list can call each other.
+func (in *Interpreter) stmts(list []ast.Stmt, e *env) flow {
+	in.bindAll(list, e)
+	for _, s := range list {
+		if f := in.stmt(s, e, nil); f.ctrl != fallOff {
+			return f
+		}
+	}
+	return flow{}
+}
+
+// bindAll declares the named functions defined by the statements in list.
+func (in *Interpreter) bindAll(list []ast.Stmt, e *env) {
+	for _, s := range list {
+		if x, ok := s.(*ast.ExprStmt); ok {
+			if fn, ok := x.X.(*ast.FunDef); ok {
+				if obj := in.Config.ObjectOf(fn.Name); obj != nil {
+					e.vars[obj] = &closure{def: fn, env: e}
+				}
+			}
+		}
+	}
+}
+
+// builtinCall evaluates x, a call to the builtin function name.
+func (in *Interpreter) builtinCall(name string, x *ast.CallExpr, e *env) []value {
+	args := make([]value, len(x.Args))
+	for i, arg := range x.Args {
+		args[i] = in.expr(arg, e)
+	}
+	spread := x.Ellipsis.Type == scan.Ellipsis
+	switch name {
+	case "len":
+		switch v := args[0].(type) {
+		case *array:
+			return []value{int64(v.len())}
+		case *record:
+			return []value{int64(len(v.vals))}
+		case string:
+			return []value{int64(utf8.RuneCountInString(v))}
+		}
+	case "append":
+		// Like the runtime, append adds the values to the array it is
+		// given, rather than to a copy, and returns it.
+		a := args[0].(*array)
+		rest := args[1:]
+		if spread {
+			rest = args[1].(*array).vals
+		}
+		for _, v := range rest {
+			a.append(v)
+		}
+		return []value{a}
+	case "delete":
+		args[0].(*array).delete(args[1])
+		return nil
+	case "printf":
+		rest := args[1:]
+		if spread {
+			rest = args[1].(*array).vals
+		}
+		fargs := make([]interface{}, len(rest))
+		for i, v := range rest {
+			fargs[i] = printfArg(v)
+		}
+		fmt.Fprintf(in.Stdout, args[0].(string), fargs...)
+		return nil
+	case "exit":
+		panic(Exit(args[0].(int64)))
+	case "panic":
+		in.errorf(x.Pos(), "%s", args[0].(string))
+	}
+	in.errorf(x.Pos(), "invalid call of builtin %s", name)
+	return nil
+}
+
+// stmt executes s. lbl is the label of s, if any.
+func (in *Interpreter) stmt(s ast.Stmt, e *env, lbl *ast.Object) flow {
+	switch s := s.(type) {
+	case *ast.EmptyStmt:
+	case *ast.BadStmt:
+		in.errorf(s.Pos(), "bad statement")
+	case *ast.DeclStmt:
+		for _, spec := range s.Decl.Specs {
+			if vs, ok := spec.(*ast.ValueSpec); ok {
+				in.valueSpec(vs, e)
+			}
+		}
+	case *ast.ExprStmt:
+		switch x := s.X.(type) {
+		case *ast.FunDef:
+			if x.Name == nil {
+				in.expr(x, e)
+			}
+		case *ast.CallExpr:
+			in.call(x, e)
+		default:
+			in.expr(x, e)
+		}
+	case *ast.AssignStmt:
+		in.assignStmt(s, e)
+	case *ast.IncDecStmt:
+		op := scan.Add
+		if s.Tok.Type == scan.Dec {
+			op = scan.Sub
+		}
+		in.assign(s.X, in.binary(op, s.Tok.Pos, in.expr(s.X, e), int64(1)), e)
+	case *ast.ReturnStmt:
+		return flow{ctrl: ret, results: in.exprs(s.Results, e)}
+	case *ast.BranchStmt:
+		f := flow{ctrl: brk}
+		if s.Tok.Type == scan.Continue {
+			f.ctrl = cont
+		}
+		if s.Label != nil {
+			f.label = in.Config.ObjectOf(s.Label)
+		}
+		return f
+	case *ast.BlockStmt:
+		return in.stmts(s.List, e)
+	case *ast.LabeledStmt:
+		lbl := in.Config.ObjectOf(s.Label)
+		f := in.stmt(s.Stmt, e, lbl)
+		if f.ctrl == brk && f.label != nil && f.label == lbl {
+			f = flow{}
+		}
+		return f
+	case *ast.IfStmt:
+		if s.Init != nil {
+			in.stmt(s.Init, e, nil)
+		}
+		if in.expr(s.Cond, e).(bool) {
+			return in.stmts(s.Body.List, e)
+		}
+		if s.Else != nil {
+			return in.stmt(s.Else, e, nil)
+		}
+	case *ast.SwitchStmt:
+		return in.switchStmt(s, e, lbl)
+	case *ast.ForStmt:
+		return in.forStmt(s, e, lbl)
+	case *ast.InStmt:
+		return in.inStmt(s, e, lbl)
+	default:
+		in.errorf(s.Pos(), "unexpected %T", s)
+	}
+	return flow{}
+}
+
+func (in *Interpreter) valueSpec(s *ast.ValueSpec, e *env) {
+	if len(s.Values) == 0 {
+		for _, id := range s.Names {
+			in.assign(id, zero(in.Config.TypeOf(id)), e)
+		}
+		return
+	}
+	vals := in.exprs(s.Values, e)
+	for i, id := range s.Names {
+		in.assign(id, vals[i], e)
+	}
+}
+
+func (in *Interpreter) assignStmt(s *ast.AssignStmt, e *env) {
+	if s.Tok.Type == scan.Assign {
+		vals := in.exprs(s.Rhs, e)
+		for i, x := range s.Lhs {
+			in.assign(x, vals[i], e)
+		}
+		return
+	}
+	// x op= y
+	op := s.Tok.Type - scan.AddAssign + scan.Add
+	x := in.expr(s.Lhs[0], e)
+	y := in.expr(s.Rhs[0], e)
+	in.assign(s.Lhs[0], in.binary(op, s.Tok.Pos, x, y), e)
+}
+
+// assign stores v in the location denoted by x.
+func (in *Interpreter) assign(x ast.Expr, v value, e *env) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		if obj := in.Config.ObjectOf(x); obj != nil {
+			e.set(obj, v)
+		}
+	case *ast.ParenExpr:
+		in.assign(x.X, v, e)
+	case *ast.SelectorExpr:
+		if id := ast.Qualified(x); id != nil {
+			in.assign(id, v, e)
+			break
+		}
+		in.setField(in.expr(x.X, e).(*record), x.Sel, v)
+	case *ast.IndexExpr:
+		if x.Backwards {
+			in.errorf(x.Pos(), "cannot assign to reverse index")
+		}
+		switch c := in.expr(x.X, e).(type) {
+		case *array:
+			c.set(in.expr(x.Index, e), v)
+		case *record:
+			in.setField(c, x.Index, v)
+		case string:
+			i := in.expr(x.Index, e).(int64)
+			r := []rune(c)
+			if i < 0 || i >= int64(len(r)) {
+				in.errorf(x.Index.Pos(), "index out of range [%d] with length %d", i, len(r))
+			}
+			u := []rune(v.(string))
+			if len(u) != 1 {
+				in.errorf(x.Pos(), "cannot assign %s to a single character", quote(v.(string)))
+			}
+			r[i] = u[0]
+			in.assign(x.X, string(r), e)
+		}
+	default:
+		in.errorf(x.Pos(), "cannot assign to %T", x)
+	}
+}
+
+// keyOf returns the record key denoted by x, following the rules of the
+// type checker.
+func keyOf(x ast.Expr) types.Key {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return types.Key(x.Name.Lit)
+	case *ast.BasicLit:
+		return types.Key(constant.MakeFromLiteral(x.Value.Lit, x.Value.Type).String())
+	}
+	return ""
+}
+
+func (in *Interpreter) field(r *record, x ast.Expr) int {
+	k := keyOf(x)
+	i := r.index(k)
+	if i < 0 {
+		in.errorf(x.Pos(), "record has no element %s", k)
+	}
+	return i
+}
+
+func (in *Interpreter) setField(r *record, x ast.Expr, v value) {
+	r.vals[in.field(r, x)] = v
+}
+
+func (in *Interpreter) switchStmt(s *ast.SwitchStmt, e *env, lbl *ast.Object) flow {
+	if s.Init != nil {
+		in.stmt(s.Init, e, nil)
+	}
+	var tag value = true
+	if s.Tag != nil {
+		tag = in.expr(s.Tag, e)
+	}
+	// The default clause runs only if no other clause matches.
+	var body, def *ast.CaseClause
+clauses:
+	for _, st := range s.Body.List {
+		cc, ok := st.(*ast.CaseClause)
+		if !ok {
+			continue
+		}
+		if cc.List == nil {
+			def = cc
+			continue
+		}
+		for _, x := range cc.List {
+			if in.expr(x, e) == tag {
+				body = cc
+				break clauses
+			}
+		}
+	}
+	if body == nil {
+		body = def
+	}
+	if body == nil {
+		return flow{}
+	}
+	f := in.stmts(body.Body, e)
+	if f.ctrl == brk && f.targets(lbl) {
+		f = flow{}
+	}
+	return f
+}
+
+// loop interprets the outcome f of running the body of a loop labeled by
+// lbl. It reports whether the loop should stop and, if so, the outcome of
+// the loop itself.
+func loop(f flow, lbl *ast.Object) (bool, flow) {
+	switch {
+	case f.ctrl == brk && f.targets(lbl):
+		return true, flow{}
+	case f.ctrl == cont && f.targets(lbl):
+		return false, flow{}
+	case f.ctrl != fallOff:
+		return true, f
+	}
+	return false, flow{}
+}
+
+func (in *Interpreter) forStmt(s *ast.ForStmt, e *env, lbl *ast.Object) flow {
+	if s.Init != nil {
+		in.stmt(s.Init, e, nil)
+	}
+	for s.Cond == nil || in.expr(s.Cond, e).(bool) {
+		if stop, f := loop(in.stmts(s.Body.List, e), lbl); stop {
+			return f
+		}
+		if s.Post != nil {
+			in.stmt(s.Post, e, nil)
+		}
+	}
+	return flow{}
+}
+
+func (in *Interpreter) inStmt(s *ast.InStmt, e *env, lbl *ast.Object) flow {
+	// The entries are fixed before the loop starts, so assigning to x in
+	// the body does not change the iteration.
+	var keys, vals []value
+	switch x := in.expr(s.X, e).(type) {
+	case *array:
+		keys = append(keys, x.keys...)
+		vals = append(vals, x.vals...)
+	case *record:
+		for i := range x.keys {
+			keys = append(keys, string(x.keys[i]))
+		}
+		vals = append(vals, x.vals...)
+	case string:
+		var i int64
+		for _, r := range x {
+			keys = append(keys, i)
+			vals = append(vals, string(r))
+			i++
+		}
+	}
+	for i := range keys {
+		if s.Index != nil {
+			in.assign(s.Index, int64(i), e)
+		}
+		if s.Key != nil {
+			in.assign(s.Key, keys[i], e)
+		}
+		if s.Value != nil {
+			in.assign(s.Value, vals[i], e)
+		}
+		if stop, f := loop(in.stmts(s.Body.List, e), lbl); stop {
+			return f
+		}
+	}
+	return flow{}
+}
+
+// exprs evaluates list, expanding a sole call that produces several values.
+func (in *Interpreter) exprs(list []ast.Expr, e *env) []value {
+	if len(list) == 1 {
+		if call, ok := list[0].(*ast.CallExpr); ok {
+			return in.call(call, e)
+		}
+	}
+	vals := make([]value, len(list))
+	for i, x := range list {
+		vals[i] = in.expr(x, e)
+	}
+	return vals
+}
+
+func (in *Interpreter) expr(x ast.Expr, e *env) value {
+	if v := in.Config.Values[x]; v != nil {
+		return in.constant(x, v)
+	}
+	switch x := x.(type) {
+	case *ast.Ident:
+		obj := in.Config.ObjectOf(x)
+		if obj == nil {
+			in.errorf(x.Pos(), "undefined: %s", x.Name.Lit)
+		}
+		if obj.Kind == ast.Con {
+			return obj.Data
+		}
+		v, ok := e.lookup(obj)
+		if !ok {
+			in.errorf(x.Pos(), "%s used before it is assigned", x.Name.Lit)
+		}
+		return v
+	case *ast.ParenExpr:
+		return in.expr(x.X, e)
+	case *ast.FunDef:
+		cl := &closure{def: x, env: e}
+		if obj := in.Config.ObjectOf(x.Name); obj != nil {
+			e.vars[obj] = cl
+		}
+		return cl
+	case *ast.CompositeLit:
+		return in.compositeLit(x, e)
+	case *ast.SelectorExpr:
+		if id := ast.Qualified(x); id != nil {
+			return in.expr(id, e)
+		}
+		r := in.expr(x.X, e).(*record)
+		return r.vals[in.field(r, x.Sel)]
+	case *ast.IndexExpr:
+		return in.index(x, e)
+	case *ast.SliceExpr:
+		return in.slice(x, e)
+	case *ast.CallExpr:
+		res := in.call(x, e)
+		if len(res) != 1 {
+			in.errorf(x.Pos(), "function call produces %d values, but 1 is expected", len(res))
+		}
+		return res[0]
+	case *ast.UnaryExpr:
+		return in.unary(x, e)
+	case *ast.BinaryExpr:
+		switch x.Op.Type {
+		case scan.Land:
+			return in.expr(x.X, e).(bool) && in.expr(x.Y, e).(bool)
+		case scan.Lor:
+			return in.expr(x.X, e).(bool) || in.expr(x.Y, e).(bool)
+		}
+		return in.binary(x.Op.Type, x.Op.Pos, in.expr(x.X, e), in.expr(x.Y, e))
+	}
+	in.errorf(x.Pos(), "cannot evaluate %T", x)
+	return nil
+}
+
+// constant returns v, the value of the constant expression x.
+func (in *Interpreter) constant(x ast.Expr, v constant.Value) value {
+	switch {
+	case v.Kind() == constant.Unknown:
+		in.errorf(x.Pos(), "invalid constant")
+	case v.Kind() == constant.Bool:
+		return constant.BoolVal(v)
+	case v.Kind() == constant.String:
+		return constant.StringVal(v)
+	case v.Kind() == constant.Float || in.Config.TypeOf(x) == types.Float:
+		f, _ := constant.Float64Val(v)
+		return f
+	default:
+		n, ok := constant.Int64Val(v)
+		if !ok {
+			in.errorf(x.Pos(), "constant %s overflows num", v)
+		}
+		return n
+	}
+	return nil
+}
+
+func (in *Interpreter) compositeLit(x *ast.CompositeLit, e *env) value {
+	switch x.Type.(type) {
+	case *ast.ArrayLit:
+		a := newArray()
+		for i, el := range x.Elts {
+			if kv, ok := el.(*ast.KeyValueExpr); ok {
+				a.set(in.expr(kv.Key, e), in.expr(kv.Value, e))
+			} else {
+				a.set(int64(i), in.expr(el, e))
+			}
+		}
+		return a
+	case *ast.RecordLit:
+		r := &record{keys: make([]types.Key, len(x.Elts)), vals: make([]value, len(x.Elts))}
+		for i, el := range x.Elts {
+			r.keys[i] = types.Key(strconv.Itoa(i))
+			if kv, ok := el.(*ast.KeyValueExpr); ok {
+				r.keys[i] = keyOf(kv.Key)
+				r.vals[i] = in.expr(kv.Value, e)
+			} else {
+				r.vals[i] = in.expr(el, e)
+			}
+		}
+		return r
+	}
+	in.errorf(x.Pos(), "missing type in composite literal")
+	return nil
+}
+
+func (in *Interpreter) index(x *ast.IndexExpr, e *env) value {
+	switch c := in.expr(x.X, e).(type) {
+	case *record:
+		return c.vals[in.field(c, x.Index)]
+	case *array:
+		k := in.expr(x.Index, e)
+		if x.Backwards {
+			keys := newArray()
+			for i := range c.vals {
+				if c.vals[i] == k {
+					keys.append(c.keys[i])
+				}
+			}
+			return keys
+		}
+		v, ok := c.get(k)
+		if !ok {
+			in.errorf(x.Index.Pos(), "key %s not in array", format(k, true))
+		}
+		return v
+	case string:
+		i := in.expr(x.Index, e).(int64)
+		r := []rune(c)
+		if i < 0 || i >= int64(len(r)) {
+			in.errorf(x.Index.Pos(), "index out of range [%d] with length %d", i, len(r))
+		}
+		return string(r[i])
+	}
+	return nil
+}
+
+// slice returns a new array or string holding the elements of x.X in
+// positions [low, high). Slicing an array renumbers its keys from zero.
+func (in *Interpreter) slice(x *ast.SliceExpr, e *env) value {
+	c := in.expr(x.X, e)
+	var n int64
+	switch c := c.(type) {
+	case *array:
+		n = int64(c.len())
+	case string:
+		n = int64(utf8.RuneCountInString(c))
+	}
+	low, high := int64(0), n
+	if x.Low != nil {
+		low = in.expr(x.Low, e).(int64)
+	}
+	if x.High != nil {
+		high = in.expr(x.High, e).(int64)
+	}
+	if low < 0 || high < low || high > n {
+		in.errorf(x.Lbrack.Pos, "slice bounds out of range [%d:%d] with length %d", low, high, n)
+	}
+	switch c := c.(type) {
+	case *array:
+		a := newArray()
+		for _, v := range c.vals[low:high] {
+			a.append(v)
+		}
+		return a
+	case string:
+		return string([]rune(c)[low:high])
+	}
+	return nil
+}
+
+// call evaluates a function call and returns its results.
+func (in *Interpreter) call(x *ast.CallExpr, e *env) []value {
+	if id, ok := x.Fun.(*ast.Ident); ok && types.IsBuiltin(id.Obj) {
+		return in.builtinCall(id.Name.Lit, x, e)
+	}
+	cl, ok := in.expr(x.Fun, e).(*closure)
+	if !ok || cl == nil {
+		in.errorf(x.Pos(), "call of nil function")
+	}
+	params := cl.def.Params
+	args := make([]value, len(params))
+	for i, pr := range params {
+		if pr.Ellipsis.Type != scan.Ellipsis {
+			args[i] = in.expr(x.Args[i], e)
+			continue
+		}
+		if x.Ellipsis.Type == scan.Ellipsis {
+			args[i] = in.expr(x.Args[i], e)
+			break
+		}
+		rest := newArray()
+		for _, arg := range x.Args[i:] {
+			rest.append(in.expr(arg, e))
+		}
+		args[i] = rest
+	}
+	fe := newEnv(cl.env)
+	for i, pr := range params {
+		if obj := in.Config.ObjectOf(pr.Name); obj != nil {
+			fe.vars[obj] = args[i]
+		}
+	}
+	return in.stmts(cl.def.Body.List, fe).results
+}
+
+func (in *Interpreter) unary(x *ast.UnaryExpr, e *env) value {
+	v := in.expr(x.X, e)
+	switch x.Op.Type {
+	case scan.Not:
+		return !v.(bool)
+	case scan.Add:
+		return v
+	case scan.Sub:
+		return in.binary(scan.Sub, x.Op.Pos, zeroOf(v), v)
+	case scan.Xor:
+		if b, ok := v.(bool); ok {
+			return !b
+		}
+		return ^v.(int64)
+	}
+	in.errorf(x.Pos(), "invalid unary operator %s", x.Op.Type)
+	return nil
+}
+
+func zeroOf(v value) value {
+	switch v.(type) {
+	case bool:
+		return false
+	case float64:
+		return float64(0)
+	}
+	return int64(0)
+}
+
+// binary applies op to x and y. Bools take part in arithmetic as 0 and 1,
+// and the result is true if it is not 0. If either operand is a float, so
+// is the result.
+func (in *Interpreter) binary(op scan.Type, pos scan.Pos, x, y value) value {
+	switch op {
+	case scan.Eql, scan.Neq:
+		if isFloat(x) || isFloat(y) {
+			return (toFloat(x) == toFloat(y)) == (op == scan.Eql)
+		}
+		return (x == y) == (op == scan.Eql)
+	case scan.Lss, scan.Leq, scan.Gtr, scan.Geq:
+		if isFloat(x) || isFloat(y) {
+			// Comparisons with NaN are false, so floats are not compared
+			// by sign.
+			a, b := toFloat(x), toFloat(y)
+			switch op {
+			case scan.Lss:
+				return a < b
+			case scan.Leq:
+				return a <= b
+			case scan.Gtr:
+				return a > b
+			}
+			return a >= b
+		}
+		c := compare(x, y)
+		switch op {
+		case scan.Lss:
+			return c < 0
+		case scan.Leq:
+			return c <= 0
+		case scan.Gtr:
+			return c > 0
+		}
+		return c >= 0
+	}
+	if s, ok := x.(string); ok && op == scan.Add {
+		return s + y.(string)
+	}
+	if isFloat(x) || isFloat(y) {
+		return in.float(op, pos, toFloat(x), toFloat(y))
+	}
+	_, isBool := x.(bool)
+	a, b := toInt(x), toInt(y)
+	var r int64
+	switch op {
+	case scan.Add:
+		r = a + b
+	case scan.Sub:
+		r = a - b
+	case scan.Mul:
+		r = a * b
+	case scan.Quo, scan.Rem:
+		if b == 0 {
+			in.errorf(pos, "integer divide by zero")
+		}
+		if op == scan.Quo {
+			r = a / b
+		} else {
+			r = a % b
+		}
+	case scan.And:
+		r = a & b
+	case scan.Or:
+		r = a | b
+	case scan.Xor:
+		r = a ^ b
+	case scan.AndNot:
+		r = a &^ b
+	case scan.Shl, scan.Shr:
+		if b < 0 {
+			in.errorf(pos, "negative shift amount")
+		}
+		if op == scan.Shl {
+			r = a << uint64(b)
+		} else {
+			r = a >> uint64(b)
+		}
+	default:
+		in.errorf(pos, "invalid binary operator %s", op)
+	}
+	if isBool {
+		return r != 0
+	}
+	return r
+}
+
+// float applies the arithmetic operator op to x and y.
+func (in *Interpreter) float(op scan.Type, pos scan.Pos, x, y float64) value {
+	switch op {
+	case scan.Add:
+		return x + y
+	case scan.Sub:
+		return x - y
+	case scan.Mul:
+		return x * y
+	case scan.Quo:
+		return x / y
+	}
+	in.errorf(pos, "invalid binary operator %s", op)
+	return nil
+}
+
+func isFloat(v value) bool {
+	_, ok := v.(float64)
+	return ok
+}
+
+// toFloat converts a num to a float. An integer literal is a num when the
+// function containing it is polymorphic, so it may meet a float at run
+// time.
+func toFloat(v value) float64 {
+	if f, ok := v.(float64); ok {
+		return f
+	}
+	return float64(toInt(v))
+}
+
+func toInt(v value) int64 {
+	if b, ok := v.(bool); ok {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	return v.(int64)
+}
+
+// compare returns -1, 0 or +1 depending on whether x is less than, equal
+// to, or greater than y.
+func compare(x, y value) int {
+	if s, ok := x.(string); ok {
+		switch t := y.(string); {
+		case s < t:
+			return -1
+		case s > t:
+			return +1
+		}
+		return 0
+	}
+	a, b := toInt(x), toInt(y)
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return +1
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package interp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/smasher164/arvo/internal/parsetest"
+	"github.com/smasher164/arvo/types"
+)
+
+func run(t *testing.T, src string) (string, error) {
+	t.Helper()
+	conf := types.Config{File: parsetest.File(t, src, nil)}
+	if err := types.Infer(&conf); err != nil {
+		t.Fatalf("check %q: %v", src, err)
+	}
+	var out strings.Builder
+	in := &Interpreter{Config: conf, Stdout: &out}
+	err := in.Run()
+	return out.String(), err
+}
+
+var runCases = []struct {
+	input, output string
+}{
+	// numbers, strings and bools
+	{"printf('%d\\n', 1 + 2 * 3 - 4 / 2 % 3)", "5\n"},
+	{"printf('%d %d %d\\n', 7 & 3, 7 &^ 3, 1 << 4 >> 1)", "3 4 8\n"},
+	{"x = 'héllo'\nprintf('%s %s %s\\n', x[1], x[1:3], x + '!')", "é él héllo!\n"},
+	{"x = 'abc'\nx[1] = 'x'\nprintf('%s\\n', x)", "axc\n"},
+	{"printf('%v %v\\n', 1 < 2 && 'a' < 'b', !true || false)", "true false\n"},
+	{"x = 5\nx += 2\nx <<= 1\nx--\nprintf('%d\\n', x)", "13\n"},
+	{"var s\nvar n\nprintf('[%s]', s + '')\nprintf(' %d\\n', n + 0)", "[] 0\n"},
+	{"x = 1.5\ny = x * 2 + 0.25\nprintf('%v %.2f\\n', y, y / 3)", "3.25 1.08\n"},
+	{"x = 1\ny = x + 2.5\nprintf('%v %v %v\\n', y, 0.1 + 0.2, 1e21)", "3.5 0.3 1e+21\n"},
+	{"x = 0.1\nprintf('%v %v %v\\n', x + 0.2, 0.1 + 0.2 == 0.3, x + 0.2 == 0.3)", "0.30000000000000004 true false\n"},
+	{"fun half(x) { return x / 2 }\nprintf('%v ', half(3.0))\nprintf('%v\\n', half(3) < 2)", "1.5 true\n"},
+	{"printf('%d ', 0b101 + 0o17 + 017 + 0x_1F + 1_000)\nprintf('%v\\n', 0x1.8p1 + 1_0e-2)", "1066 3.1\n"},
+	{"x = r{'a', 'b'}\nprintf('%s\\n', x[0b1])", "b\n"},
+
+	// arrays and records
+	{"x = a{1, 2, 3}\nx[3] = 4\nprintf('%v\\n', x)", "a{1, 2, 3, 4}\n"},
+	{"x = a{'k': 1, 'j': 2}\nprintf('%v\\n', x)", "a{'k': 1, 'j': 2}\n"},
+	{"x = a{5, 6, 5}\nprintf('%v\\n', x[[5]])", "a{0, 2}\n"},
+	{"x = a{1, 2, 3, 4}\nprintf('%v %v\\n', x[1:3], x[:1])", "a{2, 3} a{1}\n"},
+	{"x = r{name: 'n', 1}\nx.name = 'm'\nprintf('%s ', x[name])\nprintf('%d ', x[1])\nprintf('%v\\n', x)", "m 1 r{name: 'm', 1: 1}\n"},
+	{"fun f() {}\nprintf('%v %v %q %d\\n', f, r{g: f}, a{'k'}, a{1})", "fun r{g: fun} \"a{'k'}\" %!d(string=a{1})\n"},
+	{"x = a{1}\ny = x\ny[0] = 2\nprintf('%d\\n', x[0])", "2\n"},
+
+	// functions and closures
+	{"fun fib(n) { if n < 2 { return n }\nreturn fib(n-1) + fib(n-2) }\nprintf('%d\\n', fib(15))", "610\n"},
+	{"fun swap(x, y) { return y, x }\na, b = swap(1, 2)\nprintf('%d %d\\n', a, b)", "2 1\n"},
+	{"fun counter() { n = 0\nreturn fun() { n++\nreturn n } }\nc = counter()\nc()\nprintf('%d\\n', c())", "2\n"},
+	{"fun sum(...xs) { t = 0\nfor _, x in xs { t += x }\nreturn t }\nprintf('%d %d\\n', sum(1, 2, 3), sum(a{4, 5}...))", "6 9\n"},
+	{"x = one()\nfun one() { return 1 }\nprintf('%d\\n', x)", "1\n"},
+	{"printf('%v %v\\n', even(10), odd(7))\nfun even(n) { if n == 0 { return true }\nreturn odd(n - 1) }\nfun odd(n) { if n == 0 { return false }\nreturn even(n - 1) }", "true true\n"},
+	{"fun o() { fun i(n) { if n == 0 { return 0 }\nreturn i(n - 1) + 2 }\nreturn i(3) }\nprintf('%d\\n', o())", "6\n"},
+	{"fun compose(f, g) { return fun(x) { return f(g(x)) } }\nh = compose(fun(x) { return x + 1 }, fun(x) { return x * 2 })\nprintf('%d\\n', h(5))", "11\n"},
+
+	// builtins
+	{"x = a{1, 2}\nx = append(x, 3, 4)\nx = append(x, a{5}...)\nprintf('%v ', x)\nprintf('%d\\n', len(x))", "a{1, 2, 3, 4, 5} 5\n"},
+	{"x = a{'a': 1, 'b': 2, 'c': 3}\ndelete(x, 'b')\ndelete(x, 'z')\nprintf('%v\\n', x)", "a{'a': 1, 'c': 3}\n"},
+	{"printf('%d %d\\n', len('hé'), len(r{a: 1, 'b'}))", "2 2\n"},
+	{"var xs\nfor i = 0; i < 3; i++ { xs = append(xs, i * i) }\nprintf('%v\\n', xs)", "a{0, 1, 4}\n"},
+	{"x = a{10, 20, 30}\ndelete(x, 2)\nx = append(x, 40)\nx[7] = 70\ndelete(x, 7)\nprintf('%v ', append(x, 80))\nprintf('%d\\n', len(x))", "a{0: 10, 1: 20, 3: 40, 8: 80} 4\n"},
+	{"fun f(s, ...xs) { printf(s, xs...) }\nf('%s %s\\n', 'a', 'b')", "a b\n"},
+	{"printf('%d %s %v %v\\n', 1, 'a', true, 2.5)\nprintf('%v %d\\n', 1e20, 1)", "1 a true 2.5\n1e+20 1\n"},
+
+	// loops and switch
+	{"t = 0\nfor i = 0; i < 10; i++ { if i % 2 == 0 { continue }\nt += i }\nprintf('%d\\n', t)", "25\n"},
+	{"for k, v in r{a: 1, b: 2} { printf('%s=', k)\nprintf('%d ', v) }", "a=1 b=2 "},
+	{"for i, c in 'hé' { printf('%d', i)\nprintf('%s ', c) }", "0h 1é "},
+	{"outer: for i = 0; i < 3; i++ { for j = 0; j < 3; j++ { if j == 1 { continue outer }\nif i == 2 { break outer }\nprintf('%d%d ', i, j) } }", "00 10 "},
+	{"x = 2\nswitch x {\ncase 1: printf('one')\ncase 2, 3: printf('two')\nbreak\nprintf('!')\ndefault: printf('other')\n}", "two"},
+	{"x = 7\nswitch {\ndefault: printf('big')\ncase x < 5: printf('small')\n}", "big"},
+	{"l: for {\nswitch {\ncase true: break l\n}\n}\nprintf('done')", "done"},
+	{"l = 3\nl: for { l--\nif l == 0 { break l } }\nprintf('%d', l)", "0"},
+}
+
+func TestRun(t *testing.T) {
+	for _, c := range runCases {
+		out, err := run(t, c.input)
+		if err != nil {
+			t.Errorf("run %q: %v", c.input, err)
+			continue
+		}
+		if out != c.output {
+			t.Errorf("run %q: got %q, want %q", c.input, out, c.output)
+		}
+	}
+}
+
+func TestExit(t *testing.T) {
+	out, err := run(t, "printf('a')\nexit(3)\nprintf('b')")
+	if out != "a" || err != Exit(3) {
+		t.Errorf("got %q, %v; want %q, %v", out, err, "a", Exit(3))
+	}
+}
+
+var runtimeErrorCases = []struct {
+	input, msg string
+}{
+	{"x = a{1}\ny = x[1]", "key 1 not in array"},
+	{"x = 0\ny = 1 / x", "integer divide by zero"},
+	{"x = -1\ny = 1 << x", "negative shift amount"},
+	{"x = 'ab'\ny = x[2]", "index out of range [2] with length 2"},
+	{"x = a{1, 2}\ny = x[1:3]", "slice bounds out of range [1:3] with length 2"},
+	{"x = 1\npanic('bad ' + 'x')", "bad x"},
+}
+
+func TestRuntimeError(t *testing.T) {
+	for _, c := range runtimeErrorCases {
+		_, err := run(t, c.input)
+		re, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("run %q: got %v, want runtime error", c.input, err)
+			continue
+		}
+		if re.Msg != c.msg || re.Pos.Line != 2 {
+			t.Errorf("run %q: got %v, want %s on line 2", c.input, err, c.msg)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package interp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/types"
+)
+
+// A value is the result of evaluating an expression. Its dynamic type is one
+// of
+//
+//	int64     num
+//	float64   float
+//	bool      bool
+//	string    str
+//	*array    [K]V
+//	*record   r{...}
+//	*closure  fun(...)
+//
+// Arrays, records and closures are references, so assigning one does not
+// copy it.
+type value interface{}
+
+// An array maps keys to values, remembering the order in which the keys
+// were inserted.
+type array struct {
+	keys []value
+	vals []value
+	pos  map[value]int
+	next int64 // key of the next appended value: one more than the greatest num key
+}
+
+func newArray() *array {
+	return &array{pos: make(map[value]int)}
+}
+
+func (a *array) len() int { return len(a.keys) }
+
+func (a *array) get(k value) (value, bool) {
+	i, ok := a.pos[k]
+	if !ok {
+		return nil, false
+	}
+	return a.vals[i], true
+}
+
+func (a *array) set(k, v value) {
+	if i, ok := a.pos[k]; ok {
+		a.vals[i] = v
+		return
+	}
+	a.pos[k] = len(a.keys)
+	a.keys = append(a.keys, k)
+	a.vals = append(a.vals, v)
+	if n, ok := k.(int64); ok && n >= a.next {
+		a.next = n + 1
+	}
+}
+
+// append adds v under the next positional key, which is one more than the
+// greatest num key that a has ever held, or 0.
+func (a *array) append(v value) {
+	a.set(a.next, v)
+}
+
+// delete removes the entry with key k, if there is one. The entries after
+// it keep their keys and their order, and the next positional key is
+// unchanged.
+func (a *array) delete(k value) {
+	i, ok := a.pos[k]
+	if !ok {
+		return
+	}
+	delete(a.pos, k)
+	a.keys = append(a.keys[:i], a.keys[i+1:]...)
+	a.vals = append(a.vals[:i], a.vals[i+1:]...)
+	for j := i; j < len(a.keys); j++ {
+		a.pos[a.keys[j]] = j
+	}
+}
+
+// A record is a fixed sequence of named elements.
+type record struct {
+	keys []types.Key
+	vals []value
+}
+
+func (r *record) index(k types.Key) int {
+	for i := range r.keys {
+		if r.keys[i] == k {
+			return i
+		}
+	}
+	return -1
+}
+
+// A closure is a function definition together with the environment it was
+// evaluated in.
+type closure struct {
+	def *ast.FunDef
+	env *env
+}
+
+// An env holds the variables of a function invocation. Variables are keyed by
+// the object that declares them, so nested blocks need no env of their own.
+type env struct {
+	vars  map[*ast.Object]value
+	outer *env
+}
+
+func newEnv(outer *env) *env {
+	return &env{vars: make(map[*ast.Object]value), outer: outer}
+}
+
+func (e *env) lookup(obj *ast.Object) (value, bool) {
+	for ; e != nil; e = e.outer {
+		if v, ok := e.vars[obj]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// set assigns to the variable declared by obj, creating it in e if it does
+// not exist yet.
+func (e *env) set(obj *ast.Object, v value) {
+	for s := e; s != nil; s = s.outer {
+		if _, ok := s.vars[obj]; ok {
+			s.vars[obj] = v
+			return
+		}
+	}
+	e.vars[obj] = v
+}
+
+// zero returns the value of a variable of type t that has not been
+// assigned.
+func zero(t types.Type) value {
+	switch t := t.(type) {
+	case types.Basic:
+		switch t {
+		case types.Bool:
+			return false
+		case types.String:
+			return ""
+		case types.Float:
+			return float64(0)
+		}
+	case types.Array:
+		return newArray()
+	case types.Record:
+		r := &record{keys: make([]types.Key, len(t.Elts)), vals: make([]value, len(t.Elts))}
+		for i, el := range t.Elts {
+			r.keys[i], _ = el.Key.(types.Key)
+			r.vals[i] = zero(el.Value)
+		}
+		return r
+	case types.Signature:
+		return nil
+	}
+	return int64(0)
+}
+
+// format returns the printed form of v. Strings nested in arrays and records
+// are quoted. A function prints as fun, since compiled functions do not
+// know their names.
+func format(v value, nested bool) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		if nested {
+			return quote(v)
+		}
+		return v
+	case *array:
+		var b strings.Builder
+		b.WriteString("a{")
+		seq := true
+		for i, k := range v.keys {
+			if k != int64(i) {
+				seq = false
+			}
+		}
+		for i := range v.keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if !seq {
+				b.WriteString(format(v.keys[i], true) + ": ")
+			}
+			b.WriteString(format(v.vals[i], true))
+		}
+		b.WriteString("}")
+		return b.String()
+	case *record:
+		var b strings.Builder
+		b.WriteString("r{")
+		for i := range v.keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(string(v.keys[i]) + ": " + format(v.vals[i], true))
+		}
+		b.WriteString("}")
+		return b.String()
+	case *closure:
+		return "fun"
+	}
+	return fmt.Sprint(v)
+}
+
+func quote(s string) string {
+	q := strconv.Quote(s)
+	q = strings.ReplaceAll(q[1:len(q)-1], `\"`, `"`)
+	return "'" + strings.ReplaceAll(q, "'", `\'`) + "'"
+}
+
+// printfArg converts v to an operand for the fmt package. An array, record
+// or function is printed as the string of its printed form, as the runtime
+// prints it.
+func printfArg(v value) interface{} {
+	switch v.(type) {
+	case int64, float64, bool, string:
+		return v
+	}
+	return format(v, false)
+}
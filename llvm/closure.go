@@ -0,0 +1,187 @@
+package llvm
+
+import (
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/types"
+
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+// Functions are first-class values, represented as closures: a pointer to
+// a pair of the function's code and its environment. Every function takes
+// its environment as a hidden first parameter.
+//
+// A variable that a nested function refers to is captured: rather than
+// living on the stack, it is stored in a frame, which is allocated on the
+// heap each time the function that declares it is called. A frame begins
+// with a link to the environment of the function that allocated it, so the
+// environment of a nested function is the frame of its parent, or the
+// parent's own environment if the parent has no frame. Following links
+// leads to the frames of every enclosing function.
+
+// A capture is a variable that is stored in a frame.
+type capture struct {
+	owner *ast.FunDef // function that declares the variable
+	slot  int         // position of the variable in the owner's frame
+	id    *ast.Ident  // a use of the variable, whose type is the variable's
+}
+
+// findCaptures records the variables in the program that are referred to by
+// functions nested in the ones that declare them. A variable is free in a
+// function if it is declared in one of the scopes that enclose the
+// function's own scope.
+func (g *Generator) findCaptures() {
+	g.captures = make(map[*ast.Object]capture)
+	g.frames = make(map[*ast.FunDef][]*ast.Object)
+	owners := make(map[*ast.Scope]*ast.FunDef)
+	var stack []*ast.FunDef
+	for _, f := range g.Config.Files() {
+		ast.Walk(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FunDef:
+				owners[n.Scope] = n
+				stack = append(stack, n)
+			case *ast.Ident:
+				obj := g.Config.ObjectOf(n)
+				if obj == nil || obj.Kind != ast.Var || len(stack) == 0 {
+					break
+				}
+				if _, ok := g.captures[obj]; ok {
+					break
+				}
+				if owner := freeOwner(stack[len(stack)-1], obj, owners); owner != nil {
+					g.captures[obj] = capture{owner: owner, slot: len(g.frames[owner]) + 1, id: n}
+					g.frames[owner] = append(g.frames[owner], obj)
+				}
+			}
+			return true
+		}, func(n ast.Node) bool {
+			if _, ok := n.(*ast.FunDef); ok {
+				stack = stack[:len(stack)-1]
+			}
+			return true
+		})
+	}
+}
+
+// freeOwner returns the function that declares obj, if obj is free in def
+// and is not a global. owners maps the scope of every function enclosing
+// def to the function.
+func freeOwner(def *ast.FunDef, obj *ast.Object, owners map[*ast.Scope]*ast.FunDef) *ast.FunDef {
+	s := def.Scope.Outer
+	for s != nil && s.Lookup(obj.Name) != obj {
+		s = s.Outer
+	}
+	for ; s != nil; s = s.Outer {
+		if owner, ok := owners[s]; ok {
+			return owner
+		}
+	}
+	return nil
+}
+
+// frameType returns the type of the frames of fn, or reports false if fn
+// has none.
+func (g *Generator) frameType(fn *function) (llvm.Type, bool) {
+	objs := g.frames[fn.def]
+	if fn.def == nil || len(objs) == 0 {
+		return llvm.Type{}, false
+	}
+	elts := []llvm.Type{ptr}
+	for _, obj := range objs {
+		elts = append(elts, g.llvmType(fn.apply(g.Config.TypeOf(g.captures[obj].id))))
+	}
+	return llvm.StructType(elts, false), true
+}
+
+// newFrame allocates the frame of the function being generated, if it has
+// one, and sets the environment that its nested functions receive.
+func (g *Generator) newFrame() {
+	fn := g.fn
+	if fn.def == nil {
+		fn.env = llvm.ConstNull(ptr)
+		return
+	}
+	fn.env = fn.value.Param(0)
+	t, ok := g.frameType(fn)
+	if !ok {
+		return
+	}
+	frame := g.builder.CreateCall(g.builtin["gc_alloc"], []llvm.Value{llvm.SizeOf(t)}, "frame")
+	link := g.builder.CreateStructGEP(g.builder.CreateBitCast(frame, llvm.PointerType(t, 0), ""), 0, "")
+	g.builder.CreateStore(fn.env, link)
+	// The runtime zeroes the frame, which zeroes every variable in it.
+	fn.env = frame
+}
+
+// envOf returns the environment that the functions nested in target
+// receive. target is the function being generated or one that encloses it.
+func (g *Generator) envOf(target *function) llvm.Value {
+	env := g.fn.env
+	for f := g.fn; f != target; f = f.parent {
+		if t, ok := g.frameType(f); ok {
+			link := g.builder.CreateStructGEP(g.builder.CreateBitCast(env, llvm.PointerType(t, 0), ""), 0, "")
+			env = g.builder.CreateLoad(link, "")
+		}
+	}
+	return env
+}
+
+// capturedAddr returns the address of the captured variable c in the frame
+// of the nearest call of its owner.
+func (g *Generator) capturedAddr(c capture) llvm.Value {
+	f := g.fn
+	for f.def != c.owner {
+		f = f.parent
+	}
+	t, _ := g.frameType(f)
+	frame := g.builder.CreateBitCast(g.envOf(f), llvm.PointerType(t, 0), "")
+	return g.builder.CreateStructGEP(frame, c.slot, "")
+}
+
+// closureType returns the type that closures of functions of type sig
+// point to.
+func (g *Generator) closureType(sig types.Signature) llvm.Type {
+	return llvm.StructType([]llvm.Type{llvm.PointerType(g.funcType(sig), 0), ptr}, false)
+}
+
+// closure returns a closure of fn. Functions that are not nested in others
+// need no environment, so their closures are allocated statically.
+func (g *Generator) closure(fn *function) llvm.Value {
+	t := g.closureType(fn.sig)
+	if fn.parent == nil {
+		if fn.static.IsNil() {
+			fn.static = llvm.AddGlobal(g.mod, t, fn.value.Name()+".closure")
+			fn.static.SetLinkage(llvm.InternalLinkage)
+			fn.static.SetGlobalConstant(true)
+			fn.static.SetInitializer(llvm.ConstStruct([]llvm.Value{fn.value, llvm.ConstNull(ptr)}, false))
+		}
+		return fn.static
+	}
+	p := g.builder.CreateCall(g.builtin["gc_alloc"], []llvm.Value{llvm.SizeOf(t)}, "")
+	cl := g.builder.CreateBitCast(p, llvm.PointerType(t, 0), "")
+	g.builder.CreateStore(fn.value, g.builder.CreateStructGEP(cl, 0, ""))
+	g.builder.CreateStore(g.envOf(fn.parent), g.builder.CreateStructGEP(cl, 1, ""))
+	return cl
+}
+
+// callee returns the code and environment of the function that x, a call
+// to a function of type sig, calls. Calls to named functions are direct.
+func (g *Generator) callee(sig types.Signature, x *ast.CallExpr) (code, env llvm.Value) {
+	id, _ := x.Fun.(*ast.Ident)
+	if q := ast.Qualified(x.Fun); q != nil {
+		id = q
+	}
+	if obj := g.Config.ObjectOf(id); obj != nil && obj.Kind == ast.Fun && !types.IsBuiltin(obj) {
+		fn := g.spec(obj.Decl.(*ast.FunDef), sig)
+		if fn.parent == nil {
+			return fn.value, llvm.ConstNull(ptr)
+		}
+		return fn.value, g.envOf(fn.parent)
+	}
+	cl := g.expr(x.Fun)
+	g.check(g.builder.CreateIsNull(cl, ""), x.Pos(), "call of nil function")
+	code = g.builder.CreateLoad(g.builder.CreateStructGEP(cl, 0, ""), "")
+	env = g.builder.CreateLoad(g.builder.CreateStructGEP(cl, 1, ""), "")
+	return code, env
+}
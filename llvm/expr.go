@@ -0,0 +1,621 @@
+package llvm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/constant"
+	"github.com/smasher164/arvo/scan"
+	"github.com/smasher164/arvo/types"
+
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+func (g *Generator) exprs(list []ast.Expr) []llvm.Value {
+	vals := make([]llvm.Value, len(list))
+	for i, x := range list {
+		vals[i] = g.expr(x)
+	}
+	return vals
+}
+
+func (g *Generator) expr(x ast.Expr) llvm.Value {
+	if v := g.Config.Values[x]; v != nil {
+		return g.constant(x, v)
+	}
+	switch x := x.(type) {
+	case *ast.Ident:
+		return g.ident(x)
+	case *ast.ParenExpr:
+		return g.expr(x.X)
+	case *ast.FunDef:
+		return g.closure(g.spec(x, g.typeOf(x).(types.Signature)))
+	case *ast.CallExpr:
+		vals := g.call(x)
+		if len(vals) != 1 {
+			g.errorf(x.Pos(), "function call produces %d values, but 1 is expected", len(vals))
+		}
+		return vals[0]
+	case *ast.CompositeLit:
+		return g.compositeLit(x)
+	case *ast.SelectorExpr:
+		if id := ast.Qualified(x); id != nil {
+			return g.ident(id)
+		}
+		r := g.typeOf(x.X).(types.Record)
+		return g.builder.CreateLoad(g.elem(g.expr(x.X), g.field(r, x.Sel)), "")
+	case *ast.IndexExpr:
+		return g.index(x)
+	case *ast.SliceExpr:
+		return g.slice(x)
+	case *ast.UnaryExpr:
+		return g.unary(x)
+	case *ast.BinaryExpr:
+		switch x.Op.Type {
+		case scan.Land, scan.Lor:
+			return g.logical(x)
+		}
+		return g.binary(x.Op.Type, x.Op.Pos, g.typeOf(x.X), g.expr(x.X), g.expr(x.Y))
+	}
+	g.errorf(x.Pos(), "%T is not supported", x)
+	panic("unreachable")
+}
+
+func (g *Generator) ident(x *ast.Ident) llvm.Value {
+	obj := g.Config.ObjectOf(x)
+	switch {
+	case obj == nil:
+		g.errorf(x.Pos(), "cannot use %s as a value", x.Name.Lit)
+	case obj.Kind == ast.Con:
+		if obj.Data.(bool) {
+			return llvm.ConstInt(i1, 1, false)
+		}
+		return llvm.ConstInt(i1, 0, false)
+	case types.IsBuiltin(obj):
+		g.errorf(x.Pos(), "cannot use builtin %s as a value", x.Name.Lit)
+	case obj.Kind == ast.Fun:
+		return g.closure(g.spec(obj.Decl.(*ast.FunDef), g.typeOf(x).(types.Signature)))
+	}
+	return g.builder.CreateLoad(g.addr(x), "")
+}
+
+// constant returns v, the value of the constant expression x, as an
+// immediate.
+func (g *Generator) constant(x ast.Expr, v constant.Value) llvm.Value {
+	switch {
+	case v.Kind() == constant.Unknown:
+		g.errorf(x.Pos(), "invalid constant")
+	case v.Kind() == constant.Bool:
+		if constant.BoolVal(v) {
+			return llvm.ConstInt(i1, 1, false)
+		}
+		return llvm.ConstInt(i1, 0, false)
+	case v.Kind() == constant.String:
+		return g.str(constant.StringVal(v))
+	case v.Kind() == constant.Float || g.typeOf(x) == types.Float:
+		// An integer literal in a function instantiated with a float
+		// is converted here.
+		f, _ := constant.Float64Val(v)
+		return llvm.ConstFloat(f64, f)
+	default:
+		n, ok := constant.Int64Val(v)
+		if !ok {
+			g.errorf(x.Pos(), "constant %s overflows num", v)
+		}
+		return llvm.ConstInt(i64, uint64(n), true)
+	}
+	panic("unreachable")
+}
+
+// str returns a new runtime string that holds s.
+func (g *Generator) str(s string) llvm.Value {
+	v := g.builder.CreateCall(g.builtin["alloc_string"], nil, "")
+	g.builder.CreateCall(g.builtin["init_c_str"], []llvm.Value{v, g.builder.CreateGlobalStringPtr(s, "")}, "")
+	return v
+}
+
+// Kinds of array keys and values, as defined by the runtime.
+const (
+	kindWord = iota
+	kindString
+)
+
+// kindOf returns the kind of array keys or values of type t.
+func kindOf(t types.Type) llvm.Value {
+	k := kindWord
+	if t == types.String {
+		k = kindString
+	}
+	return llvm.ConstInt(i32, uint64(k), false)
+}
+
+// zero returns the value of a variable of type t that has not been
+// assigned. Arrays and records are allocated, so that they can be assigned
+// to.
+func (g *Generator) zero(t types.Type) llvm.Value {
+	switch t := t.(type) {
+	case types.Array:
+		return g.builder.CreateCall(g.builtin["alloc_array"], []llvm.Value{kindOf(t.Key)}, "")
+	case types.Record:
+		r := g.newRecord(t)
+		for i, el := range t.Elts {
+			g.builder.CreateStore(g.zero(el.Value), g.elem(r, i))
+		}
+		return r
+	}
+	return llvm.ConstNull(g.llvmType(t))
+}
+
+func (g *Generator) compositeLit(x *ast.CompositeLit) llvm.Value {
+	switch t := g.typeOf(x).(type) {
+	case types.Array:
+		a := g.builder.CreateCall(g.builtin["alloc_array"], []llvm.Value{kindOf(t.Key)}, "")
+		for i, e := range x.Elts {
+			k, v := llvm.ConstInt(i64, uint64(i), false), llvm.Value{}
+			if kv, ok := e.(*ast.KeyValueExpr); ok {
+				k, v = g.toWord(t.Key, g.expr(kv.Key)), g.expr(kv.Value)
+			} else {
+				v = g.expr(e)
+			}
+			g.builder.CreateCall(g.builtin["array_set"], []llvm.Value{a, k, g.toWord(t.Value, v)}, "")
+		}
+		return a
+	case types.Record:
+		// The elements of a record type are in the order of the literal.
+		r := g.newRecord(t)
+		for i, e := range x.Elts {
+			if kv, ok := e.(*ast.KeyValueExpr); ok {
+				e = kv.Value
+			}
+			g.builder.CreateStore(g.expr(e), g.elem(r, i))
+		}
+		return r
+	}
+	g.errorf(x.Pos(), "missing type in composite literal")
+	panic("unreachable")
+}
+
+// newRecord allocates a record of type r, whose elements are not
+// initialized.
+func (g *Generator) newRecord(r types.Record) llvm.Value {
+	t := g.llvmType(r)
+	p := g.builder.CreateCall(g.builtin["gc_alloc"], []llvm.Value{llvm.SizeOf(t.ElementType())}, "")
+	return g.builder.CreateBitCast(p, t, "")
+}
+
+// elem returns the address of the i'th element of the record r.
+func (g *Generator) elem(r llvm.Value, i int) llvm.Value {
+	return g.builder.CreateStructGEP(r, i, "")
+}
+
+// field returns the position of the element of r that x labels.
+func (g *Generator) field(r types.Record, x ast.Expr) int {
+	var k types.Key
+	switch x := x.(type) {
+	case *ast.Ident:
+		k = types.Key(x.Name.Lit)
+	case *ast.BasicLit:
+		k = types.Key(constant.MakeFromLiteral(x.Value.Lit, x.Value.Type).String())
+	}
+	for i, el := range r.Elts {
+		if el.Key == k {
+			return i
+		}
+	}
+	g.errorf(x.Pos(), "record %s has no element %s", r, k)
+	panic("unreachable")
+}
+
+func (g *Generator) index(x *ast.IndexExpr) llvm.Value {
+	switch t := g.typeOf(x.X).(type) {
+	case types.Record:
+		return g.builder.CreateLoad(g.elem(g.expr(x.X), g.field(t, x.Index)), "")
+	case types.Array:
+		a := g.expr(x.X)
+		if x.Backwards {
+			v := g.toWord(t.Value, g.expr(x.Index))
+			return g.builder.CreateCall(g.builtin["array_keys_of"], []llvm.Value{a, v, kindOf(t.Value)}, "")
+		}
+		k := g.expr(x.Index)
+		i := g.builder.CreateCall(g.builtin["array_find"], []llvm.Value{a, g.toWord(t.Key, k)}, "")
+		failed := g.builder.CreateICmp(llvm.IntSLT, i, llvm.ConstInt(i64, 0, false), "")
+		if verb, arg, ok := g.formatArg(t.Key, k); ok {
+			g.check(failed, x.Index.Pos(), "key "+verb+" not in array", arg)
+		} else {
+			g.check(failed, x.Index.Pos(), "key not in array")
+		}
+		return g.fromWord(t.Value, g.builder.CreateCall(g.builtin["array_value"], []llvm.Value{a, i}, ""))
+	}
+	s, i := g.expr(x.X), g.expr(x.Index)
+	g.checkIndex(x.Index.Pos(), s, i)
+	r := g.builder.CreateCall(g.builtin["index_rune"], []llvm.Value{s, i}, "")
+	return g.builder.CreateCall(g.builtin["rune_string"], []llvm.Value{r}, "")
+}
+
+// slice returns a new array or string holding the elements of x.X in
+// positions [low, high). Slicing an array renumbers its keys from zero.
+func (g *Generator) slice(x *ast.SliceExpr) llvm.Value {
+	v := g.expr(x.X)
+	fn, length := "slice_array", "array_len"
+	if g.typeOf(x.X) == types.String {
+		fn, length = "slice_string", "rune_count"
+	}
+	low := llvm.ConstInt(i64, 0, false)
+	if x.Low != nil {
+		low = g.expr(x.Low)
+	}
+	n := g.builder.CreateCall(g.builtin[length], []llvm.Value{v}, "")
+	high := n
+	if x.High != nil {
+		high = g.expr(x.High)
+	}
+	failed := g.builder.CreateOr(
+		g.builder.CreateOr(
+			g.builder.CreateICmp(llvm.IntSLT, low, llvm.ConstInt(i64, 0, false), ""),
+			g.builder.CreateICmp(llvm.IntSLT, high, low, ""), ""),
+		g.builder.CreateICmp(llvm.IntSGT, high, n, ""), "")
+	g.check(failed, x.Lbrack.Pos, "slice bounds out of range [%lld:%lld] with length %lld", low, high, n)
+	return g.builder.CreateCall(g.builtin[fn], []llvm.Value{v, low, high}, "")
+}
+
+// checkIndex panics at pos unless i is the index of a rune in the string s.
+func (g *Generator) checkIndex(pos scan.Pos, s, i llvm.Value) {
+	n := g.builder.CreateCall(g.builtin["rune_count"], []llvm.Value{s}, "")
+	failed := g.builder.CreateOr(
+		g.builder.CreateICmp(llvm.IntSLT, i, llvm.ConstInt(i64, 0, false), ""),
+		g.builder.CreateICmp(llvm.IntSGE, i, n, ""), "")
+	g.check(failed, pos, "index out of range [%lld] with length %lld", i, n)
+}
+
+// check generates a runtime panic at pos that occurs if failed is true.
+// The message is formatted from format and args by the runtime, like
+// printf.
+func (g *Generator) check(failed llvm.Value, pos scan.Pos, format string, args ...llvm.Value) {
+	fail := llvm.AddBasicBlock(g.fn.value, "panic")
+	ok := llvm.AddBasicBlock(g.fn.value, "ok")
+	g.builder.CreateCondBr(failed, fail, ok)
+	g.builder.SetInsertPointAtEnd(fail)
+	p := g.Config.Fset().Position(pos).String()
+	args = append([]llvm.Value{g.builder.CreateGlobalStringPtr(p, ""), g.builder.CreateGlobalStringPtr(format, "")}, args...)
+	g.builder.CreateCall(g.builtin["panic"], args, "")
+	g.builder.CreateUnreachable()
+	g.builder.SetInsertPointAtEnd(ok)
+}
+
+// formatArg returns the printf verb and argument with which a runtime
+// error message shows v, a value of type t. It reports false if values of
+// type t are not shown.
+func (g *Generator) formatArg(t types.Type, v llvm.Value) (string, llvm.Value, bool) {
+	switch t {
+	case types.Num:
+		return "%lld", v, true
+	case types.Float:
+		return "%g", v, true
+	case types.String:
+		return "'%s'", g.builder.CreateCall(g.builtin["c_str"], []llvm.Value{v}, ""), true
+	case types.Bool:
+		s := g.builder.CreateSelect(v, g.builder.CreateGlobalStringPtr("true", ""), g.builder.CreateGlobalStringPtr("false", ""), "")
+		return "%s", s, true
+	}
+	return "", llvm.Value{}, false
+}
+
+// call generates the call x and returns the values it produces.
+func (g *Generator) call(x *ast.CallExpr) []llvm.Value {
+	if id, ok := x.Fun.(*ast.Ident); ok && types.IsBuiltin(id.Obj) {
+		return g.builtinCall(id.Name.Lit, x)
+	}
+	sig := g.typeOf(x.Fun).(types.Signature)
+	code, env := g.callee(sig, x)
+	args := append([]llvm.Value{env}, g.args(sig, x)...)
+	res := results(sig)
+	v := g.builder.CreateCall(code, args, "")
+	switch len(res) {
+	case 0:
+		return nil
+	case 1:
+		return []llvm.Value{v}
+	}
+	vals := make([]llvm.Value, len(res))
+	for i := range vals {
+		vals[i] = g.builder.CreateExtractValue(v, i, "")
+	}
+	return vals
+}
+
+// args evaluates the arguments of x, a call to a function of type sig. The
+// trailing arguments to a variadic function are packed into an array.
+func (g *Generator) args(sig types.Signature, x *ast.CallExpr) []llvm.Value {
+	if !sig.Variadic || x.Ellipsis.Type == scan.Ellipsis {
+		return g.exprs(x.Args)
+	}
+	n := len(sig.Params) - 1
+	args := g.exprs(x.Args[:n])
+	elem := sig.Params[n].(types.Array).Value
+	arr := g.builder.CreateCall(g.builtin["alloc_array"], []llvm.Value{kindOf(types.Num)}, "")
+	for _, e := range x.Args[n:] {
+		g.builder.CreateCall(g.builtin["array_append"], []llvm.Value{arr, g.toWord(elem, g.expr(e))}, "")
+	}
+	return append(args, arr)
+}
+
+// toWord converts v, a value of type t, to the 64-bit representation in
+// which the runtime stores it.
+func (g *Generator) toWord(t types.Type, v llvm.Value) llvm.Value {
+	switch lt := g.llvmType(t); lt.TypeKind() {
+	case llvm.PointerTypeKind:
+		return g.builder.CreatePtrToInt(v, i64, "")
+	case llvm.DoubleTypeKind:
+		return g.builder.CreateBitCast(v, i64, "")
+	case llvm.IntegerTypeKind:
+		if lt.IntTypeWidth() < 64 {
+			return g.builder.CreateZExt(v, i64, "")
+		}
+	}
+	return v
+}
+
+// fromWord converts v from its runtime representation to a value of type t.
+func (g *Generator) fromWord(t types.Type, v llvm.Value) llvm.Value {
+	switch lt := g.llvmType(t); lt.TypeKind() {
+	case llvm.PointerTypeKind:
+		return g.builder.CreateIntToPtr(v, lt, "")
+	case llvm.DoubleTypeKind:
+		return g.builder.CreateBitCast(v, lt, "")
+	case llvm.IntegerTypeKind:
+		if lt.IntTypeWidth() < 64 {
+			return g.builder.CreateTrunc(v, lt, "")
+		}
+	}
+	return v
+}
+
+// builtinCall generates a call to the builtin function name.
+func (g *Generator) builtinCall(name string, x *ast.CallExpr) []llvm.Value {
+	switch name {
+	case "len":
+		switch t := g.typeOf(x.Args[0]).(type) {
+		case types.Record:
+			return []llvm.Value{llvm.ConstInt(i64, uint64(len(t.Elts)), false)}
+		case types.Array:
+			return []llvm.Value{g.builder.CreateCall(g.builtin["array_len"], []llvm.Value{g.expr(x.Args[0])}, "")}
+		}
+		return []llvm.Value{g.builder.CreateCall(g.builtin["rune_count"], []llvm.Value{g.expr(x.Args[0])}, "")}
+	case "append":
+		// A null array is empty, so extending it allocates the array that
+		// the values are appended to.
+		elem := g.typeOf(x.Args[0]).(types.Array).Value
+		a := g.expr(x.Args[0])
+		if x.Ellipsis.Type == scan.Ellipsis {
+			return []llvm.Value{g.builder.CreateCall(g.builtin["array_extend"], []llvm.Value{a, g.expr(x.Args[1])}, "")}
+		}
+		a = g.builder.CreateCall(g.builtin["array_extend"], []llvm.Value{a, llvm.ConstNull(ptr)}, "")
+		for _, e := range x.Args[1:] {
+			g.builder.CreateCall(g.builtin["array_append"], []llvm.Value{a, g.toWord(elem, g.expr(e))}, "")
+		}
+		return []llvm.Value{a}
+	case "delete":
+		key := g.typeOf(x.Args[0]).(types.Array).Key
+		a, k := g.expr(x.Args[0]), g.toWord(key, g.expr(x.Args[1]))
+		g.builder.CreateCall(g.builtin["array_delete"], []llvm.Value{a, k}, "")
+	case "panic":
+		g.check(llvm.ConstInt(i1, 1, false), x.Pos(), "%s", g.builder.CreateCall(g.builtin["c_str"], []llvm.Value{g.expr(x.Args[0])}, ""))
+	case "exit":
+		status := g.builder.CreateTrunc(g.expr(x.Args[0]), i32, "")
+		g.builder.CreateCall(g.builtin["exit"], []llvm.Value{status}, "")
+	case "printf":
+		// The runtime formats the arguments as the interpreter does, which
+		// depends on their types, so they are passed along with a string
+		// that describes each of their types.
+		if t := g.typeOf(x.Args[0]); t != types.String {
+			g.errorf(x.Args[0].Pos(), "printf format must be a str, not %s", t)
+		}
+		format := g.builder.CreateCall(g.builtin["c_str"], []llvm.Value{g.expr(x.Args[0])}, "")
+		if x.Ellipsis.Type == scan.Ellipsis {
+			elem := g.typeOf(x.Args[1]).(types.Array).Value
+			kind := g.builder.CreateGlobalStringPtr(printKind(elem), "")
+			g.builder.CreateCall(g.builtin["print_values"], []llvm.Value{kind, format, g.expr(x.Args[1])}, "")
+			return nil
+		}
+		var kinds string
+		args := []llvm.Value{{}, format}
+		for _, e := range x.Args[1:] {
+			v, t := g.expr(e), g.typeOf(e)
+			kinds += printKind(t)
+			switch {
+			case t == types.Bool:
+				v = g.builder.CreateZExt(v, i32, "")
+			case t == types.String:
+				v = g.builder.CreateCall(g.builtin["c_str"], []llvm.Value{v}, "")
+			case v.Type().TypeKind() == llvm.PointerTypeKind:
+				v = g.builder.CreateBitCast(v, ptr, "")
+			}
+			args = append(args, v)
+		}
+		args[0] = g.builder.CreateGlobalStringPtr(kinds, "")
+		g.builder.CreateCall(g.builtin["print_format"], args, "")
+	}
+	return nil
+}
+
+// printKind returns the description of values of type t with which the
+// runtime's print_format reads and prints them.
+func printKind(t types.Type) string {
+	switch t := t.(type) {
+	case types.Basic:
+		switch t {
+		case types.Float:
+			return "f"
+		case types.Bool:
+			return "b"
+		case types.String:
+			return "s"
+		}
+	case types.Array:
+		return "a" + printKind(t.Key) + printKind(t.Value)
+	case types.Record:
+		var b strings.Builder
+		b.WriteString("r{")
+		for _, el := range t.Elts {
+			k, _ := el.Key.(types.Key)
+			fmt.Fprintf(&b, "%d:%s%s", len(k), k, printKind(el.Value))
+		}
+		b.WriteString("}")
+		return b.String()
+	case types.Signature:
+		return "c"
+	}
+	return "n"
+}
+
+func (g *Generator) unary(x *ast.UnaryExpr) llvm.Value {
+	v := g.expr(x.X)
+	switch x.Op.Type {
+	case scan.Add:
+		return v
+	case scan.Sub:
+		if g.typeOf(x.X) == types.Float {
+			return g.builder.CreateFNeg(v, "")
+		}
+		return g.builder.CreateNeg(v, "")
+	case scan.Not, scan.Xor:
+		// ! negates a bool, and ^ complements the bits of a num or bool.
+		return g.builder.CreateNot(v, "")
+	}
+	g.errorf(x.Op.Pos, "invalid unary operator %s", x.Op.Type)
+	panic("unreachable")
+}
+
+// logical generates x, whose operator is && or ||, so that its right
+// operand is only evaluated when it determines the result.
+func (g *Generator) logical(x *ast.BinaryExpr) llvm.Value {
+	lhs := g.expr(x.X)
+	from := g.builder.GetInsertBlock()
+	rhs := llvm.AddBasicBlock(g.fn.value, "rhs")
+	done := llvm.AddBasicBlock(g.fn.value, "done")
+	short := llvm.ConstInt(i1, 0, false)
+	if x.Op.Type == scan.Land {
+		g.builder.CreateCondBr(lhs, rhs, done)
+	} else {
+		short = llvm.ConstInt(i1, 1, false)
+		g.builder.CreateCondBr(lhs, done, rhs)
+	}
+	g.builder.SetInsertPointAtEnd(rhs)
+	v := g.expr(x.Y)
+	rhs = g.builder.GetInsertBlock()
+	g.builder.CreateBr(done)
+	g.builder.SetInsertPointAtEnd(done)
+	phi := g.builder.CreatePHI(i1, "")
+	phi.AddIncoming([]llvm.Value{short, v}, []llvm.BasicBlock{from, rhs})
+	return phi
+}
+
+var (
+	signedPreds = map[scan.Type]llvm.IntPredicate{
+		scan.Eql: llvm.IntEQ,
+		scan.Neq: llvm.IntNE,
+		scan.Lss: llvm.IntSLT,
+		scan.Leq: llvm.IntSLE,
+		scan.Gtr: llvm.IntSGT,
+		scan.Geq: llvm.IntSGE,
+	}
+	// bools compare as unsigned, so that false < true
+	unsignedPreds = map[scan.Type]llvm.IntPredicate{
+		scan.Eql: llvm.IntEQ,
+		scan.Neq: llvm.IntNE,
+		scan.Lss: llvm.IntULT,
+		scan.Leq: llvm.IntULE,
+		scan.Gtr: llvm.IntUGT,
+		scan.Geq: llvm.IntUGE,
+	}
+	// every comparison with NaN is false, except !=
+	floatPreds = map[scan.Type]llvm.FloatPredicate{
+		scan.Eql: llvm.FloatOEQ,
+		scan.Neq: llvm.FloatUNE,
+		scan.Lss: llvm.FloatOLT,
+		scan.Leq: llvm.FloatOLE,
+		scan.Gtr: llvm.FloatOGT,
+		scan.Geq: llvm.FloatOGE,
+	}
+)
+
+// binary applies op to x and y, whose operands are of type t. Operations
+// that fail at run time panic at pos.
+func (g *Generator) binary(op scan.Type, pos scan.Pos, t types.Type, x, y llvm.Value) llvm.Value {
+	if pred, ok := signedPreds[op]; ok {
+		switch t {
+		case types.String:
+			cmp := g.builder.CreateCall(g.builtin["compare_strings"], []llvm.Value{x, y}, "")
+			return g.builder.CreateICmp(pred, cmp, llvm.ConstInt(i32, 0, false), "")
+		case types.Bool:
+			pred = unsignedPreds[op]
+		case types.Float:
+			return g.builder.CreateFCmp(floatPreds[op], x, y, "")
+		}
+		return g.builder.CreateICmp(pred, x, y, "")
+	}
+	if t == types.Float {
+		switch op {
+		case scan.Add:
+			return g.builder.CreateFAdd(x, y, "")
+		case scan.Sub:
+			return g.builder.CreateFSub(x, y, "")
+		case scan.Mul:
+			return g.builder.CreateFMul(x, y, "")
+		case scan.Quo:
+			return g.builder.CreateFDiv(x, y, "")
+		}
+	}
+	if t == types.String {
+		return g.builder.CreateCall(g.builtin["concat_strings"], []llvm.Value{x, y}, "")
+	}
+	if t == types.Bool {
+		// Arithmetic on bools is carried out on their integer values. A
+		// nonzero result is true.
+		x = g.builder.CreateZExt(x, i64, "")
+		if op != scan.Shl && op != scan.Shr {
+			y = g.builder.CreateZExt(y, i64, "")
+		}
+		v := g.binary(op, pos, types.Num, x, y)
+		return g.builder.CreateICmp(llvm.IntNE, v, llvm.ConstInt(i64, 0, false), "")
+	}
+	switch op {
+	case scan.Add:
+		return g.builder.CreateAdd(x, y, "")
+	case scan.Sub:
+		return g.builder.CreateSub(x, y, "")
+	case scan.Mul:
+		return g.builder.CreateMul(x, y, "")
+	case scan.Quo, scan.Rem:
+		zero := llvm.ConstInt(i64, 0, false)
+		g.check(g.builder.CreateICmp(llvm.IntEQ, y, zero, ""), pos, "integer divide by zero")
+		// Dividing the least number by -1 overflows, which LLVM leaves
+		// undefined. The quotient wraps around instead, and the remainder
+		// is 0.
+		neg1 := g.builder.CreateICmp(llvm.IntEQ, y, llvm.ConstInt(i64, ^uint64(0), true), "")
+		y = g.builder.CreateSelect(neg1, llvm.ConstInt(i64, 1, false), y, "")
+		if op == scan.Quo {
+			return g.builder.CreateSelect(neg1, g.builder.CreateNeg(x, ""), g.builder.CreateSDiv(x, y, ""), "")
+		}
+		return g.builder.CreateSelect(neg1, zero, g.builder.CreateSRem(x, y, ""), "")
+	case scan.And:
+		return g.builder.CreateAnd(x, y, "")
+	case scan.Or:
+		return g.builder.CreateOr(x, y, "")
+	case scan.Xor:
+		return g.builder.CreateXor(x, y, "")
+	case scan.AndNot:
+		return g.builder.CreateAnd(x, g.builder.CreateNot(y, ""), "")
+	case scan.Shl, scan.Shr:
+		g.check(g.builder.CreateICmp(llvm.IntSLT, y, llvm.ConstInt(i64, 0, false), ""), pos, "negative shift amount")
+		// LLVM leaves shifts by 64 or more undefined, but they shift out
+		// every bit.
+		big := g.builder.CreateICmp(llvm.IntSGT, y, llvm.ConstInt(i64, 63, false), "")
+		if op == scan.Shl {
+			return g.builder.CreateSelect(big, llvm.ConstInt(i64, 0, false), g.builder.CreateShl(x, y, ""), "")
+		}
+		return g.builder.CreateAShr(x, g.builder.CreateSelect(big, llvm.ConstInt(i64, 63, false), y, ""), "")
+	}
+	panic("unexpected operator " + op.String())
+}
@@ -1,572 +1,360 @@
+// Package llvm translates type-checked arvo programs into LLVM modules.
+//
+// Every value has the LLVM type that corresponds to its arvo type: num is
+// i64, float is double, bool is i1, and strings and arrays are pointers to
+// objects that the runtime manages. Polymorphic functions are
+// monomorphized: a function is compiled once for every combination of
+// types that it is used at. Function values are closures over the variables
+// of the functions that enclose them.
 package llvm
 
 import (
 	"fmt"
-	"strconv"
 
 	"github.com/smasher164/arvo/ast"
 	"github.com/smasher164/arvo/scan"
-
 	"github.com/smasher164/arvo/types"
 
 	"llvm.org/llvm/bindings/go/llvm"
 )
 
 type Generator struct {
-	Config  types.Config
-	builder llvm.Builder
-	mod     llvm.Module
-	builtin map[string]llvm.Value
-	m       map[ast.Node]llvm.Value
-	fnstk   []llvm.Value
-	bb      map[llvm.Value][]llvm.BasicBlock
-	ifposq  []int
+	Config   types.Config
+	builder  llvm.Builder
+	mod      llvm.Module
+	builtin  map[string]llvm.Value
+	defs     []*ast.FunDef                 // function definitions in the program, in source order
+	globals  map[*ast.Object]llvm.Value    // variables declared outside of any function
+	roots    []llvm.Value                  // globals that can refer to the heap
+	captures map[*ast.Object]capture       // variables that are stored in frames
+	frames   map[*ast.FunDef][]*ast.Object // captured variables of each function, in frame order
+	specs    map[specKey]*function         // compiled functions
+	queue    []*function                   // functions whose bodies are yet to be generated
+	fn       *function                     // function being generated
+	targets  []target                      // statements that enclose the one being generated
 }
 
-func (g *Generator) pushfn(fn llvm.Value) {
-	g.fnstk = append(g.fnstk, fn)
+// An Error is a construct that the generator cannot translate.
+type Error struct {
+	Pos scan.Position
+	Msg string
 }
 
-func (g *Generator) topfn() llvm.Value {
-	return g.fnstk[len(g.fnstk)-1]
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
 }
 
-func (g *Generator) popfn() (fn llvm.Value) {
-	fn, g.fnstk = g.fnstk[len(g.fnstk)-1], g.fnstk[:len(g.fnstk)-1]
-	return
+func (g *Generator) errorf(pos scan.Pos, format string, args ...interface{}) {
+	panic(&Error{
+		Pos: g.Config.Fset().Position(pos),
+		Msg: fmt.Sprintf(format, args...),
+	})
 }
 
-func (g *Generator) set(n ast.Node, v llvm.Value) {
-	switch n := n.(type) {
-	case *ast.Ident:
-		if n != nil {
-			if n.Obj != nil {
-				if n.Obj.Kind == ast.Fun {
-					if f, _ := n.Obj.Decl.(*ast.FunDef); f != nil {
-						// maybe an issue with anonymous functions?
-						g.m[f.Name] = v
-					}
-				} else if n.Obj.Kind == ast.Var {
-					if pr, _ := n.Obj.Decl.(*ast.Param); pr != nil {
-						g.m[pr.Name] = v
-					} else if as, _ := n.Obj.Decl.(*ast.AssignStmt); as != nil {
-						for _, e := range as.Lhs {
-							if id, _ := e.(*ast.Ident); id != nil {
-								if id.Name.Lit == n.Name.Lit {
-									g.m[id] = v
-									break
-								}
-							}
-						}
-					}
-				}
-			} else {
-				g.m[n] = v
-			}
-		}
-		g.m[n] = v
-	default:
-		g.m[n] = v
-	}
+// A function is a function definition compiled at particular types.
+type function struct {
+	def    *ast.FunDef // nil for main
+	parent *function   // function in which def is nested
+	subst  map[*types.Var]types.Type
+	sig    types.Signature
+	value  llvm.Value
+	entry  llvm.BasicBlock            // block that holds the allocas of the function's variables
+	vars   map[*ast.Object]llvm.Value // addresses of local variables
+	env    llvm.Value                 // environment that the functions nested in fn receive
+	static llvm.Value                 // closure of fn, if it is not nested
 }
 
-func (g *Generator) get(n ast.Node) llvm.Value {
-	switch n := n.(type) {
-	case *ast.Ident:
-		if n != nil {
-			if n.Obj != nil {
-				if n.Obj.Kind == ast.Fun {
-					if f, _ := n.Obj.Decl.(*ast.FunDef); f != nil {
-						return g.m[f.Name]
-					}
-				} else if n.Obj.Kind == ast.Var {
-					if pr, _ := n.Obj.Decl.(*ast.Param); pr != nil {
-						return g.m[pr.Name]
-					} else if as, _ := n.Obj.Decl.(*ast.AssignStmt); as != nil {
-						for _, e := range as.Lhs {
-							if id, _ := e.(*ast.Ident); id != nil {
-								if id.Name.Lit == n.Name.Lit {
-									return g.m[id]
-								}
-							}
-						}
-					}
-				}
-			} else {
-				return g.m[n]
+// A specKey identifies a compiled function. sig is the canonical string of
+// the types that the function is compiled at.
+type specKey struct {
+	def    *ast.FunDef
+	parent *function
+	sig    string
+}
+
+// apply returns t with the type variables that fn is compiled at replaced.
+// Type variables that remain unconstrained are represented as numbers.
+func (fn *function) apply(t types.Type) types.Type {
+	switch t := t.(type) {
+	case *types.Var:
+		for f := fn; f != nil; f = f.parent {
+			if u, ok := f.subst[t]; ok {
+				return u
 			}
 		}
-		return g.m[n]
-	default:
-		return g.m[n]
+		return types.Num
+	case types.Array:
+		return types.Array{Key: fn.apply(t.Key), Value: fn.apply(t.Value)}
+	case types.Record:
+		r := types.Record{N: t.N, Elts: make([]types.Element, len(t.Elts))}
+		for i, el := range t.Elts {
+			r.Elts[i] = types.Element{Key: el.Key, Value: fn.apply(el.Value)}
+		}
+		return r
+	case types.Tuple:
+		tu := make(types.Tuple, len(t))
+		for i := range t {
+			tu[i] = fn.apply(t[i])
+		}
+		return tu
+	case types.Signature:
+		return types.Signature{
+			Params:   fn.apply(t.Params).(types.Tuple),
+			Results:  fn.apply(t.Results),
+			Variadic: t.Variadic,
+		}
 	}
+	return t
 }
 
-func (g *Generator) pre(n ast.Node) bool {
-	switch t := n.(type) {
-	case *ast.IfStmt:
-		_ = t
-		// Thread position information for basic blocks on the way down.
-		ip := len(g.bb[g.topfn()])
-		g.ifposq = append(g.ifposq, ip)
-	case *ast.FunDef:
-		switch t.Name.Name.Lit {
-		case "printf", "exit":
-			return false
+// match binds the type variables in p to the corresponding parts of t.
+func match(p, t types.Type, m map[*types.Var]types.Type) {
+	switch p := p.(type) {
+	case *types.Var:
+		if _, ok := m[p]; !ok {
+			m[p] = t
+		}
+	case types.Array:
+		if t, ok := t.(types.Array); ok {
+			match(p.Key, t.Key, m)
+			match(p.Value, t.Value, m)
+		}
+	case types.Record:
+		if t, ok := t.(types.Record); ok && len(t.Elts) == len(p.Elts) {
+			for i := range p.Elts {
+				match(p.Elts[i].Value, t.Elts[i].Value, m)
+			}
+		}
+	case types.Tuple:
+		if t, ok := t.(types.Tuple); ok && len(t) == len(p) {
+			for i := range p {
+				match(p[i], t[i], m)
+			}
+		}
+	case types.Signature:
+		if t, ok := t.(types.Signature); ok {
+			match(p.Params, t.Params, m)
+			match(p.Results, t.Results, m)
 		}
-		// sig, ok := g.Config.Get(t).(types.Signature)
-		// if !ok {
-		//     panic("function type is not Signature")
-		// }
-		// sig
-		// main := llvm.FunctionType(llvm.Int32Type(), []llvm.Type{}, false)
-		// fn := llvm.AddFunction(g.mod, "main", main)
-		// g.pushfn(mainfn)
-		// block := llvm.AddBasicBlock(mainfn, "entry")
-		// g.bb[mainfn] = append(g.bb[mainfn], block)
-		// g.builder.SetInsertPointAtEnd(block)
-	case *ast.BlockStmt:
-		tfn := g.topfn()
-		entry := g.bb[tfn][len(g.bb[tfn])-1]
-		g.builder.SetInsertPointAtEnd(entry)
-		bb := llvm.AddBasicBlock(tfn, "")
-		g.bb[tfn] = append(g.bb[tfn], bb)
-		// g.nop()
-		g.builder.SetInsertPointAtEnd(bb)
 	}
-	return true
 }
 
-func (g *Generator) nop() {
-	g.builder.CreateAdd(llvm.ConstInt(llvm.Int64Type(), 0, false), llvm.ConstInt(llvm.Int64Type(), 0, false), "")
+// typeOf returns the type of x in the function being generated.
+func (g *Generator) typeOf(x ast.Expr) types.Type {
+	return g.fn.apply(g.Config.TypeOf(x))
 }
 
-// assignment will associate ident with value
-// use of ident in other statements/exprs will reference it
+var (
+	i1  = llvm.Int1Type()
+	i8  = llvm.Int8Type()
+	i32 = llvm.Int32Type()
+	i64 = llvm.Int64Type()
+	f64 = llvm.DoubleType()
+	ptr = llvm.PointerType(i8, 0)
+)
 
-func (g *Generator) post(n ast.Node) bool {
-	switch t := n.(type) {
-	case *ast.Ident:
-		if t.Name.Lit == "true" {
-			v := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(llvm.ConstInt(llvm.Int64Type(), 1, false), v)
-			g.set(n, g.builder.CreateLoad(v, ""))
-		} else if t.Name.Lit == "false" {
-			v := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(llvm.ConstInt(llvm.Int64Type(), 0, false), v)
-			g.set(n, g.builder.CreateLoad(v, ""))
+// llvmType returns the representation of values of type t.
+func (g *Generator) llvmType(t types.Type) llvm.Type {
+	switch t := t.(type) {
+	case types.Basic:
+		switch t {
+		case types.Bool:
+			return i1
+		case types.String:
+			return ptr
+		case types.Float:
+			return f64
 		}
-	case *ast.BasicLit:
-		if types.Match(t.Value.Type, scan.Int) {
-			i, err := strconv.Atoi(t.Value.Lit)
-			if err != nil {
-				panic(err)
-			}
-			v := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(llvm.ConstInt(llvm.Int64Type(), uint64(i), true), v)
-			g.set(n, g.builder.CreateLoad(v, ""))
-		} else if types.Match(t.Value.Type, scan.String) {
-			val, err := strconv.Unquote("\"" + t.Value.Lit[1:len(t.Value.Lit)-1] + "\"")
-			if err != nil {
-				panic(err)
-			}
-			sptr := g.builder.CreateAlloca(llvm.PointerType(llvm.Int8Type(), 0), "")
-			s := g.builder.CreateCall(g.builtin["alloc_string"], []llvm.Value{}, "")
-			g.builder.CreateCall(g.builtin["init_c_str"], []llvm.Value{s, g.builder.CreateGlobalStringPtr(val, "")}, "")
-			g.builder.CreateStore(s, sptr)
-			g.set(n, g.builder.CreateLoad(sptr, ""))
+		return i64
+	case types.Array:
+		return ptr
+	case types.Record:
+		elts := make([]llvm.Type, len(t.Elts))
+		for i, el := range t.Elts {
+			elts[i] = g.llvmType(el.Value)
 		}
-	case *ast.ParenExpr:
-		g.set(n, g.get(t.X))
-	case *ast.CallExpr:
-		if id, _ := t.Fun.(*ast.Ident); id != nil {
-			switch id.Name.Lit {
-			case "exit":
-				g.builder.CreateCall(g.builtin["exit"], []llvm.Value{
-					g.builder.CreateIntCast(g.get(t.Args[0]), llvm.Int32Type(), ""),
-				}, "")
-			case "printf":
-				values := []llvm.Value{g.builder.CreateCall(g.builtin["c_str"], []llvm.Value{g.get(t.Args[0])}, "")}
-				for i := 1; i < len(t.Args); i++ {
-					arg := g.get(t.Args[i])
-					values = append(values, g.builder.CreateCall(g.builtin["c_str"], []llvm.Value{arg}, ""))
-				}
-				g.builder.CreateCall(g.builtin["printf"], values, "")
-			}
+		return llvm.PointerType(llvm.StructType(elts, false), 0)
+	case types.Signature:
+		return llvm.PointerType(g.closureType(t), 0)
+	case types.Tuple:
+		switch len(t) {
+		case 0:
+			return llvm.VoidType()
+		case 1:
+			return g.llvmType(t[0])
 		}
-	case *ast.UnaryExpr:
-		switch t.Op.Type {
-		case scan.Add:
-			g.set(n, g.get(t.X))
-		case scan.Sub:
-			diff := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateSub(llvm.ConstInt(llvm.Int64Type(), 0, false), g.get(t.X), ""), diff)
-			g.set(n, g.builder.CreateLoad(diff, ""))
-		case scan.Not:
-			// logical negation
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			cmp := g.builder.CreateICmp(llvm.IntNE, g.get(t.X), llvm.ConstInt(llvm.Int64Type(), 0, false), "")
-			xor := g.builder.CreateXor(cmp, llvm.ConstInt(llvm.Int64Type(), 1, false), "")
-			zext := g.builder.CreateZExt(xor, llvm.Int32Type(), "")
-			sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-			g.builder.CreateStore(sext, res)
-			g.set(n, g.builder.CreateLoad(res, ""))
-		case scan.Xor:
-			// bitwise complement
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateNeg(g.get(t.X), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
+		elts := make([]llvm.Type, len(t))
+		for i := range t {
+			elts[i] = g.llvmType(t[i])
 		}
-	case *ast.BinaryExpr:
-		typX := g.Config.Get(t.X)
-		switch t.Op.Type {
-		case scan.Lor:
-			tfn := g.topfn()
-			entry := g.bb[tfn][len(g.bb[tfn])-1]
-			falseL := llvm.AddBasicBlock(tfn, "")
-			merge := llvm.AddBasicBlock(tfn, "")
-			g.bb[tfn] = append(g.bb[tfn], falseL, merge)
-			g.builder.SetInsertPointAtEnd(entry)
-			z := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			cmp1 := g.builder.CreateICmp(llvm.IntNE, g.get(t.X), llvm.ConstInt(llvm.Int64Type(), 0, false), "")
-			g.builder.CreateCondBr(cmp1, merge, falseL)
-			g.builder.SetInsertPointAtEnd(falseL)
-			cmp2 := g.builder.CreateICmp(llvm.IntNE, g.get(t.Y), llvm.ConstInt(llvm.Int64Type(), 0, false), "")
-			zext := g.builder.CreateZExt(cmp2, llvm.Int32Type(), "")
-			sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-			g.builder.CreateBr(merge)
-			g.builder.SetInsertPointAtEnd(merge)
-			phi := g.builder.CreatePHI(llvm.Int64Type(), "")
-			phi.AddIncoming([]llvm.Value{llvm.ConstInt(llvm.Int64Type(), 1, false), sext}, []llvm.BasicBlock{entry, falseL})
-			g.builder.CreateStore(phi, z)
-			g.set(n, g.builder.CreateLoad(z, ""))
-		case scan.Land:
-			tfn := g.topfn()
-			entry := g.bb[tfn][len(g.bb[tfn])-1]
-			trueL := llvm.AddBasicBlock(tfn, "")
-			merge := llvm.AddBasicBlock(tfn, "")
-			g.bb[tfn] = append(g.bb[tfn], trueL, merge)
-			g.builder.SetInsertPointAtEnd(entry)
-			z := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			cmp1 := g.builder.CreateICmp(llvm.IntNE, g.get(t.X), llvm.ConstInt(llvm.Int64Type(), 0, false), "")
-			g.builder.CreateCondBr(cmp1, trueL, merge)
-			g.builder.SetInsertPointAtEnd(trueL)
-			cmp2 := g.builder.CreateICmp(llvm.IntNE, g.get(t.Y), llvm.ConstInt(llvm.Int64Type(), 0, false), "")
-			zext := g.builder.CreateZExt(cmp2, llvm.Int32Type(), "")
-			sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-			g.builder.CreateBr(merge)
-			g.builder.SetInsertPointAtEnd(merge)
-			phi := g.builder.CreatePHI(llvm.Int64Type(), "")
-			phi.AddIncoming([]llvm.Value{llvm.ConstInt(llvm.Int64Type(), 0, false), sext}, []llvm.BasicBlock{entry, trueL})
-			g.builder.CreateStore(phi, z)
-			g.set(n, g.builder.CreateLoad(z, ""))
+		return llvm.StructType(elts, false)
+	}
+	return i64
+}
 
-		// rel_op. TODO(composite literal comparison)
-		case scan.Eql:
-			if types.Match(typX, types.Bool) || types.Match(typX, types.Num) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				cmp := g.builder.CreateICmp(llvm.IntEQ, g.get(t.X), g.get(t.Y), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			} else if types.Match(typX, types.String) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				v := g.builder.CreateCall(g.builtin["compare_strings"], []llvm.Value{g.get(t.X), g.get(t.Y)}, "")
-				cmp := g.builder.CreateICmp(llvm.IntEQ, v, llvm.ConstInt(llvm.Int32Type(), 0, false), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			}
-		case scan.Neq:
-			if types.Match(typX, types.Bool) || types.Match(typX, types.Num) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				cmp := g.builder.CreateICmp(llvm.IntNE, g.get(t.X), g.get(t.Y), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			} else if types.Match(typX, types.String) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				v := g.builder.CreateCall(g.builtin["compare_strings"], []llvm.Value{g.get(t.X), g.get(t.Y)}, "")
-				cmp := g.builder.CreateICmp(llvm.IntNE, v, llvm.ConstInt(llvm.Int32Type(), 0, false), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			}
-		case scan.Lss:
-			if types.Match(typX, types.Bool) || types.Match(typX, types.Num) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				cmp := g.builder.CreateICmp(llvm.IntSLT, g.get(t.X), g.get(t.Y), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			} else if types.Match(typX, types.String) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				v := g.builder.CreateCall(g.builtin["compare_strings"], []llvm.Value{g.get(t.X), g.get(t.Y)}, "")
-				cmp := g.builder.CreateICmp(llvm.IntSLT, v, llvm.ConstInt(llvm.Int32Type(), 0, false), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			}
-		case scan.Leq:
-			if types.Match(typX, types.Bool) || types.Match(typX, types.Num) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				cmp := g.builder.CreateICmp(llvm.IntSLE, g.get(t.X), g.get(t.Y), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			} else if types.Match(typX, types.String) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				v := g.builder.CreateCall(g.builtin["compare_strings"], []llvm.Value{g.get(t.X), g.get(t.Y)}, "")
-				cmp := g.builder.CreateICmp(llvm.IntSLE, v, llvm.ConstInt(llvm.Int32Type(), 0, false), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			}
-		case scan.Gtr:
-			if types.Match(typX, types.Bool) || types.Match(typX, types.Num) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				cmp := g.builder.CreateICmp(llvm.IntSGT, g.get(t.X), g.get(t.Y), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			} else if types.Match(typX, types.String) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				v := g.builder.CreateCall(g.builtin["compare_strings"], []llvm.Value{g.get(t.X), g.get(t.Y)}, "")
-				cmp := g.builder.CreateICmp(llvm.IntSGT, v, llvm.ConstInt(llvm.Int32Type(), 0, false), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			}
-		case scan.Geq:
-			if types.Match(typX, types.Bool) || types.Match(typX, types.Num) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				cmp := g.builder.CreateICmp(llvm.IntSGE, g.get(t.X), g.get(t.Y), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			} else if types.Match(typX, types.String) {
-				res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				v := g.builder.CreateCall(g.builtin["compare_strings"], []llvm.Value{g.get(t.X), g.get(t.Y)}, "")
-				cmp := g.builder.CreateICmp(llvm.IntSGE, v, llvm.ConstInt(llvm.Int32Type(), 0, false), "")
-				zext := g.builder.CreateZExt(cmp, llvm.Int32Type(), "")
-				sext := g.builder.CreateSExt(zext, llvm.Int64Type(), "")
-				g.builder.CreateStore(sext, res)
-				g.set(n, g.builder.CreateLoad(res, ""))
-			}
+// funcType returns the type of the code of functions of type sig, whose
+// first parameter is the environment.
+func (g *Generator) funcType(sig types.Signature) llvm.Type {
+	params := []llvm.Type{ptr}
+	for _, t := range sig.Params {
+		params = append(params, g.llvmType(t))
+	}
+	return llvm.FunctionType(g.llvmType(sig.Results), params, false)
+}
 
-		// add_op
-		case scan.Add:
-			if types.Match(typX, types.Bool) || types.Match(typX, types.Num) {
-				sum := g.builder.CreateAlloca(llvm.Int64Type(), "")
-				g.builder.CreateStore(g.builder.CreateAdd(g.get(t.X), g.get(t.Y), ""), sum)
-				g.set(n, g.builder.CreateLoad(sum, ""))
-			} else if types.Match(typX, types.String) {
-				sptr := g.builder.CreateAlloca(llvm.PointerType(llvm.Int8Type(), 0), "")
-				s := g.builder.CreateCall(g.builtin["concat_strings"], []llvm.Value{g.get(t.X), g.get(t.Y)}, "")
-				g.builder.CreateStore(s, sptr)
-				g.set(n, g.builder.CreateLoad(sptr, ""))
-			}
-		case scan.Sub:
-			diff := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateSub(g.get(t.X), g.get(t.Y), ""), diff)
-			g.set(n, g.builder.CreateLoad(diff, ""))
-		case scan.Or:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateOr(g.get(t.X), g.get(t.Y), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
-		case scan.Xor:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateXor(g.get(t.X), g.get(t.Y), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
+// results returns the types of the values that a function of type sig
+// produces.
+func results(sig types.Signature) types.Tuple {
+	tu, _ := sig.Results.(types.Tuple)
+	return tu
+}
+
+// parentOf returns the innermost function definition that contains pos,
+// other than except, or nil if pos is outside of every function.
+func (g *Generator) parentOf(pos scan.Pos, except *ast.FunDef) *ast.FunDef {
+	var p *ast.FunDef
+	for _, def := range g.defs {
+		if def != except && def.Pos() <= pos && pos < def.End() {
+			p = def
+		}
+	}
+	return p
+}
 
-		// mul_op
-		case scan.Mul:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateMul(g.get(t.X), g.get(t.Y), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
-		case scan.Quo:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateSDiv(g.get(t.X), g.get(t.Y), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
-		case scan.Rem:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateSRem(g.get(t.X), g.get(t.Y), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
-		case scan.Shl:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateShl(g.get(t.X), g.get(t.Y), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
-		case scan.Shr:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateAShr(g.get(t.X), g.get(t.Y), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
-		case scan.And:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateAnd(g.get(t.X), g.get(t.Y), ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
-		case scan.AndNot:
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			ny := g.builder.CreateNeg(g.get(t.Y), "")
-			g.builder.CreateStore(g.builder.CreateAnd(g.get(t.X), ny, ""), res)
-			g.set(n, g.builder.CreateLoad(res, ""))
+// ownerOf returns the function definition in which obj is declared, or nil
+// if it is declared outside of every function.
+func (g *Generator) ownerOf(obj *ast.Object) *ast.FunDef {
+	n, ok := obj.Decl.(ast.Node)
+	if !ok {
+		return nil
+	}
+	return g.parentOf(n.Pos(), nil)
+}
+
+// spec returns the function that def compiles to when used at type t.
+func (g *Generator) spec(def *ast.FunDef, t types.Signature) *function {
+	var parent *function
+	if p := g.parentOf(def.Pos(), def); p != nil {
+		for parent = g.fn; parent != nil && parent.def != p; parent = parent.parent {
 		}
-	case *ast.IncDecStmt:
-		if t.Tok.Type == scan.Inc {
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateAdd(g.get(t.X), llvm.ConstInt(llvm.Int64Type(), 1, false), ""), res)
-			g.set(t.X, g.builder.CreateLoad(res, ""))
-		} else if t.Tok.Type == scan.Dec {
-			res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-			g.builder.CreateStore(g.builder.CreateSub(g.get(t.X), llvm.ConstInt(llvm.Int64Type(), 1, false), ""), res)
-			g.set(t.X, g.builder.CreateLoad(res, ""))
+	}
+	key := specKey{def: def, parent: parent, sig: types.TypeString(t, nil)}
+	if fn, ok := g.specs[key]; ok {
+		return fn
+	}
+	fn := &function{def: def, parent: parent, subst: make(map[*types.Var]types.Type), sig: t}
+	match(g.Config.TypeOf(def), t, fn.subst)
+	name := "arvo.fun"
+	if def.Name != nil {
+		name = "arvo." + def.Name.Name.Lit
+	}
+	fn.value = llvm.AddFunction(g.mod, name, g.funcType(t))
+	fn.value.SetLinkage(llvm.InternalLinkage)
+	g.specs[key] = fn
+	g.queue = append(g.queue, fn)
+	return fn
+}
+
+// body generates the code of fn.
+func (g *Generator) body(fn *function) {
+	g.fn = fn
+	g.targets = nil
+	fn.vars = make(map[*ast.Object]llvm.Value)
+	fn.entry = llvm.AddBasicBlock(fn.value, "entry")
+	start := llvm.AddBasicBlock(fn.value, "start")
+	g.builder.SetInsertPointAtEnd(start)
+	g.newFrame()
+	if fn.def == nil {
+		for _, f := range g.Config.Files() {
+			g.stmts(f.Stmts)
 		}
-	case *ast.AssignStmt:
-		for i, l := range t.Lhs {
-			typL := g.Config.Get(l)
-			r := t.Rhs[i]
-			if types.Match(typL, types.Bool) || types.Match(typL, types.Num) {
-				switch t.Tok.Type {
-				case scan.In:
-				case scan.Assign:
-					as := g.builder.CreateAlloca(llvm.Int64Type(), "")
-					g.builder.CreateStore(g.get(r), as)
-					g.set(l, g.builder.CreateLoad(as, ""))
-				case scan.AddAssign:
-					if !g.get(l).IsNil() {
-						sum := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateAdd(g.get(l), g.get(r), ""), sum)
-						g.set(l, g.builder.CreateLoad(sum, ""))
-					}
-				case scan.SubAssign:
-					if !g.get(l).IsNil() {
-						diff := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateSub(g.get(l), g.get(r), ""), diff)
-						g.set(l, g.builder.CreateLoad(diff, ""))
-					}
-				case scan.MulAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateMul(g.get(l), g.get(r), ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				case scan.QuoAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateSDiv(g.get(l), g.get(r), ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				case scan.RemAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateSRem(g.get(l), g.get(r), ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				case scan.AndAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateAnd(g.get(l), g.get(r), ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				case scan.OrAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateOr(g.get(l), g.get(r), ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				case scan.XorAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateXor(g.get(l), g.get(r), ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				case scan.ShlAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateShl(g.get(l), g.get(r), ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				case scan.ShrAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						g.builder.CreateStore(g.builder.CreateAShr(g.get(l), g.get(r), ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				case scan.AndNotAssign:
-					if !g.get(l).IsNil() {
-						res := g.builder.CreateAlloca(llvm.Int64Type(), "")
-						nr := g.builder.CreateNeg(g.get(r), "")
-						g.builder.CreateStore(g.builder.CreateAnd(g.get(l), nr, ""), res)
-						g.set(l, g.builder.CreateLoad(res, ""))
-					}
-				}
-			} else if types.Match(typL, types.String) {
-				switch t.Tok.Type {
-				case scan.In:
-				case scan.Assign:
-					sptr := g.builder.CreateAlloca(llvm.PointerType(llvm.Int8Type(), 0), "")
-					g.builder.CreateStore(g.get(r), sptr)
-					g.set(l, g.builder.CreateLoad(sptr, ""))
-				case scan.AddAssign:
-					if !g.get(l).IsNil() {
-						sptr := g.builder.CreateAlloca(llvm.PointerType(llvm.Int8Type(), 0), "")
-						s := g.builder.CreateCall(g.builtin["concat_strings"], []llvm.Value{g.get(l), g.get(r)}, "")
-						g.builder.CreateStore(s, sptr)
-						g.set(l, g.builder.CreateLoad(sptr, ""))
-					}
-				}
+		g.builder.CreateRet(llvm.ConstInt(i32, 0, false))
+	} else {
+		for i, pr := range fn.def.Params {
+			if g.Config.ObjectOf(pr.Name) != nil {
+				g.builder.CreateStore(fn.value.Param(i+1), g.addr(pr.Name))
 			}
 		}
-	case *ast.IfStmt:
-		tfn := g.topfn()
-		var ip int
-		ip, g.ifposq = g.ifposq[0], g.ifposq[1:]
-		entry := g.bb[tfn][ip-1]
-		T := g.bb[tfn][ip]
-		var F llvm.BasicBlock
-		T.MoveAfter(entry)
-		if t.Else == nil {
-			// ip, ip+1
-			F = llvm.AddBasicBlock(tfn, "")
-			g.bb[tfn] = g.bb[tfn][:ip]
-		} else {
-			F = g.bb[tfn][ip+1]
-			g.bb[tfn] = g.bb[tfn][:ip+1]
+		g.stmts(fn.def.Body.List)
+		g.ret(nil)
+	}
+	g.builder.SetInsertPointAtEnd(fn.entry)
+	g.builder.CreateBr(start)
+}
+
+// ret returns vals from the function being generated. Missing values are
+// zero.
+func (g *Generator) ret(vals []llvm.Value) {
+	res := results(g.fn.sig)
+	switch len(res) {
+	case 0:
+		g.builder.CreateRetVoid()
+	case 1:
+		if len(vals) == 0 {
+			vals = []llvm.Value{llvm.ConstNull(g.llvmType(res[0]))}
+		}
+		g.builder.CreateRet(vals[0])
+	default:
+		agg := llvm.Undef(g.llvmType(res))
+		for i := range vals {
+			agg = g.builder.CreateInsertValue(agg, vals[i], i, "")
+		}
+		g.builder.CreateRet(agg)
+	}
+}
+
+// dead starts a new block for the code that follows a terminator, which is
+// unreachable.
+func (g *Generator) dead() {
+	g.builder.SetInsertPointAtEnd(llvm.AddBasicBlock(g.fn.value, ""))
+}
+
+// addr returns the address of the variable that id denotes.
+func (g *Generator) addr(id *ast.Ident) llvm.Value {
+	obj := g.Config.ObjectOf(id)
+	if c, ok := g.captures[obj]; ok {
+		return g.capturedAddr(c)
+	}
+	if g.ownerOf(obj) == nil {
+		v, ok := g.globals[obj]
+		if !ok {
+			t := g.llvmType(g.typeOf(id))
+			v = llvm.AddGlobal(g.mod, t, "arvo."+obj.Name)
+			v.SetLinkage(llvm.InternalLinkage)
+			v.SetInitializer(llvm.ConstNull(t))
+			g.globals[obj] = v
+			if t.TypeKind() == llvm.PointerTypeKind {
+				g.roots = append(g.roots, v)
+			}
 		}
-		F.MoveAfter(T)
-		E := llvm.AddBasicBlock(tfn, "")
-		E.MoveAfter(F)
-		g.builder.SetInsertPointAtEnd(entry)
-		cmp := g.builder.CreateICmp(llvm.IntNE, g.get(t.Cond), llvm.ConstInt(llvm.Int64Type(), 0, false), "")
-		g.builder.CreateCondBr(cmp, T, F)
-		g.builder.SetInsertPointAtEnd(T)
-		g.builder.CreateBr(E)
-		g.builder.SetInsertPointAtEnd(F)
-		g.builder.CreateBr(E)
-		g.builder.SetInsertPointAtEnd(E)
-	case *ast.FunDef:
-		// switch t.Name.Name.Lit {
-		// case "printf", "exit":
-		// 	g.popfn()
-		// }
-	case *ast.File:
-		// g.builder.SetInsertPointAtEnd(g.bb[g.topfn()][0])
-		ret := g.builder.CreateAlloca(llvm.Int32Type(), "ret")
-		g.builder.CreateStore(llvm.ConstInt(llvm.Int32Type(), 0, false), ret)
-		retVal := g.builder.CreateLoad(ret, "retVal")
-		g.builder.CreateRet(retVal)
+		return v
+	}
+	v, ok := g.fn.vars[obj]
+	if !ok {
+		v = g.alloca(g.llvmType(g.typeOf(id)), obj.Name)
+		g.fn.vars[obj] = v
+	}
+	return v
+}
+
+// alloca returns the address of a new zeroed local variable of type t.
+func (g *Generator) alloca(t llvm.Type, name string) llvm.Value {
+	b := llvm.NewBuilder()
+	defer b.Dispose()
+	b.SetInsertPointAtEnd(g.fn.entry)
+	v := b.CreateAlloca(t, name)
+	b.CreateStore(llvm.ConstNull(t), v)
+	return v
+}
+
+// addRoots registers the globals that can refer to the heap as roots of
+// the garbage collector, before main runs.
+func (g *Generator) addRoots(main *function) {
+	g.builder.SetInsertPointBefore(main.entry.FirstInstruction())
+	for _, v := range g.roots {
+		root := g.builder.CreateBitCast(v, llvm.PointerType(ptr, 0), "")
+		g.builder.CreateCall(g.builtin["gc_add_root"], []llvm.Value{root}, "")
 	}
-	return true
 }
 
 func (g *Generator) initLibC() {
@@ -576,23 +364,36 @@ func (g *Generator) initLibC() {
 		[]llvm.Type{llvm.Int32Type()},
 		false,
 	))
-	// void *malloc(size_t size);
-	g.builtin["malloc"] = llvm.AddFunction(g.mod, "malloc", llvm.FunctionType(
-		llvm.PointerType(llvm.Int8Type(), 0),
-		[]llvm.Type{llvm.Int64Type()},
+	// void print_format(const char *kinds, const char *format, ...);
+	g.builtin["print_format"] = llvm.AddFunction(g.mod, "print_format", llvm.FunctionType(
+		llvm.VoidType(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.PointerType(llvm.Int8Type(), 0)},
+		true,
+	))
+	// void print_values(const char *kind, const char *format, array *a);
+	g.builtin["print_values"] = llvm.AddFunction(g.mod, "print_values", llvm.FunctionType(
+		llvm.VoidType(),
+		[]llvm.Type{ptr, ptr, ptr},
 		false,
 	))
-	// void *memcpy(void *dest, const void *src, size_t n);
-	g.builtin["memcpy"] = llvm.AddFunction(g.mod, "memcpy", llvm.FunctionType(
+	// void panic(const char *pos, const char *format, ...);
+	g.builtin["panic"] = llvm.AddFunction(g.mod, "panic", llvm.FunctionType(
+		llvm.VoidType(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.PointerType(llvm.Int8Type(), 0)},
+		true,
+	))
+	// gc.h
+	// void *gc_alloc(int64_t size);
+	g.builtin["gc_alloc"] = llvm.AddFunction(g.mod, "gc_alloc", llvm.FunctionType(
 		llvm.PointerType(llvm.Int8Type(), 0),
-		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type()},
+		[]llvm.Type{llvm.Int64Type()},
 		false,
 	))
-	// int printf(const char *format, ...);
-	g.builtin["printf"] = llvm.AddFunction(g.mod, "printf", llvm.FunctionType(
-		llvm.Int32Type(),
-		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0)},
-		true,
+	// void gc_add_root(void **p);
+	g.builtin["gc_add_root"] = llvm.AddFunction(g.mod, "gc_add_root", llvm.FunctionType(
+		llvm.VoidType(),
+		[]llvm.Type{llvm.PointerType(llvm.PointerType(llvm.Int8Type(), 0), 0)},
+		false,
 	))
 	// string.h
 	// string *alloc_string();
@@ -625,12 +426,6 @@ func (g *Generator) initLibC() {
 		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type()},
 		false,
 	))
-	// void free_string(string *s);
-	g.builtin["free_string"] = llvm.AddFunction(g.mod, "free_string", llvm.FunctionType(
-		llvm.VoidType(),
-		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0)},
-		false,
-	))
 	// int32_t assign_string(string *s, int64_t i, string *c);
 	g.builtin["assign_string"] = llvm.AddFunction(g.mod, "assign_string", llvm.FunctionType(
 		llvm.Int32Type(),
@@ -649,29 +444,134 @@ func (g *Generator) initLibC() {
 		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.PointerType(llvm.Int8Type(), 0)},
 		false,
 	))
+	// string *rune_string(rune r);
+	g.builtin["rune_string"] = llvm.AddFunction(g.mod, "rune_string", llvm.FunctionType(
+		llvm.PointerType(llvm.Int8Type(), 0),
+		[]llvm.Type{llvm.Int32Type()},
+		false,
+	))
+	// string *slice_string(string *s, int64_t low, int64_t high);
+	g.builtin["slice_string"] = llvm.AddFunction(g.mod, "slice_string", llvm.FunctionType(
+		llvm.PointerType(llvm.Int8Type(), 0),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type(), llvm.Int64Type()},
+		false,
+	))
+	// array.h
+	// array *alloc_array(int32_t keykind);
+	g.builtin["alloc_array"] = llvm.AddFunction(g.mod, "alloc_array", llvm.FunctionType(
+		llvm.PointerType(llvm.Int8Type(), 0),
+		[]llvm.Type{llvm.Int32Type()},
+		false,
+	))
+	// void array_append(array *a, int64_t v);
+	g.builtin["array_append"] = llvm.AddFunction(g.mod, "array_append", llvm.FunctionType(
+		llvm.VoidType(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type()},
+		false,
+	))
+	// array *copy_array(array *a);
+	g.builtin["copy_array"] = llvm.AddFunction(g.mod, "copy_array", llvm.FunctionType(
+		llvm.PointerType(llvm.Int8Type(), 0),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0)},
+		false,
+	))
+	// int64_t array_len(array *a);
+	g.builtin["array_len"] = llvm.AddFunction(g.mod, "array_len", llvm.FunctionType(
+		llvm.Int64Type(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0)},
+		false,
+	))
+	// int64_t array_key(array *a, int64_t i);
+	g.builtin["array_key"] = llvm.AddFunction(g.mod, "array_key", llvm.FunctionType(
+		llvm.Int64Type(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type()},
+		false,
+	))
+	// int64_t array_value(array *a, int64_t i);
+	g.builtin["array_value"] = llvm.AddFunction(g.mod, "array_value", llvm.FunctionType(
+		llvm.Int64Type(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type()},
+		false,
+	))
+	// int64_t array_find(array *a, int64_t k);
+	g.builtin["array_find"] = llvm.AddFunction(g.mod, "array_find", llvm.FunctionType(
+		llvm.Int64Type(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type()},
+		false,
+	))
+	// void array_set(array *a, int64_t k, int64_t v);
+	g.builtin["array_set"] = llvm.AddFunction(g.mod, "array_set", llvm.FunctionType(
+		llvm.VoidType(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type(), llvm.Int64Type()},
+		false,
+	))
+	// array *array_keys_of(array *a, int64_t v, int32_t valkind);
+	g.builtin["array_keys_of"] = llvm.AddFunction(g.mod, "array_keys_of", llvm.FunctionType(
+		llvm.PointerType(llvm.Int8Type(), 0),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type(), llvm.Int32Type()},
+		false,
+	))
+	// array *array_extend(array *a, array *b);
+	g.builtin["array_extend"] = llvm.AddFunction(g.mod, "array_extend", llvm.FunctionType(
+		llvm.PointerType(llvm.Int8Type(), 0),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.PointerType(llvm.Int8Type(), 0)},
+		false,
+	))
+	// void array_delete(array *a, int64_t k);
+	g.builtin["array_delete"] = llvm.AddFunction(g.mod, "array_delete", llvm.FunctionType(
+		llvm.VoidType(),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type()},
+		false,
+	))
+	// array *slice_array(array *a, int64_t low, int64_t high);
+	g.builtin["slice_array"] = llvm.AddFunction(g.mod, "slice_array", llvm.FunctionType(
+		llvm.PointerType(llvm.Int8Type(), 0),
+		[]llvm.Type{llvm.PointerType(llvm.Int8Type(), 0), llvm.Int64Type(), llvm.Int64Type()},
+		false,
+	))
 }
 
-func (g *Generator) CreateModule() llvm.Module {
+// CreateModule translates the program in g.Config, which must have been
+// checked by types.Infer without errors.
+func (g *Generator) CreateModule() (mod llvm.Module, err error) {
 	g.builtin = make(map[string]llvm.Value)
-	g.m = make(map[ast.Node]llvm.Value)
-	g.bb = make(map[llvm.Value][]llvm.BasicBlock)
+	g.globals = make(map[*ast.Object]llvm.Value)
+	g.specs = make(map[specKey]*function)
 	g.builder = llvm.NewBuilder()
 	g.mod = llvm.NewModule("module")
 	g.initLibC()
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
 
-	main := llvm.FunctionType(llvm.Int32Type(), []llvm.Type{}, false)
-	mainfn := llvm.AddFunction(g.mod, "main", main)
-	g.pushfn(mainfn)
-	block := llvm.AddBasicBlock(mainfn, "entry")
-	g.bb[mainfn] = append(g.bb[mainfn], block)
-	g.builder.SetInsertPointAtEnd(block)
-
-	ast.Walk(g.Config.File, g.pre, g.post)
+	for _, f := range g.Config.Files() {
+		ast.Walk(f, func(n ast.Node) bool {
+			if def, ok := n.(*ast.FunDef); ok {
+				g.defs = append(g.defs, def)
+			}
+			return true
+		}, nil)
+	}
+	g.findCaptures()
 
-	// verify it's all good
-	if ok := llvm.VerifyModule(g.mod, llvm.ReturnStatusAction); ok != nil {
-		fmt.Println(ok.Error())
+	main := &function{sig: types.Signature{Results: types.Tuple{}}}
+	main.value = llvm.AddFunction(g.mod, "main", llvm.FunctionType(i32, nil, false))
+	g.body(main)
+	for len(g.queue) > 0 {
+		fn := g.queue[0]
+		g.queue = g.queue[1:]
+		g.body(fn)
 	}
+	g.addRoots(main)
 
-	return g.mod
+	if err := llvm.VerifyModule(g.mod, llvm.ReturnStatusAction); err != nil {
+		return g.mod, err
+	}
+	return g.mod, nil
 }
@@ -0,0 +1,257 @@
+package llvm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smasher164/arvo/internal/parsetest"
+	"github.com/smasher164/arvo/interp"
+	"github.com/smasher164/arvo/runtime"
+	"github.com/smasher164/arvo/types"
+
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+func check(t *testing.T, src string) types.Config {
+	t.Helper()
+	conf := types.Config{File: parsetest.File(t, src, nil)}
+	if err := types.Infer(&conf); err != nil {
+		t.Fatalf("check %q: %v", src, err)
+	}
+	return conf
+}
+
+func generate(t *testing.T, src string) error {
+	t.Helper()
+	g := &Generator{Config: check(t, src)}
+	mod, err := g.CreateModule()
+	mod.Dispose()
+	return err
+}
+
+var validCases = []string{
+	"fun fib(n) { if n < 2 { return n }\nreturn fib(n-1) + fib(n-2) }\nexit(fib(10))",
+	"fun swap(x, y) { return y, x }\na, b = swap(1, 2)\nc, d = swap('x', true)",
+	"fun id(x) { return x }\nx = id(1) + 1\ny = id('s') + 's'\nz = id(id)(true)",
+	"g = 1\nfun add(x) { return x + g }\ng = add(2)",
+	"f = fun(x) { return x * 2 }\nh = f\nprintf('%d\\n', h(1))",
+	"fun sum(...xs) {}\nsum()\nsum(1, 2)\nsum('a')",
+	"x = one()\nfun one() { return 1 }",
+	"fun even(n) { if n == 0 { return true }\nreturn !even(n - 1) }\nb = even(4) && true || false",
+	"fun f() { return }\nf()",
+	"b = even(10) && odd(7)\nfun even(n) { if n == 0 { return true }\nreturn odd(n - 1) }\nfun odd(n) { if n == 0 { return false }\nreturn even(n - 1) }",
+	"x = id(1) + 1\ny = id('s') + 't'\nfun id(x) { return x }",
+
+	// floats
+	"x = 1.5\ny = -x * 2 + 1 - 0.5 / x\nx++\nprintf('%v %.2f\\n', x, y)",
+	"fun half(x) { return x / 2 }\nb = half(3.0) < half(4) && half(1.0) != 0.5",
+	"x = a{1.5: 2.5}\nfor k, v in x { printf('%v %v', k, v) }\ny = x[1.5]",
+	"x = 2.5\nswitch x {\ncase 1, 2.5: printf('%v', x)\n}",
+	"x = 0b101 + 0o17 + 017 + 0x_1F + 1_000\ny = 0x1.8p1 + 1_0e-2 + 100000000000000000000\nz = r{'a', 'b'}[0b1]",
+
+	// loops and switch
+	"t = 0\nfor i = 0; i < 10; i++ { if i % 2 == 0 { continue }\nt += i }",
+	"outer: for i = 0; i < 3; i++ { for j = 0; j < 3; j++ { if j == 1 { continue outer }\nif i == 2 { break outer } } }",
+	"fun sum(...xs) { t = 0\nfor i, k, v in xs { t += i + k + v }\nreturn t }\nexit(sum(1, 2))",
+	"for i, c in 'hé' { printf('%s', c) }",
+	"x = 'b'\nswitch x {\ncase 'a': printf('a')\ncase 'b', 'c': break\ndefault: printf('other')\n}",
+	"l: for {\nswitch {\ncase true: break l\n}\n}",
+	"b: { if true { break b } }",
+
+	// arrays and records
+	"x = a{1, 2, 3}\nx[3] = 4\ny = x[[2]]\nz = x[1:3]\nexit(x[0] + y[0] + z[0])",
+	"x = a{'k': 'v'}\nx['j'] = x['k'] + '!'\nfor k, v in x { printf('%s', k + v) }",
+	"x = r{name: 'n', 1, r{b: true}}\nx.name = 'm'\nx[1] = x[1] + 1\nx[2].b = !x[2].b\nfor k, v in r{a: 1, b: 2} {}",
+	"s = 'abc'\ns[1] = 'x'\nt = s[1:] + s[:1] + s[2]",
+	"var x\nx[0] = a{r{n: 1}}\ny = x[0][0].n",
+
+	// builtins
+	"x = a{1}\nx = append(x, 2, 3)\nx = append(x, x...)\nexit(len(x) + len('hé') + len(r{a: 1}))",
+	"x = a{'k': 1.5}\ndelete(x, 'k')\nvar y\ny = append(y, 's')\nif len(y) == 0 { panic('empty') }",
+	"fun f(s) { printf(s) }\nf('x')\nb = true || false",
+	"printf('%d %s %v %v\\n', 1, 'a', true, 2.5)\nprintf('%v %d\\n', 1e20, 1)",
+
+	// closures
+	"fun f(n) { return fun() { return n } }\ny = f(1)()",
+	"fun counter() { n = 0\nreturn fun() { n++\nreturn n } }\nc = counter()\nc()\nexit(c())",
+	"fun compose(f, g) { return fun(x) { return f(g(x)) } }\nh = compose(fun(x) { return x + 1 }, fun(x) { return x * 2 })\nexit(h(3))",
+	"fun outer(x) { fun middle() { return fun() { x += 1\nreturn x } }\nreturn middle()() }\nexit(outer(1))",
+	"fun o() { fun i(n) { if n == 0 { return 0 }\nreturn i(n - 1) + 1 }\nreturn i(3) }\nexit(o())",
+	"fs = a{fun(x) { return x }, fun(x) { return -x }}\nr1 = r{f: fun(s) { return s + '!' }}\nt = fs[1](2)\nu = r1.f('a')",
+}
+
+func TestCreateModule(t *testing.T) {
+	for _, src := range validCases {
+		if err := generate(t, src); err != nil {
+			t.Errorf("generate %q: %v", src, err)
+		}
+	}
+}
+
+// runCases are programs whose output depends on how they are compiled: the
+// layout of arrays and records, the frames of closures, the checks that
+// panic at run time, the values of constants, and how values are printed.
+var runCases = []string{
+	// arrays and records
+	"x = a{1, 2, 3}\nx[5] = 6\ndelete(x, 0)\nfor k, v in x { printf('%d:%d ', k, v) }\nx = append(x, 7)\nprintf('%d %d\\n', len(x), x[6])",
+	"x = a{'b': 1.5, 'a': 2.5}\nx['c'] = x['a'] + x['b']\nfor k, v in x { printf('%s=%v ', k, v) }\nprintf('%v\\n', len(x))",
+	"x = a{5, 6, 5, 7}\nfor _, k in x[[5]] { printf('%d ', k) }\nfor _, v in x[1:3] { printf('%d ', v) }\nprintf('%d\\n', len(x[[9]]))",
+	"x = r{name: 'n', n: 1, f: 2.5, b: true}\nx.n += 1\nx.f *= 2\nprintf('%s %d %v %v\\n', x.name, x.n, x.f, x.b)\nfor k, v in r{a: 'x', b: 'y'} { printf('%s%s ', k, v) }",
+	"x = a{a{1}, a{2, 3}}\nx[0] = append(x[0], 4)\nprintf('%d %d %d\\n', len(x[0]), x[0][1], x[1][1])",
+	"s = 'héllo'\nprintf('%s %s %d\\n', s[1], s[1:4], len(s))\nfor i, c in s { if i > 1 { break }\nprintf('%d%s ', i, c) }",
+
+	// closures
+	"fun counter() { n = 0\nreturn fun() { n++\nreturn n } }\nc = counter()\nd = counter()\nc()\nc()\nprintf('%d %d\\n', c(), d())",
+	"fun outer(x) { y = x * 2\nfun inner() { return fun() { y += x\nreturn y } }\nreturn inner() }\nf = outer(3)\nf()\nprintf('%d\\n', f())",
+	"fun compose(f, g) { return fun(x) { return f(g(x)) } }\nsq = fun(x) { return x * x }\nh = compose(sq, fun(x) { return x + 0.5 })\nprintf('%v\\n', h(1.5))",
+
+	// run-time checks
+	"x = a{1, 2}\nprintf('%d\\n', x[1])\ny = x[2]",
+	"x = a{'k': 1}\ny = x['j']",
+	"s = 'ab'\ny = s[5]",
+	"x = a{1, 2}\ny = x[1:3]",
+	"x = 0\ny = 10 % x",
+	"x = -1\ny = 1 << x",
+	"printf('before\\n')\npanic('bad ' + 'thing')",
+	"printf('a')\nexit(3)\nprintf('b')",
+
+	// constants
+	"printf('%d %d %d\\n', 7 / 2, -7 % 3, (1 << 62) - 1 + (1 << 62))",
+	"printf('%v %v %v\\n', 0.1 + 0.2, 7.0 / 2, 1e300 * 10)",
+	"x = 0.1\nprintf('%v %v %v\\n', x + 0.2, 0.1 + 0.2 == 0.3, (1 << 70) >> 60)",
+	"printf('%v %v %s\\n', 1 < 2 && 'a' != 'b', !(2.5 > 3), 'con' + 'cat')",
+	"x = 9223372036854775807\nprintf('%d %d\\n', x, -9223372036854775807 - 1)",
+	"printf('%d %s %v %v\\n', 1, 'a', true, 2.5)\nprintf('%v %d\\n', 1e20, 1)",
+
+	// printing
+	"x = a{1, 2, 3}\ny = a{'k': 1.5, 'it\\'s': -2.5}\nz = r{name: 'n\\t', ok: true, f: 0.5, xs: x, g: fun(v) { return v }}\nprintf('%v %s %v\\n', x, y, z)",
+	"x = a{a{1}, a{}}\ndelete(x, 0)\nprintf('%v|%q|%8v|%-6s|%d|\\n', x, x, a{true: 'b'}, r{1, 2}, a{2.5: r{b: false}})",
+	"var f\nf = fun() {}\nprintf('%v %v %x\\n', a{f}, r{f: f}, a{'é'})",
+	"printf('%q|%+q|%#q|%#q|%x|% X|%#x|%.1x\\n', 'hé\\n\\x01', 'é', 'a b', 'a\\tb`', 'hi', 'hi', 'hi', 'hi')",
+	"fun f(s, ...xs) { printf(s, xs...) }\nf('%d-%d %v\\n', 1, 2)\nf('%v %s|', 'a', 'b', 'c')\nf('%v\\n', a{1}, a{2})",
+}
+
+// TestRun builds every program into an executable and checks that it
+// behaves as the interpreter does: that it writes the same output and
+// exits with the same status, after the same runtime error if any.
+func TestRun(t *testing.T) {
+	cc := compiler(t)
+	if err := llvm.InitializeNativeTarget(); err != nil {
+		t.Skip(err)
+	}
+	if err := llvm.InitializeNativeAsmPrinter(); err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	rt := buildRuntime(t, cc, dir)
+	for i, src := range append(validCases[:len(validCases):len(validCases)], runCases...) {
+		exe := filepath.Join(dir, fmt.Sprint("prog", i))
+		if err := build(t, cc, src, exe, rt); err != nil {
+			t.Errorf("build %q: %v", src, err)
+			continue
+		}
+		var stdout, stderr strings.Builder
+		cmd := exec.Command(exe)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		status := 0
+		if err := cmd.Run(); err != nil {
+			e, ok := err.(*exec.ExitError)
+			if !ok {
+				t.Errorf("run %q: %v", src, err)
+				continue
+			}
+			status = e.ExitCode()
+		}
+
+		var out strings.Builder
+		in := &interp.Interpreter{Config: check(t, src), Stdout: &out}
+		wantStatus, wantStderr := 0, ""
+		switch err := in.Run().(type) {
+		case nil:
+		case interp.Exit:
+			wantStatus = int(err)
+		case *interp.RuntimeError:
+			wantStatus, wantStderr = 2, err.Error()+"\n"
+		default:
+			t.Fatalf("interpret %q: %v", src, err)
+		}
+		if stdout.String() != out.String() || status != wantStatus || stderr.String() != wantStderr {
+			t.Errorf("run %q: got %q, status %d, stderr %q; want %q, status %d, stderr %q",
+				src, stdout.String(), status, stderr.String(), out.String(), wantStatus, wantStderr)
+		}
+	}
+}
+
+// compiler returns the C compiler named by $CC, or else cc or clang. It
+// skips t if there is none.
+func compiler(t *testing.T) string {
+	names := []string{"cc", "clang"}
+	if cc := os.Getenv("CC"); cc != "" {
+		names = []string{cc}
+	}
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	t.Skip("no C compiler")
+	return ""
+}
+
+// buildRuntime compiles the runtime in dir, and returns the paths of its
+// object files.
+func buildRuntime(t *testing.T, cc, dir string) []string {
+	srcs, err := runtime.WriteSources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objs []string
+	for _, src := range srcs {
+		obj := strings.TrimSuffix(src, ".c") + ".o"
+		if out, err := exec.Command(cc, "-c", "-O2", "-I", dir, "-o", obj, src).CombinedOutput(); err != nil {
+			t.Fatalf("compile %s: %v\n%s", src, err, out)
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+// build compiles src, and links it with the runtime's object files into
+// the executable exe.
+func build(t *testing.T, cc, src, exe string, rt []string) error {
+	g := &Generator{Config: check(t, src)}
+	mod, err := g.CreateModule()
+	if err != nil {
+		return err
+	}
+	defer mod.Dispose()
+	triple := llvm.DefaultTargetTriple()
+	target, err := llvm.GetTargetFromTriple(triple)
+	if err != nil {
+		return err
+	}
+	tm := target.CreateTargetMachine(triple, "", "", llvm.CodeGenLevelDefault, llvm.RelocPIC, llvm.CodeModelDefault)
+	defer tm.Dispose()
+	td := tm.CreateTargetData()
+	defer td.Dispose()
+	mod.SetTarget(triple)
+	mod.SetDataLayout(td.String())
+	buf, err := tm.EmitToMemoryBuffer(mod, llvm.ObjectFile)
+	if err != nil {
+		return err
+	}
+	defer buf.Dispose()
+	obj := exe + ".o"
+	if err := os.WriteFile(obj, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	args := append([]string{"-pthread", "-o", exe, obj}, rt...)
+	if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("link: %v\n%s", err, out)
+	}
+	return nil
+}
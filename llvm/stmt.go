@@ -0,0 +1,419 @@
+package llvm
+
+import (
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/scan"
+	"github.com/smasher164/arvo/types"
+
+	"llvm.org/llvm/bindings/go/llvm"
+)
+
+func (g *Generator) stmts(list []ast.Stmt) {
+	for _, s := range list {
+		g.stmt(s)
+	}
+}
+
+// A target is a statement that break and continue statements can transfer
+// control out of.
+type target struct {
+	label *ast.Object     // label of the statement; or nil
+	brk   llvm.BasicBlock // block that follows the statement
+	cont  llvm.BasicBlock // block that starts the next iteration; unused if !loop
+	loop  bool
+	block bool // a labeled statement other than a loop or switch
+}
+
+func (g *Generator) stmt(s ast.Stmt) {
+	g.labeled(s, nil)
+}
+
+// labeled generates s, which is labeled by lbl if lbl is not nil.
+func (g *Generator) labeled(s ast.Stmt, lbl *ast.Object) {
+	switch s := s.(type) {
+	case *ast.EmptyStmt:
+	case *ast.DeclStmt:
+		for _, spec := range s.Decl.Specs {
+			if vs, ok := spec.(*ast.ValueSpec); ok {
+				g.valueSpec(vs)
+			}
+		}
+	case *ast.ExprStmt:
+		if _, ok := s.X.(*ast.FunDef); ok {
+			// Functions are compiled when they are used.
+			break
+		}
+		if call, ok := s.X.(*ast.CallExpr); ok {
+			g.call(call)
+			break
+		}
+		g.expr(s.X)
+	case *ast.AssignStmt:
+		g.assignStmt(s)
+	case *ast.IncDecStmt:
+		op := scan.Add
+		if s.Tok.Type == scan.Dec {
+			op = scan.Sub
+		}
+		one := llvm.ConstInt(g.llvmType(g.typeOf(s.X)), 1, false)
+		if g.typeOf(s.X) == types.Float {
+			one = llvm.ConstFloat(f64, 1)
+		}
+		g.assign(s.X, g.binary(op, s.Tok.Pos, g.typeOf(s.X), g.expr(s.X), one))
+	case *ast.BlockStmt:
+		g.stmts(s.List)
+	case *ast.IfStmt:
+		g.ifStmt(s)
+	case *ast.LabeledStmt:
+		switch s.Stmt.(type) {
+		case *ast.ForStmt, *ast.InStmt, *ast.SwitchStmt:
+			g.labeled(s.Stmt, g.Config.ObjectOf(s.Label))
+		default:
+			done := llvm.AddBasicBlock(g.fn.value, "label.done")
+			g.push(target{label: g.Config.ObjectOf(s.Label), brk: done, block: true})
+			g.stmt(s.Stmt)
+			g.pop()
+			g.builder.CreateBr(done)
+			g.builder.SetInsertPointAtEnd(done)
+		}
+	case *ast.BranchStmt:
+		g.branch(s)
+	case *ast.ForStmt:
+		g.forStmt(s, lbl)
+	case *ast.InStmt:
+		g.inStmt(s, lbl)
+	case *ast.SwitchStmt:
+		g.switchStmt(s, lbl)
+	case *ast.ReturnStmt:
+		if call := multiCall(s.Results); call != nil {
+			g.ret(g.call(call))
+		} else {
+			g.ret(g.exprs(s.Results))
+		}
+		g.dead()
+	default:
+		g.errorf(s.Pos(), "%T is not supported", s)
+	}
+}
+
+// multiCall returns the call expression in list if it is the sole element.
+func multiCall(list []ast.Expr) *ast.CallExpr {
+	if len(list) != 1 {
+		return nil
+	}
+	call, _ := list[0].(*ast.CallExpr)
+	return call
+}
+
+func (g *Generator) valueSpec(s *ast.ValueSpec) {
+	lhs := make([]ast.Expr, len(s.Names))
+	for i, id := range s.Names {
+		lhs[i] = id
+	}
+	if len(s.Values) == 0 {
+		for _, x := range lhs {
+			g.assign(x, g.zero(g.typeOf(x)))
+		}
+		return
+	}
+	g.assignList(lhs, s.Values)
+}
+
+// assignList assigns the values of rhs to the corresponding operands in lhs.
+// All of rhs is evaluated before any assignment takes place.
+func (g *Generator) assignList(lhs, rhs []ast.Expr) {
+	var vals []llvm.Value
+	if call := multiCall(rhs); call != nil && len(lhs) > 1 {
+		vals = g.call(call)
+	} else {
+		vals = g.exprs(rhs)
+	}
+	for i, x := range lhs {
+		g.assign(x, vals[i])
+	}
+}
+
+var assignOps = map[scan.Type]scan.Type{
+	scan.AddAssign:    scan.Add,
+	scan.SubAssign:    scan.Sub,
+	scan.MulAssign:    scan.Mul,
+	scan.QuoAssign:    scan.Quo,
+	scan.RemAssign:    scan.Rem,
+	scan.AndAssign:    scan.And,
+	scan.OrAssign:     scan.Or,
+	scan.XorAssign:    scan.Xor,
+	scan.ShlAssign:    scan.Shl,
+	scan.ShrAssign:    scan.Shr,
+	scan.AndNotAssign: scan.AndNot,
+}
+
+func (g *Generator) assignStmt(s *ast.AssignStmt) {
+	if s.Tok.Type == scan.Assign {
+		g.assignList(s.Lhs, s.Rhs)
+		return
+	}
+	op, ok := assignOps[s.Tok.Type]
+	if !ok {
+		g.errorf(s.Tok.Pos, "%s is not supported", s.Tok.Type)
+	}
+	x := s.Lhs[0]
+	g.assign(x, g.binary(op, s.Tok.Pos, g.typeOf(x), g.expr(x), g.expr(s.Rhs[0])))
+}
+
+// assign stores v in the location that x denotes.
+func (g *Generator) assign(x ast.Expr, v llvm.Value) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		if g.Config.ObjectOf(x) == nil {
+			// The blank identifier discards v.
+			return
+		}
+		g.builder.CreateStore(v, g.addr(x))
+	case *ast.ParenExpr:
+		g.assign(x.X, v)
+	case *ast.SelectorExpr:
+		if id := ast.Qualified(x); id != nil {
+			g.assign(id, v)
+			return
+		}
+		r := g.typeOf(x.X).(types.Record)
+		g.builder.CreateStore(v, g.elem(g.expr(x.X), g.field(r, x.Sel)))
+	case *ast.IndexExpr:
+		switch t := g.typeOf(x.X).(type) {
+		case types.Record:
+			g.builder.CreateStore(v, g.elem(g.expr(x.X), g.field(t, x.Index)))
+		case types.Array:
+			a, k := g.expr(x.X), g.toWord(t.Key, g.expr(x.Index))
+			g.builder.CreateCall(g.builtin["array_set"], []llvm.Value{a, k, g.toWord(t.Value, v)}, "")
+		default:
+			// Strings are values, so the rune is replaced in a copy that
+			// is then assigned to the string's operand.
+			s, i := g.expr(x.X), g.expr(x.Index)
+			g.checkIndex(x.Index.Pos(), s, i)
+			n := g.builder.CreateCall(g.builtin["rune_count"], []llvm.Value{v}, "")
+			failed := g.builder.CreateICmp(llvm.IntNE, n, llvm.ConstInt(i64, 1, false), "")
+			g.check(failed, x.Pos(), "cannot assign '%s' to a single character", g.builder.CreateCall(g.builtin["c_str"], []llvm.Value{v}, ""))
+			s = g.builder.CreateCall(g.builtin["concat_strings"], []llvm.Value{s, llvm.ConstNull(ptr)}, "")
+			g.builder.CreateCall(g.builtin["assign_string"], []llvm.Value{s, i, v}, "")
+			g.assign(x.X, s)
+		}
+	default:
+		g.errorf(x.Pos(), "cannot assign to %T", x)
+	}
+}
+
+func (g *Generator) ifStmt(s *ast.IfStmt) {
+	if s.Init != nil {
+		g.stmt(s.Init)
+	}
+	then := llvm.AddBasicBlock(g.fn.value, "if.then")
+	els := llvm.AddBasicBlock(g.fn.value, "if.else")
+	done := llvm.AddBasicBlock(g.fn.value, "if.done")
+	g.builder.CreateCondBr(g.expr(s.Cond), then, els)
+	g.builder.SetInsertPointAtEnd(then)
+	g.stmt(s.Body)
+	g.builder.CreateBr(done)
+	g.builder.SetInsertPointAtEnd(els)
+	if s.Else != nil {
+		g.stmt(s.Else)
+	}
+	g.builder.CreateBr(done)
+	g.builder.SetInsertPointAtEnd(done)
+}
+
+func (g *Generator) push(t target) {
+	g.targets = append(g.targets, t)
+}
+
+func (g *Generator) pop() {
+	g.targets = g.targets[:len(g.targets)-1]
+}
+
+// branch generates a break or continue statement. A statement without a
+// label targets the innermost loop or, for break, switch statement.
+func (g *Generator) branch(s *ast.BranchStmt) {
+	cont := s.Tok.Type == scan.Continue
+	for i := len(g.targets) - 1; i >= 0; i-- {
+		t := g.targets[i]
+		switch {
+		case s.Label != nil && t.label != g.Config.ObjectOf(s.Label):
+			continue
+		case s.Label == nil && (t.block || cont && !t.loop):
+			continue
+		case cont && !t.loop:
+			g.errorf(s.Pos(), "invalid continue label %s", s.Label.Name.Lit)
+		case cont:
+			g.builder.CreateBr(t.cont)
+		default:
+			g.builder.CreateBr(t.brk)
+		}
+		g.dead()
+		return
+	}
+	g.errorf(s.Pos(), "%s is not in a loop, switch, or labeled statement", s.Tok.Type)
+}
+
+func (g *Generator) forStmt(s *ast.ForStmt, lbl *ast.Object) {
+	if s.Init != nil {
+		g.stmt(s.Init)
+	}
+	cond := llvm.AddBasicBlock(g.fn.value, "for.cond")
+	body := llvm.AddBasicBlock(g.fn.value, "for.body")
+	post := llvm.AddBasicBlock(g.fn.value, "for.post")
+	done := llvm.AddBasicBlock(g.fn.value, "for.done")
+	g.builder.CreateBr(cond)
+	g.builder.SetInsertPointAtEnd(cond)
+	if s.Cond != nil {
+		g.builder.CreateCondBr(g.expr(s.Cond), body, done)
+	} else {
+		g.builder.CreateBr(body)
+	}
+	g.builder.SetInsertPointAtEnd(body)
+	g.push(target{label: lbl, brk: done, cont: post, loop: true})
+	g.stmt(s.Body)
+	g.pop()
+	g.builder.CreateBr(post)
+	g.builder.SetInsertPointAtEnd(post)
+	if s.Post != nil {
+		g.stmt(s.Post)
+	}
+	g.builder.CreateBr(cond)
+	g.builder.SetInsertPointAtEnd(done)
+}
+
+// inStmt generates a loop over the entries of an array, the runes of a
+// string, or the elements of a record. Like the interpreter, it iterates
+// over the entries that x holds when the loop starts.
+func (g *Generator) inStmt(s *ast.InStmt, lbl *ast.Object) {
+	xt := g.typeOf(s.X)
+	x := g.expr(s.X)
+	if r, ok := xt.(types.Record); ok {
+		g.inRecord(s, r, x, lbl)
+		return
+	}
+	var n llvm.Value
+	if xt == types.String {
+		n = g.builder.CreateCall(g.builtin["rune_count"], []llvm.Value{x}, "")
+	} else {
+		x = g.builder.CreateCall(g.builtin["copy_array"], []llvm.Value{x}, "")
+		n = g.builder.CreateCall(g.builtin["array_len"], []llvm.Value{x}, "")
+	}
+	i := g.alloca(i64, "i")
+	g.builder.CreateStore(llvm.ConstInt(i64, 0, false), i)
+	cond := llvm.AddBasicBlock(g.fn.value, "in.cond")
+	body := llvm.AddBasicBlock(g.fn.value, "in.body")
+	post := llvm.AddBasicBlock(g.fn.value, "in.post")
+	done := llvm.AddBasicBlock(g.fn.value, "in.done")
+	g.builder.CreateBr(cond)
+	g.builder.SetInsertPointAtEnd(cond)
+	iv := g.builder.CreateLoad(i, "")
+	g.builder.CreateCondBr(g.builder.CreateICmp(llvm.IntSLT, iv, n, ""), body, done)
+	g.builder.SetInsertPointAtEnd(body)
+	var k, v llvm.Value
+	if xt == types.String {
+		k = iv
+		if s.Value != nil {
+			r := g.builder.CreateCall(g.builtin["index_rune"], []llvm.Value{x, iv}, "")
+			v = g.builder.CreateCall(g.builtin["rune_string"], []llvm.Value{r}, "")
+		}
+	} else {
+		arr := xt.(types.Array)
+		if s.Key != nil {
+			k = g.fromWord(arr.Key, g.builder.CreateCall(g.builtin["array_key"], []llvm.Value{x, iv}, ""))
+		}
+		if s.Value != nil {
+			v = g.fromWord(arr.Value, g.builder.CreateCall(g.builtin["array_value"], []llvm.Value{x, iv}, ""))
+		}
+	}
+	g.inAssign(s, iv, k, v)
+	g.push(target{label: lbl, brk: done, cont: post, loop: true})
+	g.stmt(s.Body)
+	g.pop()
+	g.builder.CreateBr(post)
+	g.builder.SetInsertPointAtEnd(post)
+	g.builder.CreateStore(g.builder.CreateAdd(g.builder.CreateLoad(i, ""), llvm.ConstInt(i64, 1, false), ""), i)
+	g.builder.CreateBr(cond)
+	g.builder.SetInsertPointAtEnd(done)
+}
+
+// inRecord generates a loop over the elements of the record x of type r.
+// Since the elements of a record are known statically, the body of the loop
+// is generated once for each element.
+func (g *Generator) inRecord(s *ast.InStmt, r types.Record, x llvm.Value, lbl *ast.Object) {
+	done := llvm.AddBasicBlock(g.fn.value, "in.done")
+	for i, el := range r.Elts {
+		next := llvm.AddBasicBlock(g.fn.value, "in.next")
+		var k, v llvm.Value
+		if s.Key != nil {
+			k = g.str(string(el.Key.(types.Key)))
+		}
+		if s.Value != nil {
+			v = g.builder.CreateLoad(g.builder.CreateStructGEP(x, i, ""), "")
+		}
+		g.inAssign(s, llvm.ConstInt(i64, uint64(i), false), k, v)
+		g.push(target{label: lbl, brk: done, cont: next, loop: true})
+		g.stmt(s.Body)
+		g.pop()
+		g.builder.CreateBr(next)
+		g.builder.SetInsertPointAtEnd(next)
+	}
+	g.builder.CreateBr(done)
+	g.builder.SetInsertPointAtEnd(done)
+}
+
+// inAssign assigns the index, key and value of an iteration to the
+// variables of s that receive them.
+func (g *Generator) inAssign(s *ast.InStmt, i, k, v llvm.Value) {
+	if s.Index != nil {
+		g.assign(s.Index, i)
+	}
+	if s.Key != nil {
+		g.assign(s.Key, k)
+	}
+	if s.Value != nil {
+		g.assign(s.Value, v)
+	}
+}
+
+func (g *Generator) switchStmt(s *ast.SwitchStmt, lbl *ast.Object) {
+	if s.Init != nil {
+		g.stmt(s.Init)
+	}
+	tag, tt := llvm.ConstInt(i1, 1, false), types.Type(types.Bool)
+	if s.Tag != nil {
+		tag, tt = g.expr(s.Tag), g.typeOf(s.Tag)
+	}
+	done := llvm.AddBasicBlock(g.fn.value, "switch.done")
+	// The clauses are tested in order, and the default clause runs only if
+	// no other clause matches.
+	var clauses []*ast.CaseClause
+	var bodies []llvm.BasicBlock
+	def := done
+	for _, st := range s.Body.List {
+		cc, ok := st.(*ast.CaseClause)
+		if !ok {
+			continue
+		}
+		body := llvm.AddBasicBlock(g.fn.value, "switch.case")
+		clauses = append(clauses, cc)
+		bodies = append(bodies, body)
+		if cc.List == nil {
+			def = body
+			continue
+		}
+		for _, x := range cc.List {
+			next := llvm.AddBasicBlock(g.fn.value, "switch.next")
+			g.builder.CreateCondBr(g.binary(scan.Eql, x.Pos(), tt, tag, g.expr(x)), body, next)
+			g.builder.SetInsertPointAtEnd(next)
+		}
+	}
+	g.builder.CreateBr(def)
+	g.push(target{label: lbl, brk: done})
+	for i, cc := range clauses {
+		g.builder.SetInsertPointAtEnd(bodies[i])
+		g.stmts(cc.Body)
+		g.builder.CreateBr(done)
+	}
+	g.pop()
+	g.builder.SetInsertPointAtEnd(done)
+}
@@ -0,0 +1,236 @@
+// Package load finds and parses the packages that make up an arvo program.
+//
+// A program is a main package, given as a list of files, together with the
+// packages that it uses, directly or indirectly. A use declaration names a
+// package by its path, a sequence of names separated by slashes, which is
+// the path of the package's directory relative to a root directory. Every
+// file in the directory whose name ends in .arvo belongs to the package.
+package load
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/parse"
+	"github.com/smasher164/arvo/scan"
+)
+
+// A Config controls how a program is loaded.
+//
+// Files are always parsed with parse.DeclarationErrors, whatever the Mode,
+// because the checker relies on the parser to report the names that are
+// redeclared in a scope.
+type Config struct {
+	Root     string        // directory in which use paths are looked up
+	Mode     parse.Mode    // mode in which files are parsed, with DeclarationErrors added
+	Universe *ast.Scope    // scope that encloses every package scope; may be nil
+	Fset     *scan.FileSet // positions of the nodes of every file; set by Load if nil
+}
+
+// Load parses the files of the main package and of the packages that it
+// uses. It returns the packages in dependency order: a package follows
+// every package that it uses, and the main package is last.
+//
+// The object that a use declaration declares refers to the package it uses
+// through its Data, and the qualified identifiers that refer to the exported
+// names of that package are resolved to their objects. The names exported
+// by a package that a file uses with a "." are resolved in that file as if
+// it declared them.
+func (c *Config) Load(filenames ...string) ([]*ast.Package, error) {
+	if c.Fset == nil {
+		c.Fset = scan.NewFileSet()
+	}
+	l := &loader{conf: c, pkgs: make(map[string]*ast.Package)}
+	main := &ast.Package{Name: "main"}
+	for _, name := range filenames {
+		if f := l.open(name); f != nil {
+			main.Files = append(main.Files, f)
+		}
+	}
+	if len(main.Files) == len(filenames) {
+		l.parse(main)
+	}
+	if len(l.errs) > 0 {
+		return nil, errors.New(strings.Join(l.errs, "\n"))
+	}
+	return l.order, nil
+}
+
+type loader struct {
+	conf  *Config
+	pkgs  map[string]*ast.Package // packages that are loaded or being loaded, by path
+	stack []string                // paths of the packages being loaded, outermost first
+	order []*ast.Package          // packages that are loaded, in dependency order
+	errs  []string
+}
+
+// errorf reports an error at tok.
+func (l *loader) errorf(tok scan.Token, format string, args ...interface{}) {
+	pos := l.conf.Fset.Position(tok.Pos)
+	l.errs = append(l.errs, fmt.Sprintf("%s:%d:%d:%d: %s", pos.Filename, pos.Offset, pos.Line, pos.Column, fmt.Sprintf(format, args...)))
+}
+
+// open opens the file at name for parsing.
+func (l *loader) open(name string) *ast.File {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		l.errs = append(l.errs, err.Error())
+		return nil
+	}
+	return &ast.File{Src: &source{strings.NewReader(string(src)), name}, Fset: l.conf.Fset}
+}
+
+type source struct {
+	*strings.Reader
+	name string
+}
+
+func (s *source) Name() string { return s.name }
+
+// parse parses the files of pkg, and then loads the packages that they use.
+func (l *loader) parse(pkg *ast.Package) {
+	pkg.Scope = ast.NewScope(l.conf.Universe)
+	if err := parse.Package(pkg, l.conf.Mode|parse.DeclarationErrors); err != nil {
+		l.errs = append(l.errs, strings.Split(err.Error(), "\n")...)
+	}
+	if pkg.Name == "" {
+		pkg.Name = path.Base(pkg.Path)
+	}
+	l.stack = append(l.stack, pkg.Path)
+	for _, f := range pkg.Files {
+		for _, d := range f.Decls {
+			for _, spec := range d.Specs {
+				if us, ok := spec.(*ast.UseSpec); ok {
+					l.use(pkg, us)
+				}
+			}
+		}
+	}
+	l.stack = l.stack[:len(l.stack)-1]
+	l.resolve(pkg)
+	l.order = append(l.order, pkg)
+}
+
+// use loads the package that us, a use declaration in pkg, refers to.
+func (l *loader) use(pkg *ast.Package, us *ast.UseSpec) {
+	p := parse.UsePath(us.Path)
+	if p == "" {
+		// reported by the parser
+		return
+	}
+	for i, q := range l.stack {
+		if q == p {
+			cycle := append(l.stack[i:], p)
+			l.errorf(us.Path, "use cycle: %s", strings.Join(cycle, " -> "))
+			return
+		}
+	}
+	dep, ok := l.pkgs[p]
+	if !ok {
+		dep = l.load(us, p)
+		l.pkgs[p] = dep
+	}
+	if dep == nil {
+		return
+	}
+	name := path.Base(p)
+	if us.Name != nil {
+		name = us.Name.Name.Lit
+	}
+	if obj := pkg.Scope.Lookup(name); obj != nil && obj.Kind == ast.Pkg {
+		obj.Data = dep
+	}
+}
+
+// load loads the package at path p, which us refers to. It returns nil if
+// there is no such package.
+func (l *loader) load(us *ast.UseSpec, p string) *ast.Package {
+	dir := filepath.Join(l.conf.Root, filepath.FromSlash(p))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		l.errorf(us.Path, "cannot find package %s in %s", p, dir)
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".arvo") {
+			names = append(names, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(names) == 0 {
+		l.errorf(us.Path, "no arvo files in %s", dir)
+		return nil
+	}
+	sort.Strings(names)
+	pkg := &ast.Package{Path: p}
+	for _, name := range names {
+		if f := l.open(name); f != nil {
+			pkg.Files = append(pkg.Files, f)
+		}
+	}
+	l.parse(pkg)
+	return pkg
+}
+
+// resolve resolves the identifiers of pkg that refer to the exported names
+// of the packages that it uses.
+func (l *loader) resolve(pkg *ast.Package) {
+	for _, f := range pkg.Files {
+		ast.Walk(f, func(n ast.Node) bool {
+			x, ok := n.(*ast.SelectorExpr)
+			if !ok || ast.Qualified(x) == nil {
+				return true
+			}
+			if dep, ok := x.X.(*ast.Ident).Obj.Data.(*ast.Package); ok {
+				x.Sel.Obj = exported(dep, x.Sel.Name.Lit)
+			}
+			return false
+		}, nil)
+
+		var dots []*ast.Package
+		for _, d := range f.Decls {
+			for _, spec := range d.Specs {
+				if us, ok := spec.(*ast.UseSpec); ok && us.Name != nil && us.Name.Name.Lit == "." {
+					if dep := l.pkgs[parse.UsePath(us.Path)]; dep != nil {
+						dots = append(dots, dep)
+					}
+				}
+			}
+		}
+		if len(dots) == 0 {
+			continue
+		}
+		i := 0
+		for _, id := range f.Unresolved {
+			for _, dep := range dots {
+				if id.Obj = exported(dep, id.Name.Lit); id.Obj != nil {
+					break
+				}
+			}
+			if id.Obj == nil {
+				f.Unresolved[i] = id
+				i++
+			}
+		}
+		f.Unresolved = f.Unresolved[:i]
+	}
+}
+
+// exported returns the object that pkg exports under name, or nil if there
+// is none.
+func exported(pkg *ast.Package, name string) *ast.Object {
+	if !ast.IsExported(name) {
+		return nil
+	}
+	obj := pkg.Scope.Lookup(name)
+	if obj == nil || obj.Kind == ast.Pkg {
+		return nil
+	}
+	return obj
+}
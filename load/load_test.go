@@ -0,0 +1,119 @@
+package load
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/types"
+)
+
+// write creates the files in the map under dir, creating directories as
+// needed.
+func write(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, map[string]string{
+		"main.arvo":       "use 'lib/b'\nuse . 'c'\nx = b.F(1) + G\n",
+		"lib/b/b1.arvo":   "pkg b\nuse 'c'\nfun F(x) { return c.G + h(x) }\n",
+		"lib/b/b2.arvo":   "pkg b\nfun h(x) { return x }\n",
+		"c/c.arvo":        "G = 2\n",
+		"c/notes.txt":     "not arvo",
+		"c/nested/n.arvo": "x = 1\n",
+	})
+	c := &Config{Root: dir}
+	pkgs, err := c.Load(filepath.Join(dir, "main.arvo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, pkg := range pkgs {
+		paths = append(paths, pkg.Path)
+	}
+	if got, want := strings.Join(paths, ","), "c,lib/b,"; got != want {
+		t.Fatalf("got packages %q, want %q", got, want)
+	}
+	c1, b, main := pkgs[0], pkgs[1], pkgs[2]
+	if main.Name != "main" || b.Name != "b" || c1.Name != "c" {
+		t.Errorf("got names %s, %s, %s; want main, b, c", main.Name, b.Name, c1.Name)
+	}
+	if len(b.Files) != 2 || len(c1.Files) != 1 {
+		t.Errorf("got %d and %d files, want 2 and 1", len(b.Files), len(c1.Files))
+	}
+	if obj := main.Scope.Lookup("b"); obj == nil || obj.Data != b {
+		t.Errorf("b does not refer to package lib/b")
+	}
+
+	f := main.Files[0]
+	sum := f.Stmts[0].(*ast.AssignStmt).Rhs[0].(*ast.BinaryExpr)
+	call := sum.X.(*ast.CallExpr)
+	if id := ast.Qualified(call.Fun); id == nil || id.Obj != b.Scope.Lookup("F") {
+		t.Errorf("b.F is not resolved to the function in lib/b")
+	}
+	if id := sum.Y.(*ast.Ident); id.Obj != c1.Scope.Lookup("G") {
+		t.Errorf("G is not resolved through the dot-use of c")
+	}
+	if len(f.Unresolved) != 0 {
+		t.Errorf("got unresolved %v", f.Unresolved)
+	}
+}
+
+var errorCases = []struct {
+	files map[string]string
+	msg   string
+}{
+	{map[string]string{"main.arvo": "use 'a'\n", "a/a.arvo": "use 'b'\n", "b/b.arvo": "use 'a'\n"}, "b.arvo:4:1:4: use cycle: a -> b -> a"},
+	{map[string]string{"main.arvo": "use 'a'\n"}, "main.arvo:4:1:4: cannot find package a"},
+	{map[string]string{"main.arvo": "use 'a'\n", "a/x.txt": ""}, "main.arvo:4:1:4: no arvo files"},
+	{map[string]string{"main.arvo": "use 'a/../b'\n"}, "main.arvo:4:1:4: invalid use path 'a/../b'"},
+	{map[string]string{"main.arvo": "use 'a'\n", "a/a.arvo": "pkg a\n", "a/b.arvo": "pkg b\n"}, "b.arvo:4:1:4: package b; expected a"},
+	{map[string]string{"main.arvo": "x = 1\nfun x() {}\n"}, "main.arvo:10:2:4: x redeclared in this block"},
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range errorCases {
+		dir := t.TempDir()
+		write(t, dir, tc.files)
+		c := &Config{Root: dir}
+		_, err := c.Load(filepath.Join(dir, "main.arvo"))
+		if err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("got error %v, want %q", err, tc.msg)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, map[string]string{
+		"main.arvo":    "use 'geo'\nx = geo.Area(2) + geo.Unit\ny = geo.Id('s') + geo.scale\n",
+		"geo/geo.arvo": "pkg geo\nUnit = 1\nscale = 2\nfun Area(r) { return r * r * scale }\n",
+		"geo/id.arvo":  "pkg geo\nfun Id(x) { return x }\n",
+	})
+	c := &Config{Root: dir, Universe: types.Universe}
+	pkgs, err := c.Load(filepath.Join(dir, "main.arvo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = types.Infer(&types.Config{Packages: pkgs})
+	list, ok := err.(types.ErrorList)
+	if !ok || len(list) != 1 {
+		t.Fatalf("got %v, want one error", err)
+	}
+	if e := list[0]; e.Code != types.UnexportedName || e.Tok.Lit != "scale" {
+		t.Errorf("got %v at %q, want %v at scale", e.Code, e.Tok.Lit, types.UnexportedName)
+	}
+}
@@ -0,0 +1,121 @@
+package parse
+
+import (
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/scan"
+)
+
+// attach distributes the comment groups that lie within n among n's
+// children. A group inside a child is handed down to it; the rest are
+// attached to the neighbouring child they are closest to:
+//   - Before the child starting on the line the group ends on, if a
+//     separator lies between the preceding child and the group,
+//   - After the child ending on the line the group starts on,
+//   - Before the child starting on the line the group ends on,
+//   - Above the child starting on the line below the group,
+//   - otherwise Below the preceding child, or Above the following one.
+//
+// A group on the line of a block's opening brace, before any statement, is
+// Trailing the block, and a group in a node without children is attached
+// Below the node itself.
+func (p *parser) attach(n ast.Node, rel **ast.RelComments, groups []*ast.CommentGroup) {
+	kids := children(n)
+	for len(groups) > 0 {
+		g := groups[0]
+		// index of the first child that does not end before g
+		i := 0
+		for i < len(kids) && kids[i].End() <= g.Pos() {
+			i++
+		}
+		if i < len(kids) && kids[i].Pos() <= g.Pos() {
+			// g is inside kids[i]; hand down every group that is
+			j := 1
+			for j < len(groups) && groups[j].End() <= kids[i].End() {
+				j++
+			}
+			if r := ast.CommentsField(kids[i]); r != nil {
+				p.attach(kids[i], r, groups[:j])
+			}
+			groups = groups[j:]
+			continue
+		}
+		var prev, next ast.Node
+		if i > 0 {
+			prev = kids[i-1]
+		}
+		if i < len(kids) {
+			next = kids[i]
+		}
+		switch {
+		case next != nil && p.separated(prev, g) && p.line(g.End()) == p.line(next.Pos()):
+			addComments(ast.CommentsField(next), ast.Before, g)
+		case prev != nil && p.line(g.Pos()) == p.line(prev.End()):
+			addComments(ast.CommentsField(prev), ast.After, g)
+		case next != nil && p.line(g.End()) == p.line(next.Pos()):
+			addComments(ast.CommentsField(next), ast.Before, g)
+		case prev == nil && p.opens(n, g):
+			addComments(rel, ast.Trailing, g)
+		case next != nil && p.line(g.End())+1 == p.line(next.Pos()):
+			addComments(ast.CommentsField(next), ast.Above, g)
+		case prev != nil:
+			addComments(ast.CommentsField(prev), ast.Below, g)
+		case next != nil:
+			addComments(ast.CommentsField(next), ast.Above, g)
+		default:
+			addComments(rel, ast.Below, g)
+		}
+		groups = groups[1:]
+	}
+}
+
+// opens reports whether g starts on the line of the opening brace of n.
+func (p *parser) opens(n ast.Node, g *ast.CommentGroup) bool {
+	b, ok := n.(*ast.BlockStmt)
+	return ok && p.line(g.Pos()) == p.line(b.Lbrace.Pos)
+}
+
+// separated reports whether a token such as a comma lies between prev and g.
+func (p *parser) separated(prev ast.Node, g *ast.CommentGroup) bool {
+	return prev != nil && p.follows[g] > prev.End()
+}
+
+func (p *parser) line(pos scan.Pos) int {
+	return p.file.Position(pos).Line
+}
+
+func addComments(rel **ast.RelComments, at ast.CommentPos, g *ast.CommentGroup) {
+	if rel == nil {
+		return
+	}
+	if *rel == nil {
+		*rel = &ast.RelComments{List: make(map[ast.CommentPos]*ast.CommentGroup)}
+	}
+	if h := (*rel).List[at]; h != nil {
+		h.List = append(h.List, g.List...)
+		return
+	}
+	(*rel).List[at] = &ast.CommentGroup{List: g.List}
+}
+
+// children returns the nodes directly below n, in source order.
+func children(n ast.Node) []ast.Node {
+	var kids []ast.Node
+	ast.Walk(n, func(c ast.Node) bool {
+		if c == n {
+			return true
+		}
+		switch c := c.(type) {
+		case nil, *ast.RelComments, *ast.CommentGroup, *ast.Comment:
+			return false
+		case *ast.Ident:
+			if c == nil {
+				return false
+			}
+		}
+		if c.Pos().IsValid() {
+			kids = append(kids, c)
+		}
+		return false
+	}, nil)
+	return kids
+}
@@ -7,16 +7,40 @@ package parse
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/smasher164/arvo/ast"
 	"github.com/smasher164/arvo/scan"
 )
 
+// A Mode controls the parser's optional behavior.
+type Mode uint
+
+const (
+	ParseComments     Mode = 1 << iota // keep comments and attach them to the AST
+	Trace                              // print a trace of the parsed productions
+	ImportsOnly                        // stop parsing after the use declarations
+	DeclarationErrors                  // report declaration errors
+	AllErrors                          // report all errors, not just the first on each line and up to MaxErrors
+)
+
 type parser struct {
+	mode       Mode
+	trace      bool // mode includes Trace
+	indent     int  // nesting of the productions being traced
+	file       *scan.File
+	comments   []*ast.CommentGroup
+	tokEnd     scan.Pos                       // end of the last token that is neither a comment nor an automatic semicolon
+	follows    map[*ast.CommentGroup]scan.Pos // tokEnd when each comment group was read
 	sc         *scan.Scanner
 	tok        scan.Token
 	unresolved []*ast.Ident
@@ -24,14 +48,99 @@ type parser struct {
 	pkgScope   *ast.Scope
 	errors     []Error
 	inRhs      bool
+
+	// Labels are scoped to the function that declares them, and a branch
+	// statement may refer to a label that is declared after it.
+	labelScope  *ast.Scope
+	targetStack [][]*ast.Ident // labels of the branch statements of each open function
+}
+
+// init prepares p to parse src, the contents of file.
+func (p *parser) init(file *scan.File, src []byte, mode Mode) {
+	p.mode = mode
+	p.trace = mode&Trace != 0
+	p.file = file
+	eh := func(pos scan.Position, msg string) {
+		p.error(scan.Token{Offset: pos.Offset, Line: pos.Line, Column: pos.Column}, msg)
+	}
+	p.sc = scan.NewFile(file, bufio.NewReader(bytes.NewReader(src)), eh)
+	p.next()
+}
+
+// traceOut is where the trace of a parse is printed.
+var traceOut io.Writer = os.Stdout
+
+func (p *parser) printTrace(a ...interface{}) {
+	const dots = ". . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . "
+	const n = len(dots)
+	fmt.Fprintf(traceOut, "%5d:%3d: ", p.tok.Line, p.tok.Column)
+	i := 2 * p.indent
+	for i > n {
+		fmt.Fprint(traceOut, dots)
+		i -= n
+	}
+	fmt.Fprint(traceOut, dots[0:i])
+	fmt.Fprintln(traceOut, a...)
+}
+
+func trace(p *parser, msg string) *parser {
+	p.printTrace(msg, "(")
+	p.indent++
+	return p
+}
+
+// Usage pattern: defer un(trace(p, "..."))
+func un(p *parser) {
+	p.indent--
+	p.printTrace(")")
 }
 
 func (p *parser) next() {
+	if p.trace && p.tok.Pos.IsValid() {
+		switch p.tok.Type {
+		case scan.Ident, scan.Int, scan.Float, scan.String:
+			p.printTrace(p.tok.Type, p.tok.Lit)
+		case scan.Semicolon:
+			p.printTrace(p.tok.Type, strconv.Quote(p.tok.Lit))
+		default:
+			p.printTrace(strconv.Quote(p.tok.Type.String()))
+		}
+	}
+	prev := p.tok
+	if prev.Type != scan.Semicolon || prev.Lit == ";" {
+		p.tokEnd = prev.End()
+	}
 	p.tok = p.sc.Scan()
+	if p.mode&ParseComments == 0 {
+		for p.tok.Type == scan.Comment {
+			p.tok = p.sc.Scan()
+		}
+		return
+	}
+	if p.tok.Type == scan.Comment && p.tok.Line == prev.Line {
+		// a group trailing the previous token ends with its line
+		p.commentGroup(0)
+	}
 	for p.tok.Type == scan.Comment {
-		// TODO(akhil): Parse and store comment state
+		p.commentGroup(1)
+	}
+}
+
+// commentGroup consumes a group of comments, each of which starts at most n
+// lines after the previous one ends.
+func (p *parser) commentGroup(n int) {
+	g := new(ast.CommentGroup)
+	end := p.tok.Line
+	for p.tok.Type == scan.Comment && p.tok.Line <= end+n {
+		g.List = append(g.List, &ast.Comment{Slash: p.tok, Text: p.tok.Lit})
+		end = p.tok.Line + strings.Count(p.tok.Lit, "\n")
 		p.tok = p.sc.Scan()
 	}
+	p.comments = append(p.comments, g)
+	if p.follows == nil {
+		p.follows = make(map[*ast.CommentGroup]scan.Pos)
+	}
+	p.follows[g] = p.tokEnd
 }
 
 type Error struct {
@@ -45,30 +154,136 @@ func (e Error) Error() string {
 	return fmt.Sprintf("%d:%d:%d: %s", e.Offset, e.Line, e.Column, e.Msg)
 }
 
+// MaxErrors is the number of errors after which the parser stops parsing a
+// file, unless the mode includes AllErrors. If it is zero, the whole file is
+// parsed however many errors it has.
+var MaxErrors = 10
+
+// A bailout is raised to stop parsing once MaxErrors are reported.
+type bailout struct{}
+
+// recover stops a bailout. It must be deferred by the function that starts
+// parsing.
+func (p *parser) recover() {
+	if e := recover(); e != nil {
+		if _, ok := e.(bailout); !ok {
+			panic(e)
+		}
+	}
+}
+
+// error reports msg at tok. Unless the mode includes AllErrors, only the
+// first error on a line is reported, since the rest of the line is likely to
+// be skipped or misread because of it, and the parser stops after
+// MaxErrors.
 func (p *parser) error(tok scan.Token, msg string) {
+	if p.mode&AllErrors != 0 {
+		p.errors = append(p.errors, Error{tok.Offset, tok.Line, tok.Column, msg})
+		return
+	}
+	if n := len(p.errors); n > 0 && p.errors[n-1].Line == tok.Line {
+		return
+	}
 	p.errors = append(p.errors, Error{tok.Offset, tok.Line, tok.Column, msg})
+	if MaxErrors > 0 && len(p.errors) >= MaxErrors {
+		panic(bailout{})
+	}
+}
+
+// errorExpected reports that tok was found where what was expected.
+func (p *parser) errorExpected(tok scan.Token, what string) {
+	found := "'" + tok.Type.String() + "'"
+	switch tok.Type {
+	case scan.Semicolon:
+		switch tok.Lit {
+		case "\n":
+			found = "newline"
+		case "":
+			// inserted at the end of the file
+			found = "EOF"
+		}
+	case scan.Ident, scan.Int, scan.Float, scan.String, scan.Illegal:
+		found = tok.Lit
+	case scan.EOF:
+		found = "EOF"
+	}
+	p.error(tok, "expected "+what+", found "+found)
+}
+
+// describe returns the description of tokens of type typ in errors.
+func describe(typ scan.Type) string {
+	switch typ {
+	case scan.Ident:
+		return "identifier"
+	case scan.Int, scan.Float, scan.String:
+		return strings.ToLower(typ.String()) + " literal"
+	}
+	return "'" + typ.String() + "'"
+}
+
+// stmtStart holds the keywords at which statements and case clauses start.
+var stmtStart = map[scan.Type]bool{
+	scan.Break:    true,
+	scan.Case:     true,
+	scan.Continue: true,
+	scan.Default:  true,
+	scan.For:      true,
+	scan.If:       true,
+	scan.Return:   true,
+	scan.Switch:   true,
+	scan.Var:      true,
+}
+
+// advance skips the rest of a statement in which an error occurred, up to
+// the semicolon or closing brace that ends it, or a keyword that starts the
+// next statement.
+func (p *parser) advance() {
+	for ; p.tok.Type != scan.EOF; p.next() {
+		if p.tok.Type == scan.Semicolon || p.tok.Type == scan.Rbrace || stmtStart[p.tok.Type] {
+			return
+		}
+	}
 }
 
 func (p *parser) expectSemi() {
 	if p.tok.Type != scan.Rparen && p.tok.Type != scan.Rbrace {
 		switch p.tok.Type {
 		case scan.Comma:
-			p.error(p.tok, "expected ;")
+			p.errorExpected(p.tok, "';'")
 			p.next()
 		case scan.Semicolon:
 			p.next()
 		default:
-			p.error(p.tok, "expected ;")
+			p.errorExpected(p.tok, "';'")
+			p.advance()
+			if p.tok.Type == scan.Semicolon {
+				p.next()
+			}
 		}
 	}
 }
 
+// atSync reports whether the current token is one at which the parser
+// resumes after an error: one that ends a statement or delimits a block.
+func (p *parser) atSync() bool {
+	switch p.tok.Type {
+	case scan.Semicolon, scan.Lbrace, scan.Rbrace, scan.EOF:
+		return true
+	}
+	return false
+}
+
+// expect consumes a token of type typ. Any other token is reported, and is
+// consumed unless the parser resumes at it.
 func (p *parser) expect(typ scan.Type) scan.Token {
 	tok := p.tok
 	if tok.Type != typ {
-		p.error(tok, "'"+typ.String()+"'")
+		p.errorExpected(tok, describe(typ))
+		if p.atSync() {
+			return tok
+		}
 	}
-	p.next()
+	p.next() // make progress
 	return tok
 }
 
@@ -101,9 +316,30 @@ func (p *parser) closeScope() {
 	p.topScope = p.topScope.Outer
 }
 
+func (p *parser) openLabelScope() {
+	p.labelScope = ast.NewScope(p.labelScope)
+	p.targetStack = append(p.targetStack, nil)
+}
+
+// closeLabelScope resolves the labels of the branch statements of the
+// function being closed against the labels it declares. A label that is
+// not declared is left unresolved; the type checker reports it, along with
+// a label that is declared twice.
+func (p *parser) closeLabelScope() {
+	n := len(p.targetStack) - 1
+	for _, id := range p.targetStack[n] {
+		id.Obj = p.labelScope.Lookup(id.Name.Lit)
+	}
+	p.targetStack = p.targetStack[:n]
+	p.labelScope = p.labelScope.Outer
+}
+
 // UseSpec       = [ "." | PackageName ] UsePath .
 func (p *parser) useSpec(_ int) ast.Spec {
-	var us ast.UseSpec
+	if p.trace {
+		defer un(trace(p, "UseSpec"))
+	}
+	us := new(ast.UseSpec)
 	tok := p.tok
 	switch p.tok.Type {
 	case scan.Period:
@@ -115,16 +351,72 @@ func (p *parser) useSpec(_ int) ast.Spec {
 	}
 	us.Path = p.tok
 	p.expect(scan.String)
-	return &us
+	p.expectSemi()
+	p.declareUse(us)
+	return us
+}
+
+// declareUse declares the name by which us refers to the package it uses:
+// the name that it gives, or else the last element of its path. The names
+// of a package's dot-uses are not declared. Every file of a package may use
+// the same path under the same name.
+func (p *parser) declareUse(us *ast.UseSpec) {
+	path := UsePath(us.Path)
+	if path == "" {
+		if us.Path.Type == scan.String {
+			p.error(us.Path, "invalid use path "+us.Path.Lit)
+		}
+		return
+	}
+	name := path[strings.LastIndex(path, "/")+1:]
+	if us.Name != nil {
+		if us.Name.Name.Lit == "." || us.Name.Name.Lit == "_" {
+			return
+		}
+		name = us.Name.Name.Lit
+	}
+	if alt := p.pkgScope.Lookup(name); alt != nil && alt.Kind == ast.Pkg {
+		if prev, ok := alt.Decl.(*ast.UseSpec); ok && UsePath(prev.Path) == path {
+			if us.Name != nil {
+				us.Name.Obj = alt
+			}
+			return
+		}
+	}
+	id := us.Name
+	if id == nil {
+		id = &ast.Ident{Name: us.Path}
+		id.Name.Lit = name
+	}
+	p.declare(us, nil, p.pkgScope, ast.Pkg, id)
+}
+
+// UsePath returns the path of the package that a use declaration refers
+// to, given the string literal that holds it. It returns "" if lit is not a
+// valid path: a sequence of names separated by slashes.
+func UsePath(lit scan.Token) string {
+	if lit.Type != scan.String || len(lit.Lit) < 2 {
+		return ""
+	}
+	path := lit.Lit[1 : len(lit.Lit)-1]
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, "\\'`\"") {
+			return ""
+		}
+	}
+	return path
 }
 
 func (p *parser) genDecl(keyword scan.Type, f func(int) ast.Spec) *ast.GenDecl {
+	if p.trace {
+		defer un(trace(p, "GenDecl"))
+	}
 	var gd ast.GenDecl
 	gd.Keyword = p.expect(keyword)
 	if p.tok.Type == scan.Lparen {
 		gd.Lparen = p.tok
 		p.next()
-		for i := 0; p.tok.Type != scan.Rparen && p.tok.Type != scan.EOF; i++ {
+		for i := 0; p.tok.Type != scan.Rparen && !p.atSync(); i++ {
 			gd.Specs = append(gd.Specs, f(i))
 		}
 		gd.Rparen = p.expect(scan.Rparen)
@@ -143,6 +435,9 @@ const (
 
 // VarSpec = IdentifierList [ "=" ExpressionList ] .
 func (p *parser) valueSpec(i int) ast.Spec {
+	if p.trace {
+		defer un(trace(p, "VarSpec"))
+	}
 	idents := p.identList()
 	var values []ast.Expr
 	if p.tok.Type == scan.Assign {
@@ -188,6 +483,9 @@ func (p *parser) rhsList() []ast.Expr {
 
 // ExpressionList = Expression { "," Expression } .
 func (p *parser) exprList(lhs bool) (list []ast.Expr) {
+	if p.trace {
+		defer un(trace(p, "ExpressionList"))
+	}
 	list = append(list, p.expr(lhs))
 	for p.tok.Type == scan.Comma {
 		p.next()
@@ -210,6 +508,9 @@ func (p *parser) typPrec() (scan.Type, int) {
 }
 
 func (p *parser) binaryExpr(lhs bool, prec1 int) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "BinaryExpr"))
+	}
 	x := p.unaryExpr(lhs)
 	for {
 		op, oprec := p.typPrec()
@@ -228,6 +529,9 @@ func (p *parser) binaryExpr(lhs bool, prec1 int) ast.Expr {
 
 // UnaryExpr = PrimaryExpr | unary_op UnaryExpr .
 func (p *parser) unaryExpr(lhs bool) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "UnaryExpr"))
+	}
 	switch p.tok.Type {
 	case scan.Add, scan.Sub, scan.Not, scan.Xor, scan.And:
 		op := p.tok
@@ -242,7 +546,7 @@ func (p *parser) unaryExpr(lhs bool) ast.Expr {
 func (p *parser) arrayLit() ast.Expr {
 	tok := p.tok
 	if tok.Type != scan.Ident && tok.Lit != "a" {
-		p.error(tok, "'"+scan.Ident.String()+"'")
+		p.errorExpected(tok, describe(scan.Ident))
 	}
 	p.next()
 	if p.tok.Type != scan.Lbrace {
@@ -255,7 +559,7 @@ func (p *parser) arrayLit() ast.Expr {
 func (p *parser) recordLit() ast.Expr {
 	tok := p.tok
 	if tok.Type != scan.Ident && tok.Lit != "r" {
-		p.error(tok, "'"+scan.Ident.String()+"'")
+		p.errorExpected(tok, describe(scan.Ident))
 	}
 	p.next()
 	if p.tok.Type != scan.Lbrace {
@@ -265,11 +569,14 @@ func (p *parser) recordLit() ast.Expr {
 }
 
 func (p *parser) body(scope *ast.Scope) *ast.BlockStmt {
+	if p.trace {
+		defer un(trace(p, "Body"))
+	}
 	lbrace := p.expect(scan.Lbrace)
 	p.topScope = scope
-	//p.openLabelScope()
+	p.openLabelScope()
 	list := p.stmtList()
-	//p.closeLabelScope()
+	p.closeLabelScope()
 	p.closeScope()
 	rbrace := p.expect(scan.Rbrace)
 	return &ast.BlockStmt{Lbrace: lbrace, List: list, Rbrace: rbrace}
@@ -279,11 +586,14 @@ func (p *parser) body(scope *ast.Scope) *ast.BlockStmt {
 // ParameterList = ParameterDecl { "," ParameterDecl } .
 // ParameterDecl = [ "..." ] identifier .
 func (p *parser) parameterList(scope *ast.Scope) []*ast.Param {
+	if p.trace {
+		defer un(trace(p, "ParameterList"))
+	}
 	var list []*ast.Param
 	nellipsis := 0
 	i := 0
 	first := false
-	for p.tok.Type != scan.Rparen && p.tok.Type != scan.EOF {
+	for p.tok.Type != scan.Rparen && !p.atSync() {
 		if !first {
 			i++
 		}
@@ -313,6 +623,9 @@ func (p *parser) parameterList(scope *ast.Scope) []*ast.Param {
 // Function = Parameters FunctionBody .
 // FunctionBody = Block .
 func (p *parser) funLit() *ast.FunDef {
+	if p.trace {
+		defer un(trace(p, "FunctionLit"))
+	}
 	tok := p.expect(scan.Fun)
 	scope := ast.NewScope(p.topScope)
 	var params []*ast.Param
@@ -325,13 +638,15 @@ func (p *parser) funLit() *ast.FunDef {
 		params = p.parameterList(scope)
 	}
 	rparen := p.expect(scan.Rparen)
-	//p.exprLev++
-	body := p.body(scope)
-	//p.exprLev--
-	def := &ast.FunDef{Fun: tok, Name: name, Lparen: lparen, Params: params, Rparen: rparen, Body: body}
+	def := &ast.FunDef{Fun: tok, Name: name, Lparen: lparen, Params: params, Rparen: rparen, Scope: scope}
 	if name != nil {
+		// The name is declared before the body, so that the function can
+		// call itself.
 		p.declare(def, nil, p.topScope, ast.Fun, name)
 	}
+	//p.exprLev++
+	def.Body = p.body(scope)
+	//p.exprLev--
 	return def
 }
 
@@ -340,6 +655,9 @@ func (p *parser) funLit() *ast.FunDef {
 // BasicLit = int_lit | float_lit | string_lit .
 // OperandName = identifier .
 func (p *parser) operand(lhs bool) (x ast.Expr) {
+	if p.trace {
+		defer un(trace(p, "Operand"))
+	}
 	switch p.tok.Type {
 	case scan.Ident:
 		if p.tok.Lit == "a" {
@@ -368,13 +686,16 @@ func (p *parser) operand(lhs bool) (x ast.Expr) {
 		return p.funLit()
 	}
 	tok := p.tok
-	p.error(tok, "expected operand")
+	p.errorExpected(tok, "operand")
 	return &ast.BadExpr{From: tok, To: p.tok}
 }
 
 // Index = [ "[" ] "[" Expression "]" [ "]" ] .
 // Slice = "[" [ Expression ] ":" [ Expression ] "]" .
 func (p *parser) indexOrSlice(x ast.Expr) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "IndexOrSlice"))
+	}
 	lbrackOut := p.expect(scan.Lbrack)
 	var lbrackIn scan.Token
 	backwards := false
@@ -433,6 +754,9 @@ func (p *parser) indexOrSlice(x ast.Expr) ast.Expr {
 
 // Selector = "." identifier .
 func (p *parser) selector(x ast.Expr) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "Selector"))
+	}
 	sel := p.ident()
 	return &ast.SelectorExpr{X: x, Sel: sel}
 }
@@ -459,6 +783,9 @@ func isLiteralType(x ast.Expr) bool {
 //     PrimaryExpr Slice |
 //     PrimaryExpr Arguments .
 func (p *parser) primaryExpr(lhs bool) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "PrimaryExpr"))
+	}
 	x := p.operand(lhs)
 L:
 	for {
@@ -473,7 +800,7 @@ L:
 				x = p.selector(x)
 			default:
 				tok := p.tok
-				p.error(tok, "expected selector")
+				p.errorExpected(tok, "selector")
 				p.next()
 				tok.Lit = "_"
 				sel := &ast.Ident{Name: tok}
@@ -519,6 +846,9 @@ func (p *parser) value(keyOk bool) ast.Expr {
 // KeyedElement = [ Element ":" ] Element .
 // Element = Expression .
 func (p *parser) element() ast.Expr {
+	if p.trace {
+		defer un(trace(p, "Element"))
+	}
 	x := p.value(true)
 	if p.tok.Type == scan.Colon {
 		colon := p.tok
@@ -530,6 +860,9 @@ func (p *parser) element() ast.Expr {
 
 // AssocLit = "{" [ ElementList [ "," ] ] "}" .
 func (p *parser) literalValue(typ ast.Expr) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "LiteralValue"))
+	}
 	lbrace := p.expect(scan.Lbrace)
 	var elts []ast.Expr
 	// p.exprLev++
@@ -552,11 +885,7 @@ func (p *parser) atComma(context string, follow scan.Type) bool {
 		return true
 	}
 	if p.tok.Type != follow {
-		msg := "missing ','"
-		if p.tok.Type == scan.Semicolon && p.tok.Lit == "\n" {
-			msg += " before newline"
-		}
-		p.error(p.tok, msg+" in "+context)
+		p.errorExpected(p.tok, "',' in "+context)
 		return true
 	}
 	return false
@@ -564,7 +893,7 @@ func (p *parser) atComma(context string, follow scan.Type) bool {
 
 func (p *parser) expectClosing(typ scan.Type, context string) scan.Token {
 	if p.tok.Type != typ && p.tok.Type == scan.Semicolon && p.tok.Lit == "\n" {
-		p.error(p.tok, "missing ',' before newline "+context)
+		p.errorExpected(p.tok, "',' in "+context)
 		p.next()
 	}
 	return p.expect(typ)
@@ -575,6 +904,9 @@ func (p *parser) expectClosing(typ scan.Type, context string) scan.Token {
 // Conversion = TypeName "(" Expression [ "," ] ")" .
 // TypeName = identifier .
 func (p *parser) callOrConversion(fn ast.Expr) *ast.CallExpr {
+	if p.trace {
+		defer un(trace(p, "CallOrConversion"))
+	}
 	lparen := p.expect(scan.Lparen)
 	// p.exprLev++
 	var list []ast.Expr
@@ -624,7 +956,7 @@ func (p *parser) declare(decl, data interface{}, scope *ast.Scope, kind ast.ObjK
 		obj.Data = data
 		ident.Obj = obj
 		if ident.Name.Lit != "_" {
-			if alt := scope.Insert(obj); alt != nil {
+			if alt := scope.Insert(obj); alt != nil && kind != ast.Lbl && p.mode&DeclarationErrors != 0 {
 				var prevDecl string
 				if pos := alt.Tok(); pos.Line != 0 && pos.Offset != 0 {
 					prevDecl = fmt.Sprintf("\n\tprevious declaration at %v", pos)
@@ -637,6 +969,9 @@ func (p *parser) declare(decl, data interface{}, scope *ast.Scope, kind ast.ObjK
 
 // StatementList = { Statement ";" } .
 func (p *parser) stmtList() (list []ast.Stmt) {
+	if p.trace {
+		defer un(trace(p, "StatementList"))
+	}
 	for p.tok.Type != scan.Case && p.tok.Type != scan.Default && p.tok.Type != scan.Rbrace && p.tok.Type != scan.EOF {
 		list = append(list, p.stmt())
 	}
@@ -676,7 +1011,7 @@ func (p *parser) assignDecl(s *ast.AssignStmt) {
 		}
 	}
 	if len(ids) < len(s.Lhs) {
-		if len(ids) > 0 {
+		if len(ids) > 0 && p.mode&DeclarationErrors != 0 {
 			// error
 			p.error(
 				ids[0].Name, fmt.Sprintf("combined old and new variables in assignment statement: %s and %s",
@@ -692,6 +1027,9 @@ func (p *parser) assignDecl(s *ast.AssignStmt) {
 
 // SimpleStmt = EmptyStmt | ExpressionStmt | IncDecStmt | Assignment .
 func (p *parser) simpleStmt(mode int) (ast.Stmt, bool) {
+	if p.trace {
+		defer un(trace(p, "SimpleStmt"))
+	}
 	xpos := p.tok
 	x := p.lhsList()
 	switch p.tok.Type {
@@ -704,7 +1042,11 @@ func (p *parser) simpleStmt(mode int) (ast.Stmt, bool) {
 		p.next()
 		var y []ast.Expr
 		isIn := false
-		if mode == inOk && p.tok.Type == scan.In {
+		if mode == inOk && tok.Type == scan.In {
+			// "for k, v in x"
+			y = []ast.Expr{&ast.UnaryExpr{Op: tok, X: p.rhs()}}
+			isIn = true
+		} else if mode == inOk && p.tok.Type == scan.In {
 			tok := p.tok
 			p.next()
 			y = []ast.Expr{&ast.UnaryExpr{Op: tok, X: p.rhs()}}
@@ -718,15 +1060,17 @@ func (p *parser) simpleStmt(mode int) (ast.Stmt, bool) {
 		return as, isIn
 	}
 	if len(x) > 1 {
-		p.error(xpos, "1 expression")
+		p.error(xpos, fmt.Sprintf("expected 1 expression, found %d", len(x)))
 	}
 	switch p.tok.Type {
 	case scan.Colon:
 		colon := p.tok
 		p.next()
 		if label, isIdent := x[0].(*ast.Ident); mode == labelOk && isIdent {
-			stmt := &ast.LabeledStmt{Label: label, Colon: colon, Stmt: p.stmt()}
-			p.declare(stmt, nil, p.topScope, ast.Lbl, label)
+			// declare the label first, so that branches in stmt can refer to it
+			stmt := &ast.LabeledStmt{Label: label, Colon: colon}
+			p.declare(stmt, nil, p.labelScope, ast.Lbl, label)
+			stmt.Stmt = p.stmt()
 			return stmt, false
 		}
 		p.error(colon, "illegal label declaration")
@@ -741,6 +1085,9 @@ func (p *parser) simpleStmt(mode int) (ast.Stmt, bool) {
 
 // ReturnStmt = "return" [ ExpressionList ] .
 func (p *parser) returnStmt() *ast.ReturnStmt {
+	if p.trace {
+		defer un(trace(p, "ReturnStmt"))
+	}
 	tok := p.expect(scan.Return)
 	var x []ast.Expr
 	if p.tok.Type != scan.Semicolon && p.tok.Type != scan.Rbrace {
@@ -753,11 +1100,15 @@ func (p *parser) returnStmt() *ast.ReturnStmt {
 // BreakStmt = "break" [ Label ] .
 // ContinueStmt = "continue" [ Label ] .
 func (p *parser) branchStmt(keyword scan.Type) *ast.BranchStmt {
+	if p.trace {
+		defer un(trace(p, "BranchStmt"))
+	}
 	tok := p.expect(keyword)
 	var label *ast.Ident
-	if tok.Type == scan.Ident {
+	if p.tok.Type == scan.Ident {
 		label = p.ident()
-		// should we add to unresolved targets w/ targetstack?
+		n := len(p.targetStack) - 1
+		p.targetStack[n] = append(p.targetStack[n], label)
 	}
 	p.expectSemi()
 	return &ast.BranchStmt{Tok: tok, Label: label}
@@ -765,6 +1116,9 @@ func (p *parser) branchStmt(keyword scan.Type) *ast.BranchStmt {
 
 // Block = "{" StatementList "}" .
 func (p *parser) blockStmt() *ast.BlockStmt {
+	if p.trace {
+		defer un(trace(p, "BlockStmt"))
+	}
 	lbrace := p.expect(scan.Lbrace)
 	p.openScope()
 	list := p.stmtList()
@@ -823,6 +1177,9 @@ func (p *parser) ifHeader() (init ast.Stmt, cond ast.Expr) {
 
 // IfStmt = "if" [ SimpleStmt ";" ] Expression Block [ "else" ( IfStmt | Block ) ] .
 func (p *parser) ifStmt() *ast.IfStmt {
+	if p.trace {
+		defer un(trace(p, "IfStmt"))
+	}
 	tok := p.expect(scan.If)
 	p.openScope()
 	defer p.closeScope()
@@ -838,7 +1195,7 @@ func (p *parser) ifStmt() *ast.IfStmt {
 			else_ = p.blockStmt()
 			p.expectSemi()
 		default:
-			p.error(p.tok, "if statement or block")
+			p.errorExpected(p.tok, "if statement or block")
 			else_ = &ast.BadStmt{From: p.tok, To: p.tok}
 		}
 	} else {
@@ -850,6 +1207,9 @@ func (p *parser) ifStmt() *ast.IfStmt {
 // CaseClause = SwitchCase ":" StatementList .
 // SwitchCase = "case" ExpressionList | "default" .
 func (p *parser) caseClause() *ast.CaseClause {
+	if p.trace {
+		defer un(trace(p, "CaseClause"))
+	}
 	tok := p.tok
 	var list []ast.Expr
 	if p.tok.Type == scan.Case {
@@ -868,6 +1228,9 @@ func (p *parser) caseClause() *ast.CaseClause {
 // SwitchStmt = ExprSwitchStmt .
 // ExprSwitchStmt = "switch" [ SimpleStmt ";" ] [ Expression ] "{" { CaseClause } "}" .
 func (p *parser) switchStmt() *ast.SwitchStmt {
+	if p.trace {
+		defer un(trace(p, "SwitchStmt"))
+	}
 	tok := p.expect(scan.Switch)
 	p.openScope()
 	defer p.closeScope()
@@ -875,8 +1238,11 @@ func (p *parser) switchStmt() *ast.SwitchStmt {
 	var e ast.Expr
 	if p.tok.Type != scan.Lbrace {
 		// exprLev?
+		var sbeg, send scan.Token
 		if p.tok.Type != scan.Semicolon {
+			sbeg = p.tok
 			s, _ = p.simpleStmt(basic)
+			send = p.tok
 		}
 		if p.tok.Type == scan.Semicolon {
 			p.next()
@@ -884,6 +1250,10 @@ func (p *parser) switchStmt() *ast.SwitchStmt {
 				// false?
 				e = p.expr(false)
 			}
+		} else {
+			// "switch x {": the statement is the tag
+			e = p.makeExpr(s, sbeg, send, "switch expression")
+			s = nil
 		}
 	}
 	lbrace := p.expect(scan.Lbrace)
@@ -919,6 +1289,9 @@ func (p *parser) makeExpr(s ast.Stmt, beg, end scan.Token, want string) ast.Expr
 // PostStmt = SimpleStmt .
 // InClause = IdentifierList "in" Expression .
 func (p *parser) forStmt() ast.Stmt {
+	if p.trace {
+		defer un(trace(p, "ForStmt"))
+	}
 	tok := p.expect(scan.For)
 	p.openScope()
 	defer p.closeScope()
@@ -974,7 +1347,7 @@ func (p *parser) forStmt() ast.Stmt {
 		case 3: // necessary?
 			index, key, value = as.Lhs[0], as.Lhs[1], as.Lhs[2]
 		default:
-			p.error(ltok, "at most 2 expressions")
+			p.error(ltok, fmt.Sprintf("expected at most 3 expressions, found %d", len(as.Lhs)))
 			return &ast.BadStmt{From: tok, To: endb}
 		}
 		x := as.Rhs[0].(*ast.UnaryExpr).X
@@ -1002,6 +1375,9 @@ func (p *parser) forStmt() ast.Stmt {
 //     BreakStmt | ContinueStmt | Block |
 //     IfStmt | SwitchStmt | ForStmt .
 func (p *parser) stmt() (s ast.Stmt) {
+	if p.trace {
+		defer un(trace(p, "Statement"))
+	}
 	switch p.tok.Type {
 	case scan.Var:
 		s = &ast.DeclStmt{Decl: p.genDecl(p.tok.Type, p.valueSpec)}
@@ -1031,10 +1407,14 @@ func (p *parser) stmt() (s ast.Stmt) {
 	case scan.Rbrace:
 		s = &ast.EmptyStmt{Semicolon: p.tok, Implicit: true}
 	default:
-		t1 := p.tok
-		p.error(p.tok, "statement")
-		p.next()
-		s = &ast.BadStmt{From: t1, To: p.tok}
+		from := p.tok
+		p.errorExpected(from, "statement")
+		p.next() // make progress
+		p.advance()
+		s = &ast.BadStmt{From: from, To: p.tok}
+		if p.tok.Type == scan.Semicolon {
+			p.next()
+		}
 	}
 	return
 }
@@ -1054,45 +1434,219 @@ func errd(es []Error) error {
 	return errors.New(s)
 }
 
-func File(f *ast.File) error {
+// File parses the source of f. If f.Scope is set, the file's declarations
+// are made in it, and the identifiers that the file does not declare are
+// resolved against it and its outer scopes, such as a universe of builtins.
+// Otherwise, the file gets a package scope of its own.
+func File(f *ast.File, mode Mode) error {
+	if f.Scope == nil {
+		f.Scope = ast.NewScope(nil)
+	}
+	outer := f.Scope.Outer
+	f.Scope.Outer = nil
+	p, err := parseFile(f, mode)
+	f.Scope.Outer = outer
+	if err != nil {
+		return err
+	}
+	resolveFile(f, f.Scope)
+	return errd(p.errors)
+}
+
+// Package parses the files of pkg into its package scope, which is created
+// if it is nil. The identifiers of a file may refer to the declarations of
+// any file in the package, and those that the package does not declare are
+// resolved against the scopes that enclose its scope. The name of the
+// package is that of its package clauses, which must agree. The files must
+// share a FileSet, which is created if the first has none.
+func Package(pkg *ast.Package, mode Mode) error {
+	if pkg.Scope == nil {
+		pkg.Scope = ast.NewScope(nil)
+	}
+	outer := pkg.Scope.Outer
+	pkg.Scope.Outer = nil
+	var errs []string
+	var fset *scan.FileSet
+	for _, f := range pkg.Files {
+		if f.Fset == nil {
+			f.Fset = fset
+		}
+		f.Scope = pkg.Scope
+		p, err := parseFile(f, mode)
+		if err != nil {
+			pkg.Scope.Outer = outer
+			return err
+		}
+		fset = f.Fset
+		for _, e := range p.errors {
+			errs = append(errs, f.Src.Name()+":"+e.Error())
+		}
+		switch name := f.Package.Name; {
+		case name == "":
+		case pkg.Name == "":
+			pkg.Name = name
+		case name != pkg.Name:
+			pos := f.Fset.Position(f.Package.NamePos)
+			errs = append(errs, fmt.Sprintf("%s:%d:%d:%d: package %s; expected %s", f.Src.Name(), pos.Offset, pos.Line, pos.Column, name, pkg.Name))
+		}
+	}
+	pkg.Scope.Outer = outer
+	for _, f := range pkg.Files {
+		resolveFile(f, pkg.Scope)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// Expr parses x, a single expression. The identifiers in it are left
+// unresolved.
+func Expr(x string) (ast.Expr, error) {
+	var p parser
+	p.topScope = ast.NewScope(nil)
+	fset := scan.NewFileSet()
+	e := p.parseExpr(fset.AddFile("", len(x)), []byte(x))
+	for _, id := range p.unresolved {
+		id.Obj = nil
+	}
+	return e, errd(p.errors)
+}
+
+// parseExpr parses an expression from src, the contents of file. The
+// expression is a BadExpr if the parser bails out.
+func (p *parser) parseExpr(file *scan.File, src []byte) (x ast.Expr) {
+	x = &ast.BadExpr{}
+	defer p.recover()
+	p.init(file, src, 0)
+	x = p.rhs()
+	if p.tok.Type == scan.Semicolon && p.tok.Lit != ";" {
+		// inserted at the end of the line or source
+		p.next()
+	}
+	p.expect(scan.EOF)
+	return x
+}
+
+// Dir parses the files in the directory at path whose names end in .arvo,
+// as a package. If filter is not nil, only the files whose FileInfo it
+// accepts are parsed. The files are parsed in the order of their names. The
+// package is returned even if it has errors.
+func Dir(path string, filter func(fs.FileInfo) bool, mode Mode) (*ast.Package, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	pkg := new(ast.Package)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".arvo") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		if filter != nil && !filter(info) {
+			continue
+		}
+		src, err := os.Open(filepath.Join(path, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		defer src.Close()
+		pkg.Files = append(pkg.Files, &ast.File{Src: src})
+	}
+	return pkg, Package(pkg, mode)
+}
+
+// parseFile parses the source of f into f.Scope, leaving the identifiers
+// that it does not declare in f.Unresolved.
+func parseFile(f *ast.File, mode Mode) (*parser, error) {
 	// SourceFile = [ PackageClause ";" ] { UseDecl ";" } StatementList .
-	p := new(parser)
-	// TODO(akhil): remove this when implementing packages! This way of including builtins
-	// breaks code with a package declaration!
-	r := io.MultiReader(strings.NewReader(
-		"fun exit(status) {}\n"+"fun printf(s1, ...s2) {}\n",
-	), f.Src)
-	p.sc = scan.New(bufio.NewReader(r))
-	p.next()
+	src, err := io.ReadAll(f.Src)
+	if err != nil {
+		return nil, err
+	}
+	if f.Fset == nil {
+		f.Fset = scan.NewFileSet()
+	}
+	var p parser
+	p.topScope = f.Scope
+	p.pkgScope = p.topScope
+	p.sourceFile(f, f.Fset.AddFile(f.Src.Name(), len(src)), src, mode)
+	i := 0
+	for _, id := range p.unresolved {
+		if id.Obj != unresolved {
+			// declared after it was marked, e.g. the lhs of an assignment
+			continue
+		}
+		id.Obj = nil
+		p.unresolved[i] = id
+		i++
+	}
+	f.Unresolved = p.unresolved[:i]
+	if p.mode&ParseComments != 0 {
+		f.Comments = p.comments
+		p.attach(f, nil, p.comments)
+	}
+	return &p, nil
+}
+
+// sourceFile parses the clauses and statements of f from src, the contents
+// of file. If the parser bails out, f holds the statements that precede the
+// one it stopped in.
+func (p *parser) sourceFile(f *ast.File, file *scan.File, src []byte, mode Mode) {
+	defer p.recover()
+	p.init(file, src, mode)
+	if p.trace {
+		defer un(trace(p, "SourceFile"))
+	}
 	if p.tok.Type == scan.Pkg {
 		f.Package.Package = p.tok
 		p.next()
 		clause := p.ident()
 		f.Package.Name = clause.Name.Lit
+		f.Package.NamePos = clause.Pos()
 		p.expectSemi()
 	}
-	p.openScope()
-	p.pkgScope = p.topScope
 	for p.tok.Type == scan.Use {
 		f.Decls = append(f.Decls, p.genDecl(scan.Use, p.useSpec))
 	}
+	if p.mode&ImportsOnly != 0 {
+		return
+	}
+	p.openLabelScope()
 	for p.tok.Type != scan.EOF {
+		if p.tok.Type == scan.Rbrace {
+			// A closing brace that no block is open for would otherwise
+			// end the statement list without being consumed.
+			from := p.tok
+			p.errorExpected(from, "statement")
+			p.next()
+			f.Stmts = append(f.Stmts, &ast.BadStmt{From: from, To: p.tok})
+			if p.tok.Type == scan.Semicolon {
+				p.next()
+			}
+			continue
+		}
 		f.Stmts = append(f.Stmts, p.stmt())
 	}
-	p.closeScope()
+	p.closeLabelScope()
+}
+
+// resolveFile resolves the unresolved identifiers of f against scope and
+// the scopes that enclose it.
+func resolveFile(f *ast.File, scope *ast.Scope) {
 	i := 0
-	for _, id := range p.unresolved {
-		id.Obj = p.pkgScope.Lookup(id.Name.Lit)
+	for _, id := range f.Unresolved {
+		for s := scope; s != nil && id.Obj == nil; s = s.Outer {
+			id.Obj = s.Lookup(id.Name.Lit)
+		}
 		if id.Obj == nil {
-			p.unresolved[i] = id
+			f.Unresolved[i] = id
 			i++
 		}
 	}
-	f.Scope = p.pkgScope
-	f.Unresolved = p.unresolved[:i]
-	return errd(p.errors)
+	f.Unresolved = f.Unresolved[:i]
 }
-
-// func Package(p *ast.Package) {
-
-// }
@@ -0,0 +1,331 @@
+package parse
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smasher164/arvo/ast"
+)
+
+type source struct {
+	*strings.Reader
+}
+
+func (source) Name() string { return "test.arvo" }
+
+func parseComments(t *testing.T, src string) *ast.File {
+	t.Helper()
+	f := &ast.File{Src: source{strings.NewReader(src)}}
+	if err := File(f, ParseComments); err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	return f
+}
+
+// comment returns the text of the group attached to n at pos.
+func comment(n ast.Node, pos ast.CommentPos) string {
+	rel := ast.CommentsField(n)
+	if rel == nil || *rel == nil || (*rel).List[pos] == nil {
+		return ""
+	}
+	var s []string
+	for _, c := range (*rel).List[pos].List {
+		s = append(s, c.Text)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestComments(t *testing.T) {
+	src := `// doc for x
+// continued
+x = 1 // after x
+/* before y */ y = 2
+
+// floating
+
+z = fun() {
+	// inside
+}
+`
+	f := parseComments(t, src)
+	if len(f.Comments) != 5 {
+		t.Fatalf("got %d comment groups, want 5", len(f.Comments))
+	}
+	stmts := f.Stmts[len(f.Stmts)-3:]
+	x, y, z := stmts[0], stmts[1], stmts[2]
+	tests := []struct {
+		n    ast.Node
+		pos  ast.CommentPos
+		want string
+	}{
+		{x, ast.Above, "// doc for x // continued"},
+		{x, ast.After, "// after x"},
+		{y, ast.Before, "/* before y */"},
+		{y, ast.Below, "// floating"},
+		{z.(*ast.AssignStmt).Rhs[0].(*ast.FunDef).Body, ast.Below, "// inside"},
+	}
+	for _, tt := range tests {
+		if got := comment(tt.n, tt.pos); got != tt.want {
+			t.Errorf("comment at %d = %q, want %q", tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestCommentsDiscarded(t *testing.T) {
+	f := &ast.File{Src: source{strings.NewReader("x = 1 // c\n")}}
+	if err := File(f, 0); err != nil {
+		t.Fatal(err)
+	}
+	if f.Comments != nil {
+		t.Errorf("got comments %v without ParseComments", f.Comments)
+	}
+}
+
+func TestCommentText(t *testing.T) {
+	g := &ast.CommentGroup{List: []*ast.Comment{
+		{Text: "// first"},
+		{Text: "/* second */"},
+	}}
+	if got, want := g.Text(), " first\n second\n"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+func TestUse(t *testing.T) {
+	src := "pkg main\nuse (\n\t'a/b'\n\tc 'c'\n)\nuse 'a/d'\nx = b.F + c.G + d.H\n"
+	f := &ast.File{Src: source{strings.NewReader(src)}}
+	if err := File(f, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Decls) != 2 || len(f.Decls[0].Specs) != 2 {
+		t.Fatalf("got %d declarations, want 2 with 2 and 1 specs", len(f.Decls))
+	}
+	for _, name := range []string{"b", "c", "d"} {
+		if obj := f.Scope.Lookup(name); obj == nil || obj.Kind != ast.Pkg {
+			t.Errorf("%s is not declared as a package", name)
+		}
+	}
+	if pos := f.Fset.Position(f.Stmts[0].Pos()); pos.Line != 7 {
+		t.Errorf("statement is on line %d, want 7", pos.Line)
+	}
+}
+
+func TestPackage(t *testing.T) {
+	outer := ast.NewScope(nil)
+	builtin := ast.NewObj(ast.Fun, "exit")
+	outer.Insert(builtin)
+	pkg := &ast.Package{
+		Files: []*ast.File{
+			{Src: source{strings.NewReader("pkg p\nx = f()\nexit(x)\n")}},
+			{Src: source{strings.NewReader("pkg p\nfun f() { return y }\n")}},
+		},
+		Scope: ast.NewScope(outer),
+	}
+	if err := Package(pkg, 0); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Name != "p" {
+		t.Errorf("package name is %q, want p", pkg.Name)
+	}
+	f, g := pkg.Files[0], pkg.Files[1]
+	if f.Fset != g.Fset {
+		t.Errorf("files do not share a FileSet")
+	}
+	call := f.Stmts[0].(*ast.AssignStmt).Rhs[0].(*ast.CallExpr)
+	if obj := call.Fun.(*ast.Ident).Obj; obj == nil || obj != pkg.Scope.Lookup("f") {
+		t.Errorf("f is not resolved to the declaration in the other file")
+	}
+	if obj := f.Stmts[1].(*ast.ExprStmt).X.(*ast.CallExpr).Fun.(*ast.Ident).Obj; obj != builtin {
+		t.Errorf("exit is not resolved to the outer scope")
+	}
+	if len(g.Unresolved) != 1 || g.Unresolved[0].Name.Lit != "y" {
+		t.Errorf("got unresolved %v, want y", g.Unresolved)
+	}
+}
+
+var errorCases = []struct {
+	src  string
+	errs []string // the messages of the errors, in order
+}{
+	{"fun f(x {\n\treturn x\n}\ny = f(1)", []string{"expected ')', found '{'"}},
+	{"x = 1 2 3 4\ny = 2", []string{"expected ';', found 2"}},
+	{"x = (1 + 2\ny = 3", []string{"expected ')', found newline"}},
+	{"f(1 2)\ng(1,\n2", []string{"expected ',' in argument list, found 2", "expected ',' in argument list, found EOF"}},
+	{"x = )\nif x { y = ] }\nz = 1", []string{"expected operand, found ')'", "expected operand, found ']'"}},
+	{"}\nx = 1\nelse y", []string{"expected statement, found '}'", "expected statement, found 'else'"}},
+	{"use 1\nx = a{1: 2 3: 4}", []string{"expected string literal, found 1", "expected ',' in composite literal, found 3"}},
+	{"x = 1 # 2\ny = 'ab\nz = 0x", []string{"illegal character U+0023 '#'", "string literal not terminated", "hexadecimal literal has no digits"}},
+}
+
+func TestErrors(t *testing.T) {
+	for i, tc := range errorCases {
+		f := &ast.File{Src: source{strings.NewReader(tc.src)}, Scope: ast.NewScope(nil)}
+		p, err := parseFile(f, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range p.errors {
+			got = append(got, e.Msg)
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.errs, "\n") {
+			t.Errorf("case #%d, got errors %q, want %q", i, got, tc.errs)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	f := &ast.File{Src: source{strings.NewReader("x = )\ny = 1 2\n}\nz = 3\n")}}
+	if err := File(f, 0); err == nil {
+		t.Fatal("expected errors")
+	}
+	if len(f.Stmts) != 5 {
+		t.Fatalf("got %d statements, want 5", len(f.Stmts))
+	}
+	if x := f.Stmts[0].(*ast.AssignStmt); !isBad(x.Rhs[0]) {
+		t.Errorf("x is assigned %T, want *ast.BadExpr", x.Rhs[0])
+	}
+	for _, i := range []int{1, 3} {
+		if _, ok := f.Stmts[i].(*ast.BadStmt); !ok {
+			t.Errorf("got %T for statement %d, want *ast.BadStmt", f.Stmts[i], i)
+		}
+	}
+	if z := f.Stmts[4].(*ast.AssignStmt); z.Lhs[0].(*ast.Ident).Name.Lit != "z" {
+		t.Errorf("the last statement does not assign z")
+	}
+}
+
+func isBad(x ast.Expr) bool {
+	_, ok := x.(*ast.BadExpr)
+	return ok
+}
+
+func TestMaxErrors(t *testing.T) {
+	src := strings.Repeat("x = ]\n", 2*MaxErrors)
+	f := &ast.File{Src: source{strings.NewReader(src)}, Scope: ast.NewScope(nil)}
+	p, err := parseFile(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.errors) != MaxErrors {
+		t.Errorf("got %d errors, want %d", len(p.errors), MaxErrors)
+	}
+	if len(f.Stmts) != MaxErrors-1 {
+		t.Errorf("got %d statements, want the %d before the last error", len(f.Stmts), MaxErrors-1)
+	}
+}
+
+func TestExpr(t *testing.T) {
+	x, err := Expr("f(a, 1) + fun(y) { return y }(2)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, ok := x.(*ast.BinaryExpr)
+	if !ok {
+		t.Fatalf("got %T, want *ast.BinaryExpr", x)
+	}
+	if f := sum.X.(*ast.CallExpr).Fun.(*ast.Ident); f.Name.Lit != "f" || f.Obj != nil {
+		t.Errorf("f is %q with object %v, want an unresolved f", f.Name.Lit, f.Obj)
+	}
+	for _, src := range []string{"1 +", "x = 1", "a b", ""} {
+		if _, err := Expr(src); err == nil {
+			t.Errorf("Expr(%q) succeeded, want an error", src)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.arvo":      "pkg p\nx = y\n",
+		"b.arvo":      "pkg p\ny = 1\n",
+		"b_test.arvo": "pkg p\nz = x\n",
+		"c.txt":       "not arvo",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg, err := Dir(dir, func(fi fs.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.arvo") }, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Name != "p" || len(pkg.Files) != 2 {
+		t.Fatalf("got package %s with %d files, want p with 2", pkg.Name, len(pkg.Files))
+	}
+	if obj := pkg.Scope.Lookup("y"); obj == nil || len(pkg.Files[0].Unresolved) != 0 {
+		t.Errorf("y in a.arvo is not resolved to its declaration in b.arvo")
+	}
+}
+
+func TestModes(t *testing.T) {
+	parse := func(src string, mode Mode) (*ast.File, []Error) {
+		f := &ast.File{Src: source{strings.NewReader(src)}, Scope: ast.NewScope(nil)}
+		p, err := parseFile(f, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f, p.errors
+	}
+	if f, _ := parse("use 'a'\nuse 'b'\nx = 1\n", ImportsOnly); len(f.Decls) != 2 || f.Stmts != nil {
+		t.Errorf("got %d declarations and %d statements, want 2 and 0", len(f.Decls), len(f.Stmts))
+	}
+
+	src := "var x = 1\nvar x = 2\n"
+	if _, errs := parse(src, 0); len(errs) != 0 {
+		t.Errorf("got errors %v without DeclarationErrors", errs)
+	}
+	if _, errs := parse(src, DeclarationErrors); len(errs) != 1 || !strings.Contains(errs[0].Msg, "x redeclared") {
+		t.Errorf("got errors %v, want x redeclared", errs)
+	}
+
+	src = strings.Repeat("x = ) ]\n", MaxErrors+1)
+	if _, errs := parse(src, AllErrors); len(errs) != 2*(MaxErrors+1) {
+		t.Errorf("got %d errors with AllErrors, want %d", len(errs), 2*(MaxErrors+1))
+	}
+
+	var buf bytes.Buffer
+	traceOut = &buf
+	defer func() { traceOut = os.Stdout }()
+	parse("return", Trace)
+	want := "    1:  0: SourceFile (\n    1:  0: . Statement (\n    1:  0: . . ReturnStmt (\n    1:  0: . . . \"return\"\n"
+	if got := buf.String(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "    1:  6: )\n") {
+		t.Errorf("got trace\n%s\nwant one that starts with\n%s", got, want)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	f := &ast.File{Src: source{strings.NewReader("l = 1\nfor { f = fun() { l: for { continue l } }\nbreak l }\nl: for {}\n")}}
+	if err := File(f, DeclarationErrors); err != nil {
+		t.Fatal(err)
+	}
+	if obj := f.Scope.Lookup("l"); obj == nil || obj.Kind != ast.Var {
+		t.Fatalf("l is declared as %v in the file scope, want a variable", obj)
+	}
+	loop := f.Stmts[1].(*ast.ForStmt)
+	inner := loop.Body.List[0].(*ast.AssignStmt).Rhs[0].(*ast.FunDef).Body.List[0].(*ast.LabeledStmt)
+	cont := inner.Stmt.(*ast.ForStmt).Body.List[0].(*ast.BranchStmt)
+	if cont.Label.Obj != inner.Label.Obj {
+		t.Errorf("continue l does not refer to the label in its function")
+	}
+	brk := loop.Body.List[1].(*ast.BranchStmt)
+	if outer := f.Stmts[2].(*ast.LabeledStmt); brk.Label.Obj != outer.Label.Obj || outer.Label.Obj.Kind != ast.Lbl {
+		t.Errorf("break l does not refer to the label declared after it")
+	}
+
+	// The type checker, not the parser, reports labels that are undeclared
+	// or declared twice.
+	f = &ast.File{Src: source{strings.NewReader("l: for {}\nl: for {}\nfun g() { break l }\n")}}
+	if err := File(f, DeclarationErrors); err != nil {
+		t.Fatal(err)
+	}
+	fun := f.Stmts[2].(*ast.ExprStmt).X.(*ast.FunDef)
+	if brk := fun.Body.List[0].(*ast.BranchStmt); brk.Label.Obj != nil {
+		t.Errorf("break l refers to %v, a label outside its function", brk.Label.Obj)
+	}
+}
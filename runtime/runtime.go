@@ -0,0 +1,40 @@
+// Package runtime holds the C runtime that programs compiled by the LLVM
+// backend are linked against. The sources are in the src directory, and
+// their tests are C programs in the testdata directory.
+package runtime
+
+import (
+	"embed"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed src/*.c src/*.h
+var src embed.FS
+
+// WriteSources writes the runtime sources into dir, and returns the paths
+// of the C files among them. The header files are written alongside, so
+// dir is the only include directory needed to compile them.
+func WriteSources(dir string) ([]string, error) {
+	entries, err := src.ReadDir("src")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		b, err := src.ReadFile(path.Join("src", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Join(dir, e.Name())
+		if err := os.WriteFile(name, b, 0666); err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(name, ".c") {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
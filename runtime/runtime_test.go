@@ -0,0 +1,45 @@
+package runtime
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// cTests lists the C test programs in testdata, together with the runtime
+// sources that each one is linked with. A test program reports its
+// failures and exits with a nonzero status if it fails.
+var cTests = []struct {
+	file string
+	srcs []string
+}{
+	{"gc_test.c", []string{"gc.c", "panic.c"}},
+	{"string_test.c", []string{"string.c", "gc.c", "panic.c"}},
+	{"print_test.c", []string{"print.c", "array.c", "string.c", "gc.c", "panic.c"}},
+	{"array_test.c", []string{"array.c", "string.c", "gc.c", "panic.c"}},
+}
+
+func TestC(t *testing.T) {
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	if _, err := exec.LookPath(cc); err != nil {
+		t.Skipf("no C compiler: %v", err)
+	}
+	for _, c := range cTests {
+		exe := filepath.Join(t.TempDir(), "test")
+		args := []string{"-std=c99", "-Wall", "-Werror", "-Isrc", "-o", exe, filepath.Join("testdata", c.file)}
+		for _, src := range c.srcs {
+			args = append(args, filepath.Join("src", src))
+		}
+		if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+			t.Errorf("build %s: %v\n%s", c.file, err, out)
+			continue
+		}
+		if out, err := exec.Command(exe).CombinedOutput(); err != nil {
+			t.Errorf("run %s: %v\n%s", c.file, err, out)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+#include <stdlib.h>
+#include <string.h>
+
+#include "arvo.h"
+
+static int equal(int32_t kind, int64_t x, int64_t y) {
+	if (kind == KIND_STRING) {
+		return compare_strings((string *)x, (string *)y) == 0;
+	}
+	return x == y;
+}
+
+// hash returns the FNV-1a hash of the key k.
+static uint64_t hash(int32_t kind, int64_t k) {
+	uint64_t h = 14695981039346656037ull;
+	if (kind == KIND_STRING) {
+		for (const char *c = c_str((string *)k); *c; c++) {
+			h = (h ^ (unsigned char)*c) * 1099511628211ull;
+		}
+		return h;
+	}
+	for (int i = 0; i < 8; i++) {
+		h = (h ^ (uint64_t)((k >> (8 * i)) & 0xff)) * 1099511628211ull;
+	}
+	return h;
+}
+
+array *alloc_array(int32_t keykind) {
+	array *a = gc_alloc(sizeof(array));
+	a->keykind = keykind;
+	return a;
+}
+
+array *copy_array(array *a) {
+	if (a == NULL) {
+		return alloc_array(KIND_WORD);
+	}
+	array *b = alloc_array(a->keykind);
+	for (int64_t i = 0; i < a->len; i++) {
+		array_set(b, a->keys[i], a->vals[i]);
+	}
+	b->next = a->next;
+	return b;
+}
+
+int64_t array_len(array *a) {
+	return a == NULL ? 0 : a->len;
+}
+
+// array_key returns the key of the entry at position i, which must be less
+// than the length of a.
+int64_t array_key(array *a, int64_t i) {
+	if (i < 0 || i >= array_len(a)) {
+		abort();
+	}
+	return a->keys[i];
+}
+
+// array_value returns the value of the entry at position i, which must be
+// less than the length of a.
+int64_t array_value(array *a, int64_t i) {
+	if (i < 0 || i >= array_len(a)) {
+		abort();
+	}
+	return a->vals[i];
+}
+
+// slot returns the index of the slot that holds k, or of the empty slot
+// where k belongs.
+static int64_t slot(array *a, int64_t k) {
+	uint64_t mask = a->nslots - 1;
+	uint64_t i = hash(a->keykind, k) & mask;
+	for (; a->slots[i] != 0; i = (i + 1) & mask) {
+		if (equal(a->keykind, a->keys[a->slots[i] - 1], k)) {
+			break;
+		}
+	}
+	return i;
+}
+
+// array_find returns the position of the entry with key k, or -1 if there
+// is none.
+int64_t array_find(array *a, int64_t k) {
+	if (array_len(a) == 0) {
+		return -1;
+	}
+	return a->slots[slot(a, k)] - 1;
+}
+
+static void grow(array *a) {
+	a->cap = a->cap == 0 ? 8 : 2 * a->cap;
+	int64_t *keys = gc_alloc(a->cap * sizeof(int64_t));
+	int64_t *vals = gc_alloc(a->cap * sizeof(int64_t));
+	if (a->len > 0) {
+		memcpy(keys, a->keys, a->len * sizeof(int64_t));
+		memcpy(vals, a->vals, a->len * sizeof(int64_t));
+	}
+	a->keys = keys;
+	a->vals = vals;
+	a->nslots = 2 * a->cap;
+	a->slots = gc_alloc(a->nslots * sizeof(int64_t));
+	for (int64_t i = 0; i < a->len; i++) {
+		a->slots[slot(a, a->keys[i])] = i + 1;
+	}
+}
+
+void array_set(array *a, int64_t k, int64_t v) {
+	if (a == NULL) {
+		abort();
+	}
+	if (a->len == a->cap) {
+		grow(a);
+	}
+	int64_t i = slot(a, k);
+	if (a->slots[i] != 0) {
+		a->vals[a->slots[i] - 1] = v;
+		return;
+	}
+	a->keys[a->len] = k;
+	a->vals[a->len] = v;
+	a->slots[i] = ++a->len;
+	if (a->keykind == KIND_WORD && k >= a->next) {
+		a->next = k + 1;
+	}
+}
+
+// array_append sets the entry under the next positional key, which is one
+// more than the greatest key that a has ever held, or 0. Only arrays keyed
+// by nums are appended to.
+void array_append(array *a, int64_t v) {
+	if (a == NULL) {
+		abort();
+	}
+	array_set(a, a->next, v);
+}
+
+// array_extend appends the values of b to a, and returns a. If a is null,
+// the values are appended to a new array.
+array *array_extend(array *a, array *b) {
+	if (a == NULL) {
+		a = alloc_array(KIND_WORD);
+	}
+	int64_t n = array_len(b); // b may be a
+	for (int64_t i = 0; i < n; i++) {
+		array_append(a, b->vals[i]);
+	}
+	return a;
+}
+
+// array_delete removes the entry with key k from a, if there is one. The
+// entries after it keep their keys and their order, and the next positional
+// key is unchanged.
+void array_delete(array *a, int64_t k) {
+	int64_t i = array_find(a, k);
+	if (i < 0) {
+		return;
+	}
+	a->len--;
+	memmove(a->keys + i, a->keys + i + 1, (a->len - i) * sizeof(int64_t));
+	memmove(a->vals + i, a->vals + i + 1, (a->len - i) * sizeof(int64_t));
+	memset(a->slots, 0, a->nslots * sizeof(int64_t));
+	for (int64_t j = 0; j < a->len; j++) {
+		a->slots[slot(a, a->keys[j])] = j + 1;
+	}
+}
+
+// array_keys_of returns an array of the keys whose value is v.
+array *array_keys_of(array *a, int64_t v, int32_t valkind) {
+	array *keys = alloc_array(KIND_WORD);
+	for (int64_t i = 0; i < array_len(a); i++) {
+		if (equal(valkind, a->vals[i], v)) {
+			array_append(keys, a->keys[i]);
+		}
+	}
+	return keys;
+}
+
+// slice_array returns an array of the values in positions [low, high) of a,
+// keyed from zero. The bounds must satisfy 0 <= low <= high <= len(a).
+array *slice_array(array *a, int64_t low, int64_t high) {
+	if (low < 0 || high < low || high > array_len(a)) {
+		abort();
+	}
+	array *b = alloc_array(KIND_WORD);
+	for (int64_t i = low; i < high; i++) {
+		array_append(b, a->vals[i]);
+	}
+	return b;
+}
@@ -0,0 +1,101 @@
+// The arvo runtime is linked into every program that the LLVM backend
+// compiles. Generated code only reaches into the heap through the functions
+// declared here, which check every access, so that a compiled program
+// cannot read or write memory that it does not own.
+
+#ifndef ARVO_H
+#define ARVO_H
+
+#include <stdarg.h>
+#include <stdint.h>
+#include <stdio.h>
+
+typedef int32_t rune;
+
+// panic reports a runtime error at the source position pos and exits. The
+// message is formatted from format and the remaining arguments, as by
+// printf.
+void panic(const char *pos, const char *format, ...);
+
+// Every object that generated code or the runtime allocates belongs to a
+// garbage-collected heap. gc_alloc returns zeroed memory, which remains
+// valid as long as a pointer into it is held on the stack, in a root, or in
+// another reachable object. Globals that hold pointers are registered as
+// roots with gc_add_root.
+void *gc_alloc(int64_t size);
+void gc_add_root(void **p);
+void gc_collect(void);
+int64_t gc_heap_size(void);
+
+// A string is an immutable sequence of UTF-8 encoded bytes. A null string
+// is empty.
+typedef struct string string;
+
+string *alloc_string(void);
+void init_c_str(string *s, const char *c);
+const char *c_str(string *s);
+int64_t rune_count(string *s);
+rune index_rune(string *s, int64_t i);
+int32_t assign_string(string *s, int64_t i, string *c);
+int32_t compare_strings(string *s1, string *s2);
+string *concat_strings(string *s1, string *s2);
+string *rune_string(rune r);
+string *slice_string(string *s, int64_t low, int64_t high);
+
+// RUNE_ERROR is the rune that each byte of an invalid UTF-8 encoding
+// decodes to.
+#define RUNE_ERROR 0xFFFD
+
+// decode_rune returns the width of the rune at the start of the n bytes at
+// p, and stores the rune in *r.
+int decode_rune(const unsigned char *p, int64_t n, rune *r);
+
+// Kinds of array keys and values, which determine how they are compared.
+enum {
+	KIND_WORD,   // compared by value: num, float, bool, and references
+	KIND_STRING, // compared by contents
+};
+
+// An array maps keys to values, remembering the order in which keys were
+// inserted. Keys and values are stored as 64-bit words: nums and bools
+// directly, floats by their bits, and every other value as a pointer. A
+// null array is empty.
+typedef struct array {
+	int32_t keykind;
+	int64_t len, cap;
+	int64_t *keys, *vals; // entries in insertion order
+	int64_t *slots;       // hash table of positions plus one; 0 if empty
+	int64_t nslots;       // power of two, at least twice len
+	int64_t next;         // key of the next appended value, for num keys
+} array;
+
+array *alloc_array(int32_t keykind);
+array *copy_array(array *a);
+int64_t array_len(array *a);
+int64_t array_key(array *a, int64_t i);
+int64_t array_value(array *a, int64_t i);
+int64_t array_find(array *a, int64_t k);
+void array_set(array *a, int64_t k, int64_t v);
+void array_append(array *a, int64_t v);
+array *array_extend(array *a, array *b);
+void array_delete(array *a, int64_t k);
+array *array_keys_of(array *a, int64_t v, int32_t valkind);
+array *slice_array(array *a, int64_t low, int64_t high);
+
+// print_format writes its arguments to standard output as described by
+// format, which uses the verbs of Go's fmt package, so that compiled
+// programs print what the interpreter prints. kinds describes the type of
+// each argument in turn: 'n' for a num, passed as an int64_t; 'f' for a
+// float, passed as a double; 'b' for a bool, passed as an int; 's' for a
+// str, passed as a C string; and, passed as pointers, 'c' for a function,
+// 'a' followed by the descriptions of its key and value types for an array,
+// and 'r{' for a record, followed by each element's key, as its length in
+// bytes, a colon and the key itself, and the description of the element's
+// type, and then '}'. vfprint_format is like print_format, but writes to f.
+// print_values is like print_format, but takes its arguments from the values
+// of a, in order, which are of the type that kind describes.
+void print_format(const char *kinds, const char *format, ...);
+void vfprint_format(FILE *f, const char *kinds, const char *format, va_list args);
+void print_values(const char *kind, const char *format, array *a);
+
+#endif
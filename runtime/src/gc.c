@@ -0,0 +1,181 @@
+// The collector is a conservative mark-and-sweep collector. Every word on
+// the stack, in the registered roots and in reachable objects is treated
+// as a reference if it points into an object, so generated code needs no
+// stack maps and may hold pointers to the interior of objects.
+
+#define _GNU_SOURCE
+#include <pthread.h>
+#include <setjmp.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "arvo.h"
+
+// An object is a block of memory returned by gc_alloc. Its header precedes
+// the memory that the program uses.
+typedef struct object {
+	size_t size;
+	int marked;
+} object;
+
+#define HEADER ((sizeof(object) + 15) & ~(size_t)15)
+
+static uintptr_t start(object *o) { return (uintptr_t)o + HEADER; }
+
+static object **objects; // every allocated object, sorted by address during a collection
+static size_t nobjects, capobjects;
+static size_t allocated; // bytes allocated to live and unreachable objects
+static size_t threshold = 1 << 20;
+
+static void ***roots;
+static size_t nroots, caproots;
+
+static uintptr_t stack_bottom; // highest address of the main thread's stack
+
+static object **marks; // objects that are marked but not yet scanned
+static size_t nmarks, capmarks;
+
+static void *xrealloc(void *p, size_t size) {
+	p = realloc(p, size);
+	if (p == NULL) {
+		panic("-", "out of memory");
+	}
+	return p;
+}
+
+// init finds the bottom of the stack, which every collection scans up to.
+static void init(void) {
+#if defined(__APPLE__)
+	stack_bottom = (uintptr_t)pthread_get_stackaddr_np(pthread_self());
+#else
+	pthread_attr_t attr;
+	void *addr;
+	size_t size;
+	if (pthread_getattr_np(pthread_self(), &attr) != 0 || pthread_attr_getstack(&attr, &addr, &size) != 0) {
+		panic("-", "cannot find the stack");
+	}
+	pthread_attr_destroy(&attr);
+	stack_bottom = (uintptr_t)addr + size;
+#endif
+}
+
+void gc_add_root(void **p) {
+	if (nroots == caproots) {
+		caproots = caproots == 0 ? 16 : 2 * caproots;
+		roots = xrealloc(roots, caproots * sizeof(*roots));
+	}
+	roots[nroots++] = p;
+}
+
+static int compare(const void *x, const void *y) {
+	uintptr_t a = (uintptr_t) * (object **)x, b = (uintptr_t) * (object **)y;
+	return (a > b) - (a < b);
+}
+
+// find returns the object that p points into, or NULL if there is none. A
+// pointer just past the end of an object points into it.
+static object *find(uintptr_t p) {
+	size_t lo = 0, hi = nobjects;
+	while (lo < hi) {
+		size_t mid = lo + (hi - lo) / 2;
+		object *o = objects[mid];
+		if (p < start(o)) {
+			hi = mid;
+		} else if (p > start(o) + o->size) {
+			lo = mid + 1;
+		} else {
+			return o;
+		}
+	}
+	return NULL;
+}
+
+static void mark(uintptr_t p) {
+	object *o = find(p);
+	if (o == NULL || o->marked) {
+		return;
+	}
+	o->marked = 1;
+	if (nmarks == capmarks) {
+		capmarks = capmarks == 0 ? 256 : 2 * capmarks;
+		marks = xrealloc(marks, capmarks * sizeof(*marks));
+	}
+	marks[nmarks++] = o;
+}
+
+// scan marks the objects that the words in [lo, hi) point into.
+static void scan(uintptr_t lo, uintptr_t hi) {
+	lo = (lo + sizeof(uintptr_t) - 1) & ~(sizeof(uintptr_t) - 1);
+	for (uintptr_t p = lo; p + sizeof(uintptr_t) <= hi; p += sizeof(uintptr_t)) {
+		mark(*(uintptr_t *)p);
+	}
+}
+
+// scan_stack marks the objects referred to from the stack. It must not be
+// inlined into gc_collect, so that its frame lies below the registers that
+// gc_collect saves.
+__attribute__((noinline)) static void scan_stack(void) {
+	volatile uintptr_t here = 0;
+	scan((uintptr_t)&here, stack_bottom);
+}
+
+void gc_collect(void) {
+	if (stack_bottom == 0) {
+		init();
+	}
+	jmp_buf regs;
+	setjmp(regs); // spill the registers onto the stack
+	qsort(objects, nobjects, sizeof(*objects), compare);
+	scan_stack();
+	for (size_t i = 0; i < nroots; i++) {
+		mark((uintptr_t)*roots[i]);
+	}
+	while (nmarks > 0) {
+		object *o = marks[--nmarks];
+		scan(start(o), start(o) + o->size);
+	}
+	size_t n = 0;
+	allocated = 0;
+	for (size_t i = 0; i < nobjects; i++) {
+		object *o = objects[i];
+		if (!o->marked) {
+			free(o);
+			continue;
+		}
+		o->marked = 0;
+		allocated += o->size;
+		objects[n++] = o;
+	}
+	nobjects = n;
+	if (threshold < 2 * allocated) {
+		threshold = 2 * allocated;
+	}
+}
+
+void *gc_alloc(int64_t size) {
+	if (size < 0) {
+		panic("-", "negative allocation size");
+	}
+	if (allocated + size > threshold) {
+		gc_collect();
+	}
+	object *o = calloc(1, HEADER + size);
+	if (o == NULL) {
+		gc_collect();
+		if ((o = calloc(1, HEADER + size)) == NULL) {
+			panic("-", "out of memory");
+		}
+	}
+	o->size = size;
+	if (nobjects == capobjects) {
+		capobjects = capobjects == 0 ? 256 : 2 * capobjects;
+		objects = xrealloc(objects, capobjects * sizeof(*objects));
+	}
+	objects[nobjects++] = o;
+	allocated += size;
+	return (void *)start(o);
+}
+
+int64_t gc_heap_size(void) {
+	return allocated;
+}
@@ -0,0 +1,19 @@
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+#include "arvo.h"
+
+// panic prints "pos: runtime error: msg" to standard error, after any output
+// that the program has written so far, and exits with status 2, as the
+// interpreter does.
+void panic(const char *pos, const char *format, ...) {
+	va_list args;
+	fflush(stdout);
+	fprintf(stderr, "%s: runtime error: ", pos);
+	va_start(args, format);
+	vfprintf(stderr, format, args);
+	va_end(args);
+	fputc('\n', stderr);
+	exit(2);
+}
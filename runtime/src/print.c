@@ -0,0 +1,728 @@
+// Formatting follows Go's fmt package rather than C's printf, so that a
+// compiled program prints what the interpreter prints. Arrays, records and
+// functions are printed as the strings that the interpreter formats them
+// as, such as a{1, 2}, a{'k': 1.5}, r{name: 'n'} and fun. Argument indexes
+// such as %[1]d are not supported.
+
+#include <math.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "arvo.h"
+
+typedef struct arg {
+	char kind;     // 'n', 'f', 'b' or 's'
+	int64_t n;     // num or bool
+	double f;      // float
+	const char *s; // str
+	char *text;    // formatted array, record or function that s points to
+} arg;
+
+// A spec is a parsed verb, such as %-8.3f.
+typedef struct spec {
+	int minus, plus, sharp, zero, space;
+	int width, prec; // -1 if absent
+	char verb;
+} spec;
+
+static const char *type_name(char kind) {
+	switch (kind) {
+	case 'n':
+		return "int64";
+	case 'f':
+		return "float64";
+	case 'b':
+		return "bool";
+	}
+	return "string";
+}
+
+// A buffer holds text that is being formatted.
+typedef struct buffer {
+	char *data; // NUL-terminated
+	size_t len, cap;
+} buffer;
+
+static void put(buffer *b, const char *s, size_t n) {
+	if (b->len + n >= b->cap) {
+		size_t cap = 2 * b->cap + n + 1;
+		char *data = realloc(b->data, cap);
+		if (data == NULL) {
+			panic("-", "out of memory");
+		}
+		b->data = data;
+		b->cap = cap;
+	}
+	memcpy(b->data + b->len, s, n);
+	b->len += n;
+	b->data[b->len] = '\0';
+}
+
+static void put_str(buffer *b, const char *s) {
+	put(b, s, strlen(s));
+}
+
+// printable reports whether r is printable, as Go's strconv.IsPrint does.
+// Outside ASCII, the runes that are not printable are the controls, the
+// spaces, the format characters, the surrogates, the runes for private use,
+// and the noncharacters. Unlike in Go, runes that are not assigned are
+// printable.
+static int printable(rune r) {
+	static const rune unprintable[][2] = {
+		{0x80, 0xA0}, {0xAD, 0xAD}, {0x600, 0x605}, {0x61C, 0x61C}, {0x6DD, 0x6DD},
+		{0x70F, 0x70F}, {0x890, 0x891}, {0x8E2, 0x8E2}, {0x180E, 0x180E}, {0x1680, 0x1680},
+		{0x2000, 0x200F}, {0x2028, 0x202F}, {0x205F, 0x2064}, {0x2066, 0x206F},
+		{0x3000, 0x3000}, {0xD800, 0xF8FF}, {0xFDD0, 0xFDEF}, {0xFEFF, 0xFEFF},
+		{0xFFF9, 0xFFFB}, {0x110BD, 0x110BD}, {0x110CD, 0x110CD}, {0x13430, 0x1343F},
+		{0x1BCA0, 0x1BCA3}, {0x1D173, 0x1D17A}, {0xE0000, 0xE00FF}, {0xF0000, 0x10FFFF},
+	};
+	if (r < 0x80) {
+		return r >= 0x20 && r < 0x7F;
+	}
+	if ((r & 0xFFFE) == 0xFFFE) {
+		return 0;
+	}
+	for (size_t i = 0; i < sizeof(unprintable) / sizeof(unprintable[0]); i++) {
+		if (r >= unprintable[i][0] && r <= unprintable[i][1]) {
+			return 0;
+		}
+	}
+	return 1;
+}
+
+// quote writes the n bytes at s to b as a string literal between quotes q,
+// escaping them as Go's strconv.Quote does. If ascii is set, the runes
+// outside ASCII are escaped too.
+static void quote(buffer *b, const char *s, size_t n, char q, int ascii) {
+	put(b, &q, 1);
+	while (n > 0) {
+		rune r;
+		int w = decode_rune((const unsigned char *)s, n, &r);
+		char esc[16];
+		if (w == 1 && r == RUNE_ERROR) {
+			snprintf(esc, sizeof(esc), "\\x%02x", (unsigned char)s[0]);
+			put_str(b, esc);
+		} else if (r == q || r == '\\') {
+			esc[0] = '\\', esc[1] = (char)r;
+			put(b, esc, 2);
+		} else if (printable(r) && (!ascii || r < 0x80)) {
+			put(b, s, w);
+		} else {
+			switch (r) {
+			case '\a':
+				put_str(b, "\\a");
+				break;
+			case '\b':
+				put_str(b, "\\b");
+				break;
+			case '\f':
+				put_str(b, "\\f");
+				break;
+			case '\n':
+				put_str(b, "\\n");
+				break;
+			case '\r':
+				put_str(b, "\\r");
+				break;
+			case '\t':
+				put_str(b, "\\t");
+				break;
+			case '\v':
+				put_str(b, "\\v");
+				break;
+			default:
+				if (r < ' ' || r == 0x7F) {
+					snprintf(esc, sizeof(esc), "\\x%02x", (unsigned)r);
+				} else if (r < 0x10000) {
+					snprintf(esc, sizeof(esc), "\\u%04x", (unsigned)r);
+				} else {
+					snprintf(esc, sizeof(esc), "\\U%08x", (unsigned)r);
+				}
+				put_str(b, esc);
+			}
+		}
+		s += w;
+		n -= w;
+	}
+	put(b, &q, 1);
+}
+
+// backquotable reports whether the n bytes at s can be written between
+// backquotes, as Go's strconv.CanBackquote does.
+static int backquotable(const char *s, size_t n) {
+	while (n > 0) {
+		rune r;
+		int w = decode_rune((const unsigned char *)s, n, &r);
+		if (w > 1 ? r == 0xFEFF : r == RUNE_ERROR || (r < ' ' && r != '\t') || r == '`' || r == 0x7F) {
+			return 0;
+		}
+		s += w;
+		n -= w;
+	}
+	return 1;
+}
+
+// runes returns the number of runes in the n bytes at s, counting the bytes
+// that do not continue an encoding.
+static int runes(const char *s, size_t n) {
+	int count = 0;
+	for (size_t i = 0; i < n; i++) {
+		count += ((unsigned char)s[i] & 0xC0) != 0x80;
+	}
+	return count;
+}
+
+// pad writes the n bytes at s, padded to the width in sp. If number is set,
+// zeros are padded after the sign.
+static void pad(FILE *f, const spec *sp, const char *s, size_t n, int number) {
+	int w = sp->width - runes(s, n);
+	if (w <= 0) {
+		fwrite(s, 1, n, f);
+		return;
+	}
+	if (sp->minus) {
+		fwrite(s, 1, n, f);
+		while (w-- > 0) {
+			fputc(' ', f);
+		}
+		return;
+	}
+	if (sp->zero && number && n > 0 && (s[0] == '-' || s[0] == '+' || s[0] == ' ')) {
+		fputc(s[0], f);
+		s++, n--;
+	}
+	while (w-- > 0) {
+		fputc(sp->zero ? '0' : ' ', f);
+	}
+	fwrite(s, 1, n, f);
+}
+
+static void print_int(FILE *f, const spec *sp, int64_t v) {
+	if (sp->verb == 'c') {
+		rune r = v < 0 || v > 0x10FFFF ? 0xFFFD : (rune)v;
+		const char *s = c_str(rune_string(r));
+		pad(f, sp, s, strlen(s), 0);
+		return;
+	}
+	int base = 10;
+	const char *digits = "0123456789abcdef", *prefix = "";
+	switch (sp->verb) {
+	case 'b':
+		base = 2, prefix = "0b";
+		break;
+	case 'o':
+		base = 8, prefix = "0";
+		break;
+	case 'x':
+		base = 16, prefix = "0x";
+		break;
+	case 'X':
+		base = 16, prefix = "0X", digits = "0123456789ABCDEF";
+		break;
+	}
+	// Room for a sign, a prefix, and 64 binary digits padded to a precision
+	// of at most 100.
+	char buf[128];
+	char *end = buf + sizeof(buf), *p = end;
+	uint64_t u = v < 0 ? -(uint64_t)v : (uint64_t)v;
+	if (v != 0 || sp->prec != 0) {
+		do {
+			*--p = digits[u % base];
+			u /= base;
+		} while (u != 0);
+	}
+	int prec = sp->prec > 100 ? 100 : sp->prec;
+	spec s = *sp;
+	if (s.zero && !s.minus && prec < 0) {
+		// Zeros pad the digits to the width, leaving room for the sign
+		// but not the prefix, as in Go.
+		prec = s.width;
+		if (v < 0 || s.plus || s.space) {
+			prec--;
+		}
+		prec = prec > 100 ? 100 : prec;
+	}
+	s.zero = 0;
+	while (end - p < prec) {
+		*--p = '0';
+	}
+	if (s.sharp && !(base == 8 && *p == '0')) {
+		for (int i = strlen(prefix) - 1; i >= 0; i--) {
+			*--p = prefix[i];
+		}
+	}
+	if (v < 0) {
+		*--p = '-';
+	} else if (s.plus) {
+		*--p = '+';
+	} else if (s.space) {
+		*--p = ' ';
+	}
+	pad(f, &s, p, end - p, 0);
+}
+
+// shortest writes to buf the shortest decimal representation of x that
+// reads back as x, in the form that Go's %v uses.
+static void shortest(char *buf, size_t size, double x, int upper) {
+	int nd;
+	for (nd = 1; nd <= 17; nd++) {
+		snprintf(buf, size, "%.*e", nd - 1, x);
+		if (nd == 17 || strtod(buf, NULL) == x) {
+			break;
+		}
+	}
+	char *e = strchr(buf, 'e');
+	int exp = atoi(e + 1);
+	if (exp < -4 || exp >= 6) {
+		if (upper) {
+			*e = 'E';
+		}
+		return;
+	}
+	int dp = exp + 1;
+	snprintf(buf, size, "%.*f", nd > dp ? nd - dp : 0, x);
+}
+
+static void print_float(FILE *f, const spec *sp, double x) {
+	spec s = *sp;
+	if (isnan(x) || isinf(x)) {
+		const char *str = isnan(x) ? "NaN" : x < 0 ? "-Inf" : "+Inf";
+		s.zero = 0;
+		pad(f, &s, str, strlen(str), 0);
+		return;
+	}
+	// %f of the largest float has 309 digits before the point.
+	char buf[512];
+	char verb = s.verb == 'v' ? 'g' : s.verb == 'F' ? 'f' : s.verb == 'x' ? 'a' : s.verb == 'X' ? 'A' : s.verb;
+	if ((verb == 'g' || verb == 'G') && s.prec < 0) {
+		char *p = buf;
+		if (!signbit(x) && (s.plus || s.space)) {
+			*p++ = s.plus ? '+' : ' ';
+		}
+		shortest(p, sizeof(buf) - 1, x, verb == 'G');
+	} else {
+		char format[16], *p = format;
+		*p++ = '%';
+		if (s.plus) {
+			*p++ = '+';
+		} else if (s.space) {
+			*p++ = ' ';
+		}
+		if (s.sharp) {
+			*p++ = '#';
+		}
+		if (s.prec >= 0 || (verb != 'a' && verb != 'A')) {
+			strcpy(p, ".*");
+			p += 2;
+		}
+		*p++ = verb;
+		*p = '\0';
+		if (verb != 'a' && verb != 'A') {
+			snprintf(buf, sizeof(buf), format, s.prec < 0 ? 6 : s.prec > 100 ? 100 : s.prec, x);
+		} else if (s.prec >= 0) {
+			snprintf(buf, sizeof(buf), format, s.prec > 100 ? 100 : s.prec, x);
+		} else {
+			snprintf(buf, sizeof(buf), format, x);
+		}
+		// Go writes at least two digits of a hexadecimal exponent.
+		char *e = strpbrk(buf, "pP");
+		if (e != NULL && e[2] != '\0' && e[3] == '\0') {
+			memmove(e + 3, e + 2, 2);
+			e[2] = '0';
+		}
+	}
+	pad(f, &s, buf, strlen(buf), 1);
+}
+
+// print_hex writes the first prec bytes of s, or all of them if prec is
+// negative, as pairs of hexadecimal digits, as Go's %x does for strings.
+static void print_hex(FILE *f, const spec *sp, const char *s) {
+	size_t n = strlen(s);
+	if (sp->prec >= 0 && (size_t)sp->prec < n) {
+		n = sp->prec;
+	}
+	const char *digits = sp->verb == 'X' ? "0123456789ABCDEF" : "0123456789abcdef";
+	char prefix[2] = {'0', sp->verb};
+	buffer b = {0};
+	for (size_t i = 0; i < n; i++) {
+		if (i > 0 && sp->space) {
+			put(&b, " ", 1);
+		}
+		if (sp->sharp && (i == 0 || sp->space)) {
+			put(&b, prefix, 2);
+		}
+		char c = s[i];
+		char pair[2] = {digits[(unsigned char)c >> 4], digits[c & 0xF]};
+		put(&b, pair, 2);
+	}
+	pad(f, sp, b.len > 0 ? b.data : "", b.len, 0);
+	free(b.data);
+}
+
+// print_string writes the first prec runes of s, or all of them if prec
+// is negative. They are quoted for %q.
+static void print_string(FILE *f, const spec *sp, const char *s) {
+	if (sp->verb == 'x' || sp->verb == 'X') {
+		print_hex(f, sp, s);
+		return;
+	}
+	size_t n = strlen(s);
+	if (sp->prec >= 0) {
+		int count = 0;
+		for (n = 0; s[n] != '\0'; n++) {
+			if (((unsigned char)s[n] & 0xC0) != 0x80 && count++ == sp->prec) {
+				break;
+			}
+		}
+	}
+	if (sp->verb != 'q') {
+		pad(f, sp, s, n, 0);
+		return;
+	}
+	buffer b = {0};
+	if (sp->sharp && backquotable(s, n)) {
+		put(&b, "`", 1);
+		put(&b, s, n);
+		put(&b, "`", 1);
+	} else {
+		quote(&b, s, n, '"', sp->plus);
+	}
+	pad(f, sp, b.data, b.len, 0);
+	free(b.data);
+}
+
+// print_arg writes a as described by sp, and reports whether the verb
+// applies to a.
+static int print_arg(FILE *f, const spec *sp, const arg *a) {
+	switch (a->kind) {
+	case 'n':
+		if (strchr("vdbocxX", sp->verb) == NULL) {
+			return 0;
+		}
+		print_int(f, sp, a->n);
+		return 1;
+	case 'f':
+		if (strchr("veEfFgGxX", sp->verb) == NULL) {
+			return 0;
+		}
+		print_float(f, sp, a->f);
+		return 1;
+	case 'b':
+		if (strchr("vt", sp->verb) == NULL) {
+			return 0;
+		}
+		print_string(f, sp, a->n ? "true" : "false");
+		return 1;
+	}
+	if (strchr("vsqxX", sp->verb) == NULL) {
+		return 0;
+	}
+	print_string(f, sp, a->s);
+	return 1;
+}
+
+// skip returns the end of the description of a type at t.
+static const char *skip(const char *t) {
+	switch (*t++) {
+	case 'a':
+		return skip(skip(t));
+	case 'r':
+		for (t++; *t != '}';) {
+			char *key;
+			size_t n = strtoul(t, &key, 10);
+			t = skip(key + 1 + n);
+		}
+		return t + 1;
+	}
+	return t;
+}
+
+static void format_value(buffer *b, const char *t, int64_t v);
+
+// format_array writes the array a, whose keys and values are of the types
+// described at key and following it. The keys are left out if they are
+// the positions of the values.
+static void format_array(buffer *b, const char *key, array *a) {
+	const char *val = skip(key);
+	int64_t n = array_len(a);
+	int seq = *key == 'n';
+	for (int64_t i = 0; seq && i < n; i++) {
+		seq = array_key(a, i) == i;
+	}
+	put_str(b, "a{");
+	for (int64_t i = 0; i < n; i++) {
+		if (i > 0) {
+			put_str(b, ", ");
+		}
+		if (!seq) {
+			format_value(b, key, array_key(a, i));
+			put_str(b, ": ");
+		}
+		format_value(b, val, array_value(a, i));
+	}
+	put_str(b, "}");
+}
+
+// format_record writes the record at p, whose elements are described at
+// t. The elements are laid out as LLVM lays out a struct for a 64-bit
+// target: a bool takes a byte, and every other value takes 8 bytes,
+// aligned to 8.
+static void format_record(buffer *b, const char *t, const char *p) {
+	put_str(b, "r{");
+	size_t off = 0;
+	for (int i = 0; *t != '}'; i++) {
+		char *key;
+		size_t n = strtoul(t, &key, 10);
+		key++;
+		if (i > 0) {
+			put_str(b, ", ");
+		}
+		put(b, key, n);
+		put_str(b, ": ");
+		t = key + n;
+		int64_t v = 0;
+		if (*t == 'b') {
+			v = p[off++] & 1;
+		} else {
+			off = (off + 7) & ~(size_t)7;
+			memcpy(&v, p + off, sizeof(v));
+			off += sizeof(v);
+		}
+		format_value(b, t, v);
+		t = skip(t);
+	}
+	put_str(b, "}");
+}
+
+// format_value writes v, a word holding a value of the type described at t, as
+// it is written inside an array or record. Nums and bools are held
+// directly, floats by their bits, and every other value as a pointer.
+static void format_value(buffer *b, const char *t, int64_t v) {
+	char buf[32];
+	switch (*t) {
+	case 'n':
+		snprintf(buf, sizeof(buf), "%lld", (long long)v);
+		put_str(b, buf);
+		break;
+	case 'f': {
+		double x;
+		memcpy(&x, &v, sizeof(x));
+		if (isnan(x) || isinf(x)) {
+			put_str(b, isnan(x) ? "NaN" : x < 0 ? "-Inf" : "+Inf");
+		} else {
+			shortest(buf, sizeof(buf), x, 0);
+			put_str(b, buf);
+		}
+		break;
+	}
+	case 'b':
+		put_str(b, v ? "true" : "false");
+		break;
+	case 's': {
+		const char *s = c_str((string *)(intptr_t)v);
+		quote(b, s, strlen(s), '\'', 0);
+		break;
+	}
+	case 'c':
+		put_str(b, v ? "fun" : "nil");
+		break;
+	case 'a':
+		format_array(b, t + 1, (array *)(intptr_t)v);
+		break;
+	case 'r':
+		format_record(b, t + 2, (const char *)(intptr_t)v);
+		break;
+	}
+}
+
+// set_text makes a a str holding the text of v, a word holding an array,
+// record or function of the type described at t.
+static void set_text(arg *a, const char *t, int64_t v) {
+	buffer b = {0};
+	format_value(&b, t, v);
+	a->kind = 's';
+	a->s = a->text = b.data;
+}
+
+static void print_value(FILE *f, const arg *a) {
+	spec sp = {.width = -1, .prec = -1, .verb = 'v'};
+	print_arg(f, &sp, a);
+}
+
+// number parses a width or precision at *p into *n, taking it from the
+// next argument if it is '*'. It returns 0 if there is none, and -1 if the
+// argument is not a num.
+static int number(const char **p, const arg *args, int nargs, int *argi, int *n) {
+	if (**p == '*') {
+		(*p)++;
+		if (*argi >= nargs || args[*argi].kind != 'n') {
+			return -1;
+		}
+		int64_t v = args[(*argi)++].n;
+		*n = v < -1000000 ? -1000000 : v > 1000000 ? 1000000 : (int)v;
+		return 1;
+	}
+	if (**p < '0' || **p > '9') {
+		return 0;
+	}
+	for (*n = 0; **p >= '0' && **p <= '9'; (*p)++) {
+		if (*n < 1000000) {
+			*n = 10 * *n + (**p - '0');
+		}
+	}
+	return 1;
+}
+
+static arg *alloc_args(int nargs) {
+	arg *args = calloc(nargs + 1, sizeof(*args));
+	if (args == NULL) {
+		panic("-", "out of memory");
+	}
+	return args;
+}
+
+// print_args writes args to f as described by format, and frees them.
+static void print_args(FILE *f, const char *format, arg *args, int nargs) {
+	int argi = 0;
+	for (const char *p = format; *p != '\0';) {
+		if (*p != '%') {
+			fputc(*p++, f);
+			continue;
+		}
+		p++;
+		spec sp = {0};
+		for (;; p++) {
+			if (*p == '-') {
+				sp.minus = 1;
+			} else if (*p == '+') {
+				sp.plus = 1;
+			} else if (*p == '#') {
+				sp.sharp = 1;
+			} else if (*p == '0') {
+				sp.zero = 1;
+			} else if (*p == ' ') {
+				sp.space = 1;
+			} else {
+				break;
+			}
+		}
+		sp.width = sp.prec = -1;
+		switch (number(&p, args, nargs, &argi, &sp.width)) {
+		case -1:
+			fputs("%!(BADWIDTH)", f);
+			break;
+		case 1:
+			if (sp.width < 0) {
+				sp.minus = 1;
+				sp.width = -sp.width;
+			}
+		}
+		if (*p == '.') {
+			p++;
+			sp.prec = 0;
+			if (number(&p, args, nargs, &argi, &sp.prec) < 0) {
+				fputs("%!(BADPREC)", f);
+			}
+			if (sp.prec < 0) {
+				sp.prec = -1;
+			}
+		}
+		if (sp.minus) {
+			sp.zero = 0;
+		}
+		if (*p == '\0') {
+			fputs("%!(NOVERB)", f);
+			break;
+		}
+		sp.verb = *p++;
+		if (sp.verb == '%') {
+			fputc('%', f);
+			continue;
+		}
+		if (argi >= nargs) {
+			fprintf(f, "%%!%c(MISSING)", sp.verb);
+			continue;
+		}
+		const arg *a = &args[argi++];
+		if (!print_arg(f, &sp, a)) {
+			fprintf(f, "%%!%c(%s=", sp.verb, type_name(a->kind));
+			print_value(f, a);
+			fputc(')', f);
+		}
+	}
+	if (argi < nargs) {
+		fputs("%!(EXTRA ", f);
+		for (int i = argi; i < nargs; i++) {
+			if (i > argi) {
+				fputs(", ", f);
+			}
+			fprintf(f, "%s=", type_name(args[i].kind));
+			print_value(f, &args[i]);
+		}
+		fputc(')', f);
+	}
+	for (int i = 0; i < nargs; i++) {
+		free(args[i].text);
+	}
+	free(args);
+}
+
+void vfprint_format(FILE *f, const char *kinds, const char *format, va_list ap) {
+	int nargs = 0;
+	for (const char *t = kinds; *t != '\0'; t = skip(t)) {
+		nargs++;
+	}
+	arg *args = alloc_args(nargs);
+	const char *t = kinds;
+	for (int i = 0; i < nargs; i++, t = skip(t)) {
+		args[i].kind = *t;
+		switch (*t) {
+		case 'n':
+			args[i].n = va_arg(ap, int64_t);
+			break;
+		case 'f':
+			args[i].f = va_arg(ap, double);
+			break;
+		case 'b':
+			args[i].n = va_arg(ap, int);
+			break;
+		case 's':
+			args[i].s = va_arg(ap, const char *);
+			break;
+		default:
+			set_text(&args[i], t, (int64_t)(intptr_t)va_arg(ap, void *));
+		}
+	}
+	print_args(f, format, args, nargs);
+}
+
+void print_format(const char *kinds, const char *format, ...) {
+	va_list ap;
+	va_start(ap, format);
+	vfprint_format(stdout, kinds, format, ap);
+	va_end(ap);
+}
+
+void print_values(const char *kind, const char *format, array *a) {
+	int nargs = array_len(a);
+	arg *args = alloc_args(nargs);
+	for (int64_t i = 0; i < nargs; i++) {
+		int64_t v = array_value(a, i);
+		args[i].kind = *kind;
+		switch (*kind) {
+		case 'n':
+		case 'b':
+			args[i].n = v;
+			break;
+		case 'f':
+			memcpy(&args[i].f, &v, sizeof(args[i].f));
+			break;
+		case 's':
+			args[i].s = c_str((string *)(intptr_t)v);
+			break;
+		default:
+			set_text(&args[i], kind, v);
+		}
+	}
+	print_args(stdout, format, args, nargs);
+}
@@ -0,0 +1,202 @@
+// Strings hold UTF-8 encoded text. Operations that count or index runes
+// decode the text as Go does: each byte of an invalid encoding is a single
+// rune, U+FFFD.
+
+#include <string.h>
+
+#include "arvo.h"
+
+struct string {
+	int64_t len; // in bytes
+	char *data;  // len bytes followed by a NUL; or NULL if len is 0
+};
+
+int decode_rune(const unsigned char *p, int64_t n, rune *r) {
+	*r = RUNE_ERROR;
+	if (n < 1) {
+		return 0;
+	}
+	unsigned char c = p[0];
+	if (c < 0x80) {
+		*r = c;
+		return 1;
+	}
+	int width;
+	rune min, v;
+	if ((c & 0xE0) == 0xC0) {
+		width = 2, min = 0x80, v = c & 0x1F;
+	} else if ((c & 0xF0) == 0xE0) {
+		width = 3, min = 0x800, v = c & 0x0F;
+	} else if ((c & 0xF8) == 0xF0) {
+		width = 4, min = 0x10000, v = c & 0x07;
+	} else {
+		return 1;
+	}
+	if (n < width) {
+		return 1;
+	}
+	for (int i = 1; i < width; i++) {
+		if ((p[i] & 0xC0) != 0x80) {
+			return 1;
+		}
+		v = v << 6 | (p[i] & 0x3F);
+	}
+	if (v < min || v > 0x10FFFF || (v >= 0xD800 && v <= 0xDFFF)) {
+		return 1;
+	}
+	*r = v;
+	return width;
+}
+
+// encode writes the UTF-8 encoding of r to p, which has room for 4 bytes,
+// and returns its width. Invalid runes are encoded as U+FFFD.
+static int encode(unsigned char *p, rune r) {
+	if (r < 0 || r > 0x10FFFF || (r >= 0xD800 && r <= 0xDFFF)) {
+		r = RUNE_ERROR;
+	}
+	if (r < 0x80) {
+		p[0] = r;
+		return 1;
+	}
+	if (r < 0x800) {
+		p[0] = 0xC0 | r >> 6;
+		p[1] = 0x80 | (r & 0x3F);
+		return 2;
+	}
+	if (r < 0x10000) {
+		p[0] = 0xE0 | r >> 12;
+		p[1] = 0x80 | (r >> 6 & 0x3F);
+		p[2] = 0x80 | (r & 0x3F);
+		return 3;
+	}
+	p[0] = 0xF0 | r >> 18;
+	p[1] = 0x80 | (r >> 12 & 0x3F);
+	p[2] = 0x80 | (r >> 6 & 0x3F);
+	p[3] = 0x80 | (r & 0x3F);
+	return 4;
+}
+
+// make returns a new string holding the n bytes at p.
+static string *make(const char *p, int64_t n) {
+	string *s = alloc_string();
+	if (n > 0) {
+		s->data = gc_alloc(n + 1);
+		memcpy(s->data, p, n);
+		s->len = n;
+	}
+	return s;
+}
+
+static int64_t len(string *s) {
+	return s == NULL ? 0 : s->len;
+}
+
+// offset returns the byte offset of the i'th rune of s, or -1 if s has
+// fewer than i runes.
+static int64_t offset(string *s, int64_t i) {
+	int64_t off = 0, n = len(s);
+	rune r;
+	for (; i > 0 && off < n; i--) {
+		off += decode_rune((unsigned char *)s->data + off, n - off, &r);
+	}
+	return i == 0 ? off : -1;
+}
+
+string *alloc_string(void) {
+	return gc_alloc(sizeof(string));
+}
+
+void init_c_str(string *s, const char *c) {
+	int64_t n = strlen(c);
+	s->data = NULL;
+	s->len = 0;
+	if (n > 0) {
+		s->data = gc_alloc(n + 1);
+		memcpy(s->data, c, n);
+		s->len = n;
+	}
+}
+
+const char *c_str(string *s) {
+	return len(s) == 0 ? "" : s->data;
+}
+
+int64_t rune_count(string *s) {
+	int64_t count = 0, n = len(s);
+	rune r;
+	for (int64_t off = 0; off < n; count++) {
+		off += decode_rune((unsigned char *)s->data + off, n - off, &r);
+	}
+	return count;
+}
+
+rune index_rune(string *s, int64_t i) {
+	int64_t off = i < 0 ? -1 : offset(s, i);
+	if (off < 0 || off >= len(s)) {
+		panic("-", "index out of range [%lld] with length %lld", (long long)i, (long long)rune_count(s));
+	}
+	rune r;
+	decode_rune((unsigned char *)s->data + off, s->len - off, &r);
+	return r;
+}
+
+// assign_string replaces the i'th rune of s with the first rune of c. It
+// returns 0, or -1 if s has no i'th rune.
+int32_t assign_string(string *s, int64_t i, string *c) {
+	int64_t off = i < 0 ? -1 : offset(s, i);
+	if (off < 0 || off >= len(s)) {
+		return -1;
+	}
+	rune old, new;
+	int oldw = decode_rune((unsigned char *)s->data + off, s->len - off, &old);
+	decode_rune((unsigned char *)c_str(c), len(c), &new);
+	unsigned char buf[4];
+	int neww = encode(buf, new);
+	int64_t n = s->len - oldw + neww;
+	char *data = gc_alloc(n + 1);
+	memcpy(data, s->data, off);
+	memcpy(data + off, buf, neww);
+	memcpy(data + off + neww, s->data + off + oldw, s->len - off - oldw);
+	s->data = data;
+	s->len = n;
+	return 0;
+}
+
+// compare_strings compares the bytes of s1 and s2, returning a negative
+// number, zero, or a positive number if s1 is less than, equal to, or
+// greater than s2.
+int32_t compare_strings(string *s1, string *s2) {
+	int64_t n1 = len(s1), n2 = len(s2);
+	int c = memcmp(c_str(s1), c_str(s2), n1 < n2 ? n1 : n2);
+	if (c != 0) {
+		return c < 0 ? -1 : 1;
+	}
+	return (n1 > n2) - (n1 < n2);
+}
+
+string *concat_strings(string *s1, string *s2) {
+	int64_t n1 = len(s1), n2 = len(s2);
+	string *s = alloc_string();
+	if (n1 + n2 > 0) {
+		s->data = gc_alloc(n1 + n2 + 1);
+		memcpy(s->data, c_str(s1), n1);
+		memcpy(s->data + n1, c_str(s2), n2);
+		s->len = n1 + n2;
+	}
+	return s;
+}
+
+string *rune_string(rune r) {
+	unsigned char buf[4];
+	return make((char *)buf, encode(buf, r));
+}
+
+// slice_string returns the runes of s in positions [low, high).
+string *slice_string(string *s, int64_t low, int64_t high) {
+	int64_t lo = low < 0 ? -1 : offset(s, low);
+	int64_t hi = high < low ? -1 : offset(s, high);
+	if (lo < 0 || hi < 0) {
+		panic("-", "slice bounds out of range [%lld:%lld] with length %lld", (long long)low, (long long)high, (long long)rune_count(s));
+	}
+	return make(c_str(s) + lo, hi - lo);
+}
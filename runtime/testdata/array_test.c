@@ -0,0 +1,145 @@
+#include <stdio.h>
+#include <string.h>
+
+#include "arvo.h"
+
+static int failed;
+
+#define check(cond, ...) \
+	do { \
+		if (!(cond)) { \
+			fprintf(stderr, "%s:%d: ", __FILE__, __LINE__); \
+			fprintf(stderr, __VA_ARGS__); \
+			fputc('\n', stderr); \
+			failed = 1; \
+		} \
+	} while (0)
+
+static string *str(const char *c) {
+	string *s = alloc_string();
+	init_c_str(s, c);
+	return s;
+}
+
+// Entries are found after the array grows and its slots are rehashed, and
+// keep the order in which they were inserted.
+static void test_grow(void) {
+	array *a = alloc_array(KIND_WORD);
+	for (int64_t i = 0; i < 1000; i++) {
+		array_set(a, 1000 - i, i);
+	}
+	check(array_len(a) == 1000, "len is %lld, want 1000", (long long)array_len(a));
+	check(a->nslots >= 2 * a->len, "%lld slots for %lld entries", (long long)a->nslots, (long long)a->len);
+	for (int64_t i = 0; i < 1000; i++) {
+		check(array_find(a, 1000 - i) == i, "key %lld is at %lld, want %lld", (long long)(1000 - i), (long long)array_find(a, 1000 - i), (long long)i);
+		check(array_key(a, i) == 1000 - i && array_value(a, i) == i, "entry %lld is %lld: %lld", (long long)i, (long long)array_key(a, i), (long long)array_value(a, i));
+	}
+	check(array_find(a, 0) == -1, "key 0 is at %lld, want -1", (long long)array_find(a, 0));
+	array_set(a, 500, -1);
+	check(array_len(a) == 1000 && array_value(a, 500) == -1, "setting an existing key adds an entry or misses it");
+}
+
+// Strings are keyed by their contents rather than by their addresses.
+static void test_string_keys(void) {
+	array *a = alloc_array(KIND_STRING);
+	array_set(a, (int64_t)str("k"), 1);
+	array_set(a, (int64_t)str("j"), 2);
+	array_set(a, (int64_t)str("k"), 3);
+	check(array_len(a) == 2, "len is %lld, want 2", (long long)array_len(a));
+	check(array_find(a, (int64_t)str("k")) == 0 && array_value(a, 0) == 3, "k is at %lld", (long long)array_find(a, (int64_t)str("k")));
+	check(array_find(a, (int64_t)str("z")) == -1, "z is found");
+}
+
+// Deleting an entry keeps the order of the others, which are still found
+// at their new positions.
+static void test_delete(void) {
+	array *a = alloc_array(KIND_WORD);
+	for (int64_t i = 0; i < 20; i++) {
+		array_append(a, i * i);
+	}
+	for (int64_t k = 0; k < 20; k += 3) {
+		array_delete(a, k);
+	}
+	array_delete(a, 100);
+	check(array_len(a) == 13, "len is %lld, want 13", (long long)array_len(a));
+	int64_t prev = -1;
+	for (int64_t i = 0; i < array_len(a); i++) {
+		int64_t k = array_key(a, i);
+		check(k > prev && k % 3 != 0, "entry %lld has key %lld after %lld", (long long)i, (long long)k, (long long)prev);
+		check(array_value(a, i) == k * k && array_find(a, k) == i, "key %lld is at %lld with %lld", (long long)k, (long long)array_find(a, k), (long long)array_value(a, i));
+		prev = k;
+	}
+	array_delete(NULL, 0);
+	check(array_find(NULL, 0) == -1 && array_len(NULL) == 0, "null array is not empty");
+}
+
+// keys_of returns the keys of the entries with a value, in order, keyed
+// from zero.
+static void test_keys_of(void) {
+	array *a = alloc_array(KIND_STRING);
+	const char *keys[] = {"a", "b", "c", "d"};
+	int64_t vals[] = {5, 6, 5, 7};
+	for (int i = 0; i < 4; i++) {
+		array_set(a, (int64_t)str(keys[i]), vals[i]);
+	}
+	array *k = array_keys_of(a, 5, KIND_WORD);
+	check(array_len(k) == 2, "%lld keys, want 2", (long long)array_len(k));
+	check(array_find(k, 1) == 1 && strcmp(c_str((string *)array_value(k, 1)), "c") == 0, "second key is not c");
+	check(array_len(array_keys_of(a, 9, KIND_WORD)) == 0, "keys of a missing value");
+
+	array *b = alloc_array(KIND_WORD);
+	array_append(b, (int64_t)str("x"));
+	array_append(b, (int64_t)str("y"));
+	k = array_keys_of(b, (int64_t)str("y"), KIND_STRING);
+	check(array_len(k) == 1 && array_value(k, 0) == 1, "strings are not compared by contents");
+}
+
+// A slice holds the values in a range of positions, keyed from zero.
+static void test_slice(void) {
+	array *a = alloc_array(KIND_WORD);
+	for (int64_t i = 0; i < 5; i++) {
+		array_set(a, 10 + i, i);
+	}
+	array *s = slice_array(a, 1, 4);
+	check(array_len(s) == 3, "len is %lld, want 3", (long long)array_len(s));
+	for (int64_t i = 0; i < array_len(s); i++) {
+		check(array_key(s, i) == i && array_value(s, i) == i + 1, "entry %lld is %lld: %lld", (long long)i, (long long)array_key(s, i), (long long)array_value(s, i));
+	}
+	check(array_len(slice_array(a, 2, 2)) == 0, "empty slice is not empty");
+	array_append(s, 9);
+	check(array_find(s, 3) == 3 && array_len(a) == 5, "appending to a slice changes it or its array wrongly");
+}
+
+// Appending after a delete adds an entry under one more than the greatest
+// key, rather than replacing the entry keyed by the length.
+static void test_append_after_delete(void) {
+	array *a = alloc_array(KIND_WORD);
+	for (int64_t i = 0; i < 3; i++) {
+		array_append(a, 10 * (i + 1));
+	}
+	array_delete(a, 0);
+	array_append(a, 40);
+	check(array_len(a) == 3, "len is %lld, want 3", (long long)array_len(a));
+	int64_t keys[] = {1, 2, 3}, vals[] = {20, 30, 40};
+	for (int64_t i = 0; i < array_len(a) && i < 3; i++) {
+		check(array_key(a, i) == keys[i] && array_value(a, i) == vals[i], "entry %lld is %lld: %lld, want %lld: %lld",
+			(long long)i, (long long)array_key(a, i), (long long)array_value(a, i), (long long)keys[i], (long long)vals[i]);
+	}
+	array_set(a, 9, 90);
+	array_delete(a, 9);
+	array_append(a, 100);
+	check(array_find(a, 10) == 3, "appended value is at %lld, want 3", (long long)array_find(a, 10));
+	array *b = copy_array(a);
+	array_append(b, 110);
+	check(array_key(b, 4) == 11, "copy appends under %lld, want 11", (long long)array_key(b, 4));
+}
+
+int main(void) {
+	test_grow();
+	test_string_keys();
+	test_delete();
+	test_keys_of();
+	test_slice();
+	test_append_after_delete();
+	return failed;
+}
@@ -0,0 +1,92 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "arvo.h"
+
+static int failed;
+
+#define check(cond, ...) \
+	do { \
+		if (!(cond)) { \
+			fprintf(stderr, "%s:%d: ", __FILE__, __LINE__); \
+			fprintf(stderr, __VA_ARGS__); \
+			fputc('\n', stderr); \
+			failed = 1; \
+		} \
+	} while (0)
+
+typedef struct node {
+	struct node *next;
+	int64_t value;
+	char pad[48];
+} node;
+
+// Unreachable objects are reclaimed, so allocating far more than fits in
+// the heap at once keeps the heap bounded.
+static void test_reclaim(void) {
+	for (int i = 0; i < 200000; i++) {
+		char *p = gc_alloc(1024);
+		p[0] = 1;
+	}
+	gc_collect();
+	check(gc_heap_size() < 16 << 20, "heap holds %lld bytes after collection", (long long)gc_heap_size());
+}
+
+// Objects that are reachable from the stack, through other objects, survive
+// collections.
+static void test_reachable(void) {
+	node *list = NULL;
+	for (int i = 0; i < 10000; i++) {
+		node *n = gc_alloc(sizeof(node));
+		n->next = list;
+		n->value = i;
+		list = n;
+		gc_alloc(512); // garbage
+	}
+	gc_collect();
+	int64_t want = 9999;
+	for (node *n = list; n != NULL; n = n->next, want--) {
+		if (n->value != want) {
+			check(0, "node holds %lld, want %lld", (long long)n->value, (long long)want);
+			return;
+		}
+	}
+	check(want == -1, "list ends at %lld", (long long)want);
+}
+
+// Objects that are only referred to by a pointer into their interior survive
+// collections.
+static void test_interior(void) {
+	char *p = gc_alloc(100);
+	strcpy(p, "interior");
+	char *q = p + 3;
+	p = NULL;
+	for (int i = 0; i < 10000; i++) {
+		gc_alloc(1024);
+	}
+	gc_collect();
+	check(strcmp(q, "erior") == 0, "interior object holds %s", q);
+}
+
+static node *global;
+
+// Objects that are reachable from a registered root survive collections.
+static void test_root(void) {
+	gc_add_root((void **)&global);
+	global = gc_alloc(sizeof(node));
+	global->value = 42;
+	for (int i = 0; i < 10000; i++) {
+		gc_alloc(1024);
+	}
+	gc_collect();
+	check(global->value == 42, "root holds %lld", (long long)global->value);
+}
+
+int main(void) {
+	test_reclaim();
+	test_reachable();
+	test_interior();
+	test_root();
+	return failed;
+}
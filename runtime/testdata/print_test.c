@@ -0,0 +1,124 @@
+#include <math.h>
+#include <stdio.h>
+#include <string.h>
+
+#include "arvo.h"
+
+static int failed;
+
+// expect checks that formatting the arguments with kinds and format prints
+// want, which is what Go's fmt.Sprintf returns for them.
+static void expect(int line, const char *want, const char *kinds, const char *format, ...) {
+	FILE *f = tmpfile();
+	if (f == NULL) {
+		fprintf(stderr, "%s:%d: cannot create a temporary file\n", __FILE__, line);
+		failed = 1;
+		return;
+	}
+	va_list args;
+	va_start(args, format);
+	vfprint_format(f, kinds, format, args);
+	va_end(args);
+	char got[256] = {0};
+	rewind(f);
+	size_t n = fread(got, 1, sizeof(got) - 1, f);
+	fclose(f);
+	if (n != strlen(want) || memcmp(got, want, n) != 0) {
+		fprintf(stderr, "%s:%d: %s printed \"%s\", want \"%s\"\n", __FILE__, line, format, got, want);
+		failed = 1;
+	}
+}
+
+static void test_nums(void) {
+	expect(__LINE__, "42|  -42|7    |-0042|+3|-ff|FF|0xff|10|010|101|\xe4\xb8\x96", "nnnnnnnnnnnn",
+		"%d|%5d|%-5d|%05d|%+d|%x|%X|%#x|%o|%#o|%b|%c",
+		(int64_t)42, (int64_t)-42, (int64_t)7, (int64_t)-42, (int64_t)3, (int64_t)-255, (int64_t)255, (int64_t)255,
+		(int64_t)8, (int64_t)8, (int64_t)5, (int64_t)0x4e16);
+	expect(__LINE__, "007|     007|0x000000ff|-9223372036854775808|9223372036854775807", "nnnnn",
+		"%.3d|%08.3d|%#08x|%v|%d",
+		(int64_t)7, (int64_t)7, (int64_t)255, INT64_MIN, INT64_MAX);
+}
+
+// Floats print as the shortest decimal that reads back as the same float.
+static void test_floats(void) {
+	volatile double tenth = 0.1;
+	expect(__LINE__, "1.5 0.30000000000000004 1e+21 100 1e+06 1.23456789e+08 0.0001", "fffffff",
+		"%v %v %v %v %v %v %v", 1.5, tenth + 0.2, 1e21, 100.0, 1e6, 123456789.0, 0.0001);
+	expect(__LINE__, "1e-05 -0 3 1e-07 1E-07 0.3333333333333333", "ffffff",
+		"%v %v %v %g %G %v", 0.00001, -0.0, 3.0, 1e-7, 1e-7, 1.0 / 3);
+	expect(__LINE__, "1.500000|2.67|   3.142|-1.2    |-0003.50|1.234568e+05|1.230E-04|+2.5| 2.5", "fffffffff",
+		"%f|%.2f|%8.3f|%-8.1f|%08.2f|%e|%.3E|%+v|% v", 1.5, 2.675, 3.14159265358979, -1.25, -3.5, 123456.789, 0.000123, 2.5, 2.5);
+	expect(__LINE__, "+Inf -Inf   NaN 1e+301", "ffff", "%v %f %5v %v", INFINITY, -INFINITY, NAN, 1e301);
+	expect(__LINE__, "0x1.fep+07|-0X1.999999999999AP-04|0x1.55p-02|0x0p+00|0x1.7e43c8800759cp+996|0x1.8p+00", "ffffff",
+		"%x|%X|%.2x|%x|%x|%x", 255.0, -0.1, 1.0 / 3, 0.0, 1e300, 1.5);
+}
+
+static void test_strings_and_bools(void) {
+	expect(__LINE__, "h\xc3\xa9llo|    \xc3\xa9|ab   |h\xc3\xa9|x", "sssss",
+		"%s|%5s|%-5s|%.2s|%v", "h\xc3\xa9llo", "\xc3\xa9", "ab", "h\xc3\xa9llo", "x");
+	expect(__LINE__, "true false  true", "bbb", "%t %v %5t", 1, 0, 1);
+}
+
+// Strings are quoted, and written in hexadecimal, as Go's fmt does.
+static void test_quotes_and_hex(void) {
+	const char *s = "h\xc3\xa9\n\"`'\t";
+	expect(__LINE__, "\"h\xc3\xa9\\n\\\"`'\\t\"|\"h\\u00e9\\n\\\"`'\\t\"|\"h\xc3\xa9\"|000000\"ab\"|`ab`        |\"a\\x7fb\"", "ssssss",
+		"%q|%+q|%.2q|%010q|%#-12q|%#q", s, s, s, "ab", "ab", "a\x7f" "b");
+	expect(__LINE__, "\"\\xff\\x01\\x7f\\u0080\xf0\x9f\x98\x80\\ufeff\"|\"\\U0001f600\"|`\xc3\xa9`|\"\\u2028\\u00a0\"", "ssss",
+		"%q|%+q|%#q|%q", "\xff\x01\x7f\xc2\x80\xf0\x9f\x98\x80\xef\xbb\xbf", "\xf0\x9f\x98\x80", "\xc3\xa9", "\xe2\x80\xa8\xc2\xa0");
+	expect(__LINE__, "68c3a90a22602709|68 C3 A9|0x68 0xc3|0X68C3|00006162|   61 62|000x6162|6162    ||   61", "ssssssssss",
+		"%x|% .3X|%# .2x|%#.2X|%08x|% 8x|%#08x|%-8x|%.0x|%5.1x", s, s, s, s, "ab", "ab", "ab", "ab", "ab", "ab");
+}
+
+static string *str(const char *c) {
+	string *s = alloc_string();
+	init_c_str(s, c);
+	return s;
+}
+
+// Arrays, records and functions print as the interpreter prints them.
+static void test_aggregates(void) {
+	array *nums = alloc_array(KIND_WORD);
+	for (int64_t i = 0; i < 3; i++) {
+		array_set(nums, i, 10 * i);
+	}
+	array *names = alloc_array(KIND_STRING);
+	double half = 0.5;
+	int64_t bits;
+	memcpy(&bits, &half, sizeof(bits));
+	array_set(names, (int64_t)(intptr_t)str("it's"), bits);
+	array_set(names, (int64_t)(intptr_t)str("b"), 0);
+	array *nested = alloc_array(KIND_WORD);
+	array_set(nested, 1, (int64_t)(intptr_t)nums);
+	array_set(nested, 0, 0);
+	struct {
+		int64_t n;
+		unsigned char b;
+		double f;
+		string *s;
+		void *fn;
+	} rec = {7, 1, 2.5, str("x\n"), &rec};
+	expect(__LINE__, "a{0, 10, 20}|a{'it\\'s': 0.5, 'b': 0}|a{1: a{0, 10, 20}, 0: a{}}|r{n: 7, 'b': true, f: 2.5, 1: 'x\\n', g: fun}", "annasfanannr{1:nn3:'b'b1:ff1:1s1:gc}",
+		"%v|%s|%v|%v", nums, names, nested, (void *)&rec);
+	expect(__LINE__, "fun nil|%!d(string=a{})|    a{}|\"a{}\"|%!(EXTRA string=a{})", "ccannannannann",
+		"%v %v|%d|%7v|%q|", (void *)&rec, (void *)NULL, (array *)NULL, (array *)NULL, (array *)NULL, (array *)NULL);
+}
+
+// Mistakes in the format are reported in the output, as Go does.
+static void test_errors(void) {
+	expect(__LINE__, "%!d(string=str) %!s(int64=1)", "sn", "%d %s", "str", (int64_t)1);
+	expect(__LINE__, "1 %!d(MISSING)", "n", "%d %d", (int64_t)1);
+	expect(__LINE__, "1%!(EXTRA string=a, float64=2.5)", "nsf", "%d", (int64_t)1, "a", 2.5);
+	expect(__LINE__, "100% %!(NOVERB)", "", "100%% %");
+	expect(__LINE__, "    1|2  |2.2", "nnnnnf", "%*d|%-*d|%.*f", (int64_t)5, (int64_t)1, (int64_t)3, (int64_t)2, (int64_t)1, 2.25);
+}
+
+int main(void) {
+	test_nums();
+	test_floats();
+	test_strings_and_bools();
+	test_quotes_and_hex();
+	test_aggregates();
+	test_errors();
+	return failed;
+}
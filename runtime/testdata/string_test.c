@@ -0,0 +1,134 @@
+#include <stdio.h>
+#include <string.h>
+
+#include "arvo.h"
+
+static int failed;
+
+#define check(cond, ...) \
+	do { \
+		if (!(cond)) { \
+			fprintf(stderr, "%s:%d: ", __FILE__, __LINE__); \
+			fprintf(stderr, __VA_ARGS__); \
+			fputc('\n', stderr); \
+			failed = 1; \
+		} \
+	} while (0)
+
+static string *str(const char *c) {
+	string *s = alloc_string();
+	init_c_str(s, c);
+	return s;
+}
+
+// A null string and a newly allocated string are both empty.
+static void test_empty(void) {
+	string *s = alloc_string();
+	check(strcmp(c_str(s), "") == 0, "c_str of new string is %s", c_str(s));
+	check(strcmp(c_str(NULL), "") == 0, "c_str of null string is %s", c_str(NULL));
+	check(rune_count(NULL) == 0, "null string has %lld runes", (long long)rune_count(NULL));
+	check(compare_strings(s, NULL) == 0, "new string and null string differ");
+	check(strcmp(c_str(str("")), "") == 0, "c_str of \"\" is %s", c_str(str("")));
+}
+
+// Runes are counted and indexed by their UTF-8 encoding.
+static void test_runes(void) {
+	string *s = str("h\xc3\xa9llo, \xe4\xb8\x96\xe7\x95\x8c \xf0\x9f\x98\x80");
+	check(rune_count(s) == 11, "rune_count is %lld, want 11", (long long)rune_count(s));
+	rune want[] = {'h', 0xE9, 'l', 'l', 'o', ',', ' ', 0x4E16, 0x754C, ' ', 0x1F600};
+	for (int i = 0; i < 11; i++) {
+		rune r = index_rune(s, i);
+		check(r == want[i], "index_rune(%d) is U+%04X, want U+%04X", i, (unsigned)r, (unsigned)want[i]);
+	}
+}
+
+// Each byte of an invalid encoding is a single U+FFFD.
+static void test_invalid(void) {
+	const char *cases[] = {
+		"\xff",             // invalid byte
+		"\xc3",             // truncated
+		"\xc0\x80",         // overlong
+		"\xed\xa0\x80",     // surrogate
+		"\xf4\x90\x80\x80", // too large
+	};
+	for (size_t i = 0; i < sizeof(cases) / sizeof(*cases); i++) {
+		string *s = str(cases[i]);
+		int64_t n = strlen(cases[i]);
+		check(rune_count(s) == n, "case %zu: rune_count is %lld, want %lld", i, (long long)rune_count(s), (long long)n);
+		for (int64_t j = 0; j < n; j++) {
+			check(index_rune(s, j) == 0xFFFD, "case %zu: index_rune(%lld) is U+%04X", i, (long long)j, (unsigned)index_rune(s, j));
+		}
+	}
+}
+
+// Assigning a rune may change the length of its encoding.
+static void test_assign(void) {
+	string *s = str("a\xc3\xa9z");
+	check(assign_string(s, 1, str("e")) == 0, "assign_string(1) failed");
+	check(strcmp(c_str(s), "aez") == 0, "got %s, want aez", c_str(s));
+	check(assign_string(s, 0, str("\xe4\xb8\x96")) == 0, "assign_string(0) failed");
+	check(strcmp(c_str(s), "\xe4\xb8\x96" "ez") == 0, "got %s", c_str(s));
+	check(rune_count(s) == 3, "rune_count is %lld, want 3", (long long)rune_count(s));
+	check(assign_string(s, 3, str("x")) == -1, "assign_string past the end succeeded");
+	check(assign_string(s, -1, str("x")) == -1, "assign_string(-1) succeeded");
+}
+
+static void test_compare(void) {
+	struct {
+		const char *x, *y;
+		int want;
+	} cases[] = {
+		{"", "", 0},
+		{"", "a", -1},
+		{"a", "", 1},
+		{"abc", "abc", 0},
+		{"abc", "abd", -1},
+		{"ab", "abc", -1},
+		{"\xc3\xa9", "z", 1},
+	};
+	for (size_t i = 0; i < sizeof(cases) / sizeof(*cases); i++) {
+		int got = compare_strings(str(cases[i].x), str(cases[i].y));
+		check(got == cases[i].want, "compare_strings(%s, %s) is %d, want %d", cases[i].x, cases[i].y, got, cases[i].want);
+	}
+}
+
+static void test_concat(void) {
+	string *s = concat_strings(str("h\xc3\xa9"), str("llo"));
+	check(strcmp(c_str(s), "h\xc3\xa9llo") == 0, "got %s", c_str(s));
+	check(rune_count(s) == 5, "rune_count is %lld, want 5", (long long)rune_count(s));
+	s = concat_strings(NULL, str("x"));
+	check(strcmp(c_str(s), "x") == 0, "got %s, want x", c_str(s));
+	s = concat_strings(NULL, NULL);
+	check(strcmp(c_str(s), "") == 0, "got %s, want empty", c_str(s));
+}
+
+static void test_slice(void) {
+	string *s = str("h\xc3\xa9llo");
+	check(strcmp(c_str(slice_string(s, 1, 3)), "\xc3\xa9l") == 0, "got %s", c_str(slice_string(s, 1, 3)));
+	check(strcmp(c_str(slice_string(s, 5, 5)), "") == 0, "got %s, want empty", c_str(slice_string(s, 5, 5)));
+	check(strcmp(c_str(rune_string(0x1F600)), "\xf0\x9f\x98\x80") == 0, "rune_string encodes U+1F600 as %s", c_str(rune_string(0x1F600)));
+	check(strcmp(c_str(rune_string(0xD800)), "\xef\xbf\xbd") == 0, "rune_string encodes a surrogate as %s", c_str(rune_string(0xD800)));
+}
+
+// Strings survive collections while they are reachable.
+static void test_collect(void) {
+	string *s = str("kept");
+	for (int i = 0; i < 100000; i++) {
+		s = concat_strings(s, NULL);
+		str("garbage");
+	}
+	gc_collect();
+	check(strcmp(c_str(s), "kept") == 0, "got %s, want kept", c_str(s));
+}
+
+int main(void) {
+	test_empty();
+	test_runes();
+	test_invalid();
+	test_assign();
+	test_compare();
+	test_concat();
+	test_slice();
+	test_collect();
+	return failed;
+}
@@ -0,0 +1,156 @@
+package scan
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Pos is a compact encoding of a source position. It is the base of the
+// file that contains the position, plus the byte offset of the position
+// within that file. The zero value is NoPos, and is never a valid position.
+type Pos int
+
+const NoPos Pos = 0
+
+func (p Pos) IsValid() bool {
+	return p != NoPos
+}
+
+// Position is the expanded form of a Pos. As with tokens, Line is 1-indexed
+// and Column is the 0-indexed byte offset from the beginning of the line.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (pos Position) IsValid() bool {
+	return pos.Line > 0
+}
+
+func (pos Position) String() string {
+	s := pos.Filename
+	if pos.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// A File records the name, size and line offsets of a source file belonging
+// to a FileSet.
+type File struct {
+	name  string
+	base  int
+	size  int
+	lines []int // offset of the first byte of each line
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Base() int {
+	return f.base
+}
+
+func (f *File) Size() int {
+	return f.size
+}
+
+func (f *File) LineCount() int {
+	return len(f.lines)
+}
+
+// AddLine records the offset at which a new line begins. Offsets that do
+// not lie beyond the last recorded line are ignored.
+func (f *File) AddLine(offset int) {
+	if n := len(f.lines); offset > f.lines[n-1] && offset <= f.size {
+		f.lines = append(f.lines, offset)
+	}
+}
+
+// Pos returns the Pos of the byte at offset in f.
+func (f *File) Pos(offset int) Pos {
+	if offset < 0 || offset > f.size {
+		panic("illegal file offset")
+	}
+	return Pos(f.base + offset)
+}
+
+// Offset returns the offset in f of p.
+func (f *File) Offset(p Pos) int {
+	if int(p) < f.base || int(p) > f.base+f.size {
+		panic("illegal Pos value")
+	}
+	return int(p) - f.base
+}
+
+// Position returns the expanded form of p, which must belong to f.
+func (f *File) Position(p Pos) Position {
+	if !p.IsValid() {
+		return Position{}
+	}
+	offset := f.Offset(p)
+	i := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	return Position{
+		Filename: f.name,
+		Offset:   offset,
+		Line:     i + 1,
+		Column:   offset - f.lines[i],
+	}
+}
+
+// A FileSet is a registry of source files. Each file occupies a distinct
+// range of positions, so that a single Pos identifies both a file and an
+// offset within it.
+type FileSet struct {
+	base  int
+	files []*File
+}
+
+func NewFileSet() *FileSet {
+	return &FileSet{base: 1}
+}
+
+// Base returns the base that the next file added to s will have.
+func (s *FileSet) Base() int {
+	return s.base
+}
+
+// AddFile registers a file with the given name and size in bytes.
+func (s *FileSet) AddFile(filename string, size int) *File {
+	if size < 0 {
+		panic("illegal file size")
+	}
+	f := &File{name: filename, base: s.base, size: size, lines: []int{0}}
+	// Leave room for the position just past the end of the file.
+	s.base += size + 1
+	s.files = append(s.files, f)
+	return f
+}
+
+// File returns the file that contains p, or nil if there is none.
+func (s *FileSet) File(p Pos) *File {
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].base > int(p) }) - 1
+	if i >= 0 {
+		if f := s.files[i]; int(p) <= f.base+f.size {
+			return f
+		}
+	}
+	return nil
+}
+
+// Position returns the expanded form of p.
+func (s *FileSet) Position(p Pos) Position {
+	if f := s.File(p); f != nil {
+		return f.Position(p)
+	}
+	return Position{}
+}
@@ -12,6 +12,10 @@ type TokenScanner interface {
 	Scan() Token
 }
 
+// An ErrorHandler is called with the position and message of each error
+// that a Scanner encounters.
+type ErrorHandler func(pos Position, msg string)
+
 type Scanner struct {
 	input  io.ByteReader
 	buf    []byte // buffer to slice tokens
@@ -25,7 +29,17 @@ type Scanner struct {
 	curr   rune   // recently read rune
 	bk     bool   // if the last pos update was a backup
 	tok    *Token
-	prev   *Token
+	prev   *Token // last token that was not a comment
+	queued *Token // token to return after tok
+	file   *File  // file in which tokens are positioned; may be nil
+	err    ErrorHandler
+
+	// position of the token being scanned
+	startOffset int
+	startLine   int
+	startColumn int
+
+	ErrorCount int // number of errors encountered
 }
 
 const eof = -1
@@ -64,6 +78,9 @@ func (s *Scanner) next() rune {
 		s.line++
 		s.lo1 = s.lo2
 		s.lo2 = s.offset
+		if s.file != nil {
+			s.file.AddLine(s.offset)
+		}
 	}
 	s.width = size
 	s.pos += s.width
@@ -86,7 +103,7 @@ func (s *Scanner) backup() {
 	}
 	s.pos -= s.width
 	s.offset -= s.width
-	s.curr, _ = utf8.DecodeRune(s.buf[:s.pos])
+	s.curr, _ = utf8.DecodeLastRune(s.buf[:s.pos])
 	s.bk = true
 }
 
@@ -103,6 +120,7 @@ func (s *Scanner) emitType(t Type) {
 		Line:   s.line,
 		Column: s.offset - l - s.lo2,
 		Lit:    ts,
+		Pos:    s.at(s.offset - l),
 	}
 	s.buf = s.buf[s.pos:]
 	s.start = 0
@@ -118,6 +136,7 @@ func (s *Scanner) emit(t Type, ts string) {
 		Line:   s.line,
 		Column: s.offset - l - s.lo2,
 		Lit:    ts,
+		Pos:    s.at(s.offset - l),
 	}
 	s.buf = s.buf[s.pos:]
 	s.start = 0
@@ -125,8 +144,31 @@ func (s *Scanner) emit(t Type, ts string) {
 	s.width = 0
 }
 
-func (s *Scanner) errorf(format string, args ...interface{}) {
-	s.emit(Illegal, fmt.Sprintf(format, args...))
+// errorAt reports msg at the given position and counts the error.
+func (s *Scanner) errorAt(offset, line, column int, msg string) {
+	if s.err != nil {
+		pos := Position{Offset: offset, Line: line, Column: column}
+		if s.file != nil {
+			pos.Filename = s.file.Name()
+		}
+		s.err(pos, msg)
+	}
+	s.ErrorCount++
+}
+
+// error reports msg at the start of the token being scanned.
+func (s *Scanner) error(msg string) {
+	s.errorAt(s.startOffset, s.startLine, s.startColumn, msg)
+}
+
+// errorAtOffset reports msg at offset, which is on the current line.
+func (s *Scanner) errorAtOffset(offset int, msg string) {
+	s.errorAt(offset, s.line, offset-s.lo2, msg)
+}
+
+// errorHere reports msg at the last rune read.
+func (s *Scanner) errorHere(msg string) {
+	s.errorAtOffset(s.offset-s.width, msg)
 }
 
 func (s *Scanner) accept(valid string) bool {
@@ -143,15 +185,35 @@ func (s *Scanner) acceptRun(valid string) {
 	s.backup()
 }
 
-func New(r io.ByteReader) *Scanner {
+// New returns a Scanner that reads its input from r. If err is not nil, it
+// is called for each error in the input. The scanner continues past
+// errors: a malformed literal is scanned as a token of its kind, and an
+// illegal character as an Illegal token whose Lit is the character.
+func New(r io.ByteReader, err ErrorHandler) *Scanner {
 	s := &Scanner{
 		input: r,
 		buf:   make([]byte, 0, 1024),
 		line:  1,
+		err:   err,
 	}
 	return s
 }
 
+// NewFile is like New, but positions the tokens that it scans within file.
+func NewFile(file *File, r io.ByteReader, err ErrorHandler) *Scanner {
+	s := New(r, err)
+	s.file = file
+	return s
+}
+
+// at returns the Pos of offset, or NoPos if the scanner has no file.
+func (s *Scanner) at(offset int) Pos {
+	if s.file == nil {
+		return NoPos
+	}
+	return s.file.Pos(offset)
+}
+
 func isLetter(ch rune) bool {
 	return unicode.IsLetter(ch) || ch == '_'
 }
@@ -197,84 +259,175 @@ func digitVal(ch rune) int {
 	return 16 // larger than any legal digit val
 }
 
-func (s *Scanner) scanMantissa(base int) {
-	ch := s.next()
-	for digitVal(ch) < base {
-		ch = s.next()
-	}
-	s.backup()
-}
+func lower(ch rune) rune { return ('a' - 'A') | ch }
 
-// '.', 'e', 'E' passed in
-func (s *Scanner) floating(ch rune) {
-	if ch == '.' {
-		s.scanMantissa(10)
-	}
-	if ch == 'e' || ch == 'E' {
-		s.accept("-+")
-		if ch = s.next(); digitVal(ch) < 10 {
-			s.scanMantissa(10)
+// digits consumes the digits of a literal in the given base, and any '_'
+// separators among them. It returns a set of bits: bit 0 is set if a digit
+// was consumed, and bit 1 if a separator was. A base of at most 10 accepts
+// every decimal digit, and invalid, if not nil, records the offset of the
+// first that is not valid in the base.
+func (s *Scanner) digits(base int, invalid *int) (digsep int) {
+	for {
+		ch := s.peek()
+		if ch == '_' {
+			digsep |= 2
+		} else if base <= 10 && isDecimalDigit(ch) || base > 10 && isHexDigit(ch) {
+			if digitVal(ch) >= base && invalid != nil && *invalid < 0 {
+				*invalid = s.offset
+			}
+			digsep |= 1
 		} else {
-			s.errorf("%v illegal floating-point exponent", s.line)
-			return
+			return digsep
 		}
+		s.next()
 	}
-	s.emitType(Float)
 }
 
-// first decimal digit is passed in
+// litname returns the name of literals with the given prefix.
+func litname(prefix rune) string {
+	switch prefix {
+	case 'x':
+		return "hexadecimal literal"
+	case 'o', '0':
+		return "octal literal"
+	case 'b':
+		return "binary literal"
+	}
+	return "decimal literal"
+}
+
+// number scans a number literal whose first character, a decimal digit or
+// a '.' followed by one, has been consumed. A malformed literal is still
+// scanned as a number, once its errors are reported.
 func (s *Scanner) number(ch rune) {
-	// must be octal, hex or float
-	if ch == '0' {
-		if s.accept("xX") {
-			// must be a hex
-			pos := s.pos
-			s.scanMantissa(16)
-			if s.pos == pos {
-				// only scanned "0x" or "0X"
-				s.errorf("%v illegal hexadecimal number", s.line)
-				return
+	typ := Int
+	base := 10        // number base
+	prefix := rune(0) // one of 0 (decimal), '0' (0-octal), 'x', 'o', or 'b'
+	digsep := 0       // bit 0: digit present, bit 1: '_' present
+	invalid := -1     // offset of the first invalid digit, or < 0
+
+	// integer part
+	if ch != '.' {
+		digsep = 1 // the first digit
+		if ch == '0' {
+			switch lower(s.peek()) {
+			case 'x':
+				base, prefix = 16, 'x'
+			case 'o':
+				base, prefix = 8, 'o'
+			case 'b':
+				base, prefix = 2, 'b'
+			default:
+				base, prefix = 8, '0'
 			}
-		} else {
-			// octal int or float
-			mustBeFloat := false
-			s.scanMantissa(8)
-			ch = s.next()
-			if ch == '8' || ch == '9' {
-				// float or illegal octal int
-				mustBeFloat = true
-				s.scanMantissa(10)
+			if prefix != '0' {
+				s.next()
+				digsep = 0
 			}
-			if ch == '.' || ch == 'e' || ch == 'E' {
-				s.floating(rune(s.buf[s.pos-1]))
-				return
+		}
+		digsep |= s.digits(base, &invalid)
+		if s.peek() == '.' {
+			ch = s.next()
+		}
+	}
+
+	// fractional part
+	if ch == '.' {
+		typ = Float
+		if prefix == 'o' || prefix == 'b' {
+			s.errorAtOffset(s.offset-1, "invalid radix point in "+litname(prefix))
+		}
+		digsep |= s.digits(base, &invalid)
+	}
+
+	if digsep&1 == 0 {
+		s.errorAtOffset(s.offset, litname(prefix)+" has no digits")
+	}
+
+	// exponent
+	if e := lower(s.peek()); e == 'e' || e == 'p' {
+		switch {
+		case e == 'e' && prefix != 0 && prefix != '0':
+			s.errorAtOffset(s.offset, fmt.Sprintf("%q exponent requires decimal mantissa", s.peek()))
+		case e == 'p' && prefix != 'x':
+			s.errorAtOffset(s.offset, fmt.Sprintf("%q exponent requires hexadecimal mantissa", s.peek()))
+		}
+		s.next()
+		typ = Float
+		if c := s.peek(); c == '+' || c == '-' {
+			s.next()
+		}
+		ds := s.digits(10, nil)
+		digsep |= ds
+		if ds&1 == 0 {
+			s.errorAtOffset(s.offset, "exponent has no digits")
+		}
+	} else if prefix == 'x' && typ == Float {
+		s.errorAtOffset(s.offset, "hexadecimal mantissa requires a 'p' exponent")
+	}
+
+	lit := string(s.buf[s.start:s.pos])
+	if typ == Int && invalid >= 0 {
+		s.errorAtOffset(invalid, fmt.Sprintf("invalid digit %q in %s", lit[invalid-s.startOffset], litname(prefix)))
+	}
+	if digsep&2 != 0 {
+		if i := invalidSep(lit); i >= 0 {
+			s.errorAtOffset(s.startOffset+i, "'_' must separate successive digits")
+		}
+	}
+	s.emitType(typ)
+}
+
+// invalidSep returns the index of the first invalid separator in x, or -1.
+func invalidSep(x string) int {
+	x1 := ' ' // prefix char, we only care if it's 'x'
+	d := '.'  // digit, one of '_', '0' (a digit), or '.' (anything else)
+	i := 0
+
+	// a prefix counts as a digit
+	if len(x) >= 2 && x[0] == '0' {
+		x1 = lower(rune(x[1]))
+		if x1 == 'x' || x1 == 'o' || x1 == 'b' {
+			d = '0'
+			i = 2
+		}
+	}
+
+	// mantissa and exponent
+	for ; i < len(x); i++ {
+		p := d // previous digit
+		d = rune(x[i])
+		switch {
+		case d == '_':
+			if p != '0' {
+				return i
 			}
-			if mustBeFloat {
-				s.errorf("%v illegal octal number", s.line)
-				return
+		case isDecimalDigit(d) || x1 == 'x' && isHexDigit(d):
+			d = '0'
+		default:
+			if p == '_' {
+				return i - 1
 			}
-			s.backup()
-		}
-	} else {
-		// decimal int or float
-		s.scanMantissa(10)
-		if s.accept(".eE") {
-			s.floating(rune(s.buf[s.pos-1]))
-			return
+			d = '.'
 		}
 	}
-	s.emitType(Int)
+	if d == '_' {
+		return len(x) - 1
+	}
+	return -1
 }
 
-// Assumes the initial '\' has already been read.
-func (s *Scanner) scanEscape(quote rune) error {
+// Assumes the initial '\' has already been read. It reports whether the
+// escape sequence is valid. A character that ends the string or line is
+// not consumed by an invalid sequence.
+func (s *Scanner) scanEscape(quote rune) bool {
 	var n int
 	var base, max uint32
 
 	ch := s.next()
 	switch ch {
 	case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\', quote:
-		return nil
+		return true
 	case '0', '1', '2', '3', '4', '5', '6', '7':
 		n, base, max = 3, 8, 255
 	case 'x':
@@ -291,7 +444,8 @@ func (s *Scanner) scanEscape(quote rune) error {
 		if ch < 0 {
 			msg = "escape sequence not terminated"
 		}
-		return fmt.Errorf("%v %s", s.line, msg)
+		s.escapeError(ch, msg)
+		return false
 	}
 
 	var x uint32
@@ -302,7 +456,8 @@ func (s *Scanner) scanEscape(quote rune) error {
 			if ch < 0 {
 				msg = "escape sequence not terminated"
 			}
-			return fmt.Errorf("%v %s", s.line, msg)
+			s.escapeError(ch, msg)
+			return false
 		}
 		x = x*base + d
 		n--
@@ -313,26 +468,35 @@ func (s *Scanner) scanEscape(quote rune) error {
 	}
 
 	if x > max || 0xD800 <= x && x < 0xE000 {
-		return fmt.Errorf("%v escape sequence is invalid Unicode code point", s.line)
+		s.errorHere("escape sequence is invalid Unicode code point")
+		return false
+	}
+	return true
+}
+
+// escapeError reports msg at ch, the character that ends an invalid escape
+// sequence, and backs up if ch ends the string.
+func (s *Scanner) escapeError(ch rune, msg string) {
+	s.errorHere(msg)
+	if ch == '\'' || ch == '\n' || ch < 0 {
+		s.backup()
 	}
-	return nil
 }
 
 func (s *Scanner) scanString() {
 	for {
 		ch := s.next()
 		if ch == '\n' || ch < 0 {
-			s.errorf("%v string literal not terminated", s.line)
-			return
+			// the newline ends the line, not the string
+			s.backup()
+			s.error("string literal not terminated")
+			break
 		}
 		if ch == '\'' {
 			break
 		}
 		if ch == '\\' {
-			if err := s.scanEscape('\''); err != nil {
-				s.errorf(err.Error())
-				return
-			}
+			s.scanEscape('\'')
 		}
 	}
 	s.emitType(String)
@@ -348,8 +512,8 @@ func (s *Scanner) scanRawString() {
 			lo = s.lo2
 		}
 		if ch < 0 {
-			s.errorf("%v raw string literal not terminated", s.line)
-			return
+			s.error("raw string literal not terminated")
+			break
 		}
 		if ch == '`' {
 			break
@@ -373,6 +537,7 @@ func (s *Scanner) scanRawString() {
 		Line:   s.line - nl,
 		Column: lo,
 		Lit:    string(tb),
+		Pos:    s.at(s.offset - len(tb)),
 	}
 	s.buf = s.buf[s.pos:]
 	s.start = 0
@@ -393,13 +558,15 @@ func stripCR(b []byte) []byte {
 }
 
 func (s *Scanner) scanComment(ch rune) {
-	hasCR := false
+	line, col := s.line, s.offset-len(s.buf[s.start:s.pos])-s.lo2
+	hasCR, hasNL := false, false
 	if ch == '/' {
 		//-style comment
 		// scan until newline or eof
 		for {
 			ch = s.next()
 			if ch == '\n' || ch < 0 {
+				hasNL = true
 				break
 			}
 			if ch == '\r' {
@@ -413,12 +580,15 @@ func (s *Scanner) scanComment(ch rune) {
 		ch = s.next()
 		for {
 			if ch < 0 {
-				s.errorf("%v comment not terminated", s.line)
-				return
+				s.error("comment not terminated")
+				break
 			}
 			if ch == '\r' {
 				hasCR = true
 			}
+			if ch == '\n' {
+				hasNL = true
+			}
 			c := s.next()
 			if ch == '*' && c == '/' {
 				break
@@ -427,10 +597,22 @@ func (s *Scanner) scanComment(ch rune) {
 		}
 	}
 	tb := s.buf[s.start:s.pos]
+	if ch == '\n' {
+		// the newline ends the line, not the comment
+		tb = tb[:len(tb)-1]
+	}
 	if hasCR {
 		tb = stripCR(tb)
 	}
-	s.emitType(Comment)
+	s.emit(Comment, string(tb))
+	s.tok.Line, s.tok.Column = line, col
+	if hasNL && s.needSemi() {
+		// A comment that runs to the end of the line terminates the
+		// statement before it, so the semicolon comes first.
+		c := *s.tok
+		s.queued = s.tok
+		s.tok = &Token{Type: Semicolon, Offset: c.Offset, Line: c.Line, Column: c.Column, Lit: "\n", Pos: c.Pos}
+	}
 }
 
 func (s *Scanner) switch2(t1, t2 Type) {
@@ -455,20 +637,31 @@ func (s *Scanner) switch3(t1, t2 Type, ch rune, t3 Type) {
 	}
 }
 
-func (s *Scanner) insertSemi() bool {
+func (s *Scanner) needSemi() bool {
 	if s.prev != nil {
 		switch s.prev.Type {
 		case Ident, Int, Float, String, Break, Continue, Return, Inc, Dec, Rparen, Rbrack, Rbrace:
-			s.emitType(Semicolon)
 			return true
 		}
 	}
 	return false
 }
 
+func (s *Scanner) insertSemi() bool {
+	if s.needSemi() {
+		s.emitType(Semicolon)
+		return true
+	}
+	return false
+}
+
 func (s *Scanner) Scan() Token {
+	s.tok, s.queued = s.queued, nil
 	for s.tok == nil {
 		ch := s.next()
+		s.startOffset = s.offset - s.width
+		s.startLine = s.line
+		s.startColumn = s.startOffset - s.lo2
 		switch {
 		case isLetter(ch):
 			s.identifier()
@@ -494,7 +687,7 @@ func (s *Scanner) Scan() Token {
 			case '.':
 				c := s.peek()
 				if isDecimalDigit(c) {
-					s.floating(ch)
+					s.number(ch)
 				} else if c == '.' {
 					s.next()
 					c = s.next()
@@ -595,12 +788,15 @@ func (s *Scanner) Scan() Token {
 			case '|':
 				s.switch3(Or, OrAssign, '|', Lor)
 			default:
-				s.errorf("illegal character %#U", ch)
+				s.error(fmt.Sprintf("illegal character %#U", ch))
+				s.emitType(Illegal)
 			}
 		}
 	}
 	t := *s.tok
-	s.prev = s.tok
+	if t.Type != Comment {
+		s.prev = s.tok
+	}
 	s.tok = nil
 	return t
 }
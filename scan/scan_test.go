@@ -1,6 +1,7 @@
 package scan
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -14,73 +15,115 @@ var cases = []struct {
 	{
 		input: `a b c`,
 		want: []Token{
-			{Ident, 0, 1, 0, "a"},
-			{Ident, 2, 1, 2, "b"},
-			{Ident, 4, 1, 4, "c"},
-			{Semicolon, 5, 1, 5, ""},
+			{Ident, 0, 1, 0, "a", NoPos},
+			{Ident, 2, 1, 2, "b", NoPos},
+			{Ident, 4, 1, 4, "c", NoPos},
+			{Semicolon, 5, 1, 5, "", NoPos},
 		},
 	},
 
 	{
 		input: `fun x`,
 		want: []Token{
-			{Fun, 0, 1, 0, "fun"},
-			{Ident, 4, 1, 4, "x"},
-			{Semicolon, 5, 1, 5, ""},
+			{Fun, 0, 1, 0, "fun", NoPos},
+			{Ident, 4, 1, 4, "x", NoPos},
+			{Semicolon, 5, 1, 5, "", NoPos},
 		},
 	},
 
 	{
 		input: `'a' '\t' '\xFF'`,
 		want: []Token{
-			{String, 0, 1, 0, `'a'`},
-			{String, 4, 1, 4, `'\t'`},
-			{String, 9, 1, 9, `'\xFF'`},
-			{Semicolon, 15, 1, 15, ""},
+			{String, 0, 1, 0, `'a'`, NoPos},
+			{String, 4, 1, 4, `'\t'`, NoPos},
+			{String, 9, 1, 9, `'\xFF'`, NoPos},
+			{Semicolon, 15, 1, 15, "", NoPos},
 		},
 	},
 
 	{
 		input: "a\nb",
 		want: []Token{
-			{Ident, 0, 1, 0, "a"},
-			{Semicolon, 1, 1, 1, "\n"},
-			{Ident, 2, 2, 0, "b"},
-			{Semicolon, 3, 2, 1, ""},
+			{Ident, 0, 1, 0, "a", NoPos},
+			{Semicolon, 1, 1, 1, "\n", NoPos},
+			{Ident, 2, 2, 0, "b", NoPos},
+			{Semicolon, 3, 2, 1, "", NoPos},
 		},
 	},
 
 	{
 		input: "`ab\ncd`",
 		want: []Token{
-			{String, 0, 1, 0, "`ab\ncd`"},
-			{Semicolon, 7, 2, 3, ""},
+			{String, 0, 1, 0, "`ab\ncd`", NoPos},
+			{Semicolon, 7, 2, 3, "", NoPos},
 		},
 	},
 
 	{
 		input: "12345 123.45 123e45",
 		want: []Token{
-			{Int, 0, 1, 0, "12345"},
-			{Float, 6, 1, 6, "123.45"},
-			{Float, 13, 1, 13, "123e45"},
-			{Semicolon, 19, 1, 19, ""},
+			{Int, 0, 1, 0, "12345", NoPos},
+			{Float, 6, 1, 6, "123.45", NoPos},
+			{Float, 13, 1, 13, "123e45", NoPos},
+			{Semicolon, 19, 1, 19, "", NoPos},
+		},
+	},
+
+	{
+		input: "0b1010 0o17 017 0X1F 1_000_000 0x_ff 0x1p-2 0x1.8P+3 0x.8p0 1_0.2_5e1_0",
+		want: []Token{
+			{Int, 0, 1, 0, "0b1010", NoPos},
+			{Int, 7, 1, 7, "0o17", NoPos},
+			{Int, 12, 1, 12, "017", NoPos},
+			{Int, 16, 1, 16, "0X1F", NoPos},
+			{Int, 21, 1, 21, "1_000_000", NoPos},
+			{Int, 31, 1, 31, "0x_ff", NoPos},
+			{Float, 37, 1, 37, "0x1p-2", NoPos},
+			{Float, 44, 1, 44, "0x1.8P+3", NoPos},
+			{Float, 53, 1, 53, "0x.8p0", NoPos},
+			{Float, 60, 1, 60, "1_0.2_5e1_0", NoPos},
+			{Semicolon, 71, 1, 71, "", NoPos},
+		},
+	},
+
+	{
+		input: "1.5e-3 .25 089.5 0.",
+		want: []Token{
+			{Float, 0, 1, 0, "1.5e-3", NoPos},
+			{Float, 7, 1, 7, ".25", NoPos},
+			{Float, 11, 1, 11, "089.5", NoPos},
+			{Float, 17, 1, 17, "0.", NoPos},
+			{Semicolon, 19, 1, 19, "", NoPos},
 		},
 	},
 
 	{
 		input: `'abcd' '\t \n\''`,
 		want: []Token{
-			{String, 0, 1, 0, `'abcd'`},
-			{String, 7, 1, 7, `'\t \n\''`},
-			{Semicolon, 16, 1, 16, ""},
+			{String, 0, 1, 0, `'abcd'`, NoPos},
+			{String, 7, 1, 7, `'\t \n\''`, NoPos},
+			{Semicolon, 16, 1, 16, "", NoPos},
+		},
+	},
+
+	{
+		input: "a // c\nb /* d\n */ c",
+		want: []Token{
+			{Ident, 0, 1, 0, "a", NoPos},
+			{Semicolon, 2, 1, 2, "\n", NoPos},
+			{Comment, 2, 1, 2, "// c", NoPos},
+			{Ident, 7, 2, 0, "b", NoPos},
+			{Semicolon, 9, 2, 2, "\n", NoPos},
+			{Comment, 9, 2, 2, "/* d\n */", NoPos},
+			{Ident, 18, 3, 4, "c", NoPos},
+			{Semicolon, 19, 3, 5, "", NoPos},
 		},
 	},
 }
 
 func TestScan(t *testing.T) {
 	for i, tc := range cases {
-		sc := New(strings.NewReader(tc.input))
+		sc := New(strings.NewReader(tc.input), nil)
 		n := len(tc.want)
 		j := 0
 		for {
@@ -105,3 +148,73 @@ func TestScan(t *testing.T) {
 		}
 	}
 }
+
+func TestFileSet(t *testing.T) {
+	fset := NewFileSet()
+	srcs := []string{"a\nbc\n\nd", "e f"}
+	var toks [][]Token
+	for i, src := range srcs {
+		file := fset.AddFile(string('x'+rune(i)), len(src))
+		sc := NewFile(file, strings.NewReader(src), nil)
+		var list []Token
+		for tok := sc.Scan(); tok.Type != EOF; tok = sc.Scan() {
+			list = append(list, tok)
+		}
+		toks = append(toks, list)
+	}
+	for i, list := range toks {
+		for _, tok := range list {
+			pos := fset.Position(tok.Pos)
+			if pos.Filename != string('x'+rune(i)) || pos.Offset != tok.Offset ||
+				pos.Line != tok.Line || pos.Column != tok.Column {
+				t.Errorf("token %v, got position %v", tok, pos)
+			}
+		}
+	}
+	if p := fset.Position(toks[0][2].Pos); p.String() != "x:2:0" {
+		t.Errorf("got %v, want x:2:0", p)
+	}
+	if f := fset.File(NoPos); f != nil {
+		t.Errorf("NoPos belongs to file %s", f.Name())
+	}
+}
+
+var errorCases = []struct {
+	input string
+	toks  []Type   // types of the tokens, up to EOF
+	lits  []string // literals of the tokens
+	errs  []string // errors, as "line:column: msg"
+}{
+	{"a # b", []Type{Ident, Illegal, Ident, Semicolon}, []string{"a", "#", "b", ""}, []string{"1:2: illegal character U+0023 '#'"}},
+	{"x = 'ab\ny", []Type{Ident, Assign, String, Semicolon, Ident, Semicolon}, []string{"x", "=", "'ab", "\n", "y", ""}, []string{"1:4: string literal not terminated"}},
+	{`'a\qb' 'c\x4'`, []Type{String, String, Semicolon}, []string{`'a\qb'`, `'c\x4'`, ""}, []string{"1:3: unknown escape sequence", "1:12: illegal character U+0027 ''' in escape sequence"}},
+	{"0x 1e+ 09", []Type{Int, Float, Int, Semicolon}, []string{"0x", "1e+", "09", ""}, []string{"1:2: hexadecimal literal has no digits", "1:6: exponent has no digits", "1:8: invalid digit '9' in octal literal"}},
+	{"0b102 0o1.5 0x1.8 0b1e3", []Type{Int, Float, Float, Float, Semicolon}, []string{"0b102", "0o1.5", "0x1.8", "0b1e3", ""}, []string{"1:4: invalid digit '2' in binary literal", "1:9: invalid radix point in octal literal", "1:17: hexadecimal mantissa requires a 'p' exponent", "1:21: 'e' exponent requires decimal mantissa"}},
+	{"1__0 1_ 0x_1 0_x", []Type{Int, Int, Int, Int, Ident, Semicolon}, []string{"1__0", "1_", "0x_1", "0_", "x", ""}, []string{"1:2: '_' must separate successive digits", "1:6: '_' must separate successive digits", "1:14: '_' must separate successive digits"}},
+	{"1.5p3", []Type{Float, Semicolon}, []string{"1.5p3", ""}, []string{"1:3: 'p' exponent requires hexadecimal mantissa"}},
+	{"a /* b", []Type{Ident, Comment, Semicolon}, []string{"a", "/* b", ""}, []string{"1:2: comment not terminated"}},
+}
+
+func TestErrors(t *testing.T) {
+	for i, tc := range errorCases {
+		var errs []string
+		sc := New(strings.NewReader(tc.input), func(pos Position, msg string) {
+			errs = append(errs, fmt.Sprintf("%v: %s", pos, msg))
+		})
+		var toks []Type
+		var lits []string
+		for tok := sc.Scan(); tok.Type != EOF; tok = sc.Scan() {
+			toks = append(toks, tok.Type)
+			lits = append(lits, tok.Lit)
+		}
+		if fmt.Sprint(toks) != fmt.Sprint(tc.toks) || strings.Join(lits, "|") != strings.Join(tc.lits, "|") {
+			t.Errorf("case #%d, got tokens %v %q, want %v %q", i, toks, lits, tc.toks, tc.lits)
+		}
+		if strings.Join(errs, "\n") != strings.Join(tc.errs, "\n") {
+			t.Errorf("case #%d, got errors %q, want %q", i, errs, tc.errs)
+		}
+		if sc.ErrorCount != len(tc.errs) {
+			t.Errorf("case #%d, got ErrorCount %d, want %d", i, sc.ErrorCount, len(tc.errs))
+		}
+	}
+}
@@ -252,4 +252,13 @@ type Token struct {
 	Line   int
 	Column int
 	Lit    string
+	Pos    Pos // NoPos unless the scanner was given a File
+}
+
+// End returns the position just past the end of the token.
+func (t Token) End() Pos {
+	if !t.Pos.IsValid() {
+		return NoPos
+	}
+	return t.Pos + Pos(len(t.Lit))
 }
@@ -0,0 +1,215 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/scan"
+)
+
+// An ErrorCode identifies the kind of a type error. The values are stable:
+// new codes are only ever appended.
+type ErrorCode int
+
+const (
+	_ ErrorCode = iota
+
+	// MismatchedTypes occurs when two types that must be equal are not.
+	MismatchedTypes
+	// InvalidOperand occurs when an operator is applied to a type it does
+	// not support.
+	InvalidOperand
+	// RecursiveType occurs when a type would have to contain itself.
+	RecursiveType
+	// UndeclaredName occurs when an identifier does not denote an object.
+	UndeclaredName
+	// WrongArgCount occurs when a call has too few or too many arguments.
+	WrongArgCount
+	// WrongResultCount occurs when a call produces a different number of
+	// values than its context expects.
+	WrongResultCount
+	// AssignCount occurs when the two sides of an assignment or
+	// declaration have a different number of operands.
+	AssignCount
+	// NotAFunction occurs when a value that is not a function is called.
+	NotAFunction
+	// NotIndexable occurs when a value cannot be indexed, sliced or ranged
+	// over in the way that it is used.
+	NotIndexable
+	// NotARecord occurs when a selector is applied to a value that is not a
+	// record.
+	NotARecord
+	// MissingKey occurs when a record has no element with a given key.
+	MissingKey
+	// InvalidKey occurs when a record key is neither a field name nor an
+	// integer.
+	InvalidKey
+	// DuplicateKey occurs when a record literal labels two elements with
+	// the same key.
+	DuplicateKey
+	// InvalidSpread occurs when ... is applied to an argument that is not
+	// the final argument to a variadic function.
+	InvalidSpread
+	// InvalidUnaryOp occurs when a token that is not a unary operator is
+	// used as one.
+	InvalidUnaryOp
+	// UntypedLiteral occurs when a composite literal is not marked as an
+	// array or record.
+	UntypedLiteral
+	// MisplacedReturn occurs when a return statement is outside of a
+	// function.
+	MisplacedReturn
+	// AssignToFunction occurs when a function name is assigned to.
+	AssignToFunction
+	// UncalledBuiltin occurs when a builtin function is used other than by
+	// calling it.
+	UncalledBuiltin
+	// UnexportedName occurs when a qualified identifier refers to a name
+	// that its package does not export.
+	UnexportedName
+	// MisusedPackage occurs when the name of a used package is not followed
+	// by a selector.
+	MisusedPackage
+	// UndeclaredLabel occurs when a branch statement refers to a label that
+	// its function does not declare.
+	UndeclaredLabel
+	// DuplicateLabel occurs when a function declares a label twice.
+	DuplicateLabel
+	// UnusedLabel occurs when no branch statement refers to a label.
+	UnusedLabel
+	// MisplacedLabel occurs when a branch statement refers to a label that
+	// does not label a statement enclosing it, or, for continue, a loop.
+	MisplacedLabel
+	// MisplacedBreak occurs when a break statement without a label is not
+	// in a loop or switch.
+	MisplacedBreak
+	// MisplacedContinue occurs when a continue statement without a label is
+	// not in a loop.
+	MisplacedContinue
+	// InvalidLiteral occurs when a literal is malformed.
+	InvalidLiteral
+	// NumericOverflow occurs when a number is too large in magnitude for
+	// its type.
+	NumericOverflow
+	// DivByZero occurs when a constant is divided by zero.
+	DivByZero
+	// InvalidShiftCount occurs when a constant shift count is negative.
+	InvalidShiftCount
+)
+
+var codes = [...]string{
+	MismatchedTypes:  "MismatchedTypes",
+	InvalidOperand:   "InvalidOperand",
+	RecursiveType:    "RecursiveType",
+	UndeclaredName:   "UndeclaredName",
+	WrongArgCount:    "WrongArgCount",
+	WrongResultCount: "WrongResultCount",
+	AssignCount:      "AssignCount",
+	NotAFunction:     "NotAFunction",
+	NotIndexable:     "NotIndexable",
+	NotARecord:       "NotARecord",
+	MissingKey:       "MissingKey",
+	InvalidKey:       "InvalidKey",
+	DuplicateKey:     "DuplicateKey",
+	InvalidSpread:    "InvalidSpread",
+	InvalidUnaryOp:   "InvalidUnaryOp",
+	UntypedLiteral:   "UntypedLiteral",
+	MisplacedReturn:  "MisplacedReturn",
+	AssignToFunction: "AssignToFunction",
+	UncalledBuiltin:  "UncalledBuiltin",
+	UnexportedName:   "UnexportedName",
+	MisusedPackage:   "MisusedPackage",
+
+	UndeclaredLabel:   "UndeclaredLabel",
+	DuplicateLabel:    "DuplicateLabel",
+	UnusedLabel:       "UnusedLabel",
+	MisplacedLabel:    "MisplacedLabel",
+	MisplacedBreak:    "MisplacedBreak",
+	MisplacedContinue: "MisplacedContinue",
+	InvalidLiteral:    "InvalidLiteral",
+	NumericOverflow:   "NumericOverflow",
+	DivByZero:         "DivByZero",
+	InvalidShiftCount: "InvalidShiftCount",
+}
+
+func (code ErrorCode) String() string {
+	s := ""
+	if 0 <= code && code < ErrorCode(len(codes)) {
+		s = codes[code]
+	}
+	if s == "" {
+		s = "code(" + strconv.Itoa(int(code)) + ")"
+	}
+	return s
+}
+
+// An Error describes a type error. Tok is the token at which the offending
+// expression or statement begins. Expected and Actual are set when the
+// error is the result of two types failing to unify.
+type Error struct {
+	Tok      scan.Token
+	Code     ErrorCode
+	Msg      string
+	Expected Type
+	Actual   Type
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%d:%d:%d: %s", e.Tok.Offset, e.Tok.Line, e.Tok.Column, e.Msg)
+}
+
+// An ErrorList is the list of errors reported by Infer.
+type ErrorList []Error
+
+func (e ErrorList) Error() string {
+	var sb strings.Builder
+	for i := range e {
+		sb.WriteString(e[i].Error())
+		if i != len(e)-1 {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// tokOf returns the first token of n.
+func tokOf(n ast.Node) scan.Token {
+	switch n := n.(type) {
+	case *ast.Ident:
+		return n.Name
+	case *ast.BasicLit:
+		return n.Value
+	case *ast.BadExpr:
+		return n.From
+	case *ast.FunDef:
+		return n.Fun
+	case *ast.CompositeLit:
+		if n.Type != nil {
+			return tokOf(n.Type)
+		}
+		return n.Lbrace
+	case *ast.ArrayLit:
+		return n.A
+	case *ast.RecordLit:
+		return n.R
+	case *ast.ParenExpr:
+		return n.Lparen
+	case *ast.SelectorExpr:
+		return tokOf(n.X)
+	case *ast.IndexExpr:
+		return tokOf(n.X)
+	case *ast.SliceExpr:
+		return tokOf(n.X)
+	case *ast.CallExpr:
+		return tokOf(n.Fun)
+	case *ast.UnaryExpr:
+		return n.Op
+	case *ast.BinaryExpr:
+		return tokOf(n.X)
+	case *ast.KeyValueExpr:
+		return tokOf(n.Key)
+	}
+	return scan.Token{}
+}
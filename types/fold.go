@@ -0,0 +1,165 @@
+package types
+
+import (
+	"math"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/constant"
+	"github.com/smasher164/arvo/scan"
+)
+
+// Constant expressions are folded once inference is done, since the
+// meaning of an operation on numbers depends on whether they are nums or
+// floats: 7 / 2 is 3 as a num and 3.5 as a float. Values are exact while
+// they are folded, so 0.1 + 0.2 is 0.3 and (1 << 70) >> 10 is 1 << 60. The
+// value of a constant expression that is not the operand of another is
+// represented as its type only where it is used: a num must fit in 64 bits,
+// rather than wrap around as it would at run time, and a float is rounded
+// by the backends, and must not overflow.
+
+// maxShift is the greatest count by which a constant other than 0 may be
+// shifted left. A larger count is an error, since it could only make the
+// value too large to fit in a num.
+const maxShift = 512
+
+// fold records the values of the constant expressions in f, and checks
+// that every value that is used can be represented by its type.
+func (c *checker) fold(f *ast.File) {
+	ast.Walk(f, nil, func(n ast.Node) bool {
+		if x, ok := n.(ast.Expr); ok {
+			c.foldExpr(x)
+		}
+		return true
+	})
+	ast.Walk(f, func(n ast.Node) bool {
+		x, ok := n.(ast.Expr)
+		if !ok || c.Values[x] == nil {
+			return true
+		}
+		c.represent(x)
+		return false
+	}, nil)
+}
+
+// represent checks that the value of x can be represented by the type of
+// x. An unrepresentable value is reported, and replaced by an unknown
+// value.
+func (c *checker) represent(x ast.Expr) {
+	v := c.Values[x]
+	switch t := c.Types[x]; {
+	case t == Num:
+		if _, ok := constant.Int64Val(v); !ok && v.Kind() == constant.Int {
+			c.errorf(tokOf(x), NumericOverflow, "constant %s overflows num", v)
+			c.Values[x] = constant.MakeUnknown()
+		}
+	case t == Float:
+		if f, _ := constant.Float64Val(v); math.IsInf(f, 0) {
+			c.errorf(tokOf(x), NumericOverflow, "constant %s overflows float", v)
+			c.Values[x] = constant.MakeUnknown()
+		}
+	}
+}
+
+// foldable reports whether the operands of an operation of type t can be
+// folded. The operations on bools that are arithmetic are not folded.
+func foldable(t Type) bool {
+	return t == Num || t == Float || t == String || t == Bool
+}
+
+// foldExpr records the value of x, if its operands, which were folded
+// before it, are constant.
+func (c *checker) foldExpr(x ast.Expr) {
+	var v constant.Value
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		v = c.Values[x]
+		if v == nil {
+			return
+		}
+	case *ast.Ident:
+		obj := c.ObjectOf(x)
+		if obj == nil || obj.Kind != ast.Con || Universe.Lookup(obj.Name) != obj {
+			return
+		}
+		v = constant.MakeBool(obj.Data.(bool))
+	case *ast.ParenExpr:
+		if v = c.Values[x.X]; v == nil {
+			return
+		}
+	case *ast.UnaryExpr:
+		xv := c.Values[x.X]
+		if xv == nil || !foldable(c.Types[x.X]) || c.Types[x.X] == Bool && x.Op.Type != scan.Not {
+			return
+		}
+		v = constant.UnaryOp(x.Op.Type, xv)
+	case *ast.BinaryExpr:
+		xv, yv := c.Values[x.X], c.Values[x.Y]
+		tx := c.Types[x.X]
+		if xv == nil && yv != nil && tx == Num {
+			// A num divided by a constant zero could only fail at run
+			// time, whether or not the dividend is constant.
+			if op := x.Op.Type; (op == scan.Quo || op == scan.Rem) && constant.Sign(yv) == 0 {
+				c.errorf(x.Op, DivByZero, "division by zero")
+			}
+		}
+		if xv == nil || yv == nil || !foldable(tx) || !foldable(c.Types[x.Y]) {
+			return
+		}
+		if v = c.binary(x, tx, xv, yv); v == nil {
+			return
+		}
+	default:
+		return
+	}
+
+	// Convert the value to the kind of the type of x, exactly.
+	switch t := c.Types[x]; {
+	case t == Float:
+		v = constant.ToFloat(v)
+	case t == Num || t == String || t == Bool:
+	default:
+		// An expression of a polymorphic type is only constant if it
+		// is a literal, whose value is converted when its function is
+		// instantiated.
+		if _, ok := x.(*ast.BasicLit); !ok {
+			return
+		}
+	}
+	c.Values[x] = v
+}
+
+// binary returns the value of the binary operation x on the constants xv
+// and yv, whose type is tx, or nil if the operation is not folded.
+func (c *checker) binary(x *ast.BinaryExpr, tx Type, xv, yv constant.Value) constant.Value {
+	switch op := x.Op.Type; op {
+	case scan.Eql, scan.Neq, scan.Lss, scan.Leq, scan.Gtr, scan.Geq:
+		if tx == Bool && op != scan.Eql && op != scan.Neq {
+			return nil
+		}
+		return constant.MakeBool(constant.Compare(xv, op, yv))
+	case scan.Land, scan.Lor:
+		return constant.BinaryOp(xv, op, yv)
+	case scan.Shl, scan.Shr:
+		if tx != Num {
+			return nil
+		}
+		s, ok := constant.Int64Val(yv)
+		if s < 0 || !ok && yv.Kind() == constant.Int {
+			c.errorf(tokOf(x.Y), InvalidShiftCount, "invalid shift count %s", yv)
+			return constant.MakeUnknown()
+		}
+		if op == scan.Shl && s > maxShift && constant.Sign(xv) != 0 {
+			c.errorf(tokOf(x), NumericOverflow, "constant %s << %s overflows num", xv, yv)
+			return constant.MakeUnknown()
+		}
+		return constant.Shift(xv, op, uint(s))
+	}
+	if tx == Bool {
+		return nil
+	}
+	if op := x.Op.Type; (op == scan.Quo || op == scan.Rem) && constant.Sign(yv) == 0 {
+		c.errorf(x.Op, DivByZero, "division by zero")
+		return constant.MakeUnknown()
+	}
+	return constant.BinaryOp(xv, x.Op.Type, yv)
+}
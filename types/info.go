@@ -0,0 +1,109 @@
+package types
+
+import (
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/constant"
+)
+
+// Info holds the results of inference: the type of every expression, the
+// value of every constant expression, the object that every identifier
+// declares or refers to, and the scopes of the program. Infer fills in the
+// maps that are nil.
+type Info struct {
+	// Types maps every expression to its type. An identifier that refers
+	// to a polymorphic function has the type of the function at that use.
+	Types map[ast.Expr]Type
+
+	// Values maps every constant expression to its exact value, of the
+	// kind that corresponds to the expression's type: an Int for a num and
+	// a Float for a float. An expression is constant if it is a literal, a
+	// predeclared bool, or an operation whose operands are constant and
+	// have a type that is not polymorphic. A number literal of a
+	// polymorphic type keeps the value it denotes. A float value is rounded
+	// to a float64 where it is used, rather than after every operation.
+	Values map[ast.Expr]constant.Value
+
+	// Defs maps every identifier that declares an object to the object.
+	Defs map[*ast.Ident]*ast.Object
+
+	// Uses maps every identifier that refers to an object to the object,
+	// including the objects of the universe and of used packages.
+	Uses map[*ast.Ident]*ast.Object
+
+	// Implicits maps every use declaration without an explicit name to the
+	// package object that it declares.
+	Implicits map[ast.Node]*ast.Object
+
+	// Scopes maps every file and function definition to the scope that it
+	// introduces.
+	Scopes map[ast.Node]*ast.Scope
+}
+
+// ObjectOf returns the object that id declares or refers to, or nil if
+// there is none: id is undefined, or labels a record element.
+func (info *Info) ObjectOf(id *ast.Ident) *ast.Object {
+	if obj := info.Defs[id]; obj != nil {
+		return obj
+	}
+	return info.Uses[id]
+}
+
+// TypeOf returns the type of x, or nil if x was not checked.
+func (info *Info) TypeOf(x ast.Expr) Type {
+	return info.Types[x]
+}
+
+func (info *Info) init() {
+	if info.Types == nil {
+		info.Types = make(map[ast.Expr]Type)
+	}
+	if info.Values == nil {
+		info.Values = make(map[ast.Expr]constant.Value)
+	}
+	if info.Defs == nil {
+		info.Defs = make(map[*ast.Ident]*ast.Object)
+	}
+	if info.Uses == nil {
+		info.Uses = make(map[*ast.Ident]*ast.Object)
+	}
+	if info.Implicits == nil {
+		info.Implicits = make(map[ast.Node]*ast.Object)
+	}
+	if info.Scopes == nil {
+		info.Scopes = make(map[ast.Node]*ast.Scope)
+	}
+}
+
+// record records the object that id declares or refers to.
+func (info *Info) record(id *ast.Ident) {
+	if declIdent(id.Obj) == id {
+		info.Defs[id] = id.Obj
+	} else {
+		info.Uses[id] = id.Obj
+	}
+}
+
+// declIdent returns the identifier that declares obj.
+func declIdent(obj *ast.Object) *ast.Ident {
+	switch d := obj.Decl.(type) {
+	case *ast.FunDef:
+		return d.Name
+	case *ast.Param:
+		return d.Name
+	case *ast.LabeledStmt:
+		return d.Label
+	case *ast.ValueSpec:
+		for _, id := range d.Names {
+			if id.Name.Lit == obj.Name {
+				return id
+			}
+		}
+	case *ast.AssignStmt:
+		for _, x := range d.Lhs {
+			if id, _ := x.(*ast.Ident); id != nil && id.Name.Lit == obj.Name {
+				return id
+			}
+		}
+	}
+	return nil
+}
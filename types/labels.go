@@ -0,0 +1,132 @@
+package types
+
+import (
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/scan"
+)
+
+// Labels are scoped to the function that declares them, or to the top level
+// of a file, and the parser resolves the label of every branch statement
+// against them, leaving a label that is not declared unresolved. The errors
+// in labels are reported here, in whatever mode the file was parsed:
+// whether a branch statement can transfer control to its target depends on
+// the statements that enclose it.
+
+// A branchTarget is a statement enclosing a branch statement.
+type branchTarget struct {
+	label *ast.Object // label of the statement; or nil
+	loop  bool
+	sw    bool
+}
+
+// checkLabels checks the labels and branch statements of every function in
+// f and of f's top level.
+func (c *checker) checkLabels(f *ast.File) {
+	c.labels(f.Stmts)
+	ast.Walk(f, func(n ast.Node) bool {
+		if def, ok := n.(*ast.FunDef); ok && def.Body != nil {
+			c.labels(def.Body.List)
+		}
+		return true
+	}, nil)
+}
+
+// labels checks the labels declared in body, the statements of a function,
+// and the branch statements that refer to them. The statements of nested
+// functions are not part of body.
+func (c *checker) labels(body []ast.Stmt) {
+	var decls []*ast.LabeledStmt
+	declared := make(map[string]bool)
+	used := make(map[*ast.Object]bool)
+	var stack []branchTarget
+
+	var stmt func(s ast.Stmt, lbl *ast.Object)
+	stmts := func(list []ast.Stmt) {
+		for _, s := range list {
+			stmt(s, nil)
+		}
+	}
+	stmt = func(s ast.Stmt, lbl *ast.Object) {
+		switch s := s.(type) {
+		case *ast.LabeledStmt:
+			if declared[s.Label.Name.Lit] {
+				c.errorf(s.Label.Name, DuplicateLabel, "label %s already declared", s.Label.Name.Lit)
+			} else {
+				declared[s.Label.Name.Lit] = true
+				decls = append(decls, s)
+			}
+			stmt(s.Stmt, s.Label.Obj)
+		case *ast.BranchStmt:
+			c.branch(s, stack, used)
+		case *ast.BlockStmt:
+			stack = append(stack, branchTarget{label: lbl})
+			stmts(s.List)
+			stack = stack[:len(stack)-1]
+		case *ast.IfStmt:
+			stack = append(stack, branchTarget{label: lbl})
+			stmts(s.Body.List)
+			if s.Else != nil {
+				stmt(s.Else, nil)
+			}
+			stack = stack[:len(stack)-1]
+		case *ast.SwitchStmt:
+			stack = append(stack, branchTarget{label: lbl, sw: true})
+			for _, cc := range s.Body.List {
+				if cc, ok := cc.(*ast.CaseClause); ok {
+					stmts(cc.Body)
+				}
+			}
+			stack = stack[:len(stack)-1]
+		case *ast.ForStmt:
+			stack = append(stack, branchTarget{label: lbl, loop: true})
+			stmts(s.Body.List)
+			stack = stack[:len(stack)-1]
+		case *ast.InStmt:
+			stack = append(stack, branchTarget{label: lbl, loop: true})
+			stmts(s.Body.List)
+			stack = stack[:len(stack)-1]
+		}
+	}
+	stmts(body)
+
+	for _, s := range decls {
+		if obj := s.Label.Obj; obj != nil && !used[obj] {
+			c.errorf(s.Label.Name, UnusedLabel, "label %s declared and not used", s.Label.Name.Lit)
+		}
+	}
+}
+
+// branch checks the branch statement s, which is enclosed by the statements
+// in stack, and marks the label it refers to as used. A statement without a
+// label targets the innermost loop or, for break, switch.
+func (c *checker) branch(s *ast.BranchStmt, stack []branchTarget, used map[*ast.Object]bool) {
+	cont := s.Tok.Type == scan.Continue
+	if s.Label == nil {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].loop || stack[i].sw && !cont {
+				return
+			}
+		}
+		if cont {
+			c.errorf(s.Tok, MisplacedContinue, "continue is not in a loop")
+		} else {
+			c.errorf(s.Tok, MisplacedBreak, "break is not in a loop or switch")
+		}
+		return
+	}
+	obj := s.Label.Obj
+	if obj == nil {
+		c.errorf(s.Label.Name, UndeclaredLabel, "label %s not declared", s.Label.Name.Lit)
+		return
+	}
+	used[obj] = true
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].label == obj {
+			if cont && !stack[i].loop {
+				break
+			}
+			return
+		}
+	}
+	c.errorf(s.Label.Name, MisplacedLabel, "invalid %s label %s", s.Tok.Type, s.Label.Name.Lit)
+}
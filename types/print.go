@@ -0,0 +1,156 @@
+package types
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/smasher164/arvo/ast"
+)
+
+// A Qualifier controls how objects named in a type are printed. If it is
+// nil, an object is printed by its name.
+type Qualifier func(*ast.Object) string
+
+// TypeString returns the canonical representation of t, such as
+// fun(num, ...str) -> (bool) or r{name: str, 0: num}. Type variables are
+// named 'a, 'b, ... in the order that they appear.
+func TypeString(t Type, qf Qualifier) string {
+	p := printer{qf: qf}
+	p.typ(t)
+	return p.String()
+}
+
+// A printer writes types in canonical form. Type variables are named
+// consistently across all of the types written by one printer.
+type printer struct {
+	strings.Builder
+	qf    Qualifier
+	names map[*Var]string
+}
+
+func (p *printer) string(t Type) string {
+	p.Reset()
+	p.typ(t)
+	return p.String()
+}
+
+func (p *printer) typ(t Type) {
+	switch t := prune(t).(type) {
+	case nil:
+		p.WriteString("<nil>")
+	case Basic:
+		p.WriteString(t.String())
+	case *Var:
+		p.WriteString(p.name(t))
+	case Array:
+		p.WriteString("[")
+		p.typ(t.Key)
+		p.WriteString("]")
+		p.typ(t.Value)
+	case Record:
+		p.WriteString("r{")
+		for i, el := range t.Elts {
+			if i > 0 {
+				p.WriteString(", ")
+			}
+			p.typ(el.Key)
+			p.WriteString(": ")
+			p.typ(el.Value)
+		}
+		p.WriteString("}")
+	case Key:
+		p.WriteString(string(t))
+	case Element:
+		p.typ(t.Key)
+		p.WriteString(": ")
+		p.typ(t.Value)
+	case Tuple:
+		p.tuple(t, false)
+	case Signature:
+		p.WriteString("fun")
+		p.tuple(t.Params, t.Variadic)
+		if res, ok := prune(t.Results).(Tuple); !ok || len(res) > 0 {
+			p.WriteString(" -> ")
+			p.typ(t.Results)
+		}
+	case Label:
+		p.WriteString("label ")
+		if p.qf != nil {
+			p.WriteString(p.qf(t.Obj))
+		} else {
+			p.WriteString(t.Obj.Name)
+		}
+	}
+}
+
+func (p *printer) tuple(t Tuple, variadic bool) {
+	p.WriteString("(")
+	for i, el := range t {
+		if i > 0 {
+			p.WriteString(", ")
+		}
+		if variadic && i == len(t)-1 {
+			if arr, ok := prune(el).(Array); ok {
+				p.WriteString("...")
+				p.typ(arr.Value)
+				continue
+			}
+		}
+		p.typ(el)
+	}
+	p.WriteString(")")
+}
+
+func (p *printer) name(v *Var) string {
+	if s, ok := p.names[v]; ok {
+		return s
+	}
+	if p.names == nil {
+		p.names = make(map[*Var]string)
+	}
+	n := len(p.names)
+	s := "'" + string(rune('a'+n%26))
+	if n >= 26 {
+		s += strconv.Itoa(n / 26)
+	}
+	p.names[v] = s
+	return s
+}
+
+var basics = [...]string{
+	Bool:   "bool",
+	Num:    "num",
+	String: "str",
+	Float:  "float",
+}
+
+func (b Basic) String() string {
+	if 0 <= b && b < Basic(len(basics)) {
+		return basics[b]
+	}
+	return "basic(" + strconv.Itoa(int(b)) + ")"
+}
+
+var classes = [...]string{
+	Any:      "any type",
+	Ordered:  "num, float, bool or str",
+	Numeric:  "num, float or bool",
+	Integral: "num or bool",
+	Number:   "num or float",
+}
+
+func (k Class) String() string {
+	if 0 <= k && k < Class(len(classes)) {
+		return classes[k]
+	}
+	return "class(" + strconv.Itoa(int(k)) + ")"
+}
+
+func (v *Var) String() string      { return TypeString(v, nil) }
+func (t Array) String() string     { return TypeString(t, nil) }
+func (t Record) String() string    { return TypeString(t, nil) }
+func (k Key) String() string       { return string(k) }
+func (t Element) String() string   { return TypeString(t, nil) }
+func (t Tuple) String() string     { return TypeString(t, nil) }
+func (t Signature) String() string { return TypeString(t, nil) }
+func (t Label) String() string     { return TypeString(t, nil) }
@@ -0,0 +1,122 @@
+package types
+
+import (
+	"sort"
+
+	"github.com/smasher164/arvo/ast"
+)
+
+// Functions declared at the top level of a program may be used before they
+// are declared, so checking them in source order would leave the type of a
+// function unknown at some of its uses. Instead, they are checked in an
+// order that follows their dependencies. Functions that depend on each
+// other form a strongly connected component of the dependency graph, and
+// are checked together: the members of a component are monomorphic in each
+// other's bodies, and are generalized once the whole component is checked.
+
+// topLevelFuns returns the named functions declared by the statements of f.
+func topLevelFuns(f *ast.File) []*ast.FunDef {
+	var defs []*ast.FunDef
+	for _, s := range f.Stmts {
+		if x, ok := s.(*ast.ExprStmt); ok {
+			if def, ok := x.X.(*ast.FunDef); ok && def.Name != nil && def.Name.Obj != nil {
+				defs = append(defs, def)
+			}
+		}
+	}
+	return defs
+}
+
+// components returns the strongly connected components of the graph in
+// which a function in defs depends on the functions in defs that it refers
+// to. A component precedes every component that depends on it.
+func components(defs []*ast.FunDef) [][]*ast.FunDef {
+	byObj := make(map[*ast.Object]*ast.FunDef)
+	for _, def := range defs {
+		byObj[def.Name.Obj] = def
+	}
+	deps := make(map[*ast.FunDef][]*ast.FunDef)
+	for _, def := range defs {
+		seen := make(map[*ast.FunDef]bool)
+		ast.Walk(def.Body, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id != nil && id.Obj != nil {
+				if d, ok := byObj[id.Obj]; ok && !seen[d] {
+					seen[d] = true
+					deps[def] = append(deps[def], d)
+				}
+			}
+			return true
+		}, nil)
+	}
+
+	// Tarjan's algorithm finds each component after the components that it
+	// depends on.
+	var (
+		sccs  [][]*ast.FunDef
+		stack []*ast.FunDef
+		index = make(map[*ast.FunDef]int) // order of discovery, from 1
+		low   = make(map[*ast.FunDef]int)
+		on    = make(map[*ast.FunDef]bool)
+	)
+	var visit func(def *ast.FunDef)
+	visit = func(def *ast.FunDef) {
+		index[def] = len(index) + 1
+		low[def] = index[def]
+		stack = append(stack, def)
+		on[def] = true
+		for _, d := range deps[def] {
+			if index[d] == 0 {
+				visit(d)
+				if low[d] < low[def] {
+					low[def] = low[d]
+				}
+			} else if on[d] && index[d] < low[def] {
+				low[def] = index[d]
+			}
+		}
+		if low[def] != index[def] {
+			return
+		}
+		var scc []*ast.FunDef
+		for {
+			d := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			on[d] = false
+			scc = append(scc, d)
+			if d == def {
+				break
+			}
+		}
+		// Check the members in source order.
+		sort.Slice(scc, func(i, j int) bool { return scc[i].Pos() < scc[j].Pos() })
+		sccs = append(sccs, scc)
+	}
+	for _, def := range defs {
+		if index[def] == 0 {
+			visit(def)
+		}
+	}
+	return sccs
+}
+
+// checkGroup checks the functions in a strongly connected component. Their
+// signatures are created before any of their bodies is checked, and are
+// generalized after all of them are.
+func (c *checker) checkGroup(defs []*ast.FunDef) {
+	c.level++
+	for _, def := range defs {
+		c.signature(def)
+	}
+	c.level--
+	for _, def := range defs {
+		c.group[def] = true
+		ast.Walk(def, c.pre, c.post)
+	}
+	for _, def := range defs {
+		delete(c.group, def)
+		sig := c.TypeOf(def).(Signature)
+		c.generalize(sig)
+		c.set(def.Name, sig)
+		c.checked[def] = true
+	}
+}
@@ -2,52 +2,121 @@ package types
 
 import (
 	"fmt"
-	"reflect"
-	"strings"
+	"path"
+	"sort"
+	"strconv"
 
 	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/constant"
+	"github.com/smasher164/arvo/parse"
 	"github.com/smasher164/arvo/scan"
 )
 
+// A Config holds the program to check and the Info that Infer records.
+// The program is either a single file or a list of packages. Its files
+// must have been parsed with parse.DeclarationErrors: the checker reports
+// misused labels itself, but not the names that are redeclared in a scope.
 type Config struct {
-	File   *ast.File
-	Types  map[ast.Expr]Type
-	retstk []Tuple
+	File     *ast.File
+	Packages []*ast.Package // in dependency order, ending with the main package
+	Info
 }
 
-func (c *checker) pushret(r Tuple) {
-	c.conf.retstk = append(c.conf.retstk, r)
+// Files returns the files of the program, in the order that they run: the
+// files of each package follow those of the packages that it uses.
+func (c *Config) Files() []*ast.File {
+	if c.Packages == nil {
+		return []*ast.File{c.File}
+	}
+	var files []*ast.File
+	for _, pkg := range c.Packages {
+		files = append(files, pkg.Files...)
+	}
+	return files
+}
+
+// Fset returns the positions of the nodes of the program.
+func (c *Config) Fset() *scan.FileSet {
+	for _, f := range c.Files() {
+		return f.Fset
+	}
+	return nil
+}
+
+func (c *checker) pushret(r Type) {
+	c.retstk = append(c.retstk, r)
 }
 
-func (c *checker) popret() (r Tuple) {
-	if len(c.conf.retstk) > 0 {
-		r, c.conf.retstk = c.conf.retstk[len(c.conf.retstk)-1], c.conf.retstk[:len(c.conf.retstk)-1]
+func (c *checker) popret() (r Type) {
+	if len(c.retstk) > 0 {
+		r, c.retstk = c.retstk[len(c.retstk)-1], c.retstk[:len(c.retstk)-1]
 	}
 	return
 }
 
-type Type interface{}
+// A Type is the type of an expression. Every Type prints in the canonical
+// syntax produced by TypeString.
+type Type interface {
+	String() string
+}
 
 type checker struct {
-	err  errorlist
-	conf *Config
+	*Info
+	err    ErrorList
+	conf   *Config
+	objs   map[*ast.Object]Type  // types of declared objects
+	keys   map[*ast.Ident]bool   // identifiers that name record elements rather than objects
+	arity  map[*ast.CallExpr]int // number of results a call must produce; -1 if any
+	level  int                   // number of enclosing function definitions
+	nvar   int
+	retstk []Type
+
+	group   map[*ast.FunDef]bool // functions whose component is being checked
+	checked map[*ast.FunDef]bool // functions that were checked ahead of the walk
+	callees map[*ast.Ident]bool  // builtin functions that are called
+
+	pkgScope *ast.Scope // scope of the file being checked
 }
 
-type errorlist []error
-
-func (e errorlist) Error() string {
-	var sb strings.Builder
-	for i := range e {
-		sb.WriteString(e[i].Error())
-		if i != len(e)-1 {
-			sb.WriteString("\n")
-		}
-	}
-	return sb.String()
+func (c *checker) errorf(tok scan.Token, code ErrorCode, format string, args ...interface{}) {
+	c.err = append(c.err, Error{Tok: tok, Code: code, Msg: fmt.Sprintf(format, args...)})
 }
 
-func (c *checker) errorf(format string, args ...interface{}) {
-	c.err = append(c.err, fmt.Errorf(format, args...))
+// expect unifies got with want, reporting msg at tok if they cannot be made
+// equal.
+func (c *checker) expect(tok scan.Token, got, want Type, msg string) bool {
+	if err := c.unify(got, want); err != nil {
+		code := MismatchedTypes
+		switch err {
+		case errClass:
+			code = InvalidOperand
+		case errOccurs:
+			code = RecursiveType
+		}
+		// Print both types with one printer so that they agree on the
+		// names of type variables.
+		var p printer
+		have, wants := p.string(got), p.string(want)
+		if v, ok := prune(want).(*Var); ok && (err == errClass || v.Class == Number) {
+			wants = v.Class.String()
+		}
+		if v, ok := prune(got).(*Var); ok && v.Class == Number {
+			have = v.Class.String()
+		}
+		detail := "have " + have + ", want " + wants
+		if err == errOccurs {
+			detail += " (recursive type)"
+		}
+		c.err = append(c.err, Error{
+			Tok:      tok,
+			Code:     code,
+			Msg:      msg + ": " + detail,
+			Expected: resolve(want),
+			Actual:   resolve(got),
+		})
+		return false
+	}
+	return true
 }
 
 type Basic int
@@ -56,13 +125,19 @@ const (
 	Bool Basic = iota
 	Num
 	String
+	Float
 )
 
+// A Record is an ordered sequence of elements, each labeled by a Key.
 type Record struct {
 	N    int
 	Elts []Element
 }
 
+// A Key labels an element of a record: either a field name or the decimal
+// index of the element.
+type Key string
+
 type Array struct {
 	Key, Value Type
 }
@@ -73,594 +148,739 @@ type Element struct {
 
 type Tuple []Type
 
+// A Signature is the type of a function. Results is a Tuple once inference
+// is complete, but is a *Var while the function's return statements are
+// still being checked.
 type Signature struct {
-	ParamLen  int
-	Params    []Type
-	ResultLen int
-	Results   []Type
-	Variadic  bool
-}
-
-type Same *Type
-
-type Invocation struct {
-	ArgLen int
-	Args   []Type
-	Spread bool
-	Sig    *Signature
+	Params   Tuple
+	Results  Type
+	Variadic bool
 }
 
 type Label struct {
 	Obj *ast.Object
 }
 
-type or struct {
-	A, B Type
+func (c *checker) set(n ast.Expr, t Type) {
+	c.Types[n] = t
 }
 
-func Match(a, b Type) bool {
-	return match(a, b)
-}
-
-func match(a, b Type) bool {
-	switch ta := a.(type) {
-	case or:
-		return match(ta.A, b) || match(ta.B, b)
-	case Same:
-		if ta != nil {
-			return match(*ta, b)
-		}
-		return reflect.DeepEqual(a, b)
-	case nil:
-		return true
-	}
-	switch tb := b.(type) {
-	case or:
-		return match(a, tb.A) || match(a, tb.B)
-	case Same:
-		if tb != nil {
-			return match(a, *tb)
-		}
-		return reflect.DeepEqual(a, b)
-	case nil:
-		return true
-	}
-	return reflect.DeepEqual(a, b)
+// objType returns the type of obj, introducing a fresh type variable when
+// obj is encountered for the first time. Only package-level objects can be
+// used before they are declared, so a variable created for such a use
+// belongs to no function.
+func (c *checker) objType(obj *ast.Object, id *ast.Ident) Type {
+	if t, ok := c.objs[obj]; ok {
+		return t
+	}
+	v := c.fresh(Any)
+	if c.Defs[id] == nil {
+		v.Level = 0
+	}
+	c.objs[obj] = v
+	return v
 }
 
-func (c *checker) set(n ast.Node, t Type) {
-	switch n := n.(type) {
+// keyOf returns the record key denoted by x.
+func keyOf(x ast.Expr) (Key, bool) {
+	switch x := x.(type) {
 	case *ast.Ident:
-		if n != nil {
-			if n.Obj != nil {
-				if n.Obj.Kind == ast.Fun {
-					if f, _ := n.Obj.Decl.(*ast.FunDef); f != nil {
-						// maybe an issue with anonymous functions?
-						c.conf.Types[f.Name] = t
-					}
-				} else if n.Obj.Kind == ast.Var {
-					if pr, _ := n.Obj.Decl.(*ast.Param); pr != nil {
-						c.conf.Types[pr.Name] = t
-					} else if as, _ := n.Obj.Decl.(*ast.AssignStmt); as != nil {
-						for _, e := range as.Lhs {
-							if id, _ := e.(*ast.Ident); id != nil {
-								if id.Name.Lit == n.Name.Lit {
-									c.conf.Types[id] = t
-									break
-								}
-							}
-						}
-					}
-				}
-			} else {
-				c.conf.Types[n] = t
-			}
+		return Key(x.Name.Lit), true
+	case *ast.BasicLit:
+		if x.Value.Type == scan.Int {
+			// Integer keys are in decimal, like the positions of the
+			// elements that have no key.
+			return Key(constant.MakeFromLiteral(x.Value.Lit, scan.Int).String()), true
 		}
-		c.conf.Types[n] = t
-	default:
-		c.conf.Types[n] = t
 	}
+	return "", false
 }
 
-func (c *Config) Get(n ast.Node) Type {
-	switch n := n.(type) {
-	case *ast.Ident:
-		if n != nil {
-			if n.Obj != nil {
-				if n.Obj.Kind == ast.Fun {
-					if f, _ := n.Obj.Decl.(*ast.FunDef); f != nil {
-						return c.Types[f.Name]
-					}
-				} else if n.Obj.Kind == ast.Var {
-					if pr, _ := n.Obj.Decl.(*ast.Param); pr != nil {
-						return c.Types[pr.Name]
-					} else if as, _ := n.Obj.Decl.(*ast.AssignStmt); as != nil {
-						for _, e := range as.Lhs {
-							if id, _ := e.(*ast.Ident); id != nil {
-								if id.Name.Lit == n.Name.Lit {
-									return c.Types[id]
-								}
-							}
-						}
-					}
-				}
-			} else {
-				return c.Types[n]
-			}
+// field returns the type of the element of r labeled by x.
+func (c *checker) field(r Record, x ast.Expr) Type {
+	k, ok := keyOf(x)
+	if !ok {
+		c.errorf(tokOf(x), InvalidKey, "record index must be a field name or integer")
+		return c.fresh(Any)
+	}
+	for _, el := range r.Elts {
+		if el.Key == k {
+			return el.Value
 		}
-		return c.Types[n]
-	default:
-		return c.Types[n]
 	}
+	c.errorf(tokOf(x), MissingKey, "record %s has no element %s", TypeString(r, nil), k)
+	return c.fresh(Any)
 }
 
-func (c *checker) get(n ast.Node) Type {
-	switch n := n.(type) {
-	case *ast.Ident:
-		if n != nil {
-			if n.Obj != nil {
-				if n.Obj.Kind == ast.Fun {
-					if f, _ := n.Obj.Decl.(*ast.FunDef); f != nil {
-						return c.conf.Types[f.Name]
-					}
-				} else if n.Obj.Kind == ast.Var {
-					if pr, _ := n.Obj.Decl.(*ast.Param); pr != nil {
-						return c.conf.Types[pr.Name]
-					} else if as, _ := n.Obj.Decl.(*ast.AssignStmt); as != nil {
-						for _, e := range as.Lhs {
-							if id, _ := e.(*ast.Ident); id != nil {
-								if id.Name.Lit == n.Name.Lit {
-									return c.conf.Types[id]
-								}
-							}
-						}
-					}
-				}
-			} else {
-				return c.conf.Types[n]
-			}
+// results returns the type of a call whose callee produces res, given the
+// number of values that the context of the call expects.
+func (c *checker) results(call *ast.CallExpr, res Type) Type {
+	n, ok := c.arity[call]
+	if !ok {
+		n = 1
+	}
+	if n < 0 {
+		return res
+	}
+	tu := make(Tuple, n)
+	for i := range tu {
+		tu[i] = c.fresh(Any)
+	}
+	if err := c.unify(res, tu); err != nil {
+		if have, ok := prune(res).(Tuple); ok {
+			c.errorf(tokOf(call), WrongResultCount, "function call produces %d values, but %d are expected", len(have), n)
+		} else {
+			c.errorf(tokOf(call), WrongResultCount, "function call produces %s, but %d values are expected", TypeString(res, nil), n)
 		}
-		return c.conf.Types[n]
-	default:
-		return c.conf.Types[n]
 	}
+	if n == 1 {
+		return tu[0]
+	}
+	return tu
 }
 
-func (c *checker) eval(t Type) Type {
-	switch t := t.(type) {
-	case Invocation:
-		if t.Sig != nil && len(t.Sig.Results) != 0 {
-			if len(t.Sig.Results) == 1 {
-				return t.Sig.Results[0]
-			} else {
-				return Tuple(t.Sig.Results)
-			}
-		}
+// multiCall returns the call expression in list if it is the sole element.
+func multiCall(list []ast.Expr) *ast.CallExpr {
+	if len(list) != 1 {
 		return nil
-	case or:
-		return or{c.eval(t.A), c.eval(t.B)}
-	default:
-		return t
 	}
+	call, _ := list[0].(*ast.CallExpr)
+	return call
 }
 
-func assert(cond bool, msg string) {
-	if !cond {
-		panic(msg)
+// signature creates the signature of def, whose parameters and results are
+// fresh type variables, and declares the types of its parameters and name.
+func (c *checker) signature(def *ast.FunDef) Signature {
+	sig := Signature{Params: make(Tuple, len(def.Params)), Results: c.fresh(Any)}
+	for i, pr := range def.Params {
+		var typ Type = c.fresh(Any)
+		if pr.Ellipsis.Type == scan.Ellipsis {
+			sig.Variadic = true
+			typ = Array{Key: Num, Value: typ}
+		}
+		sig.Params[i] = typ
+		c.set(pr.Name, typ)
+		if pr.Name.Obj != nil {
+			c.objs[pr.Name.Obj] = typ
+		}
 	}
-}
-
-func getCalledDef(e *ast.CallExpr) *ast.FunDef {
-	switch t := e.Fun.(type) {
-	case *ast.Ident:
-		if t2, _ := t.Obj.Decl.(*ast.FunDef); t2 != nil {
-			return t2
+	c.set(def, sig)
+	if def.Name != nil && def.Name.Obj != nil {
+		if prev, ok := c.objs[def.Name.Obj]; ok {
+			// The function was used before its definition, so it cannot
+			// be generalized independently of those uses.
+			c.expect(def.Name.Name, prev, sig, "function used inconsistently with its definition")
+		} else {
+			c.objs[def.Name.Obj] = sig
 		}
-	case *ast.FunDef:
-		return t
 	}
-	return nil
+	return sig
 }
 
 // on the way down
 func (c *checker) pre(n ast.Node) bool {
 	switch t := n.(type) {
-	// case *RelComments, *Comment:
-	// case *ast.Ident:
-	// case *BadExpr:
-	case *ast.BasicLit:
-		switch t.Value.Type {
-		case scan.Ident:
-			if t.Value.Lit == "true" || t.Value.Lit == "false" {
-				c.set(t, Bool)
-			}
-		case scan.Int, scan.Float:
-			c.set(t, Num)
-		case scan.String:
-			c.set(t, String)
-		}
 	case *ast.FunDef:
-		var variadic bool
-		for _, el := range t.Params {
-			if el != nil && el.Ellipsis.Type == scan.Ellipsis {
-				variadic = true
-			}
+		if c.checked[t] {
+			return false
 		}
-		c.set(t, Signature{ParamLen: len(t.Params), Params: make([]Type, len(t.Params)), Variadic: variadic})
-		if t.Name != nil {
-			c.set(t.Name, Signature{ParamLen: len(t.Params), Params: make([]Type, len(t.Params)), Variadic: variadic})
+		c.Scopes[t] = t.Scope
+		c.level++
+		sig, ok := c.TypeOf(t).(Signature)
+		if !ok {
+			sig = c.signature(t)
+		}
+		c.pushret(sig.Results)
+	case *ast.BranchStmt:
+		// An undeclared label is reported by checkLabels.
+		if t.Label != nil && t.Label.Obj == nil {
+			return false
 		}
-		c.pushret(nil)
 	case *ast.CompositeLit:
-		switch t.Type.(type) {
-		case *ast.ArrayLit:
-			c.set(t, Array{})
-		case *ast.RecordLit:
-			n := len(t.Elts)
-			c.set(t, Record{N: n, Elts: make([]Element, n)})
-		}
-	// case *ast.ArrayLit:
-	// case *ast.RecordLit:
-	// case *ast.ParenExpr:
-	// case *ast.SelectorExpr:
-	// case *ast.IndexExpr:
-	// case *ast.SliceExpr:
+		if _, ok := t.Type.(*ast.RecordLit); ok {
+			for _, e := range t.Elts {
+				if kv, ok := e.(*ast.KeyValueExpr); ok {
+					if id, ok := kv.Key.(*ast.Ident); ok {
+						c.keys[id] = true
+					}
+				}
+			}
+		}
+	case *ast.SelectorExpr:
+		if ast.Qualified(t) != nil {
+			c.qualified(t)
+			return false
+		}
+		ast.Walk(t.X, c.pre, c.post)
+		if r, ok := prune(c.TypeOf(t.X)).(Record); ok {
+			c.set(t, c.field(r, t.Sel))
+		} else {
+			c.errorf(t.Sel.Name, NotARecord, "selector %s requires a record, have %s", t.Sel.Name.Lit, TypeString(c.TypeOf(t.X), nil))
+			c.set(t, c.fresh(Any))
+		}
+		return false
+	case *ast.IndexExpr:
+		ast.Walk(t.X, c.pre, c.post)
+		if r, ok := prune(c.TypeOf(t.X)).(Record); ok && !t.Backwards {
+			c.set(t, c.field(r, t.Index))
+			return false
+		}
+		ast.Walk(t.Index, c.pre, c.post)
+		c.index(t)
+		return false
 	case *ast.CallExpr:
-		c.set(t, Invocation{ArgLen: len(t.Args), Spread: t.Ellipsis.Type == scan.Ellipsis})
-	// case *ast.UnaryExpr:
-	// case *ast.BinaryExpr:
-	case *ast.KeyValueExpr:
-		c.set(t, Element{})
-	// case *BadStmt:
-	// case *ast.DeclStmt:
-	// case *EmptyStmt:
-	// case *ast.LabeledStmt:
-	// case *ast.ExprStmt:
-	case *ast.IncDecStmt:
-		c.set(t.X, Num)
+		if id, ok := t.Fun.(*ast.Ident); ok && IsBuiltin(id.Obj) {
+			c.callees[id] = true
+		}
+	case *ast.ExprStmt:
+		if call, ok := t.X.(*ast.CallExpr); ok {
+			c.arity[call] = -1
+		}
 	case *ast.AssignStmt:
 		if len(t.Lhs) != len(t.Rhs) {
-			c.errorf("left-hand side and right-hand side do not match: %d %s %d", len(t.Lhs), t.Tok, len(t.Rhs))
+			if call := multiCall(t.Rhs); call != nil {
+				c.arity[call] = len(t.Lhs)
+			} else {
+				c.errorf(t.Tok, AssignCount, "left-hand side and right-hand side do not match: %d %s %d", len(t.Lhs), t.Tok.Type, len(t.Rhs))
+			}
+		}
+	case *ast.ReturnStmt:
+		if call := multiCall(t.Results); call != nil {
+			c.arity[call] = -1
 		}
-	// case *ast.ReturnStmt:
-	case *ast.BranchStmt:
-		if t.Label != nil {
-			c.set(t.Label, Label{Obj: t.Label.Obj})
-		}
-	// case *ast.BlockStmt:
-	// case *ast.IfStmt:
-	// case *ast.CaseClause:
-	// case *ast.SwitchStmt:
-	// case *ast.ForStmt:
 	case *ast.InStmt:
-		c.set(t.X, or{Array{}, Record{}})
-	// case *UseSpec:
+		ast.Walk(t.X, c.pre, c.post)
+		ast.Walk(t.Index, c.pre, c.post)
+		ast.Walk(t.Key, c.pre, c.post)
+		ast.Walk(t.Value, c.pre, c.post)
+		c.in(t)
+		ast.Walk(t.Body, c.pre, c.post)
+		return false
+	case *ast.UseSpec:
+		c.useSpec(t)
+		return false
 	case *ast.ValueSpec:
-		if len(t.Names) != len(t.Values) {
-			c.errorf("left-hand side and right-hand side do not match: %d = %d", len(t.Names), len(t.Values))
+		if len(t.Values) > 0 && len(t.Names) != len(t.Values) {
+			if call := multiCall(t.Values); call != nil {
+				c.arity[call] = len(t.Names)
+			} else {
+				c.errorf(tokOf(t.Names[0]), AssignCount, "left-hand side and right-hand side do not match: %d = %d", len(t.Names), len(t.Values))
+			}
 		}
-	// case *PackageDecl:
-	// case *ast.GenDecl:
-	case *ast.File:
-		// case *Package:
 	}
 	return true
 }
 
+// qualified checks t, a qualified identifier. Only the exported names of a
+// package can be referred to by the packages that use it.
+func (c *checker) qualified(t *ast.SelectorExpr) {
+	pkg, sel := t.X.(*ast.Ident), t.Sel
+	c.record(pkg)
+	switch {
+	case sel.Obj != nil:
+		ast.Walk(sel, c.pre, c.post)
+		c.set(t, c.TypeOf(sel))
+		return
+	case !ast.IsExported(sel.Name.Lit):
+		c.errorf(sel.Name, UnexportedName, "cannot refer to unexported name %s.%s", pkg.Name.Lit, sel.Name.Lit)
+	default:
+		c.errorf(sel.Name, UndeclaredName, "undefined: %s.%s", pkg.Name.Lit, sel.Name.Lit)
+	}
+	c.set(sel, c.fresh(Any))
+	c.set(t, c.TypeOf(sel))
+}
+
+// useSpec records the package object that us declares.
+func (c *checker) useSpec(us *ast.UseSpec) {
+	if us.Name != nil {
+		if us.Name.Obj != nil {
+			c.Defs[us.Name] = us.Name.Obj
+		}
+		return
+	}
+	if p := parse.UsePath(us.Path); p != "" {
+		if obj := c.pkgScope.Lookup(path.Base(p)); obj != nil && obj.Kind == ast.Pkg {
+			c.Implicits[us] = obj
+		}
+	}
+}
+
+// lenArg checks x, the argument to len, which must have a length.
+func (c *checker) lenArg(x ast.Expr) {
+	xt := prune(c.TypeOf(x))
+	if v, ok := xt.(*Var); ok {
+		c.expect(tokOf(x), v, Array{Key: c.fresh(Any), Value: c.fresh(Any)}, "argument to len must be an array, record or string")
+		return
+	}
+	switch xt.(type) {
+	case Array, Record:
+		return
+	}
+	if xt != String {
+		c.errorf(tokOf(x), InvalidOperand, "invalid argument to len: %s", TypeString(xt, nil))
+	}
+}
+
+func (c *checker) index(t *ast.IndexExpr) {
+	xt := prune(c.TypeOf(t.X))
+	if v, ok := xt.(*Var); ok {
+		arr := Array{Key: c.fresh(Any), Value: c.fresh(Any)}
+		c.expect(tokOf(t.X), v, arr, "indexed value must be an array, record or string")
+		xt = arr
+	}
+	switch x := xt.(type) {
+	case Array:
+		if t.Backwards {
+			c.expect(tokOf(t.Index), c.TypeOf(t.Index), x.Value, "array value and index types do not match")
+			c.set(t, Array{Key: Num, Value: x.Key})
+		} else {
+			c.expect(tokOf(t.Index), c.TypeOf(t.Index), x.Key, "array key and index types do not match")
+			c.set(t, x.Value)
+		}
+		return
+	case Record:
+		c.errorf(t.LbrackIn, NotIndexable, "cannot reverse-index record %s", TypeString(x, nil))
+	case Basic:
+		if x != String {
+			break
+		}
+		if t.Backwards {
+			c.errorf(t.LbrackIn, NotIndexable, "string cannot be reverse-indexed")
+		} else {
+			c.expect(tokOf(t.Index), c.TypeOf(t.Index), Num, "string index must be a number")
+		}
+		c.set(t, String)
+		return
+	default:
+		c.errorf(tokOf(t.X), NotIndexable, "cannot index %s", TypeString(xt, nil))
+	}
+	c.set(t, c.fresh(Any))
+}
+
+func (c *checker) in(t *ast.InStmt) {
+	xt := prune(c.TypeOf(t.X))
+	if v, ok := xt.(*Var); ok {
+		arr := Array{Key: c.fresh(Any), Value: c.fresh(Any)}
+		c.expect(tokOf(t.X), v, arr, "cannot range over value")
+		xt = arr
+	}
+	var kt, vt Type
+	switch x := xt.(type) {
+	case Array:
+		kt, vt = x.Key, x.Value
+	case Record:
+		kt, vt = String, c.fresh(Any)
+		for _, el := range x.Elts {
+			if !c.expect(tokOf(t.X), el.Value, vt, "record elements must share a type to be ranged over") {
+				break
+			}
+		}
+	default:
+		if x == String {
+			kt, vt = Num, String
+			break
+		}
+		c.errorf(tokOf(t.X), NotIndexable, "cannot range over %s", TypeString(xt, nil))
+		kt, vt = c.fresh(Any), c.fresh(Any)
+	}
+	if t.Index != nil {
+		c.expect(tokOf(t.Index), c.TypeOf(t.Index), Num, "range index must be a number")
+	}
+	if t.Key != nil {
+		c.expect(tokOf(t.Key), c.TypeOf(t.Key), kt, "range key does not match key type")
+	}
+	if t.Value != nil {
+		c.expect(tokOf(t.Value), c.TypeOf(t.Value), vt, "range value does not match value type")
+	}
+}
+
 // on the way up
 func (c *checker) post(n ast.Node) bool {
 	switch t := n.(type) {
-	// case *RelComments, *Comment:
-	// case *ast.Ident:
-	// case *BadExpr:
-	// case *ast.BasicLit:
+	case *ast.Ident:
+		if t == nil || c.keys[t] {
+			break
+		}
+		if t.Obj == nil {
+			if t.Name.Lit != "_" {
+				c.errorf(t.Name, UndeclaredName, "undefined: %s", t.Name.Lit)
+			}
+			c.set(t, c.fresh(Any))
+			break
+		}
+		c.record(t)
+		switch {
+		case t.Obj.Type != nil:
+			// predeclared
+			if IsBuiltin(t.Obj) && !c.callees[t] {
+				c.errorf(t.Name, UncalledBuiltin, "%s (builtin function) must be called", t.Name.Lit)
+			}
+			c.set(t, c.instantiate(t.Obj.Type.(Type)))
+		case t.Obj.Kind == ast.Pkg:
+			c.errorf(t.Name, MisusedPackage, "use of package %s without selector", t.Name.Lit)
+			c.set(t, c.fresh(Any))
+		case t.Obj.Kind == ast.Lbl:
+			c.set(t, Label{Obj: t.Obj})
+		case t.Obj.Kind == ast.Fun:
+			c.set(t, c.instantiate(c.objType(t.Obj, t)))
+		default:
+			c.set(t, c.objType(t.Obj, t))
+		}
+	case *ast.BadExpr:
+		c.set(t, c.fresh(Any))
+	case *ast.BasicLit:
+		switch t.Value.Type {
+		case scan.Int:
+			// An integer literal may be a num or a float, whichever its
+			// context requires.
+			c.set(t, c.fresh(Number))
+		case scan.Float:
+			c.set(t, Float)
+		case scan.String:
+			c.set(t, String)
+		}
+		v := constant.MakeFromLiteral(t.Value.Lit, t.Value.Type)
+		if v.Kind() == constant.Unknown {
+			c.errorf(t.Value, InvalidLiteral, "malformed literal %s", t.Value.Lit)
+		}
+		c.Values[t] = v
 	case *ast.FunDef:
-		sig, ok := c.get(t).(Signature)
-		assert(ok, "function definition contains signature")
-		for i := range sig.Params {
-			sig.Params[i] = c.get(t.Params[i].Name)
+		sig := c.TypeOf(t).(Signature)
+		if _, ok := prune(sig.Results).(*Var); ok {
+			// No return statement produced a value.
+			c.expect(t.Fun, sig.Results, Tuple{}, "function returns no values")
 		}
-		if sig.Variadic {
-			sig.Params[len(sig.Params)-1] = Array{Key: Num, Value: sig.Params[len(sig.Params)-1]}
+		c.popret()
+		c.level--
+		if t.Name != nil && !c.group[t] {
+			c.generalize(sig)
+			c.set(t.Name, sig)
 		}
-		sig.Results = c.popret()
-		sig.ResultLen = len(sig.Results)
-		c.set(t, sig)
-		c.set(t.Name, sig)
 	case *ast.CompositeLit:
 		switch t.Type.(type) {
 		case *ast.ArrayLit:
-			if len(t.Elts) != 0 {
-				var errd bool
-				t0 := c.get(t.Elts[0])
-				for i := 1; i < len(t.Elts); i++ {
-					if t0 != c.get(t.Elts[1]) {
-						c.errorf("array holds values of varying type")
-						errd = true
-					}
+			arr := Array{Key: c.fresh(Any), Value: c.fresh(Any)}
+			for _, e := range t.Elts {
+				k, v := Type(Num), c.TypeOf(e)
+				if kv, ok := e.(*ast.KeyValueExpr); ok {
+					k, v = c.TypeOf(kv.Key), c.TypeOf(kv.Value)
 				}
-				if !errd {
-					if el, ok := t0.(Element); ok {
-						c.set(t, Array{Key: c.eval(el.Key), Value: c.eval(el.Value)})
-					} else {
-						c.set(t, Array{Key: Num, Value: c.eval(t0)})
-					}
+				if !c.expect(tokOf(e), k, arr.Key, "array holds keys of varying type") ||
+					!c.expect(tokOf(e), v, arr.Value, "array holds values of varying type") {
+					break
 				}
 			}
+			c.set(t, arr)
 		case *ast.RecordLit:
-			e := c.get(t).(Record).Elts
-			for i := range t.Elts {
-				t0 := c.get(t.Elts[i])
-				if el, ok := t0.(Element); ok {
-					e[i] = Element{Key: el.Key, Value: el.Value}
-				} else {
-					e[i] = Element{Key: Num, Value: t0}
-				}
-			}
-		}
-	// case *ast.ArrayLit:
-	// case *ast.RecordLit:
-	case *ast.ParenExpr:
-		c.set(t, c.get(t.X))
-	case *ast.SelectorExpr:
-		// c.conf.Types[t] = Selection{A: c.conf.Types[t.X], B: c.conf.Types[t.Sel]}
-		c.set(t, c.get(t.Sel))
-	case *ast.IndexExpr:
-		if t.Backwards {
-			switch t0 := c.get(t.X).(type) {
-			case Array:
-				if t0.Value != nil && c.get(t.Index) != nil && t0.Value != c.get(t.Index) {
-					c.errorf("array value and index types do not match")
-				}
-				c.set(t, Array{Key: Num, Value: c.eval(c.get(t.Index))})
-			case Record:
-				c.errorf("record cannot be reverse-indexed")
-			}
-		} else {
-			switch t0 := c.get(t.X).(type) {
-			case Array:
-				if t0.Key != nil && c.get(t.Index) != nil && t0.Key != c.get(t.Index) {
-					c.errorf("array key and index types do not match")
-				}
-			case Record:
-				id := c.get(t.Index)
-				var found bool
-				for i := range t0.Elts {
-					k := t0.Elts[i].Key
-					if k == id {
-						found = true
-						break
+			r := Record{N: len(t.Elts), Elts: make([]Element, len(t.Elts))}
+			seen := make(map[Key]bool)
+			for i, e := range t.Elts {
+				k, v := Key(strconv.Itoa(i)), c.TypeOf(e)
+				if kv, ok := e.(*ast.KeyValueExpr); ok {
+					v = c.TypeOf(kv.Value)
+					if k, ok = keyOf(kv.Key); !ok {
+						c.errorf(tokOf(kv.Key), InvalidKey, "record key must be a field name or integer")
 					}
 				}
-				if !found {
-					c.errorf("record key and index types do not match")
+				if seen[k] {
+					c.errorf(tokOf(e), DuplicateKey, "duplicate key %s in record literal", k)
 				}
+				seen[k] = true
+				r.Elts[i] = Element{Key: k, Value: v}
 			}
+			c.set(t, r)
+		default:
+			c.errorf(t.Lbrace, UntypedLiteral, "missing type in composite literal")
+			c.set(t, c.fresh(Any))
 		}
+	case *ast.ParenExpr:
+		c.set(t, c.TypeOf(t.X))
 	case *ast.SliceExpr:
-		switch c.get(t.X).(type) {
+		xt := prune(c.TypeOf(t.X))
+		if v, ok := xt.(*Var); ok {
+			arr := Array{Key: c.fresh(Any), Value: c.fresh(Any)}
+			c.expect(tokOf(t.X), v, arr, "sliced value must be an array or string")
+			xt = arr
+		}
+		for _, x := range []ast.Expr{t.Low, t.High} {
+			if x != nil {
+				c.expect(tokOf(x), c.TypeOf(x), Num, "slice bounds must be numbers")
+			}
+		}
+		switch x := xt.(type) {
 		case Array:
-			if c.get(t.Low) != Num && c.get(t.High) != Num {
-				c.errorf("slice bounds must be numbers")
+			c.expect(tokOf(t.X), x.Key, Num, "sliced array must be keyed by numbers")
+			c.set(t, x)
+		default:
+			if x == String {
+				c.set(t, x)
+				break
 			}
-			c.set(t, c.eval(c.get(t.X)))
-		case Record:
-			c.errorf("record cannot be sliced")
+			c.errorf(tokOf(t.X), NotIndexable, "cannot slice %s", TypeString(xt, nil))
+			c.set(t, c.fresh(Any))
 		}
 	case *ast.CallExpr:
-		inv, ok := c.get(t).(Invocation)
-		assert(ok, "cannot retrieve function invocation")
-		sig, ok := c.get(t.Fun).(Signature)
-		assert(ok, "cannot retrieve function declaration")
-		if !sig.Variadic && inv.ArgLen != sig.ParamLen {
-			c.errorf("number of arguments does not match number of parameters")
-			break
+		ft := prune(c.TypeOf(t.Fun))
+		if v, ok := ft.(*Var); ok {
+			sig := Signature{Params: make(Tuple, len(t.Args)), Results: c.fresh(Any)}
+			for i := range sig.Params {
+				sig.Params[i] = c.fresh(Any)
+			}
+			c.expect(tokOf(t.Fun), v, sig, "called value is not a function")
+			ft = sig
 		}
-		if sig.Variadic && inv.ArgLen < (sig.ParamLen-1) {
-			c.errorf("number of arguments does not match number of parameters")
+		sig, ok := ft.(Signature)
+		if !ok {
+			c.errorf(tokOf(t.Fun), NotAFunction, "cannot call non-function %s", TypeString(ft, nil))
+			c.set(t, c.fresh(Any))
 			break
 		}
-		n := sig.ParamLen
+		var builtin string
+		if id, ok := t.Fun.(*ast.Ident); ok && IsBuiltin(id.Obj) {
+			builtin = id.Obj.Name
+		}
+		n := len(sig.Params)
 		if sig.Variadic {
 			n--
 		}
-		for i := 0; i < n; i++ {
-			at := c.get(t.Args[i])
-			inv.Args = append(inv.Args, at)
-			typ := c.eval(sig.Params[i])
-			if !match(at, c.eval(sig.Params[i])) {
-				c.errorf("argument types don't match parameter types")
-				break
-			}
-			if typ == nil {
-				if ce := getCalledDef(t); ce != nil {
-					ot := or{typ, at}
-					c.set(ce.Params[i], ot)
-					// reflow function's type
-					sig.Params[i] = ot
+		spread := t.Ellipsis.Type == scan.Ellipsis
+		switch {
+		case len(t.Args) < n || !sig.Variadic && len(t.Args) > n:
+			c.errorf(t.Lparen, WrongArgCount, "wrong number of arguments in call to %s: have %d", TypeString(sig, nil), len(t.Args))
+		case spread && (!sig.Variadic || len(t.Args) != len(sig.Params)):
+			c.errorf(t.Ellipsis, InvalidSpread, "can only use ... with final argument to variadic function")
+		default:
+			for i := 0; i < n; i++ {
+				if !c.expect(tokOf(t.Args[i]), c.TypeOf(t.Args[i]), sig.Params[i], "argument types don't match parameter types") {
+					break
 				}
 			}
-		}
-		if sig.Variadic {
-			ar, ok := sig.Params[len(sig.Params)-1].(Array)
-			if !ok && inv.ArgLen >= sig.ParamLen {
-				panic("variadic parameter must have type array")
-			}
-			t1 := ar.Value
-			for i := n; i < inv.ArgLen; i++ {
-				at := c.get(t.Args[i])
-				inv.Args = append(inv.Args, at)
-				if !match(at, t1) {
-					c.errorf("argument types don't match parameter types")
-					break
+			if spread {
+				c.expect(tokOf(t.Args[n]), c.TypeOf(t.Args[n]), sig.Params[n], "argument types don't match parameter types")
+			} else if sig.Variadic {
+				elem := prune(sig.Params[n]).(Array).Value
+				for i := n; i < len(t.Args); i++ {
+					if builtin == "printf" {
+						// The arguments to printf are formatted
+						// according to their own types.
+						elem = c.fresh(Any)
+					}
+					if !c.expect(tokOf(t.Args[i]), c.TypeOf(t.Args[i]), elem, "argument types don't match parameter types") {
+						break
+					}
 				}
 			}
 		}
-		inv.Sig = &sig
-		c.set(t, inv)
+		if builtin == "len" && len(t.Args) == 1 {
+			c.lenArg(t.Args[0])
+		}
+		c.set(t, c.results(t, sig.Results))
 	case *ast.UnaryExpr:
-		// if t.X has a type and is not a number or bool, error
-		typ := c.get(t.X)
-		if typ != nil {
-			if t0, ok := typ.(Basic); !ok || t0 == String {
-				c.errorf("unary operation can only be performed on number or bool")
-				break
-			}
-		} else {
-			typ = or{Bool, Num}
+		tx := c.TypeOf(t.X)
+		switch t.Op.Type {
+		case scan.Not:
+			c.expect(t.Op, tx, Bool, "operand of ! must be a bool")
+			c.set(t, Bool)
+		case scan.Add, scan.Sub:
+			c.expect(t.Op, tx, c.fresh(Numeric), "unary operation can only be performed on number or bool")
+			c.set(t, tx)
+		case scan.Xor:
+			c.expect(t.Op, tx, c.fresh(Integral), "unary ^ can only be performed on integer or bool")
+			c.set(t, tx)
+		default:
+			c.errorf(t.Op, InvalidUnaryOp, "invalid unary operator %s", t.Op.Type)
+			c.set(t, c.fresh(Any))
 		}
-		typ = c.eval(typ)
-		c.set(t, typ)
-		c.set(t.X, typ)
 	case *ast.BinaryExpr:
-		tx, ty := c.get(t.X), c.get(t.Y)
-		var typ Type
-		if t.Op.Type == scan.Add || t.Op.Type == scan.Eql || t.Op.Type == scan.Lss ||
-			t.Op.Type == scan.Gtr || t.Op.Type == scan.Neq ||
-			t.Op.Type == scan.Leq || t.Op.Type == scan.Geq {
-			if tx != nil || ty != nil {
-				typ = or{tx, ty}
-			} else {
-				typ = or{or{Bool, Num}, String}
+		tx, ty := c.TypeOf(t.X), c.TypeOf(t.Y)
+		switch t.Op.Type {
+		case scan.Land, scan.Lor:
+			c.expect(tokOf(t.X), tx, Bool, "operands of "+t.Op.Type.String()+" must be bools")
+			c.expect(tokOf(t.Y), ty, Bool, "operands of "+t.Op.Type.String()+" must be bools")
+			c.set(t, Bool)
+		case scan.Eql, scan.Neq, scan.Lss, scan.Leq, scan.Gtr, scan.Geq:
+			if c.expect(t.Op, ty, tx, "operands of comparison do not match") {
+				c.expect(t.Op, tx, c.fresh(Ordered), "comparison can only be performed between numbers, bools or strings")
 			}
-		} else {
-			if tx != nil && ty != nil {
-				tx0, ok1 := tx.(Basic)
-				ty0, ok2 := ty.(Basic)
-				if (!ok1 || tx0 == String) || (!ok2 || ty0 == String) {
-					c.errorf("binary operation can only be performed between numbers or bools")
-					break
-				} else {
-					typ = or{tx, ty}
-				}
-			} else {
-				typ = or{Bool, Num}
+			c.set(t, Bool)
+		case scan.Shl, scan.Shr:
+			c.expect(tokOf(t.X), tx, c.fresh(Integral), "shifted operand must be an integer or bool")
+			c.expect(tokOf(t.Y), ty, Num, "shift count must be a number")
+			c.set(t, tx)
+		case scan.Add:
+			if c.expect(t.Op, ty, tx, "operands of + do not match") {
+				c.expect(t.Op, tx, c.fresh(Ordered), "+ can only be performed between numbers, bools or strings")
 			}
+			c.set(t, tx)
+		case scan.Sub, scan.Mul, scan.Quo:
+			if c.expect(t.Op, ty, tx, "operands of binary operation do not match") {
+				c.expect(t.Op, tx, c.fresh(Numeric), "binary operation can only be performed between numbers or bools")
+			}
+			c.set(t, tx)
+		default:
+			if c.expect(t.Op, ty, tx, "operands of binary operation do not match") {
+				c.expect(t.Op, tx, c.fresh(Integral), t.Op.Type.String()+" can only be performed between integers or bools")
+			}
+			c.set(t, tx)
 		}
-		typ = c.eval(typ)
-		c.set(t, typ)
-		c.set(t.X, typ)
-		c.set(t.Y, Same(&typ))
 	case *ast.KeyValueExpr:
-		c.set(t, Element{Key: c.eval(c.get(t.Key)), Value: c.eval(c.get(t.Value))})
-	// case *BadStmt:
-	case *ast.DeclStmt:
-	// case *EmptyStmt:
-	// case *ast.LabeledStmt:
-	// case *ast.ExprStmt:
+		c.set(t, Element{Key: c.TypeOf(t.Key), Value: c.TypeOf(t.Value)})
 	case *ast.IncDecStmt:
-		// if t.X has a type and is not a number or bool, error
-		if typ := c.get(t.X); typ != nil {
-			if t0, ok := typ.(Basic); !ok || t0 == String {
-				c.errorf("can only increment and decrement a number or bool")
-			}
-		}
+		c.expect(t.Tok, c.TypeOf(t.X), c.fresh(Numeric), "can only increment and decrement a number or bool")
 	case *ast.AssignStmt:
-		if t.Tok.Type != scan.Assign && (len(t.Lhs) > 1 || len(t.Rhs) > 1) {
-			c.errorf("assignment operator can only operate on one element on lhs and rhs")
-			break
-		}
-		if t.Tok.Type != scan.Assign {
-			if c.get(t.Lhs[0]) != nil && !match(c.get(t.Lhs[0]), c.get(t.Rhs[0])) {
-				c.errorf("lhs does not match rhs type")
-				break
+		for _, x := range t.Lhs {
+			if id, _ := x.(*ast.Ident); id != nil && id.Obj != nil && id.Obj.Kind == ast.Fun {
+				c.errorf(id.Name, AssignToFunction, "cannot assign to function %s", id.Name.Lit)
 			}
-			c.set(t.Lhs[0], c.eval(c.get(t.Rhs[0])))
-		} else {
-			for i := range t.Lhs {
-				if t0 := c.get(t.Lhs[i]); t0 != nil {
-					if !match(t0, c.eval(c.get(t.Rhs[i]))) {
-						fmt.Println("HEREAFTER")
-						c.errorf("lhs does not match rhs type")
-					} else {
-						c.set(t.Lhs[i], c.eval(c.get(t.Rhs[i])))
-					}
-				} else {
-					c.set(t.Lhs[i], c.eval(c.get(t.Rhs[i])))
+		}
+		switch {
+		case t.Tok.Type == scan.Assign:
+			if len(t.Lhs) == len(t.Rhs) {
+				for i := range t.Lhs {
+					c.expect(tokOf(t.Rhs[i]), c.TypeOf(t.Rhs[i]), c.TypeOf(t.Lhs[i]), "lhs does not match rhs type")
 				}
+			} else if call := multiCall(t.Rhs); call != nil {
+				lhs := make(Tuple, len(t.Lhs))
+				for i := range t.Lhs {
+					lhs[i] = c.TypeOf(t.Lhs[i])
+				}
+				c.expect(tokOf(call), c.TypeOf(call), lhs, "lhs does not match rhs type")
 			}
-		}
-	case *ast.ReturnStmt:
-		tuple := c.conf.retstk[len(c.conf.retstk)-1]
-		if tuple == nil {
-			for i := range t.Results {
-				tuple = append(tuple, c.eval(c.get(t.Results[i])))
+		case len(t.Lhs) != 1 || len(t.Rhs) != 1:
+			c.errorf(t.Tok, AssignCount, "assignment operator can only operate on one element on lhs and rhs")
+		default:
+			k := Integral
+			switch t.Tok.Type {
+			case scan.AddAssign:
+				k = Ordered
+			case scan.SubAssign, scan.MulAssign, scan.QuoAssign:
+				k = Numeric
+			}
+			if c.expect(t.Tok, c.TypeOf(t.Rhs[0]), c.TypeOf(t.Lhs[0]), "lhs does not match rhs type") {
+				c.expect(t.Tok, c.TypeOf(t.Lhs[0]), c.fresh(k), "invalid operands for "+t.Tok.Type.String())
 			}
-			c.popret()
-			c.pushret(tuple)
-			break
 		}
-		// if result types list exist and types of results don't match their respective signature result types, error
-		if len(tuple) != len(t.Results) {
-			c.errorf("number of return values do not match")
+	case *ast.ReturnStmt:
+		if len(c.retstk) == 0 {
+			c.errorf(t.Return, MisplacedReturn, "return statement outside function")
 			break
 		}
-		for i, t1 := range tuple {
-			if !match(c.get(t.Results[i]), t1) {
-				c.errorf("return statement does not match signature")
+		var res Type
+		if call := multiCall(t.Results); call != nil {
+			res = c.TypeOf(call)
+			if v, ok := prune(res).(*Var); ok {
+				// The number of values the callee produces is not yet
+				// known, so assume that it produces one.
+				res = Tuple{c.fresh(Any)}
+				c.unify(v, res)
 			}
+		} else {
+			tu := make(Tuple, len(t.Results))
+			for i := range t.Results {
+				tu[i] = c.TypeOf(t.Results[i])
+			}
+			res = tu
+		}
+		c.expect(t.Return, res, c.retstk[len(c.retstk)-1], "return statement does not match signature")
+	case *ast.IfStmt:
+		c.expect(tokOf(t.Cond), c.TypeOf(t.Cond), Bool, "if condition must be a bool")
+	case *ast.ForStmt:
+		if t.Cond != nil {
+			c.expect(tokOf(t.Cond), c.TypeOf(t.Cond), Bool, "loop condition must be a bool")
 		}
-	case *ast.BranchStmt:
-	// case *ast.BlockStmt:
-	// case *ast.IfStmt:
-	// case *ast.CaseClause:
 	case *ast.SwitchStmt:
-		// figure out type of tag
-		var typ Type = Bool
+		var tag Type = Bool
 		if t.Tag != nil {
-			typ = c.get(t.Tag)
+			tag = c.TypeOf(t.Tag)
 		}
-		// make other types point to that type
 		for i := range t.Body.List {
 			s, _ := t.Body.List[i].(*ast.CaseClause)
 			if s != nil {
-				for j := range s.List {
-					e := s.List[j]
-					if t2 := c.get(e); t2 != nil && t2 != typ {
-						c.errorf("case expressions must match switch tag type")
-					} else {
-						c.set(e, Same(&typ))
-					}
+				for _, e := range s.List {
+					c.expect(tokOf(e), c.TypeOf(e), tag, "case expressions must match switch tag type")
 				}
 			}
 		}
-	// case *ast.ForStmt:
-	// case *ast.InStmt:
-	// case *UseSpec:
 	case *ast.ValueSpec:
-		if len(t.Values) > 0 && len(t.Names) != len(t.Values) {
-			c.errorf("Lhs and Rhs do not match")
-			break
-		}
-		for i := range t.Names {
-			c.set(t.Names[i], c.eval(c.get(t.Values[i])))
+		if len(t.Values) == len(t.Names) {
+			for i := range t.Names {
+				c.expect(tokOf(t.Values[i]), c.TypeOf(t.Values[i]), c.TypeOf(t.Names[i]), "lhs does not match rhs type")
+			}
+		} else if call := multiCall(t.Values); call != nil {
+			lhs := make(Tuple, len(t.Names))
+			for i := range t.Names {
+				lhs[i] = c.TypeOf(t.Names[i])
+			}
+			c.expect(tokOf(call), c.TypeOf(call), lhs, "lhs does not match rhs type")
 		}
-	// case *PackageDecl:
-	// case *ast.GenDecl:
-	case *ast.File:
-		// case *Package:
 	}
 	return true
 }
 
-// Type checker performs inference and validation over the syntax tree using
-// only a single traversal. The resulting types of expressions are stored in
-// the Config's Types map.
+// Type checker performs inference and validation over the syntax trees of
+// the program's files. The functions declared at the top level are checked
+// first, in the order of their dependencies, and then each file is checked
+// in a single traversal, which records the Config's Info. The types of
+// expressions have their type variables resolved. Type variables that
+// remain are those quantified over by polymorphic functions.
 func (c *checker) check() error {
-	ast.Walk(c.conf.File, c.pre, c.post)
+	files := c.conf.Files()
+	var defs []*ast.FunDef
+	for _, f := range files {
+		// A file that was parsed without the universe scope leaves the
+		// predeclared names unresolved.
+		for _, id := range f.Unresolved {
+			if id.Obj == nil {
+				id.Obj = Universe.Lookup(id.Name.Lit)
+			}
+		}
+		defs = append(defs, topLevelFuns(f)...)
+	}
+	for _, group := range components(defs) {
+		c.checkGroup(group)
+	}
+	for _, f := range files {
+		c.Scopes[f] = f.Scope
+		c.pkgScope = f.Scope
+		ast.Walk(f, c.pre, c.post)
+		c.checkLabels(f)
+	}
+	for _, t := range c.Types {
+		settle(t)
+	}
+	for n, t := range c.Types {
+		c.Types[n] = resolve(t)
+	}
+	if len(c.err) == 0 {
+		// Every operation is valid for its operands, so constant
+		// expressions can be folded.
+		for _, f := range files {
+			c.fold(f)
+		}
+	}
 	if len(c.err) == 0 {
 		return nil
 	}
+	// Report the errors in source order, rather than in the order in which
+	// the functions were checked.
+	sort.SliceStable(c.err, func(i, j int) bool { return c.err[i].Tok.Pos < c.err[j].Tok.Pos })
 	return c.err
 }
 
 func Infer(conf *Config) error {
-	// ignore package and use declarations. so just worry about statements
 	if conf == nil {
 		panic("unexpected nil config")
 	}
-	if conf.Types == nil {
-		conf.Types = make(map[ast.Expr]Type)
+	conf.Info.init()
+	c := &checker{
+		Info:    &conf.Info,
+		conf:    conf,
+		objs:    make(map[*ast.Object]Type),
+		keys:    make(map[*ast.Ident]bool),
+		arity:   make(map[*ast.CallExpr]int),
+		group:   make(map[*ast.FunDef]bool),
+		checked: make(map[*ast.FunDef]bool),
+		callees: make(map[*ast.Ident]bool),
 	}
-	return (&checker{conf: conf}).check()
+	return c.check()
 }
@@ -0,0 +1,273 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/smasher164/arvo/ast"
+	"github.com/smasher164/arvo/internal/parsetest"
+)
+
+func check(t *testing.T, src string) (*Config, error) {
+	t.Helper()
+	conf := &Config{File: parsetest.File(t, src, Universe)}
+	return conf, Infer(conf)
+}
+
+var inferCases = []struct {
+	input string
+	ok    bool
+}{
+	// polymorphic functions are instantiated at every use
+	{"fun id(x) { return x }\na = id(1)\nb = id('s')\nc = a + 1\nd = b + 't'", true},
+	{"fun id(x) { return x }\na = id(1)\na = id('s')", false},
+	{"fun pair(x, y) { return y, x }\na, b = pair(1, 's')\nc = a + 't'\nd = b - 1", true},
+	{"fun pair(x, y) { return y, x }\na, b = pair(1, 's')\nc = a - 1", false},
+
+	// operators constrain their operands
+	{"x = 1\nx = 'a'", false},
+	{"x = 'a' - 'b'", false},
+	{"x = 'a' + 'b'", true},
+	{"x = 1 < 2 && true", true},
+	{"x = 1 && 2", false},
+	{"fun add(a, b) { return a + b }\nx = add(1, 2)\ny = add('a', 'b')", true},
+	{"fun sub(a, b) { return a - b }\ny = sub('a', 'b')", false},
+
+	// integer literals are nums or floats, and floats only take part in
+	// arithmetic
+	{"x = 1.5\ny = x + 1\nz = x * 2 - y / 0.5", true},
+	{"x = 1.5\ny = x / 0", true},
+	{"x = 1\ny = x + 2.5", true},
+	{"x = 1\ny = x % 2\nz = x + 2.5", false},
+	{"x = 1.5 % 2", false},
+	{"x = 1.5\nx <<= 1", false},
+	{"x = ^1.5", false},
+	{"fun half(x) { return x / 2 }\na = half(3)\nb = half(3.0)\nc = a % 2", true},
+	{"x = a{1.5: 's'}\ny = x[2]", true},
+	{"x = 0b101 + 0o17 + 0x_1f + 1_000\ny = x % 2", true},
+	{"x = 100000000000000000000 * 0x1p-4", true},
+	{"x = 100000000000000000000\ny = x % 2", false},
+
+	// functions are checked in the order of their dependencies, and
+	// mutually recursive functions are monomorphic in each other's bodies
+	{"fun even(n) { if n == 0 { return true }\nreturn odd(n - 1) }\nfun odd(n) { if n == 0 { return false }\nreturn even(n - 1) }\nx = even(4) && odd(3)", true},
+	{"fun even(n) { if n == 0 { return true }\nreturn odd(n - 1) }\nfun odd(n) { if n == 0 { return false }\nreturn even(n - 1) }\nx = even('a')", false},
+	{"x = id(1) + 1\ny = id('s') + 't'\nfun id(x) { return x }", true},
+	{"fun f(x) { return g(x) }\nfun g(x) { return x }\na = f(1) + 1\nb = f('s') + 's'", true},
+	{"fun f(x) { g(1)\ng('s') }\nfun g(y) { f(y) }", false},
+
+	// occurs check
+	{"fun f(x) { x(x) }", false},
+
+	// arrays and records
+	{"x = a{1, 2, 3}\ny = x[0] + 1", true},
+	{"x = a{1, 'a'}", false},
+	{"x = a{'k': 1}\ny = x['k']\nz = x[0]", false},
+	{"x = r{name: 'n', 1}\ny = x[name] + 's'\nz = x[1] + 1\nw = x.name + x.name", true},
+	{"x = r{name: 'n'}\ny = x[age]", false},
+	{"x = a{'k': 1}\nfor k, v = in x { y = k + 's'\nz = v + 1 }", true},
+
+	// variadic functions
+	{"fun sum(...xs) { return xs[0] }\nx = sum(1, 2, 3) + 1", true},
+	{"fun sum(...xs) { return xs[0] }\nx = sum(1, 'a')", false},
+
+	// calls
+	{"fun f(x) { return x }\nf(1, 2)", false},
+	{"fun f() { return 1, 2 }\nx = f()", false},
+	{"x = 1\nx()", false},
+	{"x = y", false},
+
+	// builtins
+	{"x = append(a{1}, 2, 3)\ny = len(x) + len('s') + len(r{a: 1})", true},
+	{"x = append(a{'k': 1}, 2)", false},
+	{"x = a{'k': 1}\ndelete(x, 'k')\ndelete(x, 1)", false},
+	{"fun f(x) { return len(x) }\ny = f(a{1}) + f(a{'k': 's'})", true},
+	{"x = len(1)", false},
+	{"printf('%d %d', 1, 2)\nexit(0)\npanic('p')", true},
+	{"printf('%d %s %v', 1, 'a', 1.5)", true},
+	{"printf(1)", false},
+	{"f = len", false},
+	{"fun len(x) { return 1 }\ny = len(2)", true},
+	{"b = true && !false", true},
+}
+
+func TestInfer(t *testing.T) {
+	for i, tc := range inferCases {
+		_, err := check(t, tc.input)
+		if tc.ok && err != nil {
+			t.Errorf("case #%d, unexpected error: %v", i, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("case #%d, expected a type error", i)
+		}
+	}
+}
+
+func TestGeneralize(t *testing.T) {
+	conf, err := check(t, "fun id(x) { return x }\nid(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := conf.File.Scope.Lookup("id").Decl.(*ast.FunDef)
+	sig, ok := conf.Types[def.Name].(Signature)
+	if !ok {
+		t.Fatalf("id has type %v, want a signature", conf.Types[def.Name])
+	}
+	v, ok := sig.Params[0].(*Var)
+	if !ok || v.Level != generic {
+		t.Fatalf("parameter of id has type %v, want a quantified variable", sig.Params[0])
+	}
+	if res := sig.Results.(Tuple); len(res) != 1 || res[0] != v {
+		t.Errorf("result of id has type %v, want %v", res, v)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	conf, err := check(t, "use 'lib/m'\nfun f(x) { return x }\ny = f(1)\nz = len('s')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := conf.File
+	us := f.Decls[0].Specs[0].(*ast.UseSpec)
+	if obj := conf.Implicits[us]; obj == nil || obj.Kind != ast.Pkg || obj.Name != "m" {
+		t.Errorf("use declaration implicitly declares %v, want package m", obj)
+	}
+	def := f.Stmts[0].(*ast.ExprStmt).X.(*ast.FunDef)
+	if conf.Scopes[f] != f.Scope || conf.Scopes[def] != def.Scope {
+		t.Errorf("scopes of the file and of f are not recorded")
+	}
+	x := def.Body.List[0].(*ast.ReturnStmt).Results[0].(*ast.Ident)
+	if obj := conf.Defs[def.Params[0].Name]; obj == nil || conf.Uses[x] != obj {
+		t.Errorf("use of x does not refer to the parameter that declares it")
+	}
+	y := f.Stmts[1].(*ast.AssignStmt)
+	if obj := conf.ObjectOf(y.Rhs[0].(*ast.CallExpr).Fun.(*ast.Ident)); obj == nil || obj != conf.Defs[def.Name] {
+		t.Errorf("call of f does not refer to its definition")
+	}
+	if typ := conf.TypeOf(y.Lhs[0]); typ != Num {
+		t.Errorf("y has type %v, want num", typ)
+	}
+	z := f.Stmts[2].(*ast.AssignStmt)
+	if obj := conf.ObjectOf(z.Rhs[0].(*ast.CallExpr).Fun.(*ast.Ident)); obj != Universe.Lookup("len") {
+		t.Errorf("len refers to %v, want the builtin", obj)
+	}
+}
+
+var errorCases = []struct {
+	input string
+	code  ErrorCode
+	lit   string
+}{
+	{"x = 1\ny = x + 'a'", MismatchedTypes, "+"},
+	{"x = 'a' - 'b'", InvalidOperand, "-"},
+	{"x = 1.5 % 2", InvalidOperand, "%"},
+	{"fun f(x) { x(x) }", RecursiveType, "x"},
+	{"x = y", UndeclaredName, "y"},
+	{"fun f(x) { return x }\nf(1, 2)", WrongArgCount, "("},
+	{"x = r{name: 1}\ny = x.age", MissingKey, "age"},
+	{"f = printf", UncalledBuiltin, "printf"},
+	{"x = len(true)", InvalidOperand, "true"},
+	{"if true { break }", MisplacedBreak, "break"},
+	{"fun f() { switch { default: continue } }", MisplacedContinue, "continue"},
+	{"for { break l }", UndeclaredLabel, "l"},
+	{"l: for { f = fun() { continue l }\nbreak l }", UndeclaredLabel, "l"},
+	{"l: for {}", UnusedLabel, "l"},
+	{"l: x = 1\nfor { break l }", MisplacedLabel, "l"},
+	{"l: switch { default: for { continue l } }", MisplacedLabel, "l"},
+	{"for { l: for { break l }\nl: for { break } }", DuplicateLabel, "l"},
+	{"x = 0x8000_0000_0000_0000", NumericOverflow, "0x8000_0000_0000_0000"},
+	{"x = 1e400", NumericOverflow, "1e400"},
+	{"x = 10 / (2 - 2)", DivByZero, "/"},
+	{"x = 5\ny = x / 0", DivByZero, "/"},
+	{"fun f(x) { return x % (1 - 1) }\ny = f(5)", DivByZero, "%"},
+	{"x = 0x7fff_ffff_ffff_ffff + 1", NumericOverflow, "0x7fff_ffff_ffff_ffff"},
+	{"x = 1 << 70", NumericOverflow, "1"},
+	{"x = 1 << 600 >> 600", NumericOverflow, "1"},
+	{"y = 2\nx = y + 0x8000_0000_0000_0000", NumericOverflow, "0x8000_0000_0000_0000"},
+	{"x = 1 >> -1", InvalidShiftCount, "-"},
+	{"x = 1e300 * 1e300", NumericOverflow, "1e300"},
+}
+
+var valueCases = []struct {
+	input string
+	want  string
+}{
+	{"x = 7 / 2", "3"},
+	{"x = -7 % 3", "-1"},
+	{"x = 7.0 / 2", "3.5"},
+	{"x = 0.1 + 0.2", "0.3"},
+	{"x = (1 << 62) - 1 + (1 << 62)", "9223372036854775807"},
+	{"x = 1 >> 70", "0"},
+	{"x = (1 << 70) >> 60", "1024"},
+	{"x = -0x8000_0000_0000_0000", "-9223372036854775808"},
+	{"x = 1e400 / 1e300", "1e+100"},
+	{"x = 'a' + 'b'", "'ab'"},
+	{"x = !(1 < 2 && 'a' == 'b')", "true"},
+	{"x = -(2 * 3)", "-6"},
+}
+
+func TestValues(t *testing.T) {
+	for i, tc := range valueCases {
+		conf, err := check(t, tc.input)
+		if err != nil {
+			t.Errorf("case #%d, %s: %v", i, tc.input, err)
+			continue
+		}
+		x := conf.File.Stmts[0].(*ast.AssignStmt).Rhs[0]
+		if v := conf.Values[x]; v == nil || v.String() != tc.want {
+			t.Errorf("case #%d, %s: got %v, want %s", i, tc.input, v, tc.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for i, tc := range errorCases {
+		_, err := check(t, tc.input)
+		list, ok := err.(ErrorList)
+		if !ok || len(list) == 0 {
+			t.Errorf("case #%d, got %v, want an ErrorList", i, err)
+			continue
+		}
+		if e := list[0]; e.Code != tc.code || e.Tok.Lit != tc.lit {
+			t.Errorf("case #%d, got %v at %q, want %v at %q", i, e.Code, e.Tok.Lit, tc.code, tc.lit)
+		}
+	}
+}
+
+var stringCases = []struct {
+	input string
+	name  string
+	want  string
+}{
+	{"fun f(x, ...y) { return x < 1 }", "f", "fun('a, ...'b) -> (bool)"},
+	{"fun f(x, ...y) { return x < 1.5 }", "f", "fun(float, ...'a) -> (bool)"},
+	{"fun f(x) { return x % 2 }", "f", "fun(num) -> (num)"},
+	{"fun f(x, y) { return y, x }", "f", "fun('a, 'b) -> ('b, 'a)"},
+	{"fun f() {}", "f", "fun()"},
+	{"x = r{name: 's', 1}", "x", "r{name: str, 1: num}"},
+	{"x = a{'k': a{1}}", "x", "[str][num]num"},
+	{"x = fun(f) { return f(1) }", "x", "fun(fun(num) -> ('a)) -> ('a)"},
+	{"fun even(n) { if n == 0 { return true }\nreturn odd(n - 1) }\nfun odd(n) { if n == 0 { return false }\nreturn even(n - 1) }", "odd", "fun('a) -> (bool)"},
+	{"fun f(x) { return g(x) }\nfun g(y) { return y, 'k' }", "f", "fun('a) -> ('a, str)"},
+	{"fun f(xs, x) { return append(xs, x) }", "f", "fun([num]'a, 'a) -> ([num]'a)"},
+	{"fun f(x) { return len(x) }", "f", "fun(['a]'b) -> (num)"},
+}
+
+func TestTypeString(t *testing.T) {
+	for i, tc := range stringCases {
+		conf, err := check(t, tc.input)
+		if err != nil {
+			t.Errorf("case #%d, unexpected error: %v", i, err)
+			continue
+		}
+		obj := conf.File.Scope.Lookup(tc.name)
+		var typ Type
+		for id, def := range conf.Defs {
+			if def == obj {
+				typ = conf.TypeOf(id)
+			}
+		}
+		if got := TypeString(typ, nil); got != tc.want {
+			t.Errorf("case #%d, got %s, want %s", i, got, tc.want)
+		}
+	}
+}
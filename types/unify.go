@@ -0,0 +1,387 @@
+package types
+
+import (
+	"errors"
+	"math"
+)
+
+// A Class restricts the types that a type variable may be bound to. It is
+// how the overloaded operators constrain their operands without committing
+// to a particular basic type.
+type Class int
+
+const (
+	Any      Class = iota // any type
+	Ordered               // bool, num, float or str: operands of +, == and <
+	Numeric               // bool, num or float: operands of arithmetic operators
+	Integral              // bool or num: operands of %, bitwise operators and shifts
+	Number                // num or float: the type of an integer literal
+)
+
+// members holds the set of basic types that each class other than Any
+// admits, with bit b set if it admits Basic b.
+var members = [...]uint{
+	Ordered:  1<<Bool | 1<<Num | 1<<Float | 1<<String,
+	Numeric:  1<<Bool | 1<<Num | 1<<Float,
+	Integral: 1<<Bool | 1<<Num,
+	Number:   1<<Num | 1<<Float,
+}
+
+func (k Class) admits(t Type) bool {
+	if k == Any {
+		return true
+	}
+	b, ok := t.(Basic)
+	return ok && members[k]&(1<<uint(b)) != 0
+}
+
+// meet returns the class that admits the types admitted by both k and l.
+// If the only type they have in common is a basic type, meet returns it as
+// t instead, and if they have no type in common, it reports !ok.
+func meet(k, l Class) (m Class, t Type, ok bool) {
+	switch {
+	case k == Any:
+		return l, nil, true
+	case l == Any:
+		return k, nil, true
+	}
+	set := members[k] & members[l]
+	for m := range members {
+		if m != int(Any) && members[m] == set {
+			return Class(m), nil, true
+		}
+	}
+	for b := range basics {
+		if set == 1<<uint(b) {
+			return Any, Basic(b), true
+		}
+	}
+	return Any, nil, false
+}
+
+// generic is the level of a type variable that has been bound by a ∀
+// quantifier. Such variables are replaced with fresh ones at every use.
+const generic = math.MaxInt32
+
+// A Var is a type variable. Unification binds it by setting Link, so a chain
+// of bound variables forms the union-find structure described in HM.md.
+// Level is the number of enclosing function definitions at the point where
+// the variable was created; variables whose level is deeper than that of a
+// function definition are not free in its environment and may be generalized.
+type Var struct {
+	ID    int
+	Class Class
+	Level int
+	Link  Type
+}
+
+var (
+	errMismatch = errors.New("type mismatch")
+	errOccurs   = errors.New("recursive type")
+	errClass    = errors.New("type not permitted by operator")
+)
+
+func (c *checker) fresh(k Class) *Var {
+	c.nvar++
+	return &Var{ID: c.nvar, Class: k, Level: c.level}
+}
+
+// prune returns the representative of t, compressing the path of bound
+// variables that leads to it.
+func prune(t Type) Type {
+	v, ok := t.(*Var)
+	if !ok || v.Link == nil {
+		return t
+	}
+	v.Link = prune(v.Link)
+	return v.Link
+}
+
+func (c *checker) unify(a, b Type) error {
+	a, b = prune(a), prune(b)
+	if va, ok := a.(*Var); ok {
+		return bind(va, b)
+	}
+	if vb, ok := b.(*Var); ok {
+		return bind(vb, a)
+	}
+	switch ta := a.(type) {
+	case Basic:
+		if tb, ok := b.(Basic); ok && ta == tb {
+			return nil
+		}
+	case Array:
+		if tb, ok := b.(Array); ok {
+			if err := c.unify(ta.Key, tb.Key); err != nil {
+				return err
+			}
+			return c.unify(ta.Value, tb.Value)
+		}
+	case Record:
+		if tb, ok := b.(Record); ok && ta.N == tb.N {
+			for i := range ta.Elts {
+				if ta.Elts[i].Key != tb.Elts[i].Key {
+					return errMismatch
+				}
+				if err := c.unify(ta.Elts[i].Value, tb.Elts[i].Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case Tuple:
+		if tb, ok := b.(Tuple); ok && len(ta) == len(tb) {
+			for i := range ta {
+				if err := c.unify(ta[i], tb[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case Signature:
+		if tb, ok := b.(Signature); ok && ta.Variadic == tb.Variadic {
+			if err := c.unify(ta.Params, tb.Params); err != nil {
+				return err
+			}
+			return c.unify(ta.Results, tb.Results)
+		}
+	case Label:
+		if tb, ok := b.(Label); ok && ta.Obj == tb.Obj {
+			return nil
+		}
+	}
+	return errMismatch
+}
+
+func bind(v *Var, t Type) error {
+	if u, ok := t.(*Var); ok {
+		if u == v {
+			return nil
+		}
+		k, b, ok := meet(v.Class, u.Class)
+		if !ok {
+			return errClass
+		}
+		u.Class = k
+		if v.Level < u.Level {
+			u.Level = v.Level
+		}
+		v.Link = u
+		if b != nil {
+			u.Link = b
+		}
+		return nil
+	}
+	if !v.Class.admits(t) {
+		if v.Class == Number {
+			// v is the type of an integer literal rather than of an
+			// operator's operand.
+			return errMismatch
+		}
+		return errClass
+	}
+	if occurs(v, t) {
+		return errOccurs
+	}
+	v.Link = t
+	return nil
+}
+
+// occurs reports whether v appears in t. Along the way, it lowers the level
+// of every variable in t to that of v, since they become reachable from
+// wherever v is.
+func occurs(v *Var, t Type) bool {
+	switch t := prune(t).(type) {
+	case *Var:
+		if t == v {
+			return true
+		}
+		if t.Level > v.Level {
+			t.Level = v.Level
+		}
+	case Array:
+		return occurs(v, t.Key) || occurs(v, t.Value)
+	case Record:
+		for _, el := range t.Elts {
+			if occurs(v, el.Value) {
+				return true
+			}
+		}
+	case Tuple:
+		for _, el := range t {
+			if occurs(v, el) {
+				return true
+			}
+		}
+	case Signature:
+		return occurs(v, t.Params) || occurs(v, t.Results)
+	}
+	return false
+}
+
+// generalize quantifies over the variables in t that were created inside
+// the function definition being left.
+func (c *checker) generalize(t Type) {
+	switch t := prune(t).(type) {
+	case *Var:
+		if t.Level > c.level {
+			t.Level = generic
+		}
+	case Array:
+		c.generalize(t.Key)
+		c.generalize(t.Value)
+	case Record:
+		for _, el := range t.Elts {
+			c.generalize(el.Value)
+		}
+	case Tuple:
+		for _, el := range t {
+			c.generalize(el)
+		}
+	case Signature:
+		c.generalize(t.Params)
+		c.generalize(t.Results)
+	}
+}
+
+// instantiate replaces the quantified variables in t with fresh ones.
+func (c *checker) instantiate(t Type) Type {
+	return c.copyType(t, make(map[*Var]*Var))
+}
+
+func (c *checker) copyType(t Type, m map[*Var]*Var) Type {
+	switch t := prune(t).(type) {
+	case *Var:
+		if t.Level != generic {
+			return t
+		}
+		if v, ok := m[t]; ok {
+			return v
+		}
+		v := c.fresh(t.Class)
+		m[t] = v
+		return v
+	case Array:
+		return Array{Key: c.copyType(t.Key, m), Value: c.copyType(t.Value, m)}
+	case Record:
+		r := Record{N: t.N, Elts: make([]Element, len(t.Elts))}
+		for i, el := range t.Elts {
+			r.Elts[i] = Element{Key: el.Key, Value: c.copyType(el.Value, m)}
+		}
+		return r
+	case Tuple:
+		tu := make(Tuple, len(t))
+		for i := range t {
+			tu[i] = c.copyType(t[i], m)
+		}
+		return tu
+	case Signature:
+		return Signature{
+			Params:   c.copyType(t.Params, m).(Tuple),
+			Results:  c.copyType(t.Results, m),
+			Variadic: t.Variadic,
+		}
+	default:
+		return t
+	}
+}
+
+// settle binds every unresolved, constrained, non-generic variable in t to
+// num, which is the default type of the overloaded operators and of integer
+// literals.
+func settle(t Type) {
+	switch t := prune(t).(type) {
+	case *Var:
+		if t.Class != Any && t.Level != generic {
+			t.Link = Num
+		}
+	case Array:
+		settle(t.Key)
+		settle(t.Value)
+	case Record:
+		for _, el := range t.Elts {
+			settle(el.Value)
+		}
+	case Tuple:
+		for _, el := range t {
+			settle(el)
+		}
+	case Signature:
+		settle(t.Params)
+		settle(t.Results)
+	case Element:
+		settle(t.Key)
+		settle(t.Value)
+	}
+}
+
+// resolve replaces bound type variables in t with the types they stand for.
+func resolve(t Type) Type {
+	switch t := prune(t).(type) {
+	case Array:
+		return Array{Key: resolve(t.Key), Value: resolve(t.Value)}
+	case Record:
+		r := Record{N: t.N, Elts: make([]Element, len(t.Elts))}
+		for i, el := range t.Elts {
+			r.Elts[i] = Element{Key: el.Key, Value: resolve(el.Value)}
+		}
+		return r
+	case Tuple:
+		tu := make(Tuple, len(t))
+		for i := range t {
+			tu[i] = resolve(t[i])
+		}
+		return tu
+	case Signature:
+		return Signature{Params: resolve(t.Params).(Tuple), Results: resolve(t.Results), Variadic: t.Variadic}
+	case Element:
+		return Element{Key: resolve(t.Key), Value: resolve(t.Value)}
+	default:
+		return t
+	}
+}
+
+// Identical reports whether a and b are the same type once bound type
+// variables have been resolved.
+func Identical(a, b Type) bool {
+	a, b = prune(a), prune(b)
+	switch ta := a.(type) {
+	case *Var:
+		return a == b
+	case Basic:
+		tb, ok := b.(Basic)
+		return ok && ta == tb
+	case Array:
+		tb, ok := b.(Array)
+		return ok && Identical(ta.Key, tb.Key) && Identical(ta.Value, tb.Value)
+	case Record:
+		tb, ok := b.(Record)
+		if !ok || ta.N != tb.N {
+			return false
+		}
+		for i := range ta.Elts {
+			if ta.Elts[i].Key != tb.Elts[i].Key || !Identical(ta.Elts[i].Value, tb.Elts[i].Value) {
+				return false
+			}
+		}
+		return true
+	case Tuple:
+		tb, ok := b.(Tuple)
+		if !ok || len(ta) != len(tb) {
+			return false
+		}
+		for i := range ta {
+			if !Identical(ta[i], tb[i]) {
+				return false
+			}
+		}
+		return true
+	case Signature:
+		tb, ok := b.(Signature)
+		return ok && ta.Variadic == tb.Variadic && Identical(ta.Params, tb.Params) && Identical(ta.Results, tb.Results)
+	case Label:
+		tb, ok := b.(Label)
+		return ok && ta.Obj == tb.Obj
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package types
+
+import "github.com/smasher164/arvo/ast"
+
+// Universe is the scope that encloses every package scope. It declares the
+// predeclared constants and the builtin functions. The Type of each of its
+// objects is the object's type, in which the type variables are quantified.
+//
+// The builtin functions are implemented by the backends rather than by arvo
+// code, so they can only be called, not used as values. Their signatures are
+//
+//	len     fun('a) -> (num)
+//	append  fun([num]'a, ...'a) -> ([num]'a)
+//	delete  fun(['a]'b, 'a)
+//	printf  fun(str, ...'a)
+//	exit    fun(num)
+//	panic   fun(str)
+//
+// The argument to len must be an array, record or string, a restriction that
+// its signature cannot express. Nor can it express that the arguments after
+// printf's format may each have a different type.
+var Universe *ast.Scope
+
+func init() {
+	Universe = ast.NewScope(nil)
+	for _, name := range []string{"false", "true"} {
+		obj := ast.NewObj(ast.Con, name)
+		obj.Data = name == "true"
+		obj.Type = Bool
+		Universe.Insert(obj)
+	}
+	a, b := &Var{Level: generic}, &Var{Level: generic}
+	builtins := map[string]Signature{
+		"len":    {Params: Tuple{a}, Results: Tuple{Num}},
+		"append": {Params: Tuple{Array{Key: Num, Value: a}, Array{Key: Num, Value: a}}, Results: Tuple{Array{Key: Num, Value: a}}, Variadic: true},
+		"delete": {Params: Tuple{Array{Key: a, Value: b}, a}, Results: Tuple{}},
+		"printf": {Params: Tuple{String, Array{Key: Num, Value: a}}, Results: Tuple{}, Variadic: true},
+		"exit":   {Params: Tuple{Num}, Results: Tuple{}},
+		"panic":  {Params: Tuple{String}, Results: Tuple{}},
+	}
+	for name, sig := range builtins {
+		obj := ast.NewObj(ast.Fun, name)
+		obj.Type = sig
+		Universe.Insert(obj)
+	}
+}
+
+// IsBuiltin reports whether obj is a builtin function.
+func IsBuiltin(obj *ast.Object) bool {
+	return obj != nil && obj.Kind == ast.Fun && Universe.Lookup(obj.Name) == obj
+}